@@ -0,0 +1,132 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	substate "github.com/Fantom-foundation/Substate"
+	"github.com/ethereum/go-ethereum/cmd/evm/internal/t8ntool"
+	"github.com/ethereum/go-ethereum/core/replay"
+	"github.com/ethereum/go-ethereum/tests"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	ConvertFormatFlag = cli.StringFlag{
+		Name:  "convert.format",
+		Usage: "Format of the converted substates, statetest or t8n",
+		Value: "statetest",
+	}
+	ConvertDirFlag = cli.StringFlag{
+		Name:  "convert.dir",
+		Usage: "Directory the converted substates are written to",
+		Value: "./fixtures",
+	}
+)
+
+var convertCommand = cli.Command{
+	Action:    convertCmd,
+	Name:      "convert",
+	Usage:     "converts recorded substates into state tests or t8n input",
+	ArgsUsage: "<blockNumFirst> <blockNumLast>",
+	Description: `
+The convert command converts the substates of the given block range into
+fixtures of the standard test runners, using the fork of the mainnet
+configuration active at the block of each substate. The expected state root
+and logs hash are the ones of the recorded output.
+
+With --convert.format statetest, each substate is written as a state test
+named <block>_<tx> to <block>_<tx>.json, runnable with evm statetest. With
+--convert.format t8n, the alloc.json, env.json and txs.json input of evm t8n
+and the expected result in expected.json are written to the directory
+<block>_<tx>.
+
+Recorded transactions carry no signature, their sender is stored in the
+sender field of the transaction instead.`,
+	Flags: []cli.Flag{
+		SubstateDirFlag,
+		WorkersFlag,
+		SkipTransferTxsFlag,
+		SkipCallTxsFlag,
+		SkipCreateTxsFlag,
+		ConvertFormatFlag,
+		ConvertDirFlag,
+	},
+}
+
+func convertCmd(ctx *cli.Context) error {
+	first, last, err := parseBlockRange(ctx)
+	if err != nil {
+		return err
+	}
+	var (
+		config = replay.DefaultChainConfig()
+		dir    = ctx.String(ConvertDirFlag.Name)
+		format = ctx.String(ConvertFormatFlag.Name)
+		write  func(name, fork string, s *substate.Substate) error
+	)
+	switch format {
+	case "statetest":
+		write = func(name, fork string, s *substate.Substate) error {
+			test, err := tests.StateTestFromSubstate(s, fork)
+			if err != nil {
+				return err
+			}
+			out, err := json.MarshalIndent(map[string]*tests.StateTest{name: test}, "", "  ")
+			if err != nil {
+				return err
+			}
+			return ioutil.WriteFile(filepath.Join(dir, name+".json"), out, 0644)
+		}
+	case "t8n":
+		write = func(name, fork string, s *substate.Substate) error {
+			fixture, err := t8ntool.FixtureFromSubstate(s, fork, config.ChainID)
+			if err != nil {
+				return err
+			}
+			return fixture.Write(filepath.Join(dir, name))
+		}
+	default:
+		return fmt.Errorf("unknown format %q, want statetest or t8n", format)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	db, err := openSubstateDB(ctx, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	task := func(block uint64, tx int, s *substate.Substate, pool *substate.SubstateTaskPool) error {
+		var (
+			name = fmt.Sprintf("%d_%d", block, tx)
+			fork = tests.ForkName(config, new(big.Int).SetUint64(block))
+		)
+		if err := write(name, fork, s); err != nil {
+			return fmt.Errorf("substate %s: %v", name, err)
+		}
+		return nil
+	}
+	return newSubstateTaskPool(ctx, "evm convert", db, first, last, task).Execute()
+}
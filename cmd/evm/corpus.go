@@ -0,0 +1,95 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+
+	substate "github.com/Fantom-foundation/Substate"
+	"github.com/ethereum/go-ethereum/core/corpus"
+	"github.com/ethereum/go-ethereum/core/replay"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var CorpusDirFlag = cli.StringFlag{
+	Name:  "corpus.dir",
+	Usage: "Directory the extracted corpus is written to",
+	Value: "./corpus",
+}
+
+var corpusCommand = cli.Command{
+	Action:    corpusCmd,
+	Name:      "corpus",
+	Usage:     "extracts the contract bytecodes of recorded substates",
+	ArgsUsage: "<blockNumFirst> <blockNumLast>",
+	Description: `
+The corpus command walks the substates of the given block range and collects
+every runtime bytecode touched by a transaction, deduplicated by code hash.
+Each code is written hex encoded to <codehash>.hex in the corpus directory and
+the entries are listed in corpus.json with the code size, the first and last
+block the code was seen in, the number of transactions touching it, the
+number of times it was executed, the transaction and the account that
+deployed it and the compiler fingerprint of its metadata trailer.
+
+A transaction touches a code if the code's account is part of its substate,
+whether or not the code was executed. The executions and the deploying
+accounts are traced while replaying the transactions, the deploying account
+is the contract executing the CREATE for internal deployments. Codes
+deployed and self-destructed within a single transaction are not recorded in
+the substates and thus missing from the corpus.`,
+	Flags: []cli.Flag{
+		SubstateDirFlag,
+		WorkersFlag,
+		SkipTransferTxsFlag,
+		SkipCallTxsFlag,
+		SkipCreateTxsFlag,
+		CorpusDirFlag,
+	},
+}
+
+func corpusCmd(ctx *cli.Context) error {
+	first, last, err := parseBlockRange(ctx)
+	if err != nil {
+		return err
+	}
+	db, err := openSubstateDB(ctx, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	c := corpus.New()
+	task := func(block uint64, tx int, s *substate.Substate, pool *substate.SubstateTaskPool) error {
+		trace := corpus.NewTrace()
+		cfg := &replay.Config{VMConfig: vm.Config{Debug: true, Tracer: trace}}
+		if _, _, err := replay.Execute(tx, s, cfg); err != nil {
+			return fmt.Errorf("transaction %d_%d: %v", block, tx, err)
+		}
+		c.Add(block, tx, s, trace)
+		return nil
+	}
+	if err := newSubstateTaskPool(ctx, "evm corpus", db, first, last, task).Execute(); err != nil {
+		return err
+	}
+	dir := ctx.String(CorpusDirFlag.Name)
+	if err := c.Write(dir); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %d contracts to %s\n", c.Len(), dir)
+	return nil
+}
@@ -0,0 +1,559 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	substate "github.com/Fantom-foundation/Substate"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/debugger"
+	"github.com/ethereum/go-ethereum/core/replay"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/tests"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	DebugRPCFlag = cli.StringFlag{
+		Name:  "rpc",
+		Usage: "Serve the debug_evmSession* JSON-RPC API on the given HTTP address instead of starting the REPL",
+	}
+	DebugRPCStateTestsFlag = cli.StringFlag{
+		Name:  "rpc.statetests",
+		Usage: "Directory of the state test files the JSON-RPC API may debug (state tests are disabled if unset)",
+	}
+	DebugRPCSessionsFlag = cli.IntFlag{
+		Name:  "rpc.sessions",
+		Usage: "Maximum number of sessions open through the JSON-RPC API (0 means no limit)",
+		Value: debugger.DefaultMaxSessions,
+	}
+	DebugRPCIdleTimeoutFlag = cli.DurationFlag{
+		Name:  "rpc.idletimeout",
+		Usage: "Time after which unused JSON-RPC sessions are closed (0 keeps them open)",
+		Value: debugger.DefaultIdleTimeout,
+	}
+	StateTestNameFlag = cli.StringFlag{
+		Name:  "statetest.name",
+		Usage: "Name of the state test to debug (default: first test of the file)",
+	}
+	StateTestForkFlag = cli.StringFlag{
+		Name:  "statetest.fork",
+		Usage: "Fork of the state test to debug (default: first fork of the test)",
+	}
+	StateTestIndexFlag = cli.IntFlag{
+		Name:  "statetest.index",
+		Usage: "Index of the subtest among the subtests of the fork",
+	}
+	CheckpointIntervalFlag = cli.Uint64Flag{
+		Name:  "checkpoint.interval",
+		Usage: "Number of steps between checkpoints of the interpreter state (0 disables checkpoints)",
+		Value: debugger.DefaultCheckpointInterval,
+	}
+)
+
+var debugCommand = cli.Command{
+	Action:    debugCmd,
+	Name:      "debug",
+	Usage:     "interactively debugs a recorded substate or a state test",
+	ArgsUsage: "<block> <tx> | <statetest.json>",
+	Description: `
+The debug command executes a transaction step by step. Given a block and a
+transaction index, the transaction is replayed from the substate database;
+given a file, a state test is executed. Type "help" for the commands of the
+interactive prompt.
+
+Moving forwards continues the paused execution. Every --checkpoint.interval
+steps, the state and the open call frames are recorded; stepping backwards
+resumes the transaction from the nearest checkpoint before the target step,
+or re-executes it from its start if there is none. Re-executions are compared
+with the checkpoints to detect non-deterministic runs.
+
+With --rpc, no transaction is loaded and the debug_evmSession* JSON-RPC API
+is served instead. debug_evmSessionOpen takes either {"block": n, "tx": i}
+for a substate or {"file": "...", "name": "...", "fork": "...", "index": n}
+for a state test, whose file is resolved within --rpc.statetests. At most
+--rpc.sessions sessions are open at a time, sessions unused for
+--rpc.idletimeout are closed.`,
+	Flags: []cli.Flag{
+		SubstateDirFlag,
+		InterpreterFlag,
+		StateTestNameFlag,
+		StateTestForkFlag,
+		StateTestIndexFlag,
+		CheckpointIntervalFlag,
+		DebugRPCFlag,
+		DebugRPCStateTestsFlag,
+		DebugRPCSessionsFlag,
+		DebugRPCIdleTimeoutFlag,
+	},
+}
+
+// debugSource describes the transaction of a debugging session.
+type debugSource struct {
+	Block *uint64 `json:"block"` // block of a recorded substate
+	Tx    int     `json:"tx"`    // transaction index of a recorded substate
+	File  string  `json:"file"`  // state test file
+	Name  string  `json:"name"`  // state test name
+	Fork  string  `json:"fork"`  // state test fork
+	Index int     `json:"index"` // subtest index within the fork
+}
+
+// debugLoader creates the runners of debugging sessions. The substate
+// database is opened on first use.
+type debugLoader struct {
+	ctx *cli.Context
+
+	mu sync.Mutex // protects db, sessions are loaded concurrently by the API
+	db *substate.SubstateDB
+}
+
+func (l *debugLoader) load(src *debugSource) (debugger.Runner, error) {
+	cfg := vm.Config{InterpreterImpl: l.ctx.String(InterpreterFlag.Name)}
+	if src.File != "" {
+		return stateTestRunner(src, cfg)
+	}
+	if src.Block == nil {
+		return nil, errors.New("either a substate block or a state test file is required")
+	}
+	db, err := l.substateDB()
+	if err != nil {
+		return nil, err
+	}
+	if !db.HasSubstate(*src.Block, src.Tx) {
+		return nil, fmt.Errorf("substate %d_%d not found", *src.Block, src.Tx)
+	}
+	s := db.GetSubstate(*src.Block, src.Tx)
+	return debugger.SubstateRunner(*src.Block, src.Tx, s, &replay.Config{VMConfig: cfg}), nil
+}
+
+// substateDB returns the substate database, opening it on first use.
+func (l *debugLoader) substateDB() (*substate.SubstateDB, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.db == nil {
+		db, err := openSubstateDB(l.ctx, true)
+		if err != nil {
+			return nil, err
+		}
+		l.db = db
+	}
+	return l.db, nil
+}
+
+func (l *debugLoader) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.db != nil {
+		l.db.Close()
+	}
+}
+
+// stateTestPath resolves the state test file of an API request within dir.
+// Files outside of dir are rejected.
+func stateTestPath(dir, file string) (string, error) {
+	if dir == "" {
+		return "", fmt.Errorf("state tests are disabled, see --%s", DebugRPCStateTestsFlag.Name)
+	}
+	clean := filepath.Clean(file)
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("state test file %q outside of %s", file, dir)
+	}
+	return filepath.Join(dir, clean), nil
+}
+
+// stateTestRunner returns a runner executing the selected subtest of a state test.
+func stateTestRunner(src *debugSource, cfg vm.Config) (debugger.Runner, error) {
+	data, err := ioutil.ReadFile(src.File)
+	if err != nil {
+		return nil, err
+	}
+	var stateTests map[string]tests.StateTest
+	if err := json.Unmarshal(data, &stateTests); err != nil {
+		return nil, err
+	}
+	name := src.Name
+	if name == "" {
+		names := make([]string, 0, len(stateTests))
+		for name := range stateTests {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return nil, fmt.Errorf("no state tests in %s", src.File)
+		}
+		name = names[0]
+	}
+	test, ok := stateTests[name]
+	if !ok {
+		return nil, fmt.Errorf("state test %q not found in %s", name, src.File)
+	}
+	var subtests []tests.StateSubtest
+	for _, st := range test.Subtests() {
+		if src.Fork == "" || st.Fork == src.Fork {
+			subtests = append(subtests, st)
+		}
+	}
+	if src.Index < 0 || src.Index >= len(subtests) {
+		return nil, fmt.Errorf("state test %q has no subtest %d for fork %q", name, src.Index, src.Fork)
+	}
+	subtest := subtests[src.Index]
+	return func(vmConfig vm.Config) error {
+		config := cfg
+		config.Debug, config.Tracer, config.Resume = vmConfig.Debug, vmConfig.Tracer, vmConfig.Resume
+		_, _, _, _, err := test.RunNoVerify(subtest, config, false)
+		return err
+	}, nil
+}
+
+func debugCmd(ctx *cli.Context) error {
+	loader := &debugLoader{ctx: ctx}
+	defer loader.close()
+
+	config := &debugger.Config{CheckpointInterval: ctx.Uint64(CheckpointIntervalFlag.Name)}
+	if addr := ctx.String(DebugRPCFlag.Name); addr != "" {
+		config.MaxSessions = ctx.Int(DebugRPCSessionsFlag.Name)
+		config.IdleTimeout = ctx.Duration(DebugRPCIdleTimeoutFlag.Name)
+		return serveDebugAPI(addr, loader, config)
+	}
+	src := &debugSource{
+		Name:  ctx.String(StateTestNameFlag.Name),
+		Fork:  ctx.String(StateTestForkFlag.Name),
+		Index: ctx.Int(StateTestIndexFlag.Name),
+	}
+	switch len(ctx.Args()) {
+	case 1:
+		src.File = ctx.Args().First()
+	case 2:
+		block, err := strconv.ParseUint(ctx.Args().Get(0), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid block: %v", err)
+		}
+		tx, err := strconv.Atoi(ctx.Args().Get(1))
+		if err != nil {
+			return fmt.Errorf("invalid transaction index: %v", err)
+		}
+		src.Block, src.Tx = &block, tx
+	default:
+		return errors.New("either <block> <tx> or <statetest.json> required")
+	}
+	run, err := loader.load(src)
+	if err != nil {
+		return err
+	}
+	session := debugger.NewSession(run, config)
+	defer session.Close()
+	return debugREPL(session, os.Stdin, os.Stdout)
+}
+
+// serveDebugAPI serves the debugging API over HTTP until the process is stopped.
+func serveDebugAPI(addr string, loader *debugLoader, config *debugger.Config) error {
+	server := rpc.NewServer()
+	defer server.Stop()
+	load := func(source json.RawMessage) (debugger.Runner, error) {
+		src := new(debugSource)
+		if err := json.Unmarshal(source, src); err != nil {
+			return nil, err
+		}
+		if src.File != "" {
+			file, err := stateTestPath(loader.ctx.String(DebugRPCStateTestsFlag.Name), src.File)
+			if err != nil {
+				return nil, err
+			}
+			src.File = file
+		}
+		return loader.load(src)
+	}
+	for _, api := range debugger.APIs(load, config) {
+		if err := server.RegisterName(api.Namespace, api.Service); err != nil {
+			return err
+		}
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Info("Serving EVM debugging API", "addr", listener.Addr())
+	return http.Serve(listener, server)
+}
+
+const debugHelp = `Commands:
+  s, step              execute the next instruction, entering calls
+  n, next              execute the next instruction, completing calls
+  o, out               continue until the current frame returned
+  c, continue          continue until a breakpoint matches
+  sb, back             return to the previous instruction
+  rc, reverse-continue return to the last earlier instruction matching a breakpoint
+  goto <step>          move to the given step, forwards or backwards
+  b, break <cond>...   add a breakpoint matching all conditions:
+                         pc=<n> address=<addr> op=<name> slot=<hash> depth=<n>
+  d, delete <id>       remove a breakpoint
+  breakpoints          list the breakpoints
+  stack                print the stack, the top element first
+  mem [offset [size]]  print the memory
+  storage <slot> [addr] print a storage slot of the executing or given account
+  returndata           print the return data of the last call
+  checkpoints [step]   list the checkpoints or print the state of one
+  status               print the current instruction
+  q, quit              abort the execution
+`
+
+// printStatus prints the status of a session.
+func printStatus(w io.Writer, status debugger.Status) {
+	if status.Finished {
+		if status.Error != "" {
+			fmt.Fprintf(w, "finished after %d steps: %s\n", status.Steps, status.Error)
+		} else {
+			fmt.Fprintf(w, "finished after %d steps\n", status.Steps)
+		}
+		return
+	}
+	fmt.Fprintf(w, "[%d] %x depth=%d pc=%d %s gas=%d cost=%d", status.Steps, status.Code, status.Depth, status.PC, status.Op, status.Gas, status.Cost)
+	if status.Reason == debugger.ReasonBreakpoint {
+		fmt.Fprintf(w, " (breakpoint %d)", status.Breakpoint)
+	}
+	if status.Error != "" {
+		fmt.Fprintf(w, " error: %s", status.Error)
+	}
+	fmt.Fprintln(w)
+}
+
+// parseBreakpoint parses the conditions of a break command.
+func parseBreakpoint(args []string) (debugger.Breakpoint, error) {
+	var bp debugger.Breakpoint
+	if len(args) == 0 {
+		return bp, errors.New("breakpoint conditions required")
+	}
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return bp, fmt.Errorf("invalid condition %q", arg)
+		}
+		switch kv[0] {
+		case "pc":
+			pc, err := strconv.ParseUint(kv[1], 0, 64)
+			if err != nil {
+				return bp, fmt.Errorf("invalid pc: %v", err)
+			}
+			bp.PC = &pc
+		case "address":
+			if !common.IsHexAddress(kv[1]) {
+				return bp, fmt.Errorf("invalid address %q", kv[1])
+			}
+			address := common.HexToAddress(kv[1])
+			bp.Address = &address
+		case "op":
+			bp.OpCode = strings.ToUpper(kv[1])
+		case "slot":
+			slot := common.HexToHash(kv[1])
+			bp.Slot = &slot
+		case "depth":
+			depth, err := strconv.Atoi(kv[1])
+			if err != nil {
+				return bp, fmt.Errorf("invalid depth: %v", err)
+			}
+			bp.Depth = &depth
+		default:
+			return bp, fmt.Errorf("unknown condition %q", kv[0])
+		}
+	}
+	return bp, nil
+}
+
+// debugREPL reads commands from in until the input ends or quit is entered.
+func debugREPL(session *debugger.Session, in io.Reader, out io.Writer) error {
+	printStatus(out, session.Status())
+	scanner := bufio.NewScanner(in)
+	for fmt.Fprint(out, "> "); scanner.Scan(); fmt.Fprint(out, "> ") {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		var (
+			status debugger.Status
+			err    error
+			resume = true
+		)
+		switch cmd, args := fields[0], fields[1:]; cmd {
+		case "s", "step":
+			status, err = session.StepInto()
+		case "n", "next":
+			status, err = session.StepOver()
+		case "o", "out":
+			status, err = session.StepOut()
+		case "c", "continue":
+			status, err = session.Continue()
+		case "sb", "back":
+			status, err = session.StepBack()
+		case "rc", "reverse-continue":
+			status, err = session.ReverseContinue()
+		case "goto":
+			if len(args) != 1 {
+				err = errors.New("step required")
+				break
+			}
+			var step uint64
+			if step, err = strconv.ParseUint(args[0], 10, 64); err == nil {
+				status, err = session.GoTo(step)
+			}
+		case "status":
+			status = session.Status()
+		default:
+			resume = false
+			if cmd == "q" || cmd == "quit" {
+				return nil
+			}
+			err = debugInspect(session, cmd, args, out)
+		}
+		if err != nil {
+			fmt.Fprintln(out, "error:", err)
+		} else if resume {
+			printStatus(out, status)
+		}
+	}
+	return scanner.Err()
+}
+
+// debugInspect executes the commands which do not resume the execution.
+func debugInspect(session *debugger.Session, cmd string, args []string, out io.Writer) error {
+	switch cmd {
+	case "b", "break":
+		bp, err := parseBreakpoint(args)
+		if err != nil {
+			return err
+		}
+		id, err := session.AddBreakpoint(bp)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "breakpoint %d added\n", id)
+	case "d", "delete":
+		if len(args) != 1 {
+			return errors.New("breakpoint ID required")
+		}
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return err
+		}
+		if !session.RemoveBreakpoint(id) {
+			return fmt.Errorf("unknown breakpoint %d", id)
+		}
+	case "breakpoints":
+		for _, bp := range session.Breakpoints() {
+			data, _ := json.Marshal(bp)
+			fmt.Fprintln(out, string(data))
+		}
+	case "stack":
+		stack, err := session.Stack()
+		if err != nil {
+			return err
+		}
+		for i := len(stack) - 1; i >= 0; i-- {
+			fmt.Fprintf(out, "%4d: %#x\n", len(stack)-1-i, stack[i])
+		}
+	case "mem":
+		offset, size := uint64(0), uint64(1<<62)
+		if len(args) > 0 {
+			v, err := strconv.ParseUint(args[0], 0, 64)
+			if err != nil {
+				return err
+			}
+			offset = v
+		}
+		if len(args) > 1 {
+			v, err := strconv.ParseUint(args[1], 0, 64)
+			if err != nil {
+				return err
+			}
+			size = v
+		}
+		mem, err := session.Memory(offset, size)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < len(mem); i += 32 {
+			end := i + 32
+			if end > len(mem) {
+				end = len(mem)
+			}
+			fmt.Fprintf(out, "%#06x: %x\n", offset+uint64(i), mem[i:end])
+		}
+	case "storage":
+		if len(args) == 0 {
+			return errors.New("storage slot required")
+		}
+		var address *common.Address
+		if len(args) > 1 {
+			a := common.HexToAddress(args[1])
+			address = &a
+		}
+		value, err := session.Storage(address, common.HexToHash(args[0]))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%#x\n", new(big.Int).SetBytes(value.Bytes()))
+	case "returndata":
+		data, err := session.ReturnData()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, hexutil.Encode(data))
+	case "checkpoints":
+		if len(args) == 0 {
+			for _, step := range session.Checkpoints() {
+				fmt.Fprintln(out, step)
+			}
+			break
+		}
+		step, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return err
+		}
+		c, ok := session.Checkpoint(step)
+		if !ok {
+			return fmt.Errorf("no checkpoint at step %d", step)
+		}
+		printStatus(out, c.Status)
+		for i := len(c.Stack) - 1; i >= 0; i-- {
+			fmt.Fprintf(out, "%4d: %#x\n", len(c.Stack)-1-i, c.Stack[i])
+		}
+		fmt.Fprintln(out, "memory:", hexutil.Encode(c.Memory))
+		fmt.Fprintln(out, "returndata:", hexutil.Encode(c.ReturnData))
+	case "h", "help":
+		fmt.Fprint(out, debugHelp)
+	default:
+		return fmt.Errorf("unknown command %q, try help", cmd)
+	}
+	return nil
+}
@@ -17,20 +17,31 @@
 package main
 
 import (
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"strings"
 
 	"github.com/ethereum/go-ethereum/core/asm"
+	"github.com/ethereum/go-ethereum/core/vm"
 	"gopkg.in/urfave/cli.v1"
 )
 
+var CFGFlag = cli.BoolFlag{
+	Name:  "cfg",
+	Usage: "output the control-flow graph in DOT format",
+}
+
 var disasmCommand = cli.Command{
 	Action:    disasmCmd,
 	Name:      "disasm",
 	Usage:     "disassembles evm binary",
 	ArgsUsage: "<file>",
+	Flags: []cli.Flag{
+		CFGFlag,
+	},
 }
 
 func disasmCmd(ctx *cli.Context) error {
@@ -50,6 +61,13 @@ func disasmCmd(ctx *cli.Context) error {
 	}
 
 	code := strings.TrimSpace(in)
+	if ctx.Bool(CFGFlag.Name) {
+		script, err := hex.DecodeString(strings.TrimPrefix(code, "0x"))
+		if err != nil {
+			return err
+		}
+		return vm.BuildControlFlowGraph(script).WriteDOT(os.Stdout, "cfg")
+	}
 	fmt.Printf("%v\n", code)
 	return asm.PrintDisassembled(code)
 }
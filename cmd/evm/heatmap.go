@@ -0,0 +1,123 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	substate "github.com/Fantom-foundation/Substate"
+	"github.com/ethereum/go-ethereum/core/replay"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	HeatmapDirFlag = cli.StringFlag{
+		Name:  "heatmap.dir",
+		Usage: "Directory the heatmap reports are written to",
+		Value: "./heatmap",
+	}
+	HeatmapSortFlag = cli.StringFlag{
+		Name:  "heatmap.sort",
+		Usage: "Column the reports are sorted by in descending order (" + strings.Join(replay.HeatmapSortKeys, ", ") + ")",
+		Value: "txs",
+	}
+	HeatmapTopFlag = cli.IntFlag{
+		Name:  "heatmap.top",
+		Usage: "Number of accounts and slots written to the reports, all if zero",
+	}
+)
+
+var heatmapCommand = cli.Command{
+	Action:    heatmapCmd,
+	Name:      "heatmap",
+	Usage:     "counts the accesses of accounts and storage slots of recorded substates",
+	ArgsUsage: "<blockNumFirst> <blockNumLast>",
+	Description: `
+The heatmap command replays the transactions of the given block range and
+counts, for every account and storage slot, the transactions reading and
+writing it, together with the first and last block it was accessed in. From
+Berlin on, every access is also classified as cold or warm according to
+EIP-2929, i.e. whether the item was the sender, the recipient, a precompile or
+part of the access list of the transaction.
+
+The balance, nonce and code of the sender and the balance of the coinbase are
+accessed by every transaction to pay the fee. These accesses are not counted,
+the fees column of accounts.csv counts the fee payments instead. A transaction
+whose outcome differs from the recorded one aborts the command.
+
+The counts are written to accounts.csv and slots.csv in the --heatmap.dir
+directory, sorted by the --heatmap.sort column.`,
+	Flags: []cli.Flag{
+		SubstateDirFlag,
+		WorkersFlag,
+		InterpreterFlag,
+		SkipTransferTxsFlag,
+		SkipCallTxsFlag,
+		SkipCreateTxsFlag,
+		ReplayShardSizeFlag,
+		HeatmapDirFlag,
+		HeatmapSortFlag,
+		HeatmapTopFlag,
+	},
+}
+
+func heatmapCmd(ctx *cli.Context) error {
+	first, last, err := parseBlockRange(ctx)
+	if err != nil {
+		return err
+	}
+	sortKey := ctx.String(HeatmapSortFlag.Name)
+	if !replay.ValidHeatmapSortKey(sortKey) {
+		return fmt.Errorf("invalid --%s %q, want one of %v", HeatmapSortFlag.Name, sortKey, replay.HeatmapSortKeys)
+	}
+	db, err := openSubstateDB(ctx, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	h := replay.NewHeatmap()
+	task := func(block uint64, tx int, s *substate.Substate, cfg *replay.Config) error {
+		if err := h.Record(block, tx, s, cfg); err != nil {
+			return fmt.Errorf("transaction %d_%d: %v", block, tx, err)
+		}
+		return nil
+	}
+	sched := &replay.Scheduler{
+		Source:    db,
+		Range:     replay.Range{First: first, Last: last},
+		Workers:   ctx.Int(WorkersFlag.Name),
+		ShardSize: ctx.Uint64(ReplayShardSizeFlag.Name),
+		Skip:      func(s *substate.Substate) bool { return skipSubstate(ctx, s) },
+		NewConfig: func(int) *replay.Config {
+			return &replay.Config{VMConfig: vm.Config{InterpreterImpl: ctx.String(InterpreterFlag.Name)}}
+		},
+		Task: task,
+	}
+	if _, err := sched.Run(); err != nil {
+		return err
+	}
+	dir := ctx.String(HeatmapDirFlag.Name)
+	if err := h.Write(dir, sortKey, ctx.Int(HeatmapTopFlag.Name)); err != nil {
+		return err
+	}
+	accounts, slots := h.Len()
+	fmt.Printf("wrote heatmap of %d accounts and %d slots to %s\n", accounts, slots, dir)
+	return nil
+}
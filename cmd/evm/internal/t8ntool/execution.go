@@ -41,6 +41,28 @@ import (
 type Prestate struct {
 	Env stEnv             `json:"env"`
 	Pre core.GenesisAlloc `json:"pre"`
+
+	// Senders maps the indexes of unsigned transactions to the accounts they
+	// are executed for, e.g. transactions converted from recorded substates.
+	// Unsigned transactions with equal fields share their hash, so they are
+	// identified by index.
+	Senders map[int]common.Address `json:"-"`
+}
+
+// senderSigner is a signer returning the configured sender of an unsigned
+// transaction instead of recovering it from the signature.
+type senderSigner struct {
+	types.Signer
+	sender common.Address
+}
+
+func (s senderSigner) Sender(tx *types.Transaction) (common.Address, error) {
+	return s.sender, nil
+}
+
+func (s senderSigner) Equal(s2 types.Signer) bool {
+	x, ok := s2.(senderSigner)
+	return ok && x.sender == s.sender && x.Signer.Equal(s.Signer)
 }
 
 // ExecutionResult contains the execution status after running a state test, any
@@ -138,9 +160,14 @@ func (pre *Prestate) Apply(vmConfig vm.Config, chainConfig *params.ChainConfig,
 		chainConfig.DAOForkBlock.Cmp(new(big.Int).SetUint64(pre.Env.Number)) == 0 {
 		misc.ApplyDAOHardFork(statedb)
 	}
+	vm.ActivateStatePrecompiles(chainConfig, new(big.Int).SetUint64(pre.Env.Number), statedb)
 
 	for i, tx := range txs {
-		msg, err := tx.AsMessage(signer, pre.Env.BaseFee)
+		txSigner := signer
+		if sender, ok := pre.Senders[i]; ok {
+			txSigner = senderSigner{signer, sender}
+		}
+		msg, err := tx.AsMessage(txSigner, pre.Env.BaseFee)
 		if err != nil {
 			log.Warn("rejected tx", "index", i, "hash", tx.Hash(), "error", err)
 			rejectedTxs = append(rejectedTxs, &rejectedTx{i, err.Error()})
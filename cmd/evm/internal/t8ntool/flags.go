@@ -90,6 +90,10 @@ var (
 		Usage: "Mining reward. Set to -1 to disable",
 		Value: 0,
 	}
+	StatePrecompilesFlag = cli.StringFlag{
+		Name:  "state.precompiles",
+		Usage: "JSON file with the stateful precompiles to activate, in the format of the chain config's statePrecompiles",
+	}
 	ChainIDFlag = cli.Int64Flag{
 		Name:  "state.chainid",
 		Usage: "ChainID to use",
@@ -0,0 +1,150 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package t8ntool
+
+import (
+	"math/big"
+	"os"
+
+	substate "github.com/Fantom-foundation/Substate"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/tests"
+)
+
+// SubstateFixture is the t8n input of a recorded substate along with the
+// state root and logs hash the transition is expected to produce.
+type SubstateFixture struct {
+	Alloc    core.GenesisAlloc
+	Env      stEnv
+	Txs      []*txWithKey
+	Expected SubstateExpectation
+}
+
+// SubstateExpectation holds the expected result of a SubstateFixture under the
+// fork to pass as --state.fork. The hashes use the field names of the result.
+// Extensions lists the non-standard input fields the fixture relies on.
+type SubstateExpectation struct {
+	Fork       string      `json:"fork"`
+	StateRoot  common.Hash `json:"stateRoot"`
+	LogsHash   common.Hash `json:"logsHash"`
+	Extensions []string    `json:"extensions,omitempty"`
+}
+
+// FixtureFromSubstate converts a recorded substate into t8n input for the
+// given fork. The transaction is left unsigned and carries its recorded
+// sender in the non-standard `sender` field, since the signature is not part
+// of the substate. The fixture is thus only executable by this tool.
+func FixtureFromSubstate(s *substate.Substate, fork string, chainID *big.Int) (*SubstateFixture, error) {
+	root, logs, err := tests.SubstatePostState(s, fork, false)
+	if err != nil {
+		return nil, err
+	}
+	env := stEnv{
+		Coinbase:   s.Env.Coinbase,
+		Difficulty: new(big.Int),
+		GasLimit:   s.Env.GasLimit,
+		Number:     s.Env.Number,
+		Timestamp:  s.Env.Timestamp,
+	}
+	if s.Env.Difficulty != nil {
+		env.Difficulty.Set(s.Env.Difficulty)
+	}
+	if s.Env.BaseFee != nil {
+		env.BaseFee = new(big.Int).Set(s.Env.BaseFee)
+	}
+	if len(s.Env.BlockHashes) > 0 {
+		env.BlockHashes = make(map[math.HexOrDecimal64]common.Hash, len(s.Env.BlockHashes))
+		for num, hash := range s.Env.BlockHashes {
+			env.BlockHashes[math.HexOrDecimal64(num)] = hash
+		}
+	}
+	from := s.Message.From
+	return &SubstateFixture{
+		Alloc:    tests.GenesisAllocFromSubstate(s.InputAlloc),
+		Env:      env,
+		Txs:      []*txWithKey{{sender: &from, tx: substateTransaction(s, chainID)}},
+		Expected: SubstateExpectation{Fork: fork, StateRoot: root, LogsHash: logs, Extensions: []string{"txs.sender"}},
+	}, nil
+}
+
+// substateTransaction returns the unsigned transaction of the message of a
+// substate. The transaction type is not recorded, a dynamic fee transaction
+// is assumed if the fee caps differ from the gas price.
+func substateTransaction(s *substate.Substate, chainID *big.Int) *types.Transaction {
+	msg := s.Message
+	dynamic := s.Env.BaseFee != nil && msg.GasFeeCap != nil && msg.GasTipCap != nil &&
+		(msg.GasFeeCap.Cmp(msg.GasPrice) != 0 || msg.GasTipCap.Cmp(msg.GasPrice) != 0)
+	switch {
+	case dynamic:
+		return types.NewTx(&types.DynamicFeeTx{
+			ChainID:    chainID,
+			Nonce:      msg.Nonce,
+			GasTipCap:  msg.GasTipCap,
+			GasFeeCap:  msg.GasFeeCap,
+			Gas:        msg.Gas,
+			To:         msg.To,
+			Value:      msg.Value,
+			Data:       msg.Data,
+			AccessList: msg.AccessList,
+		})
+	case msg.AccessList != nil:
+		return types.NewTx(&types.AccessListTx{
+			ChainID:    chainID,
+			Nonce:      msg.Nonce,
+			GasPrice:   msg.GasPrice,
+			Gas:        msg.Gas,
+			To:         msg.To,
+			Value:      msg.Value,
+			Data:       msg.Data,
+			AccessList: msg.AccessList,
+		})
+	}
+	return types.NewTx(&types.LegacyTx{
+		Nonce:    msg.Nonce,
+		GasPrice: msg.GasPrice,
+		Gas:      msg.Gas,
+		To:       msg.To,
+		Value:    msg.Value,
+		Data:     msg.Data,
+	})
+}
+
+// Write writes the fixture to alloc.json, env.json, txs.json and
+// expected.json in the given directory.
+func (f *SubstateFixture) Write(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return NewError(ErrorIO, err)
+	}
+	files := []struct {
+		name string
+		obj  interface{}
+	}{
+		{"alloc.json", f.Alloc},
+		{"env.json", &f.Env},
+		{"txs.json", f.Txs},
+		{"expected.json", &f.Expected},
+	}
+	for _, file := range files {
+		if err := saveFile(dir, file.name, file.obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package t8ntool
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	substate "github.com/Fantom-foundation/Substate"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/tests"
+)
+
+type testRecorder []*substate.Substate
+
+func (r *testRecorder) RecordSubstate(block uint64, tx int, record *substate.Substate) error {
+	*r = append(*r, record)
+	return nil
+}
+
+var counterAddress = common.HexToAddress("0xc0de")
+
+// recordSubstates records the substates of a chain calling a contract that
+// stores the hash of the previous block and emits a log.
+func recordSubstates(t *testing.T) []*substate.Substate {
+	var (
+		config = params.AllEthashProtocolChanges
+		signer = types.LatestSigner(config)
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		from   = crypto.PubkeyToAddress(key.PublicKey)
+		gspec  = &core.Genesis{
+			Config: config,
+			Alloc: core.GenesisAlloc{
+				from: {Balance: big.NewInt(1000000000000000000)},
+				// SSTORE(NUMBER, BLOCKHASH(NUMBER-1)); LOG0(0, 0)
+				counterAddress: {Code: common.FromHex("6001430340435560006000a0"), Balance: common.Big0},
+			},
+		}
+		db      = rawdb.NewMemoryDatabase()
+		genesis = gspec.MustCommit(db)
+	)
+	blocks, _ := core.GenerateChain(config, genesis, ethash.NewFaker(), db, 2, func(i int, b *core.BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(from), counterAddress, common.Big0, 100000, b.BaseFee(), nil), signer, key)
+		b.AddTx(tx)
+	})
+	recorder := new(testRecorder)
+	db = rawdb.NewMemoryDatabase()
+	gspec.MustCommit(db)
+	chain, _ := core.NewBlockChain(db, nil, config, ethash.NewFaker(), vm.Config{SubstateRecorder: recorder}, nil, nil)
+	defer chain.Stop()
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	return *recorder
+}
+
+// roundTrip encodes and decodes v as JSON into out.
+func roundTrip(t *testing.T, v, out interface{}) {
+	t.Helper()
+	blob, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("failed to encode %T: %v", v, err)
+	}
+	if err := json.Unmarshal(blob, out); err != nil {
+		t.Fatalf("failed to decode %T: %v\n%s", out, err, blob)
+	}
+}
+
+// apply executes the decoded t8n input like the transition command does.
+func apply(t *testing.T, pre *Prestate, txsWithKeys []*txWithKey, fork string) (*ExecutionResult, error) {
+	t.Helper()
+	chainConfig, _, err := tests.GetChainConfig(fork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := types.MakeSigner(chainConfig, new(big.Int).SetUint64(pre.Env.Number))
+	txs, err := signUnsignedTransactions(txsWithKeys, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pre.Senders = unsignedSenders(txsWithKeys)
+	noTracer := func(int, common.Hash) (vm.Tracer, error) { return nil, nil }
+	_, result, err := pre.Apply(vm.Config{}, chainConfig, txs, 0, noTracer)
+	return result, err
+}
+
+func TestFixtureFromSubstate(t *testing.T) {
+	for i, s := range recordSubstates(t) {
+		fixture, err := FixtureFromSubstate(s, "London", params.AllEthashProtocolChanges.ChainID)
+		if err != nil {
+			t.Fatalf("substate %d: failed to convert: %v", i, err)
+		}
+		var (
+			pre         Prestate
+			txsWithKeys []*txWithKey
+			expected    SubstateExpectation
+		)
+		roundTrip(t, fixture.Alloc, &pre.Pre)
+		roundTrip(t, &fixture.Env, &pre.Env)
+		roundTrip(t, fixture.Txs, &txsWithKeys)
+		roundTrip(t, &fixture.Expected, &expected)
+
+		if len(txsWithKeys) != 1 || txsWithKeys[0].sender == nil || *txsWithKeys[0].sender != s.Message.From {
+			t.Fatalf("substate %d: sender lost in encoding", i)
+		}
+		result, err := apply(t, &pre, txsWithKeys, expected.Fork)
+		if err != nil {
+			t.Fatalf("substate %d: transition failed: %v", i, err)
+		}
+		if len(result.Rejected) != 0 {
+			t.Fatalf("substate %d: transaction rejected: %s", i, result.Rejected[0].Err)
+		}
+		if result.StateRoot != expected.StateRoot {
+			t.Errorf("substate %d: state root mismatch: have %x, want %x", i, result.StateRoot, expected.StateRoot)
+		}
+		if result.LogsHash != expected.LogsHash {
+			t.Errorf("substate %d: logs hash mismatch: have %x, want %x", i, result.LogsHash, expected.LogsHash)
+		}
+		if len(expected.Extensions) == 0 {
+			t.Errorf("substate %d: sender extension not flagged", i)
+		}
+	}
+}
+
+func TestUnsignedSendersByIndex(t *testing.T) {
+	var (
+		alice = common.HexToAddress("0xa11ce")
+		bob   = common.HexToAddress("0xb0b")
+		pre   = &Prestate{
+			Env: stEnv{Difficulty: new(big.Int), GasLimit: 1000000, BaseFee: big.NewInt(1)},
+			Pre: core.GenesisAlloc{
+				alice: {Balance: big.NewInt(1000000000)},
+				bob:   {Balance: big.NewInt(1000000000)},
+			},
+		}
+		// Both transactions have the same fields and thus the same hash.
+		tx  = types.NewTransaction(0, counterAddress, common.Big1, 21000, common.Big1, nil)
+		txs = []*txWithKey{{sender: &alice, tx: tx}, {sender: &bob, tx: tx}}
+	)
+	var decoded []*txWithKey
+	roundTrip(t, txs, &decoded)
+	if decoded[0].tx.Hash() != decoded[1].tx.Hash() {
+		t.Fatal("transactions differ")
+	}
+	result, err := apply(t, pre, decoded, "London")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// With a shared sender, the nonce of the second transaction is too low.
+	if len(result.Rejected) != 0 || len(result.Receipts) != 2 {
+		t.Fatalf("unexpected result: %d receipts, rejected %v", len(result.Receipts), result.Rejected)
+	}
+	if pre.Senders[0] != alice || pre.Senders[1] != bob {
+		t.Errorf("wrong senders: %v", pre.Senders)
+	}
+}
@@ -192,6 +192,16 @@ func Main(ctx *cli.Context) error {
 	}
 	// Set the chain id
 	chainConfig.ChainID = big.NewInt(ctx.Int64(ChainIDFlag.Name))
+	if file := ctx.String(StatePrecompilesFlag.Name); file != "" {
+		precompiles, err := LoadStatePrecompiles(file)
+		if err != nil {
+			return NewError(ErrorVMConfig, err)
+		}
+		chainConfig.StatePrecompiles = precompiles
+		if err := vm.CheckStatePrecompiles(chainConfig); err != nil {
+			return NewError(ErrorVMConfig, err)
+		}
+	}
 
 	var txsWithKeys []*txWithKey
 	if txStr != stdinSelector {
@@ -246,6 +256,7 @@ func Main(ctx *cli.Context) error {
 	if txs, err = signUnsignedTransactions(txsWithKeys, signer); err != nil {
 		return NewError(ErrorJson, fmt.Errorf("failed signing transactions: %v", err))
 	}
+	prestate.Senders = unsignedSenders(txsWithKeys)
 	// Sanity check, to not `panic` in state_transition
 	if chainConfig.IsLondon(big.NewInt(int64(prestate.Env.Number))) {
 		if prestate.Env.BaseFee == nil {
@@ -267,19 +278,22 @@ func Main(ctx *cli.Context) error {
 // txWithKey is a helper-struct, to allow us to use the types.Transaction along with
 // a `secretKey`-field, for input
 type txWithKey struct {
-	key *ecdsa.PrivateKey
-	tx  *types.Transaction
+	key    *ecdsa.PrivateKey
+	sender *common.Address // sender of an unsigned transaction without key
+	tx     *types.Transaction
 }
 
 func (t *txWithKey) UnmarshalJSON(input []byte) error {
 	// Read the secretKey, if present
 	type sKey struct {
-		Key *common.Hash `json:"secretKey"`
+		Key    *common.Hash    `json:"secretKey"`
+		Sender *common.Address `json:"sender"`
 	}
 	var key sKey
 	if err := json.Unmarshal(input, &key); err != nil {
 		return err
 	}
+	t.sender = key.Sender
 	if key.Key != nil {
 		k := key.Key.Hex()[2:]
 		if ecdsaKey, err := crypto.HexToECDSA(k); err != nil {
@@ -297,6 +311,44 @@ func (t *txWithKey) UnmarshalJSON(input []byte) error {
 	return nil
 }
 
+// MarshalJSON encodes the transaction in the standard tx json format, along
+// with the `sender` of an unsigned transaction. The `sender` field is an
+// extension of this tool, other t8n implementations reject such transactions.
+func (t *txWithKey) MarshalJSON() ([]byte, error) {
+	enc, err := json.Marshal(t.tx)
+	if err != nil || t.sender == nil {
+		return enc, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(enc, &fields); err != nil {
+		return nil, err
+	}
+	if fields["sender"], err = json.Marshal(t.sender); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+// LoadStatePrecompiles reads the stateful precompiles to activate from a
+// JSON file and checks that they are registered.
+func LoadStatePrecompiles(file string) ([]params.StatePrecompileConfig, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading stateful precompiles: %v", err)
+	}
+	config := new(params.ChainConfig)
+	if err := json.Unmarshal(data, &config.StatePrecompiles); err != nil {
+		return nil, fmt.Errorf("failed unmarshaling stateful precompiles: %v", err)
+	}
+	if err := config.CheckConfigForkOrder(); err != nil {
+		return nil, err
+	}
+	if err := vm.CheckStatePrecompiles(config); err != nil {
+		return nil, err
+	}
+	return config.StatePrecompiles, nil
+}
+
 // signUnsignedTransactions converts the input txs to canonical transactions.
 //
 // The transactions can have two forms, either
@@ -304,6 +356,8 @@ func (t *txWithKey) UnmarshalJSON(input []byte) error {
 //   2. signed
 // For (1), r, s, v, need so be zero, and the `secretKey` needs to be set.
 // If so, we sign it here and now, with the given `secretKey`
+// Unsigned transactions without `secretKey` but with a `sender` are executed
+// on behalf of the sender without signature, see Prestate.Senders.
 // If the condition above is not met, then it's considered a signed transaction.
 //
 // To manage this, we read the transactions twice, first trying to read the secretKeys,
@@ -329,6 +383,19 @@ func signUnsignedTransactions(txs []*txWithKey, signer types.Signer) (types.Tran
 	return signedTxs, nil
 }
 
+// unsignedSenders returns the senders of the unsigned transactions which are
+// executed without signature by transaction index.
+func unsignedSenders(txs []*txWithKey) map[int]common.Address {
+	senders := make(map[int]common.Address)
+	for i, tx := range txs {
+		v, r, s := tx.tx.RawSignatureValues()
+		if tx.key == nil && tx.sender != nil && v.BitLen()+r.BitLen()+s.BitLen() == 0 {
+			senders[i] = *tx.sender
+		}
+	}
+	return senders
+}
+
 type Alloc map[common.Address]core.GenesisAccount
 
 func (g Alloc) OnRoot(common.Hash) {}
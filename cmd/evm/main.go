@@ -129,6 +129,10 @@ var (
 		Name:  "noreturndata",
 		Usage: "disable return data output",
 	}
+	DifferentialFlag = cli.StringFlag{
+		Name:  "differential",
+		Usage: "comma separated interpreters to execute and compare call frame by call frame, the first one is the reference",
+	}
 )
 
 var stateTransitionCommand = cli.Command{
@@ -150,6 +154,7 @@ var stateTransitionCommand = cli.Command{
 		t8ntool.InputTxsFlag,
 		t8ntool.ForknameFlag,
 		t8ntool.ChainIDFlag,
+		t8ntool.StatePrecompilesFlag,
 		t8ntool.RewardFlag,
 		t8ntool.VerbosityFlag,
 	},
@@ -180,10 +185,18 @@ func init() {
 		DisableStackFlag,
 		DisableStorageFlag,
 		DisableReturnDataFlag,
+		DifferentialFlag,
+		t8ntool.StatePrecompilesFlag,
 	}
 	app.Commands = []cli.Command{
 		compileCommand,
+		convertCommand,
+		corpusCommand,
 		disasmCommand,
+		heatmapCommand,
+		minimizeCommand,
+		replayCommand,
+		debugCommand,
 		runCommand,
 		stateTestCommand,
 		stateTransitionCommand,
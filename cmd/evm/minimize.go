@@ -0,0 +1,144 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/core/replay"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/tests"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var MinimizeOutputFlag = cli.StringFlag{
+	Name:  "minimize.out",
+	Usage: "File the reduced state test is written to, stdout if empty",
+}
+
+var minimizeCommand = cli.Command{
+	Action:    minimizeCmd,
+	Name:      "minimize",
+	Usage:     "reduces a failing substate to a minimal state test",
+	ArgsUsage: "<blockNum> <txIndex>",
+	Description: `
+The minimize command removes accounts, storage slots and block hashes from the
+input alloc of a recorded substate while its failure still reproduces, and
+writes the reduced case as a state test runnable with evm statetest.
+
+With --differential the failure is a divergence between the given
+interpreters. Otherwise it is the error or panic of the execution with
+--interpreter or, if the execution succeeds, by default a mismatch of the
+replay against the recorded output. If the replay matches the output, the
+failure is a divergence between geth and --interpreter. The expected post
+state of the test is the one produced by the reference interpreter, the first
+one of --differential or geth.`,
+	Flags: []cli.Flag{
+		SubstateDirFlag,
+		InterpreterFlag,
+		DifferentialFlag,
+		MinimizeOutputFlag,
+	},
+}
+
+func minimizeCmd(ctx *cli.Context) error {
+	if len(ctx.Args()) != 2 {
+		return errors.New("substate <blockNum> <txIndex> required")
+	}
+	block, err := strconv.ParseUint(ctx.Args().Get(0), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid block: %v", err)
+	}
+	tx, err := strconv.Atoi(ctx.Args().Get(1))
+	if err != nil {
+		return fmt.Errorf("invalid transaction index: %v", err)
+	}
+	db, err := openSubstateDB(ctx, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if !db.HasSubstate(block, tx) {
+		return fmt.Errorf("substate %d_%d not found", block, tx)
+	}
+	s := db.GetSubstate(block, tx)
+
+	var (
+		interpreter  = ctx.String(InterpreterFlag.Name)
+		interpreters = splitInterpreters(ctx.String(DifferentialFlag.Name))
+		cfg          = &replay.Config{VMConfig: vm.Config{InterpreterImpl: interpreter}}
+		reproduces   replay.Reproducer
+	)
+	switch {
+	case len(interpreters) > 0:
+		reproduces = replay.Diverges(tx, cfg, interpreters)
+	default:
+		if err := replay.ExecuteRecover(tx, s, cfg); err != nil {
+			log.Info("Minimizing execution error", "err", err)
+			reproduces = replay.FailsWith(tx, cfg, err.Error())
+		} else if mismatches := replay.Mismatches(block, tx, s, cfg); mismatches(s) {
+			log.Info("Minimizing output mismatch")
+			reproduces = mismatches
+		} else if interpreter != "geth" {
+			interpreters = []string{"geth", interpreter}
+			reproduces = replay.Diverges(tx, cfg, interpreters)
+		} else {
+			return fmt.Errorf("substate %d_%d does not fail, select the interpreters to compare with --differential", block, tx)
+		}
+	}
+	min, err := replay.Minimize(s, reproduces)
+	if err != nil {
+		return fmt.Errorf("substate %d_%d: %v", block, tx, err)
+	}
+	log.Info("Minimized substate", "accounts", fmt.Sprintf("%d/%d", len(min.InputAlloc), len(s.InputAlloc)),
+		"blockhashes", fmt.Sprintf("%d/%d", len(min.Env.BlockHashes), len(s.Env.BlockHashes)))
+
+	// The output alloc of the minimized substate is stale, so the expected post
+	// state is re-computed with the reference interpreter.
+	fork := tests.ForkName(replay.DefaultChainConfig(), new(big.Int).SetUint64(min.Env.Number))
+	test, err := tests.StateTestFromSubstate(min, fork)
+	if err != nil {
+		return err
+	}
+	var (
+		subtest = tests.StateSubtest{Fork: fork}
+		ref     = vm.Config{InterpreterImpl: "geth"}
+	)
+	if len(interpreters) > 0 {
+		ref.InterpreterImpl = interpreters[0]
+	}
+	if err := test.FillPostState(subtest, ref); err != nil {
+		log.Warn("Failed to fill expected post state", "interpreter", ref.InterpreterImpl, "err", err)
+	}
+	out, err := json.MarshalIndent(map[string]*tests.StateTest{fmt.Sprintf("%d_%d", block, tx): test}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if file := ctx.String(MinimizeOutputFlag.Name); file != "" {
+		return ioutil.WriteFile(file, out, 0644)
+	}
+	_, err = os.Stdout.Write(append(out, '\n'))
+	return err
+}
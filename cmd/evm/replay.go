@@ -0,0 +1,284 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	substate "github.com/Fantom-foundation/Substate"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/replay"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	SubstateDirFlag = cli.StringFlag{
+		Name:  "substatedir",
+		Usage: "Data directory of the substate database",
+		Value: "./substate.fantom",
+	}
+	WorkersFlag = cli.IntFlag{
+		Name:  "workers",
+		Usage: "Number of worker threads that execute in parallel",
+		Value: 4,
+	}
+	InterpreterFlag = cli.StringFlag{
+		Name:  "interpreter",
+		Usage: "EVM interpreter implementation to use (registered via vm.RegisterInterpreterFactory)",
+		Value: "geth",
+	}
+	SkipTransferTxsFlag = cli.BoolFlag{
+		Name:  "skip-transfer-txs",
+		Usage: "Skip executing transactions that only transfer ETH",
+	}
+	SkipCallTxsFlag = cli.BoolFlag{
+		Name:  "skip-call-txs",
+		Usage: "Skip executing CALL transactions to accounts with contract bytecode",
+	}
+	SkipCreateTxsFlag = cli.BoolFlag{
+		Name:  "skip-create-txs",
+		Usage: "Skip executing CREATE transactions",
+	}
+	ReplayCheckpointFlag = cli.StringFlag{
+		Name:  "replay.checkpoint",
+		Usage: "File recording the completed blocks, an interrupted replay resumes from it",
+	}
+	ReplayShardSizeFlag = cli.Uint64Flag{
+		Name:  "replay.shardsize",
+		Usage: "Number of consecutive blocks replayed by a worker at once",
+		Value: replay.DefaultShardSize,
+	}
+	DiffJSONFlag = cli.BoolFlag{
+		Name:  "diff.json",
+		Usage: "Write the report of a mismatching or diverging transaction as JSON to stdout",
+	}
+	SuperInstructionsFlag = cli.IntFlag{
+		Name:  "superinstructions",
+		Usage: "Profile opcode sequences of length 2..n and report the ranked super-instruction candidates",
+	}
+	SuperInstructionsTopFlag = cli.IntFlag{
+		Name:  "superinstructions.top",
+		Usage: "Number of super-instruction candidates reported (0 for all)",
+		Value: 50,
+	}
+)
+
+// replayProfilerBufferSize is the number of contract invocations the
+// profiler of a replay queues before the workers block.
+const replayProfilerBufferSize = 4096
+
+var replayCommand = cli.Command{
+	Action:    replayCmd,
+	Name:      "replay",
+	Usage:     "replays recorded substates and compares the results",
+	ArgsUsage: "<blockNumFirst> <blockNumLast>",
+	Description: `
+The replay command executes the transactions of a substate database in the
+given block range and compares the resulting output alloc and receipt with the
+recorded ones. The first mismatch is reported with the differing account
+fields, storage slots and receipt fields and aborts the replay.
+
+With --differential, every transaction is instead executed with each of the
+given interpreters and the executions are compared with the one of the first
+interpreter. The first diverging call frame or outcome aborts the replay.
+
+The block range is split into shards of --replay.shardsize blocks replayed by
+--workers concurrent workers. With --replay.checkpoint, completed shards are
+recorded in the given file and a replay restarted with the same file skips
+them. The file also records the block range, interpreters and skip flags, a
+replay with different ones refuses to resume from it.
+
+With --superinstructions n, the opcode sequences of length 2..n executed by
+all workers are profiled and the sequences ranked by frequency times the saved
+instruction dispatches are printed once the replay completed.`,
+	Flags: []cli.Flag{
+		SubstateDirFlag,
+		WorkersFlag,
+		InterpreterFlag,
+		SkipTransferTxsFlag,
+		SkipCallTxsFlag,
+		SkipCreateTxsFlag,
+		ReplayCheckpointFlag,
+		ReplayShardSizeFlag,
+		DiffJSONFlag,
+		DifferentialFlag,
+		SuperInstructionsFlag,
+		SuperInstructionsTopFlag,
+	},
+}
+
+// parseBlockRange parses the first two command arguments as an inclusive
+// block range.
+func parseBlockRange(ctx *cli.Context) (uint64, uint64, error) {
+	if len(ctx.Args()) != 2 {
+		return 0, 0, errors.New("block range <blockNumFirst> <blockNumLast> required")
+	}
+	first, err := strconv.ParseUint(ctx.Args().Get(0), 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid first block: %v", err)
+	}
+	last, err := strconv.ParseUint(ctx.Args().Get(1), 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid last block: %v", err)
+	}
+	if first > last {
+		return 0, 0, fmt.Errorf("first block %d is larger than last block %d", first, last)
+	}
+	return first, last, nil
+}
+
+// splitInterpreters splits the comma separated interpreter names of the
+// --differential flag.
+func splitInterpreters(names string) []string {
+	var interpreters []string
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			interpreters = append(interpreters, name)
+		}
+	}
+	return interpreters
+}
+
+// openSubstateDB opens the substate database given by --substatedir.
+func openSubstateDB(ctx *cli.Context, readOnly bool) (*substate.SubstateDB, error) {
+	dir := ctx.String(SubstateDirFlag.Name)
+	backend, err := rawdb.NewLevelDBDatabase(dir, 1024, 100, "substatedir", readOnly)
+	if err != nil {
+		return nil, fmt.Errorf("error opening substate database %s: %v", dir, err)
+	}
+	return substate.NewSubstateDB(backend), nil
+}
+
+// newSubstateTaskPool creates a task pool iterating over the substates of the
+// given block range with the worker and skip flags of the command.
+func newSubstateTaskPool(ctx *cli.Context, name string, db *substate.SubstateDB, first, last uint64, task substate.SubstateTaskFunc) *substate.SubstateTaskPool {
+	return &substate.SubstateTaskPool{
+		Name:            name,
+		TaskFunc:        task,
+		First:           first,
+		Last:            last,
+		Workers:         ctx.Int(WorkersFlag.Name),
+		SkipTransferTxs: ctx.Bool(SkipTransferTxsFlag.Name),
+		SkipCallTxs:     ctx.Bool(SkipCallTxsFlag.Name),
+		SkipCreateTxs:   ctx.Bool(SkipCreateTxsFlag.Name),
+		DB:              db,
+	}
+}
+
+// skipSubstate reports whether the transaction of the given substate is
+// excluded by the skip flags of the command.
+func skipSubstate(ctx *cli.Context, s *substate.Substate) bool {
+	to := s.Message.To
+	if to == nil {
+		return ctx.Bool(SkipCreateTxsFlag.Name)
+	}
+	if account, ok := s.InputAlloc[*to]; ok && len(account.Code) > 0 {
+		return ctx.Bool(SkipCallTxsFlag.Name)
+	}
+	return ctx.Bool(SkipTransferTxsFlag.Name)
+}
+
+func replayCmd(ctx *cli.Context) error {
+	first, last, err := parseBlockRange(ctx)
+	if err != nil {
+		return err
+	}
+	db, err := openSubstateDB(ctx, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var (
+		interpreters = splitInterpreters(ctx.String(DifferentialFlag.Name))
+		reportJSON   = ctx.Bool(DiffJSONFlag.Name)
+		reportLock   sync.Mutex
+		profiler     *vm.MicroProfiler
+	)
+	// All workers share one profiler, its statistic covers the whole range.
+	if n := ctx.Int(SuperInstructionsFlag.Name); n > 0 {
+		profiler = vm.NewMicroProfiler(replayProfilerBufferSize)
+		if err := profiler.SetMaxNGramLength(n); err != nil {
+			profiler.Close()
+			return err
+		}
+	}
+	report := func(v interface{}) {
+		if !reportJSON {
+			return
+		}
+		reportLock.Lock()
+		defer reportLock.Unlock()
+
+		out, _ := json.MarshalIndent(v, "", "  ")
+		fmt.Println(string(out))
+	}
+	task := func(block uint64, tx int, s *substate.Substate, cfg *replay.Config) error {
+		if len(interpreters) > 0 {
+			err := replay.Differential(replay.SubstateExecution(tx, s, cfg), interpreters)
+			if err == nil {
+				return nil
+			}
+			if divergence, ok := err.(*replay.DivergenceError); ok {
+				report(divergence)
+			}
+			return fmt.Errorf("transaction %d_%d: %v", block, tx, err)
+		}
+		err := replay.Replay(block, tx, s, cfg)
+		if mismatch, ok := err.(*replay.MismatchError); ok {
+			report(mismatch)
+		}
+		return err
+	}
+	sched := &replay.Scheduler{
+		Source:     db,
+		Range:      replay.Range{First: first, Last: last},
+		Workers:    ctx.Int(WorkersFlag.Name),
+		ShardSize:  ctx.Uint64(ReplayShardSizeFlag.Name),
+		Checkpoint: ctx.String(ReplayCheckpointFlag.Name),
+		Options: map[string]string{
+			InterpreterFlag.Name:     ctx.String(InterpreterFlag.Name),
+			DifferentialFlag.Name:    ctx.String(DifferentialFlag.Name),
+			SkipTransferTxsFlag.Name: fmt.Sprint(ctx.Bool(SkipTransferTxsFlag.Name)),
+			SkipCallTxsFlag.Name:     fmt.Sprint(ctx.Bool(SkipCallTxsFlag.Name)),
+			SkipCreateTxsFlag.Name:   fmt.Sprint(ctx.Bool(SkipCreateTxsFlag.Name)),
+		},
+		Skip: func(s *substate.Substate) bool { return skipSubstate(ctx, s) },
+		NewConfig: func(int) *replay.Config {
+			return &replay.Config{VMConfig: vm.Config{
+				InterpreterImpl: ctx.String(InterpreterFlag.Name),
+				MicroProfiler:   profiler,
+			}}
+		},
+		Task: task,
+	}
+	_, err = sched.Run()
+	if profiler != nil {
+		stats := profiler.Close()
+		if err == nil {
+			err = stats.WriteSuperInstructionReport(os.Stdout, ctx.Int(SuperInstructionsTopFlag.Name))
+		}
+	}
+	return err
+}
@@ -33,6 +33,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/replay"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/core/vm/runtime"
@@ -263,6 +264,39 @@ func runCmd(ctx *cli.Context) error {
 		}
 	}
 
+	// Compare the interpreters on copies of the state before the actual run
+	if interpreters := splitInterpreters(ctx.GlobalString(DifferentialFlag.Name)); len(interpreters) > 0 {
+		exec := func(cfg vm.Config) (*replay.Outcome, error) {
+			config := runtimeConfig
+			config.State = statedb.Copy()
+			config.State.EnableSubstateRecording()
+			config.EVMConfig.InterpreterImpl = cfg.InterpreterImpl
+			config.EVMConfig.Debug, config.EVMConfig.Tracer = cfg.Debug, cfg.Tracer
+
+			var (
+				output      []byte
+				leftOverGas uint64
+				err         error
+			)
+			if ctx.GlobalBool(CreateFlag.Name) {
+				output, _, leftOverGas, err = runtime.Create(input, &config)
+			} else {
+				output, leftOverGas, err = runtime.Call(receiver, input, &config)
+			}
+			return &replay.Outcome{
+				State:      config.State,
+				Root:       config.State.IntermediateRoot(true),
+				GasUsed:    initialGas - leftOverGas,
+				Logs:       config.State.Logs(),
+				ReturnData: output,
+				Err:        err,
+			}, nil
+		}
+		if err := replay.Differential(exec, interpreters); err != nil {
+			return err
+		}
+	}
+
 	bench := ctx.GlobalBool(BenchFlag.Name)
 	output, leftOverGas, stats, err := timedExec(bench, execFunc)
 
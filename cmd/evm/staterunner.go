@@ -23,9 +23,12 @@ import (
 	"io/ioutil"
 	"os"
 
+	"github.com/ethereum/go-ethereum/cmd/evm/internal/t8ntool"
+	"github.com/ethereum/go-ethereum/core/replay"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/tests"
 
 	"gopkg.in/urfave/cli.v1"
@@ -48,6 +51,21 @@ type StatetestResult struct {
 	State *state.Dump `json:"state,omitempty"`
 }
 
+// stateTestExecution returns an Execution of a state test for differential
+// execution. Every execution runs on a fresh pre-state.
+func stateTestExecution(test *tests.StateTest, subtest tests.StateSubtest, cfg vm.Config) replay.Execution {
+	return func(vmConfig vm.Config) (*replay.Outcome, error) {
+		config := cfg
+		config.InterpreterImpl = vmConfig.InterpreterImpl
+		config.Debug, config.Tracer = vmConfig.Debug, vmConfig.Tracer
+		_, statedb, root, gasUsed, err := test.RunNoVerify(subtest, config, false)
+		if err != nil {
+			return nil, err
+		}
+		return &replay.Outcome{State: statedb, Root: root, Logs: statedb.Logs(), GasUsed: gasUsed}, nil
+	}
+}
+
 func stateTestCmd(ctx *cli.Context) error {
 	if len(ctx.Args().First()) == 0 {
 		return errors.New("path-to-test argument required")
@@ -93,11 +111,26 @@ func stateTestCmd(ctx *cli.Context) error {
 		Tracer: tracer,
 		Debug:  ctx.GlobalBool(DebugFlag.Name) || ctx.GlobalBool(MachineFlag.Name),
 	}
+	var precompiles []params.StatePrecompileConfig
+	if file := ctx.GlobalString(t8ntool.StatePrecompilesFlag.Name); file != "" {
+		if precompiles, err = t8ntool.LoadStatePrecompiles(file); err != nil {
+			return err
+		}
+	}
+	interpreters := splitInterpreters(ctx.GlobalString(DifferentialFlag.Name))
 	results := make([]StatetestResult, 0, len(tests))
 	for key, test := range tests {
+		test.StatePrecompiles = precompiles
 		for _, st := range test.Subtests() {
 			// Run the test and aggregate the result
 			result := &StatetestResult{Name: key, Fork: st.Fork, Pass: true}
+			if len(interpreters) > 0 {
+				if err := replay.Differential(stateTestExecution(&test, st, cfg), interpreters); err != nil {
+					result.Pass, result.Error = false, err.Error()
+					results = append(results, *result)
+					continue
+				}
+			}
 			_, s, err := test.Run(st, cfg, false)
 			// print state root for evmlab tracing
 			if ctx.GlobalBool(MachineFlag.Name) && s != nil {
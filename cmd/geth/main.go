@@ -239,6 +239,8 @@ func init() {
 		utils.ShowDeprecated,
 		// See snapshot.go
 		snapshotCommand,
+		// See tracecmd.go
+		traceCommand,
 	}
 	sort.Sort(cli.CommandsByName(app.Commands))
 
@@ -0,0 +1,184 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/eth/downloader"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/ethereum/go-ethereum/log"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	traceTracerFlag = cli.StringFlag{
+		Name:  "tracer",
+		Usage: "Name of the native or JavaScript tracer to run",
+		Value: "callTracer",
+	}
+	traceTracerConfigFlag = cli.StringFlag{
+		Name:  "tracer.config",
+		Usage: "JSON config passed to the native tracer",
+	}
+	traceOutputFlag = cli.StringFlag{
+		Name:  "output",
+		Usage: "Directory the trace shards and manifest are written to",
+	}
+	traceWorkersFlag = cli.IntFlag{
+		Name:  "workers",
+		Usage: "Number of shards traced concurrently",
+		Value: runtime.NumCPU(),
+	}
+	traceShardSizeFlag = cli.Uint64Flag{
+		Name:  "shardsize",
+		Usage: "Number of blocks written to a shard",
+		Value: tracers.DefaultExportShardSize,
+	}
+	traceReexecFlag = cli.Uint64Flag{
+		Name:  "reexec",
+		Usage: "Number of blocks to re-execute to regenerate missing historical state",
+		Value: 128,
+	}
+	traceTimeoutFlag = cli.DurationFlag{
+		Name:  "timeout",
+		Usage: "Maximum time the tracer may spend on a transaction",
+		Value: 5 * time.Second,
+	}
+
+	traceCommand = cli.Command{
+		Name:      "trace",
+		Usage:     "Offline transaction tracing",
+		ArgsUsage: "",
+		Category:  "BLOCKCHAIN COMMANDS",
+		Subcommands: []cli.Command{
+			{
+				Name:      "export",
+				Usage:     "Export the traces of a block range into compressed files",
+				ArgsUsage: "<firstBlock> <lastBlock>",
+				Action:    utils.MigrateFlags(exportTraces),
+				Category:  "BLOCKCHAIN COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+					utils.CacheFlag,
+					utils.SyncModeFlag,
+					utils.RopstenFlag,
+					utils.RinkebyFlag,
+					utils.GoerliFlag,
+					traceTracerFlag,
+					traceTracerConfigFlag,
+					traceOutputFlag,
+					traceWorkersFlag,
+					traceShardSizeFlag,
+					traceReexecFlag,
+					traceTimeoutFlag,
+				},
+				Description: `
+geth trace export --output <dir> <firstBlock> <lastBlock>
+runs the tracer over every transaction of the given block range against the
+local database. The blocks are split into shards of --shardsize blocks which
+are traced by --workers workers concurrently. Every shard is written to a
+gzip compressed file holding one JSON object per transaction and line.
+
+The completed shards are recorded in the manifest.json file of the output
+directory. An interrupted export is resumed by running the same command
+again, only the shards missing from the manifest are traced.`,
+			},
+		},
+	}
+)
+
+// exportTraces traces a block range of the local chain into the output
+// directory.
+func exportTraces(ctx *cli.Context) error {
+	if len(ctx.Args()) != 2 {
+		utils.Fatalf("This command requires two arguments.")
+	}
+	first, err := strconv.ParseUint(ctx.Args().Get(0), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid first block: %v", err)
+	}
+	last, err := strconv.ParseUint(ctx.Args().Get(1), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid last block: %v", err)
+	}
+	dir := ctx.String(traceOutputFlag.Name)
+	if dir == "" {
+		return errors.New("no output directory specified")
+	}
+	var (
+		tracer  = ctx.String(traceTracerFlag.Name)
+		reexec  = ctx.Uint64(traceReexecFlag.Name)
+		timeout = ctx.Duration(traceTimeoutFlag.Name).String()
+		config  = &tracers.TraceConfig{Tracer: &tracer, Reexec: &reexec, Timeout: &timeout}
+	)
+	if cfg := ctx.String(traceTracerConfigFlag.Name); cfg != "" {
+		if !json.Valid([]byte(cfg)) {
+			return errors.New("invalid tracer config")
+		}
+		config.TracerConfig = json.RawMessage(cfg)
+	}
+	stack, cfg := makeConfigNode(ctx)
+	defer stack.Close()
+
+	if cfg.Eth.SyncMode == downloader.LightSync {
+		return errors.New("trace export is not supported by light clients")
+	}
+	_, eth := utils.RegisterEthService(stack, &cfg.Eth)
+
+	// Stop the export on interrupt, the completed shards remain in the manifest.
+	exportCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigc)
+	go func() {
+		select {
+		case <-sigc:
+			log.Info("Got interrupt, stopping trace export")
+			cancel()
+		case <-exportCtx.Done():
+		}
+	}()
+	start := time.Now()
+	manifest, err := tracers.ExportTraces(exportCtx, eth.APIBackend, &tracers.ExportConfig{
+		Dir:       dir,
+		First:     first,
+		Last:      last,
+		ShardSize: ctx.Uint64(traceShardSizeFlag.Name),
+		Workers:   ctx.Int(traceWorkersFlag.Name),
+		Trace:     config,
+	})
+	if err != nil {
+		return err
+	}
+	log.Info("Exported traces", "first", first, "last", last, "shards", len(manifest.Shards), "dir", dir, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
@@ -217,6 +217,8 @@ func GenerateChain(config *params.ChainConfig, parent *types.Block, engine conse
 		if config.DAOForkSupport && config.DAOForkBlock != nil && config.DAOForkBlock.Cmp(b.header.Number) == 0 {
 			misc.ApplyDAOHardFork(statedb)
 		}
+		vm.ActivateStatePrecompiles(config, b.header.Number, statedb)
+
 		// Execute any user modifications to the block
 		if gen != nil {
 			gen(i, b)
@@ -0,0 +1,214 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package corpus extracts the contract bytecodes touched by recorded substates
+// into a corpus deduplicated by code hash.
+package corpus
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	substate "github.com/Fantom-foundation/Substate"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// IndexFile is the name of the file holding the entries of a written corpus.
+const IndexFile = "corpus.json"
+
+// Deployment identifies the transaction which deployed a contract.
+type Deployment struct {
+	Block   uint64         `json:"block"`
+	Tx      int            `json:"tx"`
+	Sender  common.Address `json:"sender"`  // sender of the deploying transaction
+	Creator common.Address `json:"creator"` // account executing the CREATE, zero if unknown
+	Direct  bool           `json:"direct"`  // true if deployed by a CREATE transaction
+}
+
+// before reports whether d happened before the deployment o.
+func (d *Deployment) before(o *Deployment) bool {
+	return d.Block < o.Block || (d.Block == o.Block && d.Tx < o.Tx)
+}
+
+// Entry describes a runtime bytecode of the corpus.
+//
+// A transaction touches a code if an account holding it is part of the
+// transaction's substate, which includes accounts whose code was not
+// executed, e.g. accounts whose balance or code size was queried. The
+// executions of a code are counted separately from the trace of the replayed
+// transaction. The substates only record the state before and after a
+// transaction, so codes deployed and self-destructed within the same
+// transaction are missing.
+type Entry struct {
+	CodeHash    common.Hash   `json:"codeHash"`
+	Code        hexutil.Bytes `json:"-"`
+	Size        int           `json:"size"`
+	FirstBlock  uint64        `json:"firstBlock"`  // first block the code was seen in
+	LastBlock   uint64        `json:"lastBlock"`   // last block the code was seen in
+	TouchingTxs uint64        `json:"touchingTxs"` // number of transactions touching the code
+	Invocations uint64        `json:"invocations"` // number of times the code was executed
+	Deployments uint64        `json:"deployments"` // number of observed deployments
+	DeployedBy  *Deployment   `json:"deployedBy,omitempty"`
+	Metadata    *Metadata     `json:"metadata,omitempty"`
+}
+
+// Corpus collects the runtime bytecodes of substates. It is safe for
+// concurrent use.
+type Corpus struct {
+	entries map[common.Hash]*Entry
+	lock    sync.Mutex
+}
+
+// New creates an empty corpus.
+func New() *Corpus {
+	return &Corpus{entries: make(map[common.Hash]*Entry)}
+}
+
+// Add adds the bytecodes of all accounts touched by the given substate. A
+// code present in the output alloc but not in the input alloc of an account
+// is counted as deployed by the transaction. The trace of the replayed
+// transaction provides the executions and the creators of the codes, it may
+// be nil if the transaction was not replayed.
+func (c *Corpus) Add(block uint64, tx int, s *substate.Substate, trace *Trace) {
+	if trace == nil {
+		trace = NewTrace()
+	}
+	// Collect the codes first so that a code shared by several accounts is
+	// only counted once per transaction.
+	var (
+		codes    = make(map[common.Hash][]byte)
+		deployed = make(map[common.Hash]*Deployment)
+	)
+	for _, account := range s.InputAlloc {
+		if len(account.Code) > 0 {
+			codes[crypto.Keccak256Hash(account.Code)] = account.Code
+		}
+	}
+	for addr, account := range s.OutputAlloc {
+		if len(account.Code) == 0 {
+			continue
+		}
+		hash := crypto.Keccak256Hash(account.Code)
+		codes[hash] = account.Code
+		if input, ok := s.InputAlloc[addr]; ok && len(input.Code) > 0 {
+			continue
+		}
+		deployment := &Deployment{Block: block, Tx: tx, Sender: s.Message.From, Creator: trace.Creators[addr]}
+		if s.Message.To == nil && s.Result.ContractAddress == addr {
+			deployment.Direct = true
+		}
+		if prev, ok := deployed[hash]; !ok || (!prev.Direct && deployment.Direct) {
+			deployed[hash] = deployment
+		}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for hash, code := range codes {
+		entry, ok := c.entries[hash]
+		if !ok {
+			entry = &Entry{
+				CodeHash:   hash,
+				Code:       common.CopyBytes(code),
+				Size:       len(code),
+				FirstBlock: block,
+				LastBlock:  block,
+				Metadata:   ParseMetadata(code),
+			}
+			c.entries[hash] = entry
+		}
+		if block < entry.FirstBlock {
+			entry.FirstBlock = block
+		}
+		if block > entry.LastBlock {
+			entry.LastBlock = block
+		}
+		entry.TouchingTxs++
+		entry.Invocations += trace.Invocations[hash]
+
+		if deployment, ok := deployed[hash]; ok {
+			entry.Deployments++
+			// Substates may be added out of order, keep the earliest deployment.
+			if entry.DeployedBy == nil || deployment.before(entry.DeployedBy) {
+				entry.DeployedBy = deployment
+			}
+		}
+	}
+}
+
+// Len returns the number of distinct codes in the corpus.
+func (c *Corpus) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.entries)
+}
+
+// Entry returns the entry of the given code hash, or nil if the code is not
+// part of the corpus.
+func (c *Corpus) Entry(hash common.Hash) *Entry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.entries[hash]
+}
+
+// Entries returns the entries of the corpus ordered by the block they were
+// first seen in, ties are broken by code hash.
+func (c *Corpus) Entries() []*Entry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entries := make([]*Entry, 0, len(c.entries))
+	for _, entry := range c.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].FirstBlock != entries[j].FirstBlock {
+			return entries[i].FirstBlock < entries[j].FirstBlock
+		}
+		return entries[i].CodeHash.Hex() < entries[j].CodeHash.Hex()
+	})
+	return entries
+}
+
+// Write writes the corpus to the given directory. Every code is stored hex
+// encoded in a file named after its code hash, so it can be passed to
+// evm --codefile or evm disasm, and the entries are stored in IndexFile.
+func (c *Corpus) Write(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	entries := c.Entries()
+	for _, entry := range entries {
+		name := filepath.Join(dir, entry.CodeHash.Hex()[2:]+".hex")
+		if err := ioutil.WriteFile(name, []byte(common.Bytes2Hex(entry.Code)), 0644); err != nil {
+			return fmt.Errorf("failed to write code %x: %v", entry.CodeHash, err)
+		}
+	}
+	index, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, IndexFile), index, 0644)
+}
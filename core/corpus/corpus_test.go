@@ -0,0 +1,136 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package corpus
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	substate "github.com/Fantom-foundation/Substate"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var (
+	sender   = common.HexToAddress("0xaaaa")
+	contract = common.HexToAddress("0xc0de")
+	clone    = common.HexToAddress("0xc1de")
+	code     = withTrailer(common.FromHex("6080604052600080fd"), "a164736f6c6343000811")
+)
+
+// newTestSubstate creates a substate of a transaction sent by sender with the
+// given input and output allocs.
+func newTestSubstate(to *common.Address, input, output substate.SubstateAlloc) *substate.Substate {
+	return substate.NewSubstate(input, output, &substate.SubstateEnv{},
+		&substate.SubstateMessage{From: sender, To: to},
+		&substate.SubstateResult{ContractAddress: contract})
+}
+
+// newTestTrace creates a trace executing code n times and holding the given
+// creators.
+func newTestTrace(n uint64, creators map[common.Address]common.Address) *Trace {
+	trace := NewTrace()
+	if n > 0 {
+		trace.Invocations[crypto.Keccak256Hash(code)] = n
+	}
+	for addr, creator := range creators {
+		trace.Creators[addr] = creator
+	}
+	return trace
+}
+
+func TestCorpusAdd(t *testing.T) {
+	var (
+		c     = New()
+		empty = substate.NewSubstateAccount(0, big.NewInt(0), nil)
+		acc   = substate.NewSubstateAccount(1, big.NewInt(0), code)
+		hash  = crypto.Keccak256Hash(code)
+	)
+	// Calls are added out of order, followed by the deployments. The first
+	// call executes the code twice, the second one was not replayed.
+	c.Add(7, 0, newTestSubstate(&contract, substate.SubstateAlloc{contract: acc, clone: acc}, substate.SubstateAlloc{contract: acc}), newTestTrace(2, nil))
+	c.Add(5, 1, newTestSubstate(&contract, substate.SubstateAlloc{contract: acc}, substate.SubstateAlloc{contract: acc}), nil)
+	c.Add(3, 0, newTestSubstate(&contract, substate.SubstateAlloc{contract: acc}, substate.SubstateAlloc{clone: acc}), newTestTrace(1, map[common.Address]common.Address{clone: contract}))
+	c.Add(2, 4, newTestSubstate(nil, substate.SubstateAlloc{contract: empty}, substate.SubstateAlloc{contract: acc}), newTestTrace(0, map[common.Address]common.Address{contract: sender}))
+
+	if c.Len() != 1 {
+		t.Fatalf("wrong number of entries: have %d, want 1", c.Len())
+	}
+	entry := c.Entry(hash)
+	if entry == nil {
+		t.Fatalf("code %x missing", hash)
+	}
+	if entry.Size != len(code) || entry.FirstBlock != 2 || entry.LastBlock != 7 {
+		t.Errorf("wrong entry: size %d, blocks %d-%d", entry.Size, entry.FirstBlock, entry.LastBlock)
+	}
+	if entry.TouchingTxs != 4 {
+		t.Errorf("wrong touching transaction count: have %d, want 4", entry.TouchingTxs)
+	}
+	if entry.Invocations != 3 {
+		t.Errorf("wrong invocation count: have %d, want 3", entry.Invocations)
+	}
+	if entry.Deployments != 2 {
+		t.Errorf("wrong deployment count: have %d, want 2", entry.Deployments)
+	}
+	want := Deployment{Block: 2, Tx: 4, Sender: sender, Creator: sender, Direct: true}
+	if entry.DeployedBy == nil || *entry.DeployedBy != want {
+		t.Errorf("wrong deployment: have %+v, want %+v", entry.DeployedBy, want)
+	}
+	// Without the direct deployment, the internal one is kept along with the
+	// contract executing the CREATE.
+	c = New()
+	c.Add(3, 0, newTestSubstate(&contract, substate.SubstateAlloc{contract: acc}, substate.SubstateAlloc{clone: acc}), newTestTrace(1, map[common.Address]common.Address{clone: contract}))
+	want = Deployment{Block: 3, Tx: 0, Sender: sender, Creator: contract}
+	if entry := c.Entry(hash); entry == nil || entry.DeployedBy == nil || *entry.DeployedBy != want {
+		t.Errorf("wrong internal deployment: have %+v, want %+v", entry, want)
+	}
+	if entry.Metadata == nil || entry.Metadata.Fingerprint() != "solc 0.8.17" {
+		t.Errorf("wrong metadata: %+v", entry.Metadata)
+	}
+}
+
+func TestCorpusWrite(t *testing.T) {
+	c := New()
+	c.Add(1, 0, newTestSubstate(&contract, substate.SubstateAlloc{contract: substate.NewSubstateAccount(0, big.NewInt(0), code)}, nil), nil)
+
+	dir := t.TempDir()
+	if err := c.Write(dir); err != nil {
+		t.Fatalf("failed to write corpus: %v", err)
+	}
+	hash := crypto.Keccak256Hash(code)
+	hex, err := ioutil.ReadFile(filepath.Join(dir, hash.Hex()[2:]+".hex"))
+	if err != nil {
+		t.Fatalf("failed to read code: %v", err)
+	}
+	if have := common.FromHex(string(hex)); string(have) != string(code) {
+		t.Errorf("code mismatch: have %x, want %x", have, code)
+	}
+	index, err := ioutil.ReadFile(filepath.Join(dir, IndexFile))
+	if err != nil {
+		t.Fatalf("failed to read index: %v", err)
+	}
+	var entries []*Entry
+	if err := json.Unmarshal(index, &entries); err != nil {
+		t.Fatalf("failed to decode index: %v", err)
+	}
+	if len(entries) != 1 || entries[0].CodeHash != hash || entries[0].TouchingTxs != 1 {
+		t.Errorf("wrong index: %s", index)
+	}
+}
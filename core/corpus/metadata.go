@@ -0,0 +1,217 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package corpus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// Metadata is the compiler fingerprint contained in the CBOR encoded trailer
+// solc and vyper append to the runtime bytecode.
+type Metadata struct {
+	Compiler     string        `json:"compiler,omitempty"` // solc or vyper, empty if not recorded
+	Version      string        `json:"version,omitempty"`  // compiler version, empty if not recorded
+	HashType     string        `json:"hashType,omitempty"` // ipfs, bzzr0 or bzzr1
+	Hash         hexutil.Bytes `json:"hash,omitempty"`     // hash of the metadata file
+	Experimental bool          `json:"experimental,omitempty"`
+}
+
+// Fingerprint returns a short description of the compiler, e.g. "solc 0.8.17",
+// or the metadata hash type if the compiler is not recorded.
+func (m *Metadata) Fingerprint() string {
+	var parts []string
+	for _, s := range []string{m.Compiler, m.Version} {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	if len(parts) == 0 {
+		parts = append(parts, m.HashType)
+	}
+	return strings.Join(parts, " ")
+}
+
+// ParseMetadata extracts the compiler metadata from the trailer of the given
+// runtime bytecode. The last two bytes of the code hold the length of the
+// CBOR encoded trailer preceding them. Nil is returned if the code has no
+// well-formed trailer.
+func ParseMetadata(code []byte) *Metadata {
+	if len(code) < 2 {
+		return nil
+	}
+	size := int(binary.BigEndian.Uint16(code[len(code)-2:]))
+	if size == 0 || size+2 > len(code) {
+		return nil
+	}
+	d := &cborDecoder{data: code[len(code)-2-size : len(code)-2]}
+	v, err := d.decode(0)
+	if err != nil || len(d.data) != 0 {
+		return nil
+	}
+	// Since vyper 0.3.10 the trailer is an array ending with the map.
+	if items, ok := v.([]interface{}); ok && len(items) > 0 {
+		v = items[len(items)-1]
+	}
+	fields, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	m := new(Metadata)
+	for _, typ := range []string{"ipfs", "bzzr1", "bzzr0"} {
+		if hash, ok := fields[typ].([]byte); ok {
+			m.HashType, m.Hash = typ, hash
+			break
+		}
+	}
+	switch version := fields["solc"].(type) {
+	case []byte:
+		// Releases encode the version as three bytes.
+		if len(version) == 3 {
+			m.Compiler, m.Version = "solc", fmt.Sprintf("%d.%d.%d", version[0], version[1], version[2])
+		}
+	case string:
+		// Pre-releases encode the full version string.
+		m.Compiler, m.Version = "solc", version
+	}
+	if version, ok := fields["vyper"].([]interface{}); ok {
+		parts := make([]string, 0, len(version))
+		for _, part := range version {
+			if n, ok := part.(uint64); ok {
+				parts = append(parts, fmt.Sprint(n))
+			}
+		}
+		m.Compiler, m.Version = "vyper", strings.Join(parts, ".")
+	}
+	m.Experimental, _ = fields["experimental"].(bool)
+	if m.Compiler == "" && m.HashType == "" {
+		return nil
+	}
+	return m
+}
+
+var errInvalidCBOR = errors.New("invalid cbor")
+
+// maxCBORDepth limits the nesting of decoded CBOR items.
+const maxCBORDepth = 4
+
+// cborDecoder decodes the subset of CBOR used by compiler metadata: unsigned
+// integers, byte and text strings, arrays, maps with text keys and the simple
+// values true, false and null.
+type cborDecoder struct {
+	data []byte
+}
+
+// header decodes the major type and argument of the next item.
+func (d *cborDecoder) header() (byte, uint64, error) {
+	if len(d.data) == 0 {
+		return 0, 0, errInvalidCBOR
+	}
+	major, info := d.data[0]>>5, d.data[0]&0x1f
+	d.data = d.data[1:]
+	if info < 24 {
+		return major, uint64(info), nil
+	}
+	if info > 27 {
+		return 0, 0, errInvalidCBOR
+	}
+	size := 1 << (info - 24)
+	if len(d.data) < size {
+		return 0, 0, errInvalidCBOR
+	}
+	var arg uint64
+	for _, b := range d.data[:size] {
+		arg = arg<<8 | uint64(b)
+	}
+	d.data = d.data[size:]
+	return major, arg, nil
+}
+
+// bytes consumes n bytes of the input.
+func (d *cborDecoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)) {
+		return nil, errInvalidCBOR
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b, nil
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > maxCBORDepth {
+		return nil, errInvalidCBOR
+	}
+	major, arg, err := d.header()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case 0:
+		return arg, nil
+	case 2:
+		b, err := d.bytes(arg)
+		return common.CopyBytes(b), err
+	case 3:
+		b, err := d.bytes(arg)
+		return string(b), err
+	case 4:
+		if arg > uint64(len(d.data)) {
+			return nil, errInvalidCBOR
+		}
+		items := make([]interface{}, arg)
+		for i := range items {
+			if items[i], err = d.decode(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	case 5:
+		if arg > uint64(len(d.data)) {
+			return nil, errInvalidCBOR
+		}
+		fields := make(map[string]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			key, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, errInvalidCBOR
+			}
+			if fields[name], err = d.decode(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return fields, nil
+	case 7:
+		switch arg {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22:
+			return nil, nil
+		}
+	}
+	return nil, errInvalidCBOR
+}
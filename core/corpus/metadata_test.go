@@ -0,0 +1,87 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package corpus
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// withTrailer appends the given CBOR encoded trailer and its length to code.
+func withTrailer(code []byte, trailer string) []byte {
+	cbor := common.FromHex(trailer)
+	return append(append(common.CopyBytes(code), cbor...), byte(len(cbor)>>8), byte(len(cbor)))
+}
+
+func TestParseMetadata(t *testing.T) {
+	var (
+		runtime = common.FromHex("6080604052600080fd")
+		ipfs    = bytes.Repeat([]byte{0xaa}, 34)
+		bzzr    = bytes.Repeat([]byte{0xbb}, 32)
+	)
+	tests := []struct {
+		code        []byte
+		fingerprint string
+		hashType    string
+		hash        []byte
+	}{
+		// {"ipfs": h'aa..', "solc": h'000811'}
+		{withTrailer(runtime, "a2646970667358"+"22"+common.Bytes2Hex(ipfs)+"64736f6c6343000811"), "solc 0.8.17", "ipfs", ipfs},
+		// {"bzzr0": h'bb..'}
+		{withTrailer(runtime, "a165627a7a72305820"+common.Bytes2Hex(bzzr)), "bzzr0", "bzzr0", bzzr},
+		// {"solc": "0.8.18-ci", "experimental": true}
+		{withTrailer(runtime, "a264736f6c6369302e382e31382d63696c6578706572696d656e74616cf5"), "solc 0.8.18-ci", "", nil},
+		// {"vyper": [0, 3, 7]}
+		{withTrailer(runtime, "a165767970657283000307"), "vyper 0.3.7", "", nil},
+	}
+	for i, tt := range tests {
+		m := ParseMetadata(tt.code)
+		if m == nil {
+			t.Errorf("test %d: no metadata found", i)
+			continue
+		}
+		if have := m.Fingerprint(); have != tt.fingerprint {
+			t.Errorf("test %d: fingerprint mismatch: have %q, want %q", i, have, tt.fingerprint)
+		}
+		if m.HashType != tt.hashType || !bytes.Equal(m.Hash, tt.hash) {
+			t.Errorf("test %d: hash mismatch: have %s %x, want %s %x", i, m.HashType, m.Hash, tt.hashType, tt.hash)
+		}
+	}
+}
+
+func TestParseMetadataInvalid(t *testing.T) {
+	tests := [][]byte{
+		nil,
+		{0x00},
+		common.FromHex("6080604052600080fd"),
+		// Length exceeding the code.
+		common.FromHex("a1ff00ff"),
+		// Trailing bytes after the map.
+		withTrailer(nil, "a1646970667341aa00"),
+		// Map without known fields.
+		withTrailer(nil, "a163666f6f01"),
+		// Non-text map key.
+		withTrailer(nil, "a10101"),
+	}
+	for i, code := range tests {
+		if m := ParseMetadata(code); m != nil {
+			t.Errorf("test %d: unexpected metadata %+v", i, m)
+		}
+	}
+}
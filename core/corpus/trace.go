@@ -0,0 +1,81 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package corpus
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var emptyCodeHash = crypto.Keccak256Hash(nil)
+
+// Trace is a vm.Tracer recording the codes executed by a transaction and the
+// accounts creating contracts. It is attached to the replay of a substate and
+// passed to Corpus.Add.
+type Trace struct {
+	Invocations map[common.Hash]uint64            // number of executions by code hash
+	Creators    map[common.Address]common.Address // account executing the CREATE by created contract
+
+	env *vm.EVM
+}
+
+// NewTrace creates an empty trace.
+func NewTrace() *Trace {
+	return &Trace{
+		Invocations: make(map[common.Hash]uint64),
+		Creators:    make(map[common.Address]common.Address),
+	}
+}
+
+// invoke counts the execution of the code held by addr. Calls of accounts
+// without code, including precompiles, are not counted.
+func (t *Trace) invoke(addr common.Address) {
+	if hash := t.env.StateDB.GetCodeHash(addr); hash != (common.Hash{}) && hash != emptyCodeHash {
+		t.Invocations[hash]++
+	}
+}
+
+func (t *Trace) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	t.env = env
+	if create {
+		t.Creators[to] = from
+	} else {
+		t.invoke(to)
+	}
+}
+
+func (t *Trace) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+}
+
+func (t *Trace) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	if typ == vm.CREATE || typ == vm.CREATE2 {
+		t.Creators[to] = from
+	} else {
+		t.invoke(to)
+	}
+}
+
+func (t *Trace) CaptureExit(output []byte, gasUsed uint64, err error) {}
+
+func (t *Trace) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+}
+
+func (t *Trace) CaptureEnd(output []byte, gasUsed uint64, _ time.Duration, err error) {}
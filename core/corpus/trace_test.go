@@ -0,0 +1,71 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package corpus
+
+import (
+	"math/big"
+	"testing"
+
+	substate "github.com/Fantom-foundation/Substate"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/replay"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestTrace(t *testing.T) {
+	var (
+		caller = common.HexToAddress("0xca11")
+		// Calls contract twice, queries the code size of clone and creates an
+		// empty contract.
+		callerCode = common.FromHex("60006000600060006000" + "61c0de" + "61fffff150" +
+			"60006000600060006000" + "61c0de" + "61fffff150" +
+			"61c1de3b50" + "600060006000f050" + "00")
+		cloneCode = common.FromHex("00")
+		alloc     = substate.SubstateAlloc{
+			sender:   substate.NewSubstateAccount(0, big.NewInt(0), nil),
+			caller:   substate.NewSubstateAccount(1, big.NewInt(0), callerCode),
+			contract: substate.NewSubstateAccount(1, big.NewInt(0), code),
+			clone:    substate.NewSubstateAccount(1, big.NewInt(0), cloneCode),
+		}
+		s = substate.NewSubstate(alloc, nil,
+			&substate.SubstateEnv{Number: 1, GasLimit: 10000000, Difficulty: big.NewInt(0), BaseFee: big.NewInt(0)},
+			&substate.SubstateMessage{From: sender, To: &caller, Gas: 1000000, GasPrice: big.NewInt(0),
+				GasFeeCap: big.NewInt(0), GasTipCap: big.NewInt(0), Value: big.NewInt(0)},
+			&substate.SubstateResult{})
+		trace = NewTrace()
+	)
+	if _, _, err := replay.Execute(0, s, &replay.Config{VMConfig: vm.Config{Debug: true, Tracer: trace}}); err != nil {
+		t.Fatalf("failed to replay: %v", err)
+	}
+	want := map[common.Hash]uint64{
+		crypto.Keccak256Hash(callerCode): 1,
+		crypto.Keccak256Hash(code):       2,
+	}
+	if len(trace.Invocations) != len(want) {
+		t.Errorf("wrong number of invoked codes: have %d, want %d", len(trace.Invocations), len(want))
+	}
+	for hash, n := range want {
+		if trace.Invocations[hash] != n {
+			t.Errorf("wrong invocations of %x: have %d, want %d", hash, trace.Invocations[hash], n)
+		}
+	}
+	created := crypto.CreateAddress(caller, 1)
+	if creator, ok := trace.Creators[created]; !ok || creator != caller {
+		t.Errorf("wrong creator of %x: have %x, want %x", created, creator, caller)
+	}
+}
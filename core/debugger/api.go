@@ -0,0 +1,349 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package debugger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Loader creates the runner of a debugging session from a source
+// description. The format of the description is defined by the loader.
+type Loader func(source json.RawMessage) (Runner, error)
+
+// ErrTooManySessions is returned when a session is opened while the maximum
+// number of sessions is open.
+var ErrTooManySessions = errors.New("too many debugging sessions")
+
+// API provides the debug_evmSession* JSON-RPC methods. Sessions are
+// identified by the ID returned by debug_evmSessionOpen.
+type API struct {
+	loader Loader
+	config *Config
+
+	mu       sync.Mutex
+	sessions map[rpc.ID]*apiSession
+}
+
+// apiSession is a session opened through the API.
+type apiSession struct {
+	*Session
+	used time.Time // time of the last call for the session
+}
+
+// NewAPI creates a debugging API loading sessions with loader. The sessions
+// are configured by config, nil selects the defaults.
+func NewAPI(loader Loader, config *Config) *API {
+	if config == nil {
+		config = &Config{
+			CheckpointInterval: DefaultCheckpointInterval,
+			MaxSessions:        DefaultMaxSessions,
+			IdleTimeout:        DefaultIdleTimeout,
+		}
+	}
+	api := &API{loader: loader, config: config, sessions: make(map[rpc.ID]*apiSession)}
+	if config.IdleTimeout > 0 {
+		go api.timeoutLoop(config.IdleTimeout)
+	}
+	return api
+}
+
+// timeoutLoop runs at the interval set by timeout and closes the sessions
+// which have not been used within the timeout. It is started when the API is
+// created.
+func (api *API) timeoutLoop(timeout time.Duration) {
+	ticker := time.NewTicker(timeout)
+	defer ticker.Stop()
+	for {
+		<-ticker.C
+		api.closeIdle(time.Now().Add(-timeout))
+	}
+}
+
+// closeIdle closes the sessions which have not been used since the deadline.
+func (api *API) closeIdle(deadline time.Time) {
+	var idle []*Session
+	api.mu.Lock()
+	for id, s := range api.sessions {
+		if s.used.Before(deadline) {
+			idle = append(idle, s.Session)
+			delete(api.sessions, id)
+		}
+	}
+	api.mu.Unlock()
+
+	// Closing waits for the running commands of the sessions, so it is done
+	// outside the lock.
+	for _, s := range idle {
+		s.Close()
+	}
+}
+
+// full reports whether the maximum number of sessions is open. The caller
+// has to hold api.mu.
+func (api *API) full() bool {
+	return api.config.MaxSessions > 0 && len(api.sessions) >= api.config.MaxSessions
+}
+
+// APIs returns the RPC descriptors of the debugging API.
+func APIs(loader Loader, config *Config) []rpc.API {
+	return []rpc.API{{
+		Namespace: "debug",
+		Version:   "1.0",
+		Service:   NewAPI(loader, config),
+		Public:    false,
+	}}
+}
+
+// session returns the session with the given ID.
+func (api *API) session(id rpc.ID) (*Session, error) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	s, ok := api.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown session %s", id)
+	}
+	s.used = time.Now()
+	return s.Session, nil
+}
+
+// EvmSessionOpen starts a debugging session paused at its first instruction.
+func (api *API) EvmSessionOpen(source json.RawMessage) (rpc.ID, error) {
+	api.mu.Lock()
+	full := api.full()
+	api.mu.Unlock()
+	if full {
+		return "", ErrTooManySessions
+	}
+	run, err := api.loader(source)
+	if err != nil {
+		return "", err
+	}
+	s := NewSession(run, api.config)
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if api.full() {
+		s.Close()
+		return "", ErrTooManySessions
+	}
+	id := rpc.NewID()
+	api.sessions[id] = &apiSession{Session: s, used: time.Now()}
+	return id, nil
+}
+
+// EvmSessionClose cancels the execution of a session and releases it.
+func (api *API) EvmSessionClose(id rpc.ID) error {
+	s, err := api.session(id)
+	if err != nil {
+		return err
+	}
+	api.mu.Lock()
+	delete(api.sessions, id)
+	api.mu.Unlock()
+	s.Close()
+	return nil
+}
+
+// EvmSessionStatus returns the status of a session.
+func (api *API) EvmSessionStatus(id rpc.ID) (Status, error) {
+	s, err := api.session(id)
+	if err != nil {
+		return Status{}, err
+	}
+	return s.Status(), nil
+}
+
+// EvmSessionStepInto executes the next instruction, entering calls.
+func (api *API) EvmSessionStepInto(id rpc.ID) (Status, error) {
+	s, err := api.session(id)
+	if err != nil {
+		return Status{}, err
+	}
+	return s.StepInto()
+}
+
+// EvmSessionStepOver executes the next instruction, completing calls.
+func (api *API) EvmSessionStepOver(id rpc.ID) (Status, error) {
+	s, err := api.session(id)
+	if err != nil {
+		return Status{}, err
+	}
+	return s.StepOver()
+}
+
+// EvmSessionStepOut continues until the current frame returned.
+func (api *API) EvmSessionStepOut(id rpc.ID) (Status, error) {
+	s, err := api.session(id)
+	if err != nil {
+		return Status{}, err
+	}
+	return s.StepOut()
+}
+
+// EvmSessionContinue continues until a breakpoint matches.
+func (api *API) EvmSessionContinue(id rpc.ID) (Status, error) {
+	s, err := api.session(id)
+	if err != nil {
+		return Status{}, err
+	}
+	return s.Continue()
+}
+
+// EvmSessionStepBack returns to the previous instruction.
+func (api *API) EvmSessionStepBack(id rpc.ID) (Status, error) {
+	s, err := api.session(id)
+	if err != nil {
+		return Status{}, err
+	}
+	return s.StepBack()
+}
+
+// EvmSessionGoTo moves the session to the given step, forwards or backwards.
+func (api *API) EvmSessionGoTo(id rpc.ID, step hexutil.Uint64) (Status, error) {
+	s, err := api.session(id)
+	if err != nil {
+		return Status{}, err
+	}
+	return s.GoTo(uint64(step))
+}
+
+// EvmSessionReverseContinue returns to the last earlier instruction at which
+// a breakpoint matches.
+func (api *API) EvmSessionReverseContinue(id rpc.ID) (Status, error) {
+	s, err := api.session(id)
+	if err != nil {
+		return Status{}, err
+	}
+	return s.ReverseContinue()
+}
+
+// CheckpointResult is the JSON representation of a checkpoint.
+type CheckpointResult struct {
+	Status
+	Stack      []*hexutil.Big `json:"stack"`
+	Memory     hexutil.Bytes  `json:"memory"`
+	ReturnData hexutil.Bytes  `json:"returnData"`
+}
+
+// EvmSessionCheckpoints returns the steps at which checkpoints were recorded.
+func (api *API) EvmSessionCheckpoints(id rpc.ID) ([]uint64, error) {
+	s, err := api.session(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.Checkpoints(), nil
+}
+
+// EvmSessionCheckpoint returns the checkpoint recorded at a step.
+func (api *API) EvmSessionCheckpoint(id rpc.ID, step hexutil.Uint64) (*CheckpointResult, error) {
+	s, err := api.session(id)
+	if err != nil {
+		return nil, err
+	}
+	c, ok := s.Checkpoint(uint64(step))
+	if !ok {
+		return nil, fmt.Errorf("no checkpoint at step %d", step)
+	}
+	result := &CheckpointResult{Status: c.Status, Memory: c.Memory, ReturnData: c.ReturnData}
+	for _, item := range c.Stack {
+		result.Stack = append(result.Stack, (*hexutil.Big)(item))
+	}
+	return result, nil
+}
+
+// EvmSessionSetBreakpoint adds a breakpoint and returns its ID.
+func (api *API) EvmSessionSetBreakpoint(id rpc.ID, bp Breakpoint) (int, error) {
+	s, err := api.session(id)
+	if err != nil {
+		return 0, err
+	}
+	return s.AddBreakpoint(bp)
+}
+
+// EvmSessionRemoveBreakpoint removes a breakpoint.
+func (api *API) EvmSessionRemoveBreakpoint(id rpc.ID, bp int) error {
+	s, err := api.session(id)
+	if err != nil {
+		return err
+	}
+	if !s.RemoveBreakpoint(bp) {
+		return fmt.Errorf("unknown breakpoint %d", bp)
+	}
+	return nil
+}
+
+// EvmSessionBreakpoints returns the breakpoints of a session.
+func (api *API) EvmSessionBreakpoints(id rpc.ID) ([]Breakpoint, error) {
+	s, err := api.session(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.Breakpoints(), nil
+}
+
+// EvmSessionStack returns the stack of the current frame, the top element last.
+func (api *API) EvmSessionStack(id rpc.ID) ([]*hexutil.Big, error) {
+	s, err := api.session(id)
+	if err != nil {
+		return nil, err
+	}
+	stack, err := s.Stack()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*hexutil.Big, len(stack))
+	for i, item := range stack {
+		items[i] = (*hexutil.Big)(item)
+	}
+	return items, nil
+}
+
+// EvmSessionMemory returns a range of the current frame's memory.
+func (api *API) EvmSessionMemory(id rpc.ID, offset hexutil.Uint64, size hexutil.Uint64) (hexutil.Bytes, error) {
+	s, err := api.session(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.Memory(uint64(offset), uint64(size))
+}
+
+// EvmSessionStorage returns a storage slot of the given account, or of the
+// executing frame's account if address is omitted.
+func (api *API) EvmSessionStorage(id rpc.ID, slot common.Hash, address *common.Address) (common.Hash, error) {
+	s, err := api.session(id)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return s.Storage(address, slot)
+}
+
+// EvmSessionReturnData returns the return data of the current frame's last call.
+func (api *API) EvmSessionReturnData(id rpc.ID) (hexutil.Bytes, error) {
+	s, err := api.session(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.ReturnData()
+}
@@ -0,0 +1,774 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package debugger implements interactive single-step debugging of EVM
+// executions with breakpoints and inspection of the execution state.
+package debugger
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// ErrFinished is returned when a finished session is resumed or inspected.
+var ErrFinished = errors.New("execution finished")
+
+// Runner executes a transaction with the given EVM configuration. The
+// configuration enables the session's tracer, which suspends the execution
+// whenever the session pauses, and the frames the execution resumes from.
+// Resuming requires the EVM to use a *state.StateDB.
+type Runner func(cfg vm.Config) error
+
+// Pause reasons
+const (
+	ReasonStep       = "step"       // a step command completed
+	ReasonBreakpoint = "breakpoint" // a breakpoint matched
+)
+
+// Status describes a paused or finished session.
+type Status struct {
+	Finished   bool           `json:"finished"`
+	Reason     string         `json:"reason,omitempty"`     // why the session paused
+	Breakpoint int            `json:"breakpoint,omitempty"` // ID of the matched breakpoint
+	Steps      uint64         `json:"steps"`                // number of executed instructions
+	PC         uint64         `json:"pc"`
+	Op         string         `json:"op,omitempty"`
+	Gas        uint64         `json:"gas"`
+	Cost       uint64         `json:"cost"`
+	Depth      int            `json:"depth"`
+	Address    common.Address `json:"address"`         // account of the executing frame
+	Code       common.Address `json:"code"`            // account holding the executed code
+	Error      string         `json:"error,omitempty"` // error of the instruction or the execution
+}
+
+// Breakpoint pauses the execution at instructions matching all its set
+// conditions. Slot matches SLOAD and SSTORE instructions accessing the slot;
+// Depth matches the first instruction of a frame at the given call depth.
+type Breakpoint struct {
+	ID      int             `json:"id"`
+	PC      *uint64         `json:"pc,omitempty"`
+	Address *common.Address `json:"address,omitempty"` // account of the executing frame
+	OpCode  string          `json:"opcode,omitempty"`
+	Slot    *common.Hash    `json:"slot,omitempty"`
+	Depth   *int            `json:"depth,omitempty"`
+}
+
+// validate checks that the breakpoint has a known opcode and any condition.
+func (bp *Breakpoint) validate() error {
+	if bp.OpCode != "" && vm.StringToOp(bp.OpCode) == vm.STOP && bp.OpCode != "STOP" {
+		return fmt.Errorf("unknown opcode %q", bp.OpCode)
+	}
+	if bp.PC == nil && bp.Address == nil && bp.OpCode == "" && bp.Slot == nil && bp.Depth == nil {
+		return errors.New("breakpoint without condition")
+	}
+	return nil
+}
+
+// matches reports whether the instruction about to be executed matches.
+func (bp *Breakpoint) matches(pc uint64, op vm.OpCode, scope *vm.ScopeContext, depth int, entering bool) bool {
+	if bp.PC != nil && *bp.PC != pc {
+		return false
+	}
+	if bp.Address != nil && *bp.Address != scope.Contract.Address() {
+		return false
+	}
+	if bp.OpCode != "" && vm.StringToOp(bp.OpCode) != op {
+		return false
+	}
+	if bp.Slot != nil {
+		if (op != vm.SLOAD && op != vm.SSTORE) || scope.Stack.Len() == 0 {
+			return false
+		}
+		if common.Hash(scope.Stack.Back(0).Bytes32()) != *bp.Slot {
+			return false
+		}
+	}
+	if bp.Depth != nil && (*bp.Depth != depth || !entering) {
+		return false
+	}
+	return true
+}
+
+// stepMode selects where a resumed execution pauses next.
+type stepMode int
+
+const (
+	stepInto stepMode = iota // pause at the next instruction
+	stepOver                 // pause at the next instruction of the current or a parent frame
+	stepOut                  // pause at the next instruction of a parent frame
+	resume                   // pause at breakpoints only
+	abort                    // cancel the execution
+)
+
+// Defaults of the session options.
+const (
+	DefaultCheckpointInterval = 1000             // number of steps between checkpoints
+	DefaultMaxSessions        = 16               // number of sessions open through the API
+	DefaultIdleTimeout        = 10 * time.Minute // time after which unused API sessions are closed
+)
+
+// Config contains the options of debugging sessions and of the API serving
+// them.
+type Config struct {
+	// CheckpointInterval is the number of steps between two checkpoints of
+	// the execution state, zero disables checkpoints. Executions moving
+	// backwards resume from the nearest checkpoint before their target.
+	CheckpointInterval uint64
+
+	// MaxSessions limits the number of sessions open through the API, zero
+	// means no limit.
+	MaxSessions int
+
+	// IdleTimeout is the time after which the API closes a session which
+	// was not used, zero keeps unused sessions open.
+	IdleTimeout time.Duration
+}
+
+// Checkpoint is the interpreter state before the execution of a step.
+type Checkpoint struct {
+	Status
+	Stack      []*big.Int
+	Memory     []byte
+	ReturnData []byte
+
+	restore *restorePoint // nil if executions cannot resume from the checkpoint
+}
+
+// pendingCall is a call or create instruction of an open frame, the frame is
+// resumed by executing the instruction again.
+type pendingCall struct {
+	frame    *vm.FrameSnapshot
+	position state.JournalPosition // journal position before the instruction
+}
+
+// restorePoint is the execution state at a checkpoint.
+type restorePoint struct {
+	calls []pendingCall     // call instructions of the frames below the current one
+	frame *vm.FrameSnapshot // the current frame
+	state *state.StateDB    // state copy including the journal of the transaction
+	depth int               // depth of the instruction preceding the checkpoint
+}
+
+// frames returns the frame snapshots an execution resumes from.
+func (r *restorePoint) frames() []*vm.FrameSnapshot {
+	frames := make([]*vm.FrameSnapshot, 0, len(r.calls)+1)
+	for _, call := range r.calls {
+		frames = append(frames, call.frame)
+	}
+	return append(frames, r.frame)
+}
+
+// equal reports whether two checkpoints describe the same interpreter state.
+func (c *Checkpoint) equal(o *Checkpoint) bool {
+	if c.PC != o.PC || c.Gas != o.Gas || c.Depth != o.Depth || c.Address != o.Address || len(c.Stack) != len(o.Stack) {
+		return false
+	}
+	for i := range c.Stack {
+		if c.Stack[i].Cmp(o.Stack[i]) != 0 {
+			return false
+		}
+	}
+	return bytes.Equal(c.Memory, o.Memory) && bytes.Equal(c.ReturnData, o.ReturnData)
+}
+
+// frame is the execution state of a paused session.
+type frame struct {
+	env        *vm.EVM
+	scope      *vm.ScopeContext
+	returnData []byte
+}
+
+// checkpoint copies the interpreter state of the frame.
+func (f *frame) checkpoint(status Status) *Checkpoint {
+	c := &Checkpoint{
+		Status:     status,
+		Memory:     common.CopyBytes(f.scope.Memory.Data()),
+		ReturnData: common.CopyBytes(f.returnData),
+	}
+	for _, item := range f.scope.Stack.Data() {
+		c.Stack = append(c.Stack, item.ToBig())
+	}
+	return c
+}
+
+// request is a command for the paused execution.
+type request struct {
+	mode    stepMode
+	target  uint64       // if set, run up to this step before obeying the mode
+	inspect func(*frame) // executed while paused instead of resuming
+	done    chan struct{}
+}
+
+// execution is a single run of a session's transaction. Stepping backwards
+// starts a new execution which resumes from a checkpoint and runs without
+// pausing up to the target step.
+type execution struct {
+	session  *Session
+	resume   *Checkpoint  // checkpoint the execution resumes from, if any
+	requests chan request // commands for the paused execution
+	events   chan Status  // pauses and the final status of the execution
+
+	// fields of the execution goroutine
+	mode      stepMode // current step mode
+	modeDepth int      // depth at which the step command was issued
+	depth     int      // depth of the last instruction
+	steps     uint64   // number of executed instructions
+	detached  bool     // the tracer no longer pauses
+
+	target     uint64 // step to pause at before obeying the step mode
+	targetID   int    // breakpoint reported when pausing at the target
+	scanEnd    uint64 // if set, look for breakpoints before this step and abort
+	lastMatch  uint64 // step of the last breakpoint match found by a scan
+	lastID     int    // breakpoint of the last match found by a scan
+	divergence uint64 // first step whose state differs from its checkpoint
+
+	calls []pendingCall // call instructions of the open frames, by depth
+}
+
+// Session is a paused EVM execution. The execution runs in a background
+// goroutine which is suspended by the session's tracer; all inspection
+// happens on that goroutine while it is suspended.
+//
+// Moving forwards continues the paused execution. Moving backwards starts a
+// new execution. At checkpoints, recorded every CheckpointInterval steps, the
+// session keeps a copy of the StateDB and snapshots of the open call frames;
+// a new execution resumes from the nearest checkpoint before its target, so
+// a backward step costs at most CheckpointInterval instructions. Without such
+// a checkpoint, or if the runner does not support resuming, the transaction
+// is re-executed from its start. Every execution is compared against the
+// recorded checkpoints to detect runners which are not deterministic.
+type Session struct {
+	run    Runner
+	config Config
+
+	control sync.Mutex // serialises the controlling calls
+	exec    *execution // current execution
+	status  Status     // status of the last pause
+
+	mu          sync.Mutex             // protects the breakpoints and checkpoints
+	breakpoints []Breakpoint           // active breakpoints
+	nextID      int                    // ID of the next breakpoint
+	checkpoints map[uint64]*Checkpoint // checkpoints by step
+}
+
+// NewSession starts the execution of run and pauses it at its first
+// instruction. If the execution does not execute any code, the returned
+// session is already finished. A nil config selects the default options.
+func NewSession(run Runner, config *Config) *Session {
+	if config == nil {
+		config = &Config{CheckpointInterval: DefaultCheckpointInterval}
+	}
+	s := &Session{
+		run:         run,
+		config:      *config,
+		nextID:      1,
+		checkpoints: make(map[uint64]*Checkpoint),
+	}
+	s.start(&execution{target: 1})
+	return s
+}
+
+// start runs a new execution and waits for its first pause.
+func (s *Session) start(e *execution) {
+	e.session = s
+	e.requests = make(chan request)
+	e.events = make(chan Status)
+	cfg := vm.Config{Debug: true, Tracer: (*sessionTracer)(e)}
+	if e.resume != nil {
+		cfg.Resume = e.resume.restore.frames()
+	}
+	go func() {
+		err := s.run(cfg)
+		status := Status{Finished: true, Steps: e.steps}
+		if e.divergence != 0 {
+			status.Error = fmt.Sprintf("re-execution diverged from checkpoint at step %d", e.divergence)
+		} else if err != nil {
+			status.Error = err.Error()
+		}
+		e.events <- status
+		close(e.events)
+	}()
+	s.exec = e
+	s.status = <-e.events
+}
+
+// stop cancels the current execution and waits for its termination.
+func (s *Session) stop() {
+	if s.status.Finished {
+		return
+	}
+	s.exec.requests <- request{mode: abort}
+	for status := range s.exec.events {
+		s.status = status
+	}
+}
+
+// Status returns the status of the last pause.
+func (s *Session) Status() Status {
+	s.control.Lock()
+	defer s.control.Unlock()
+	return s.status
+}
+
+// StepInto executes the next instruction, entering called contracts.
+func (s *Session) StepInto() (Status, error) {
+	return s.resume(stepInto)
+}
+
+// StepOver executes the next instruction. Calls are executed completely
+// unless a breakpoint matches within the callee.
+func (s *Session) StepOver() (Status, error) {
+	return s.resume(stepOver)
+}
+
+// StepOut continues until the current frame returned to its caller.
+func (s *Session) StepOut() (Status, error) {
+	return s.resume(stepOut)
+}
+
+// Continue resumes the execution until a breakpoint matches or the
+// execution finishes.
+func (s *Session) Continue() (Status, error) {
+	return s.resume(resume)
+}
+
+// position returns the step of the current instruction. Once finished, the
+// position is the step following the last instruction.
+func (s *Session) position() uint64 {
+	if s.status.Finished {
+		return s.status.Steps + 1
+	}
+	return s.status.Steps
+}
+
+// StepBack returns to the previous instruction.
+func (s *Session) StepBack() (Status, error) {
+	s.control.Lock()
+	defer s.control.Unlock()
+	if s.position() <= 1 {
+		return s.status, errors.New("already at the first instruction")
+	}
+	s.goTo(s.position() - 1)
+	return s.status, nil
+}
+
+// GoTo continues the execution up to a later step, or resumes the
+// transaction from the checkpoint before an earlier one.
+func (s *Session) GoTo(step uint64) (Status, error) {
+	s.control.Lock()
+	defer s.control.Unlock()
+	if step == 0 {
+		return s.status, errors.New("steps start at 1")
+	}
+	s.goTo(step)
+	return s.status, nil
+}
+
+// goTo moves the session to the given step. Later steps are reached by the
+// paused execution, earlier ones by a new execution.
+func (s *Session) goTo(step uint64) {
+	if !s.status.Finished && step >= s.status.Steps {
+		if step > s.status.Steps {
+			s.exec.requests <- request{mode: stepInto, target: step}
+			s.status = <-s.exec.events
+		}
+		return
+	}
+	s.stop()
+	s.start(&execution{target: step, resume: s.restorePoint(step)})
+}
+
+// restorePoint returns the last checkpoint at or before step which an
+// execution can resume from, or nil if there is none.
+func (s *Session) restorePoint(step uint64) *Checkpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var nearest *Checkpoint
+	for at, c := range s.checkpoints {
+		if at <= step && c.restore != nil && (nearest == nil || at > nearest.Steps) {
+			nearest = c
+		}
+	}
+	return nearest
+}
+
+// ReverseContinue returns to the last instruction before the current one at
+// which a breakpoint matches, or to the first instruction if none matches.
+func (s *Session) ReverseContinue() (Status, error) {
+	s.control.Lock()
+	defer s.control.Unlock()
+	if s.position() <= 1 {
+		return s.status, errors.New("already at the first instruction")
+	}
+	// scan the steps before the current one for breakpoint matches, one
+	// checkpoint interval at a time starting with the last one
+	end := s.position()
+	s.stop()
+	for {
+		from := s.restorePoint(end - 1)
+		scan := &execution{scanEnd: end, resume: from}
+		s.start(scan)
+		switch {
+		case scan.divergence != 0:
+			return s.status, nil
+		case scan.lastMatch != 0:
+			s.start(&execution{target: scan.lastMatch, targetID: scan.lastID, resume: s.restorePoint(scan.lastMatch)})
+			return s.status, nil
+		case from == nil:
+			s.start(&execution{target: 1})
+			return s.status, nil
+		}
+		end = from.Steps
+	}
+}
+
+// Checkpoints returns the steps of all recorded checkpoints in order.
+func (s *Session) Checkpoints() []uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	steps := make([]uint64, 0, len(s.checkpoints))
+	for step := range s.checkpoints {
+		steps = append(steps, step)
+	}
+	sort.Slice(steps, func(i, j int) bool { return steps[i] < steps[j] })
+	return steps
+}
+
+// Checkpoint returns the checkpoint recorded at a step.
+func (s *Session) Checkpoint(step uint64) (*Checkpoint, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.checkpoints[step]
+	return c, ok
+}
+
+// Close cancels an unfinished execution and waits for its termination.
+func (s *Session) Close() {
+	s.control.Lock()
+	defer s.control.Unlock()
+	s.stop()
+}
+
+// resume continues the paused execution and waits for the next pause.
+func (s *Session) resume(mode stepMode) (Status, error) {
+	s.control.Lock()
+	defer s.control.Unlock()
+	if s.status.Finished {
+		return s.status, ErrFinished
+	}
+	s.exec.requests <- request{mode: mode}
+	s.status = <-s.exec.events
+	return s.status, nil
+}
+
+// inspect runs fn on the execution goroutine while the session is paused.
+func (s *Session) inspect(fn func(*frame)) error {
+	s.control.Lock()
+	defer s.control.Unlock()
+	if s.status.Finished {
+		return ErrFinished
+	}
+	done := make(chan struct{})
+	s.exec.requests <- request{inspect: fn, done: done}
+	<-done
+	return nil
+}
+
+// Stack returns the stack of the current frame, the top element last.
+func (s *Session) Stack() ([]*big.Int, error) {
+	var stack []*big.Int
+	err := s.inspect(func(f *frame) {
+		for _, item := range f.scope.Stack.Data() {
+			stack = append(stack, item.ToBig())
+		}
+	})
+	return stack, err
+}
+
+// Memory returns size bytes of the current frame's memory at offset. Bytes
+// beyond the memory's size are omitted.
+func (s *Session) Memory(offset, size uint64) ([]byte, error) {
+	var mem []byte
+	err := s.inspect(func(f *frame) {
+		data := f.scope.Memory.Data()
+		if offset >= uint64(len(data)) {
+			return
+		}
+		end := offset + size
+		if end > uint64(len(data)) || end < offset {
+			end = uint64(len(data))
+		}
+		mem = common.CopyBytes(data[offset:end])
+	})
+	return mem, err
+}
+
+// Storage returns the value of a storage slot. If address is nil, the
+// storage of the executing frame's account is read.
+func (s *Session) Storage(address *common.Address, slot common.Hash) (common.Hash, error) {
+	var value common.Hash
+	err := s.inspect(func(f *frame) {
+		account := f.scope.Contract.Address()
+		if address != nil {
+			account = *address
+		}
+		value = f.env.StateDB.GetState(account, slot)
+	})
+	return value, err
+}
+
+// ReturnData returns the return data of the last call of the current frame.
+func (s *Session) ReturnData() ([]byte, error) {
+	var data []byte
+	err := s.inspect(func(f *frame) {
+		data = common.CopyBytes(f.returnData)
+	})
+	return data, err
+}
+
+// AddBreakpoint adds a breakpoint and returns its ID.
+func (s *Session) AddBreakpoint(bp Breakpoint) (int, error) {
+	if err := bp.validate(); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bp.ID = s.nextID
+	s.nextID++
+	s.breakpoints = append(s.breakpoints, bp)
+	return bp.ID, nil
+}
+
+// RemoveBreakpoint removes a breakpoint and reports whether it existed.
+func (s *Session) RemoveBreakpoint(id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, bp := range s.breakpoints {
+		if bp.ID == id {
+			s.breakpoints = append(s.breakpoints[:i], s.breakpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Breakpoints returns the active breakpoints.
+func (s *Session) Breakpoints() []Breakpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Breakpoint(nil), s.breakpoints...)
+}
+
+// matchBreakpoint returns the ID of the first breakpoint matching the
+// instruction, or 0.
+func (s *Session) matchBreakpoint(pc uint64, op vm.OpCode, scope *vm.ScopeContext, depth int, entering bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.breakpoints {
+		if s.breakpoints[i].matches(pc, op, scope, depth, entering) {
+			return s.breakpoints[i].ID
+		}
+	}
+	return 0
+}
+
+// checkpoint records the execution state at checkpoint steps, or compares
+// the interpreter state with the recorded one. It reports whether the state
+// diverged.
+func (s *Session) checkpoint(e *execution, f *frame, status Status, prevDepth int) bool {
+	if s.config.CheckpointInterval == 0 || status.Steps%s.config.CheckpointInterval != 0 {
+		return false
+	}
+	c := f.checkpoint(status)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if recorded, ok := s.checkpoints[status.Steps]; ok {
+		return !recorded.equal(c)
+	}
+	c.restore = e.restorePoint(f, status, prevDepth)
+	s.checkpoints[status.Steps] = c
+	return false
+}
+
+// restorePoint captures the state of the execution at the current
+// instruction, or returns nil if the execution cannot resume from it.
+func (e *execution) restorePoint(f *frame, status Status, prevDepth int) *restorePoint {
+	statedb, ok := f.env.StateDB.(*state.StateDB)
+	if !ok || status.Error != "" || len(e.calls) < status.Depth-1 {
+		return nil
+	}
+	return &restorePoint{
+		calls: append([]pendingCall(nil), e.calls[:status.Depth-1]...),
+		frame: vm.SnapshotFrame(f.env, status.PC, status.Gas, status.Cost, f.scope, f.returnData),
+		state: statedb.CopyWithJournal(),
+		depth: prevDepth,
+	}
+}
+
+// trackCall records the call and create instructions of the open frames,
+// which are executed again to resume their callees.
+func (e *execution) trackCall(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int) {
+	if len(e.calls) >= depth {
+		e.calls = e.calls[:depth-1]
+	}
+	switch op {
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL, vm.CREATE, vm.CREATE2:
+	default:
+		return
+	}
+	statedb, ok := env.StateDB.(*state.StateDB)
+	if !ok || len(e.calls) != depth-1 {
+		return
+	}
+	e.calls = append(e.calls, pendingCall{
+		frame:    vm.SnapshotFrame(env, pc, gas, cost, scope, rData),
+		position: statedb.JournalPosition(),
+	})
+}
+
+// resumeFrame restores the StateDB for the frames resumed from the
+// execution's checkpoint. It reports whether the instruction is the one of
+// the checkpoint, which is the first step counted by the execution.
+func (e *execution) resumeFrame(env *vm.EVM, depth int) bool {
+	r := e.resume.restore
+	if env.ResumedFrames() != depth {
+		// the interpreter does not resume, the execution starts at step 1
+		e.resume = nil
+		return true
+	}
+	statedb := env.StateDB.(*state.StateDB)
+	if depth <= len(r.calls) {
+		statedb.ResetTo(r.state, r.calls[depth-1].position)
+		return false
+	}
+	statedb.ResetTo(r.state, r.state.JournalPosition())
+	e.steps, e.depth = e.resume.Steps-1, r.depth
+	e.calls = append([]pendingCall(nil), r.calls...)
+	e.resume = nil
+	return true
+}
+
+// step is invoked by the tracer before every instruction and suspends the
+// execution if the session has to pause.
+func (e *execution) step(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	if e.detached {
+		return
+	}
+	if e.resume != nil && !e.resumeFrame(env, depth) {
+		return
+	}
+	e.steps++
+	entering, prevDepth := depth > e.depth, e.depth
+	e.depth = depth
+
+	status := Status{Steps: e.steps, PC: pc, Op: op.String(), Gas: gas, Cost: cost, Depth: depth, Address: scope.Contract.Address(), Code: scope.Contract.Address()}
+	if scope.Contract.CodeAddr != nil {
+		status.Code = *scope.Contract.CodeAddr
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	f := &frame{env: env, scope: scope, returnData: rData}
+	if e.session.config.CheckpointInterval != 0 && err == nil {
+		e.trackCall(env, pc, op, gas, cost, scope, rData, depth)
+	}
+	if e.session.checkpoint(e, f, status, prevDepth) {
+		// the execution is useless if it does not reproduce the recorded one
+		e.divergence = e.steps
+		e.detached = true
+		env.Cancel()
+		return
+	}
+	switch {
+	case e.scanEnd != 0:
+		if e.steps >= e.scanEnd {
+			e.detached = true
+			env.Cancel()
+		} else if id := e.session.matchBreakpoint(pc, op, scope, depth, entering); id != 0 {
+			e.lastMatch, e.lastID = e.steps, id
+		}
+		return
+
+	case e.target != 0:
+		if e.steps < e.target {
+			return
+		}
+		if e.targetID != 0 {
+			status.Reason, status.Breakpoint = ReasonBreakpoint, e.targetID
+		} else {
+			status.Reason = ReasonStep
+		}
+		e.target, e.targetID = 0, 0
+
+	default:
+		if id := e.session.matchBreakpoint(pc, op, scope, depth, entering); id != 0 {
+			status.Reason, status.Breakpoint = ReasonBreakpoint, id
+		} else {
+			switch {
+			case e.mode == stepInto,
+				e.mode == stepOver && depth <= e.modeDepth,
+				e.mode == stepOut && depth < e.modeDepth:
+				status.Reason = ReasonStep
+			default:
+				return
+			}
+		}
+	}
+	// report the pause and serve requests until the execution is resumed
+	e.events <- status
+	for req := range e.requests {
+		if req.inspect != nil {
+			req.inspect(f)
+			close(req.done)
+			continue
+		}
+		e.mode, e.modeDepth, e.target = req.mode, depth, req.target
+		if req.mode == abort {
+			e.detached = true
+			env.Cancel()
+		}
+		return
+	}
+}
+
+// sessionTracer implements vm.Tracer for an execution.
+type sessionTracer execution
+
+func (t *sessionTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+}
+
+func (t *sessionTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	(*execution)(t).step(env, pc, op, gas, cost, scope, rData, depth, err)
+}
+
+func (t *sessionTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+}
+
+func (t *sessionTracer) CaptureExit(output []byte, gasUsed uint64, err error) {}
+
+func (t *sessionTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+}
+
+func (t *sessionTracer) CaptureEnd(output []byte, gasUsed uint64, _ time.Duration, err error) {}
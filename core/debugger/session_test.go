@@ -0,0 +1,394 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package debugger
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/runtime"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+var calleeAddress = common.HexToAddress("0xcc")
+
+// testRunner returns a runner executing a contract which stores 42 in memory
+// and calls a contract storing 7 in slot 1.
+func testRunner() Runner {
+	code := []byte{
+		byte(vm.PUSH1), 42, byte(vm.PUSH1), 0, byte(vm.MSTORE), // 0
+		byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, // 5
+		byte(vm.PUSH1), 0, byte(vm.PUSH1), 0xcc, byte(vm.GAS), byte(vm.CALL), // 13
+		byte(vm.STOP), // 19
+	}
+	callee := []byte{
+		byte(vm.PUSH1), 7, byte(vm.PUSH1), 1, byte(vm.SSTORE), // 0
+		byte(vm.STOP), // 5
+	}
+	return func(cfg vm.Config) error {
+		statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		statedb.SetCode(calleeAddress, callee)
+		_, _, err := runtime.Execute(code, nil, &runtime.Config{State: statedb, EVMConfig: cfg})
+		return err
+	}
+}
+
+func checkStatus(t *testing.T, status Status, err error, pc uint64, op string, depth int, reason string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status.PC != pc || status.Op != op || status.Depth != depth || status.Reason != reason {
+		t.Fatalf("status mismatch: have pc %d %s depth %d reason %q, want pc %d %s depth %d reason %q",
+			status.PC, status.Op, status.Depth, status.Reason, pc, op, depth, reason)
+	}
+}
+
+func TestSessionStepOver(t *testing.T) {
+	s := NewSession(testRunner(), nil)
+	checkStatus(t, s.Status(), nil, 0, "PUSH1", 1, ReasonStep)
+
+	status, err := s.StepInto()
+	checkStatus(t, status, err, 2, "PUSH1", 1, ReasonStep)
+
+	if _, err := s.AddBreakpoint(Breakpoint{OpCode: "NOSUCHOP"}); err == nil {
+		t.Fatal("breakpoint with unknown opcode accepted")
+	}
+	id, err := s.AddBreakpoint(Breakpoint{OpCode: "CALL"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err = s.Continue()
+	checkStatus(t, status, err, 18, "CALL", 1, ReasonBreakpoint)
+	if status.Breakpoint != id {
+		t.Errorf("breakpoint mismatch: have %d, want %d", status.Breakpoint, id)
+	}
+
+	stack, err := s.Stack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stack) != 7 || stack[5].Cmp(big.NewInt(0xcc)) != 0 {
+		t.Errorf("unexpected stack %v", stack)
+	}
+	mem, err := s.Memory(0, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mem) != 32 || mem[31] != 42 {
+		t.Errorf("unexpected memory %x", mem)
+	}
+
+	// stepping over the call executes the callee completely
+	status, err = s.StepOver()
+	checkStatus(t, status, err, 19, "STOP", 1, ReasonStep)
+	value, err := s.Storage(&calleeAddress, common.BigToHash(big.NewInt(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != common.BigToHash(big.NewInt(7)) {
+		t.Errorf("storage mismatch: have %x, want 7", value)
+	}
+
+	status, err = s.Continue()
+	if err != nil || !status.Finished || status.Steps != 16 {
+		t.Fatalf("unexpected final status %+v, %v", status, err)
+	}
+	if _, err := s.StepInto(); err != ErrFinished {
+		t.Errorf("resumed finished session: %v", err)
+	}
+	if _, err := s.Stack(); err != ErrFinished {
+		t.Errorf("inspected finished session: %v", err)
+	}
+}
+
+func TestSessionStepInto(t *testing.T) {
+	s := NewSession(testRunner(), nil)
+	if _, err := s.AddBreakpoint(Breakpoint{PC: new(uint64)}); err != nil {
+		t.Fatal(err)
+	}
+	depth := 2
+	if _, err := s.AddBreakpoint(Breakpoint{Depth: &depth}); err != nil {
+		t.Fatal(err)
+	}
+	// the pc breakpoint matches the first instructions of both frames, so it
+	// is removed before entering the callee
+	if !s.RemoveBreakpoint(1) || s.RemoveBreakpoint(1) {
+		t.Fatal("failed to remove breakpoint")
+	}
+	status, err := s.Continue()
+	checkStatus(t, status, err, 0, "PUSH1", 2, ReasonBreakpoint)
+	if status.Address != calleeAddress {
+		t.Errorf("address mismatch: have %x, want %x", status.Address, calleeAddress)
+	}
+
+	slot := common.BigToHash(big.NewInt(1))
+	if _, err := s.AddBreakpoint(Breakpoint{Slot: &slot, Address: &calleeAddress}); err != nil {
+		t.Fatal(err)
+	}
+	status, err = s.Continue()
+	checkStatus(t, status, err, 4, "SSTORE", 2, ReasonBreakpoint)
+
+	status, err = s.StepOut()
+	checkStatus(t, status, err, 19, "STOP", 1, ReasonStep)
+	if len(s.Breakpoints()) != 2 {
+		t.Errorf("unexpected breakpoints %v", s.Breakpoints())
+	}
+	s.Close()
+	if !s.Status().Finished {
+		t.Fatal("closed session not finished")
+	}
+}
+
+func TestSessionAPI(t *testing.T) {
+	server := rpc.NewServer()
+	defer server.Stop()
+	loader := func(source json.RawMessage) (Runner, error) {
+		return testRunner(), nil
+	}
+	for _, api := range APIs(loader, nil) {
+		if err := server.RegisterName(api.Namespace, api.Service); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := rpc.DialInProc(server)
+	defer client.Close()
+
+	var id rpc.ID
+	if err := client.Call(&id, "debug_evmSessionOpen", json.RawMessage(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	var bp int
+	if err := client.Call(&bp, "debug_evmSessionSetBreakpoint", id, map[string]interface{}{"pc": 18}); err != nil {
+		t.Fatal(err)
+	}
+	var status Status
+	if err := client.Call(&status, "debug_evmSessionContinue", id); err != nil {
+		t.Fatal(err)
+	}
+	checkStatus(t, status, nil, 18, "CALL", 1, ReasonBreakpoint)
+
+	var stack []*hexutil.Big
+	if err := client.Call(&stack, "debug_evmSessionStack", id); err != nil {
+		t.Fatal(err)
+	}
+	if len(stack) != 7 {
+		t.Errorf("unexpected stack %v", stack)
+	}
+	var mem hexutil.Bytes
+	if err := client.Call(&mem, "debug_evmSessionMemory", id, hexutil.Uint64(31), hexutil.Uint64(1)); err != nil {
+		t.Fatal(err)
+	}
+	if len(mem) != 1 || mem[0] != 42 {
+		t.Errorf("unexpected memory %x", mem)
+	}
+	if err := client.Call(&status, "debug_evmSessionStepBack", id); err != nil {
+		t.Fatal(err)
+	}
+	checkStatus(t, status, nil, 17, "GAS", 1, ReasonStep)
+	if err := client.Call(nil, "debug_evmSessionClose", id); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call(&status, "debug_evmSessionStatus", id); err == nil {
+		t.Error("closed session still available")
+	}
+}
+
+func TestSessionAPILimits(t *testing.T) {
+	loader := func(source json.RawMessage) (Runner, error) {
+		return testRunner(), nil
+	}
+	api := NewAPI(loader, &Config{MaxSessions: 1})
+	id, err := api.EvmSessionOpen(json.RawMessage(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.EvmSessionOpen(json.RawMessage(`{}`)); err != ErrTooManySessions {
+		t.Fatalf("session limit not enforced: %v", err)
+	}
+	// sessions used after the deadline are kept
+	api.closeIdle(time.Now().Add(-time.Minute))
+	if _, err := api.EvmSessionStatus(id); err != nil {
+		t.Fatal(err)
+	}
+	api.closeIdle(time.Now().Add(time.Minute))
+	if _, err := api.EvmSessionStatus(id); err == nil {
+		t.Fatal("idle session not closed")
+	}
+	if _, err := api.EvmSessionOpen(json.RawMessage(`{}`)); err != nil {
+		t.Fatalf("closed session still counted: %v", err)
+	}
+	api.closeIdle(time.Now().Add(time.Minute))
+}
+
+func TestSessionReverse(t *testing.T) {
+	s := NewSession(testRunner(), &Config{CheckpointInterval: 4})
+	defer s.Close()
+
+	if _, err := s.StepBack(); err == nil {
+		t.Fatal("stepped back from the first instruction")
+	}
+	status, err := s.GoTo(11)
+	checkStatus(t, status, err, 18, "CALL", 1, ReasonStep)
+	status, err = s.StepBack()
+	checkStatus(t, status, err, 17, "GAS", 1, ReasonStep)
+	if status.Steps != 10 {
+		t.Errorf("step mismatch: have %d, want 10", status.Steps)
+	}
+	// the memory written before the target step is restored
+	mem, err := s.Memory(31, 1)
+	if err != nil || len(mem) != 1 || mem[0] != 42 {
+		t.Errorf("unexpected memory %x, %v", mem, err)
+	}
+
+	// land on the write of a slot from the end of the execution
+	if status, err = s.Continue(); err != nil || !status.Finished {
+		t.Fatalf("unexpected status %+v, %v", status, err)
+	}
+	status, err = s.StepBack()
+	checkStatus(t, status, err, 19, "STOP", 1, ReasonStep)
+	slot := common.BigToHash(big.NewInt(1))
+	id, err := s.AddBreakpoint(Breakpoint{Slot: &slot})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err = s.ReverseContinue()
+	checkStatus(t, status, err, 4, "SSTORE", 2, ReasonBreakpoint)
+	if status.Breakpoint != id || status.Steps != 14 {
+		t.Errorf("unexpected status %+v", status)
+	}
+	value, err := s.Storage(nil, slot)
+	if err != nil || value != (common.Hash{}) {
+		t.Errorf("slot written before the SSTORE: %x, %v", value, err)
+	}
+	status, err = s.ReverseContinue()
+	checkStatus(t, status, err, 0, "PUSH1", 1, ReasonStep)
+
+	if steps := s.Checkpoints(); len(steps) != 4 || steps[0] != 4 || steps[3] != 16 {
+		t.Fatalf("unexpected checkpoints %v", steps)
+	}
+	c, ok := s.Checkpoint(12)
+	if !ok || c.PC != 0 || c.Depth != 2 || len(c.Stack) != 0 {
+		t.Errorf("unexpected checkpoint %+v", c)
+	}
+}
+
+func TestSessionDivergence(t *testing.T) {
+	// a runner pushing a different value on every execution
+	var runs byte
+	run := func(cfg vm.Config) error {
+		runs++
+		code := []byte{byte(vm.PUSH1), runs, byte(vm.PUSH1), 0, byte(vm.MSTORE), byte(vm.STOP)}
+		_, _, err := runtime.Execute(code, nil, &runtime.Config{EVMConfig: cfg})
+		return err
+	}
+	s := NewSession(run, &Config{CheckpointInterval: 2})
+	defer s.Close()
+
+	if status, err := s.Continue(); err != nil || !status.Finished || status.Error != "" {
+		t.Fatalf("unexpected status %+v, %v", status, err)
+	}
+	// the execution before the first checkpoint starts from scratch
+	status, err := s.GoTo(1)
+	checkStatus(t, status, err, 0, "PUSH1", 1, ReasonStep)
+	status, err = s.Continue()
+	if err != nil || !status.Finished || status.Error == "" {
+		t.Fatalf("divergence not detected: %+v, %v", status, err)
+	}
+}
+
+func TestSessionGoToForward(t *testing.T) {
+	var runs int
+	run := testRunner()
+	s := NewSession(func(cfg vm.Config) error {
+		runs++
+		return run(cfg)
+	}, nil)
+	defer s.Close()
+
+	status, err := s.GoTo(6)
+	checkStatus(t, status, err, 9, "PUSH1", 1, ReasonStep)
+	status, err = s.GoTo(11)
+	checkStatus(t, status, err, 18, "CALL", 1, ReasonStep)
+	if runs != 1 {
+		t.Errorf("moving forwards re-executed the transaction: %d runs", runs)
+	}
+	status, err = s.GoTo(6)
+	checkStatus(t, status, err, 9, "PUSH1", 1, ReasonStep)
+	if runs != 2 {
+		t.Errorf("run count mismatch: have %d, want 2", runs)
+	}
+}
+
+// countingTracer counts the instructions reported to a tracer.
+type countingTracer struct {
+	vm.Tracer
+	steps *int
+}
+
+func (t countingTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	*t.steps++
+	t.Tracer.CaptureState(env, pc, op, gas, cost, scope, rData, depth, err)
+}
+
+func TestSessionResume(t *testing.T) {
+	var steps int
+	run := testRunner()
+	s := NewSession(func(cfg vm.Config) error {
+		steps = 0
+		cfg.Tracer = countingTracer{cfg.Tracer, &steps}
+		return run(cfg)
+	}, &Config{CheckpointInterval: 4})
+	defer s.Close()
+
+	status, err := s.GoTo(15)
+	checkStatus(t, status, err, 5, "STOP", 2, ReasonStep)
+
+	// the execution resumes in the callee from the checkpoint at step 12 by
+	// executing the pending call again
+	status, err = s.StepBack()
+	checkStatus(t, status, err, 4, "SSTORE", 2, ReasonStep)
+	if status.Steps != 14 || steps != 4 {
+		t.Errorf("unexpected resumed execution: step %d, %d instructions", status.Steps, steps)
+	}
+	slot := common.BigToHash(big.NewInt(1))
+	if value, err := s.Storage(nil, slot); err != nil || value != (common.Hash{}) {
+		t.Errorf("slot written before the SSTORE: %x, %v", value, err)
+	}
+	status, err = s.StepInto()
+	checkStatus(t, status, err, 5, "STOP", 2, ReasonStep)
+	if value, err := s.Storage(nil, slot); err != nil || value != common.BigToHash(big.NewInt(7)) {
+		t.Errorf("slot mismatch: have %x, want 7, %v", value, err)
+	}
+
+	// the caller continues with its restored memory
+	status, err = s.StepInto()
+	checkStatus(t, status, err, 19, "STOP", 1, ReasonStep)
+	if mem, err := s.Memory(31, 1); err != nil || len(mem) != 1 || mem[0] != 42 {
+		t.Errorf("unexpected memory %x, %v", mem, err)
+	}
+	if status, err = s.Continue(); err != nil || !status.Finished || status.Steps != 16 || status.Error != "" {
+		t.Fatalf("unexpected final status %+v, %v", status, err)
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package debugger
+
+import (
+	substate "github.com/Fantom-foundation/Substate"
+	"github.com/ethereum/go-ethereum/core/replay"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// SubstateRunner returns a runner replaying the recorded substate of a
+// transaction. The interpreter is selected by cfg. If the replayed output
+// differs from the recorded one, the runner fails with a
+// *replay.MismatchError describing the divergence.
+func SubstateRunner(block uint64, tx int, s *substate.Substate, cfg *replay.Config) Runner {
+	return func(vmConfig vm.Config) error {
+		config := *cfg
+		config.VMConfig.Debug = vmConfig.Debug
+		config.VMConfig.Tracer = vmConfig.Tracer
+		config.VMConfig.Resume = vmConfig.Resume
+		return replay.Replay(block, tx, s, &config)
+	}
+}
@@ -233,6 +233,12 @@ func gatherForks(config *params.ChainConfig) []uint64 {
 			forks = append(forks, rule.Uint64())
 		}
 	}
+	// Stateful precompiles activated after genesis change the rules as well
+	for _, p := range config.StatePrecompiles {
+		if p.Block != nil {
+			forks = append(forks, p.Block.Uint64())
+		}
+	}
 	// Sort the fork block numbers to permit chronological XOR
 	for i := 0; i < len(forks); i++ {
 		for j := i + 1; j < len(forks); j++ {
@@ -19,6 +19,7 @@ package forkid
 import (
 	"bytes"
 	"math"
+	"math/big"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -245,3 +246,19 @@ func TestEncoding(t *testing.T) {
 		}
 	}
 }
+
+// Tests that the activation blocks of stateful precompiles are fork blocks.
+func TestStatePrecompileForks(t *testing.T) {
+	config := *params.MainnetChainConfig
+	config.StatePrecompiles = []params.StatePrecompileConfig{
+		{Name: "genesis", Address: common.HexToAddress("0x1000")},
+		{Name: "late", Address: common.HexToAddress("0x1001"), Block: big.NewInt(20000000)},
+	}
+	forks := gatherForks(&config)
+	if have, want := forks[len(forks)-1], uint64(20000000); have != want {
+		t.Fatalf("last fork mismatch: have %d, want %d", have, want)
+	}
+	if have := NewID(&config, params.MainnetGenesisHash, 13773000); have.Next != 20000000 {
+		t.Errorf("next fork mismatch: have %d, want 20000000", have.Next)
+	}
+}
@@ -0,0 +1,306 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package replay
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	substate "github.com/Fantom-foundation/Substate"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// FieldDiff is a single differing value, rendered as string for both the text
+// and the JSON report.
+type FieldDiff struct {
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// SlotDiff is a storage slot with differing values. A slot missing on one
+// side is reported with the zero value and flagged, so a missing slot is
+// distinguished from a slot holding zero.
+type SlotDiff struct {
+	Key        common.Hash `json:"key"`
+	Expected   common.Hash `json:"expected"`
+	Actual     common.Hash `json:"actual"`
+	Missing    bool        `json:"missing,omitempty"`    // expected slot absent in the actual alloc
+	Unexpected bool        `json:"unexpected,omitempty"` // actual slot absent in the expected alloc
+}
+
+// AccountDiff lists all differences of a single account.
+type AccountDiff struct {
+	Address    common.Address `json:"address"`
+	Missing    bool           `json:"missing,omitempty"`    // expected account absent in the actual alloc
+	Unexpected bool           `json:"unexpected,omitempty"` // actual account absent in the expected alloc
+	Nonce      *FieldDiff     `json:"nonce,omitempty"`
+	Balance    *FieldDiff     `json:"balance,omitempty"`
+	Code       *FieldDiff     `json:"code,omitempty"` // code hashes
+	Storage    []SlotDiff     `json:"storage,omitempty"`
+}
+
+// LogDiff is a difference in the log at the given index. Field is one of
+// "address", "topics", "data" or "log" for a log present on one side only.
+type LogDiff struct {
+	Index int    `json:"index"`
+	Field string `json:"field"`
+	FieldDiff
+}
+
+// ResultDiff lists all differences of the transaction result (receipt).
+type ResultDiff struct {
+	Status          *FieldDiff `json:"status,omitempty"`
+	GasUsed         *FieldDiff `json:"gasUsed,omitempty"`
+	ContractAddress *FieldDiff `json:"contractAddress,omitempty"`
+	Bloom           *FieldDiff `json:"bloom,omitempty"`
+	Logs            []LogDiff  `json:"logs,omitempty"`
+}
+
+// Diff is the structured difference between a recorded and a replayed
+// substate output. Accounts are ordered by address and storage slots by key,
+// so the first entry is the first divergence in a deterministic order.
+type Diff struct {
+	Accounts []AccountDiff `json:"accounts,omitempty"`
+	Result   *ResultDiff   `json:"result,omitempty"`
+}
+
+// Empty returns whether no difference was found.
+func (d *Diff) Empty() bool {
+	return len(d.Accounts) == 0 && d.Result == nil
+}
+
+// Compare computes the difference between the expected and actual output
+// alloc and result.
+func Compare(expected, actual substate.SubstateAlloc, expectedResult, actualResult *substate.SubstateResult) *Diff {
+	return &Diff{
+		Accounts: DiffAlloc(expected, actual),
+		Result:   DiffResult(expectedResult, actualResult),
+	}
+}
+
+// DiffAlloc returns the per-account differences of two allocs, ordered by
+// address. An account listed on one side only is reported as missing or
+// unexpected even if it is nil, matching SubstateAlloc.Equal.
+func DiffAlloc(expected, actual substate.SubstateAlloc) []AccountDiff {
+	addrs := make(map[common.Address]struct{})
+	for addr := range expected {
+		addrs[addr] = struct{}{}
+	}
+	for addr := range actual {
+		addrs[addr] = struct{}{}
+	}
+	sorted := make([]common.Address, 0, len(addrs))
+	for addr := range addrs {
+		sorted = append(sorted, addr)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i][:], sorted[j][:]) < 0 })
+
+	var diffs []AccountDiff
+	for _, addr := range sorted {
+		x, inExpected := expected[addr]
+		y, inActual := actual[addr]
+		switch {
+		case !inActual:
+			diffs = append(diffs, AccountDiff{Address: addr, Missing: true})
+		case !inExpected:
+			diffs = append(diffs, AccountDiff{Address: addr, Unexpected: true})
+		default:
+			if diff := DiffAccount(addr, x, y); diff != nil {
+				diffs = append(diffs, *diff)
+			}
+		}
+	}
+	return diffs
+}
+
+// DiffAccount returns the differences of a single account or nil if both are
+// equal. Either of the accounts may be nil.
+func DiffAccount(addr common.Address, expected, actual *substate.SubstateAccount) *AccountDiff {
+	switch {
+	case expected == nil && actual == nil:
+		return nil
+	case actual == nil:
+		return &AccountDiff{Address: addr, Missing: true}
+	case expected == nil:
+		return &AccountDiff{Address: addr, Unexpected: true}
+	}
+	diff := &AccountDiff{Address: addr}
+	if expected.Nonce != actual.Nonce {
+		diff.Nonce = &FieldDiff{fmt.Sprint(expected.Nonce), fmt.Sprint(actual.Nonce)}
+	}
+	if expected.Balance.Cmp(actual.Balance) != 0 {
+		diff.Balance = &FieldDiff{expected.Balance.String(), actual.Balance.String()}
+	}
+	if !bytes.Equal(expected.Code, actual.Code) {
+		diff.Code = &FieldDiff{crypto.Keccak256Hash(expected.Code).Hex(), crypto.Keccak256Hash(actual.Code).Hex()}
+	}
+	keys := make(map[common.Hash]struct{})
+	for key := range expected.Storage {
+		keys[key] = struct{}{}
+	}
+	for key := range actual.Storage {
+		keys[key] = struct{}{}
+	}
+	for key := range keys {
+		x, inExpected := expected.Storage[key]
+		y, inActual := actual.Storage[key]
+		if x != y || inExpected != inActual {
+			diff.Storage = append(diff.Storage, SlotDiff{Key: key, Expected: x, Actual: y, Missing: !inActual, Unexpected: !inExpected})
+		}
+	}
+	sort.Slice(diff.Storage, func(i, j int) bool {
+		return bytes.Compare(diff.Storage[i].Key[:], diff.Storage[j].Key[:]) < 0
+	})
+	if diff.Nonce == nil && diff.Balance == nil && diff.Code == nil && len(diff.Storage) == 0 {
+		return nil
+	}
+	return diff
+}
+
+// DiffResult returns the differences of two transaction results or nil if
+// they are equal.
+func DiffResult(expected, actual *substate.SubstateResult) *ResultDiff {
+	if expected == nil || actual == nil {
+		if expected == actual {
+			return nil
+		}
+		return &ResultDiff{Status: &FieldDiff{resultString(expected), resultString(actual)}}
+	}
+	diff := new(ResultDiff)
+	if expected.Status != actual.Status {
+		diff.Status = &FieldDiff{statusString(expected.Status), statusString(actual.Status)}
+	}
+	if expected.GasUsed != actual.GasUsed {
+		diff.GasUsed = &FieldDiff{fmt.Sprint(expected.GasUsed), fmt.Sprint(actual.GasUsed)}
+	}
+	if expected.ContractAddress != actual.ContractAddress {
+		diff.ContractAddress = &FieldDiff{expected.ContractAddress.Hex(), actual.ContractAddress.Hex()}
+	}
+	if expected.Bloom != actual.Bloom {
+		diff.Bloom = &FieldDiff{fmt.Sprintf("%#x", expected.Bloom[:]), fmt.Sprintf("%#x", actual.Bloom[:])}
+	}
+	diff.Logs = diffLogs(expected.Logs, actual.Logs)
+	if diff.Status == nil && diff.GasUsed == nil && diff.ContractAddress == nil && diff.Bloom == nil && len(diff.Logs) == 0 {
+		return nil
+	}
+	return diff
+}
+
+// diffLogs compares two log lists entry by entry.
+func diffLogs(expected, actual []*types.Log) []LogDiff {
+	var diffs []LogDiff
+	for i := 0; i < len(expected) || i < len(actual); i++ {
+		switch {
+		case i >= len(actual):
+			diffs = append(diffs, LogDiff{i, "log", FieldDiff{logString(expected[i]), "<none>"}})
+		case i >= len(expected):
+			diffs = append(diffs, LogDiff{i, "log", FieldDiff{"<none>", logString(actual[i])}})
+		default:
+			x, y := expected[i], actual[i]
+			if x.Address != y.Address {
+				diffs = append(diffs, LogDiff{i, "address", FieldDiff{x.Address.Hex(), y.Address.Hex()}})
+			}
+			if topicsString(x.Topics) != topicsString(y.Topics) {
+				diffs = append(diffs, LogDiff{i, "topics", FieldDiff{topicsString(x.Topics), topicsString(y.Topics)}})
+			}
+			if !bytes.Equal(x.Data, y.Data) {
+				diffs = append(diffs, LogDiff{i, "data", FieldDiff{fmt.Sprintf("%#x", x.Data), fmt.Sprintf("%#x", y.Data)}})
+			}
+		}
+	}
+	return diffs
+}
+
+func statusString(status uint64) string {
+	if status == types.ReceiptStatusSuccessful {
+		return "successful"
+	}
+	return "failed"
+}
+
+func resultString(result *substate.SubstateResult) string {
+	if result == nil {
+		return "<none>"
+	}
+	return statusString(result.Status)
+}
+
+func topicsString(topics []common.Hash) string {
+	s := make([]string, len(topics))
+	for i, topic := range topics {
+		s[i] = topic.Hex()
+	}
+	return "[" + strings.Join(s, ",") + "]"
+}
+
+func logString(log *types.Log) string {
+	return fmt.Sprintf("{address: %s, topics: %s, data: %#x}", log.Address.Hex(), topicsString(log.Topics), log.Data)
+}
+
+// WriteText writes the human readable report of the difference to w, one
+// line per differing value.
+func (d *Diff) WriteText(w io.Writer) {
+	if r := d.Result; r != nil {
+		writeField(w, "result status", r.Status)
+		writeField(w, "result gas used", r.GasUsed)
+		writeField(w, "result contract address", r.ContractAddress)
+		writeField(w, "result bloom", r.Bloom)
+		for _, log := range r.Logs {
+			writeField(w, fmt.Sprintf("result log %d %s", log.Index, log.Field), &log.FieldDiff)
+		}
+	}
+	for _, account := range d.Accounts {
+		prefix := "account " + account.Address.Hex()
+		switch {
+		case account.Missing:
+			fmt.Fprintf(w, "%s: missing\n", prefix)
+		case account.Unexpected:
+			fmt.Fprintf(w, "%s: unexpected\n", prefix)
+		}
+		writeField(w, prefix+" nonce", account.Nonce)
+		writeField(w, prefix+" balance", account.Balance)
+		writeField(w, prefix+" code hash", account.Code)
+		for _, slot := range account.Storage {
+			expected, actual := slot.Expected.Hex(), slot.Actual.Hex()
+			if slot.Unexpected {
+				expected = "<none>"
+			}
+			if slot.Missing {
+				actual = "<none>"
+			}
+			fmt.Fprintf(w, "%s storage %s: expected %s, got %s\n", prefix, slot.Key.Hex(), expected, actual)
+		}
+	}
+}
+
+func writeField(w io.Writer, name string, diff *FieldDiff) {
+	if diff != nil {
+		fmt.Fprintf(w, "%s: expected %s, got %s\n", name, diff.Expected, diff.Actual)
+	}
+}
+
+// String returns the human readable report of the difference.
+func (d *Diff) String() string {
+	var buf bytes.Buffer
+	d.WriteText(&buf)
+	return buf.String()
+}
@@ -0,0 +1,134 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package replay
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	substate "github.com/Fantom-foundation/Substate"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestDiffAlloc(t *testing.T) {
+	var (
+		addr1 = common.HexToAddress("0x01")
+		addr2 = common.HexToAddress("0x02")
+		addr3 = common.HexToAddress("0x03")
+		slot1 = common.HexToHash("0x01")
+		slot2 = common.HexToHash("0x02")
+	)
+	expected := substate.SubstateAlloc{
+		addr1: substate.NewSubstateAccount(1, big.NewInt(10), nil),
+		addr2: substate.NewSubstateAccount(0, big.NewInt(0), []byte{0x00}),
+	}
+	expected[addr2].Storage[slot1] = common.HexToHash("0xaa")
+	expected[addr2].Storage[slot2] = common.HexToHash("0xbb")
+
+	actual := substate.SubstateAlloc{
+		addr1: substate.NewSubstateAccount(1, big.NewInt(10), nil),
+		addr2: substate.NewSubstateAccount(0, big.NewInt(0), []byte{0x00}),
+		addr3: substate.NewSubstateAccount(0, big.NewInt(1), nil),
+	}
+	actual[addr2].Storage[slot1] = common.HexToHash("0xaa")
+	actual[addr2].Storage[slot2] = common.HexToHash("0xcc")
+
+	diffs := DiffAlloc(expected, actual)
+	if len(diffs) != 2 {
+		t.Fatalf("wrong number of account diffs: have %d, want 2", len(diffs))
+	}
+	if diffs[0].Address != addr2 || len(diffs[0].Storage) != 1 || diffs[0].Storage[0].Key != slot2 {
+		t.Errorf("wrong storage diff: %+v", diffs[0])
+	}
+	if diffs[1].Address != addr3 || !diffs[1].Unexpected {
+		t.Errorf("wrong unexpected account diff: %+v", diffs[1])
+	}
+	if diffs := DiffAlloc(expected, expected); len(diffs) != 0 {
+		t.Errorf("diff of equal allocs not empty: %+v", diffs)
+	}
+}
+
+func TestDiffAllocPresence(t *testing.T) {
+	var (
+		addr1 = common.HexToAddress("0x01")
+		addr2 = common.HexToAddress("0x02")
+		slot  = common.HexToHash("0x01")
+	)
+	// A slot holding zero differs from a missing slot and a nil account from
+	// a missing one, as for SubstateAlloc.Equal.
+	expected := substate.SubstateAlloc{
+		addr1: substate.NewSubstateAccount(0, big.NewInt(0), nil),
+		addr2: nil,
+	}
+	expected[addr1].Storage[slot] = common.Hash{}
+	actual := substate.SubstateAlloc{
+		addr1: substate.NewSubstateAccount(0, big.NewInt(0), nil),
+	}
+	if expected.Equal(actual) {
+		t.Fatalf("allocs unexpectedly equal")
+	}
+	diffs := DiffAlloc(expected, actual)
+	if len(diffs) != 2 {
+		t.Fatalf("wrong number of account diffs: have %d, want 2", len(diffs))
+	}
+	if len(diffs[0].Storage) != 1 || !diffs[0].Storage[0].Missing {
+		t.Errorf("missing slot not reported: %+v", diffs[0])
+	}
+	if diffs[1].Address != addr2 || !diffs[1].Missing {
+		t.Errorf("missing nil account not reported: %+v", diffs[1])
+	}
+	if text := Compare(expected, actual, nil, nil).String(); !strings.Contains(text, "expected 0x0000000000000000000000000000000000000000000000000000000000000000, got <none>") {
+		t.Errorf("missing slot not marked in text report:\n%s", text)
+	}
+}
+
+func TestDiffResult(t *testing.T) {
+	expected := &substate.SubstateResult{
+		Status:  types.ReceiptStatusSuccessful,
+		GasUsed: 21000,
+		Logs:    []*types.Log{{Address: common.HexToAddress("0x01"), Data: []byte{1}}},
+	}
+	actual := &substate.SubstateResult{
+		Status:  types.ReceiptStatusFailed,
+		GasUsed: 21000,
+		Logs:    []*types.Log{{Address: common.HexToAddress("0x01"), Data: []byte{2}}},
+	}
+	diff := Compare(nil, nil, expected, actual)
+	if diff.Empty() {
+		t.Fatalf("diff of different results is empty")
+	}
+	if diff.Result.Status == nil || diff.Result.GasUsed != nil {
+		t.Errorf("wrong result diff: %+v", diff.Result)
+	}
+	if len(diff.Result.Logs) != 1 || diff.Result.Logs[0].Field != "data" {
+		t.Errorf("wrong log diff: %+v", diff.Result.Logs)
+	}
+	text := diff.String()
+	if !strings.Contains(text, "result status: expected successful, got failed") {
+		t.Errorf("missing status in text report:\n%s", text)
+	}
+	out, err := json.Marshal(diff)
+	if err != nil {
+		t.Fatalf("failed to marshal diff: %v", err)
+	}
+	if !strings.Contains(string(out), `"status":{"expected":"successful","actual":"failed"}`) {
+		t.Errorf("wrong JSON report: %s", out)
+	}
+}
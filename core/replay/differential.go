@@ -0,0 +1,392 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package replay
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	substate "github.com/Fantom-foundation/Substate"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// Outcome is the result of executing a message. Together with the call frames
+// observed during the execution, it is compared by Differential. If State
+// records the substate, see StateDB.EnableSubstateRecording, diverging states
+// are reported with the accounts and slots accessed by either execution.
+type Outcome struct {
+	State      *state.StateDB // state after the message
+	Root       common.Hash    // state root after the message
+	GasUsed    uint64         // gas used by the message, zero if unknown
+	Logs       []*types.Log   // logs emitted by the message
+	ReturnData []byte         // data returned by the message
+	Err        error          // execution error of the message, e.g. a revert
+}
+
+// Execution executes a message on a fresh copy of its pre-state. The
+// InterpreterImpl, Debug and Tracer fields of cfg have to be applied to the
+// EVM executing the message. The returned error is only non-nil if the
+// message could not be applied at all.
+type Execution func(cfg vm.Config) (*Outcome, error)
+
+// SubstateExecution returns an Execution of the message of a recorded
+// substate on its input alloc.
+func SubstateExecution(tx int, s *substate.Substate, cfg *Config) Execution {
+	return func(vmConfig vm.Config) (*Outcome, error) {
+		config := *cfg
+		config.VMConfig.InterpreterImpl = vmConfig.InterpreterImpl
+		config.VMConfig.Debug, config.VMConfig.Tracer = vmConfig.Debug, vmConfig.Tracer
+		statedb, evm, result, err := execute(tx, s, &config)
+		if err != nil {
+			return nil, err
+		}
+		return &Outcome{
+			State:      statedb,
+			Root:       statedb.IntermediateRoot(config.chainConfig().IsEIP158(evm.Context.BlockNumber)),
+			GasUsed:    result.UsedGas,
+			Logs:       statedb.GetLogs(txHash, blockHash),
+			ReturnData: result.ReturnData,
+			Err:        result.Err,
+		}, nil
+	}
+}
+
+// CallFrame is a call frame observed during an execution.
+type CallFrame struct {
+	Type    string         `json:"type"`
+	Depth   int            `json:"depth"`
+	From    common.Address `json:"from"`
+	To      common.Address `json:"to"`
+	Input   hexutil.Bytes  `json:"input"`
+	Gas     uint64         `json:"gas"`
+	Value   *big.Int       `json:"value,omitempty"`
+	Output  hexutil.Bytes  `json:"output"`
+	GasUsed uint64         `json:"gasUsed"`
+	Error   string         `json:"error,omitempty"` // error class, see ErrorClass
+}
+
+func (f *CallFrame) String() string {
+	return fmt.Sprintf("%s %s at depth %d", f.Type, f.To.Hex(), f.Depth)
+}
+
+// ErrorClass maps an execution error to an implementation independent class,
+// so interpreters reporting the same failure with different messages are
+// considered equal. Unknown errors are classified by their message.
+func ErrorClass(err error) string {
+	switch err.(type) {
+	case nil:
+		return ""
+	case *vm.ErrStackUnderflow:
+		return "stack underflow"
+	case *vm.ErrStackOverflow:
+		return "stack overflow"
+	case *vm.ErrInvalidOpCode:
+		return "invalid opcode"
+	}
+	for _, known := range []error{
+		vm.ErrOutOfGas, vm.ErrCodeStoreOutOfGas, vm.ErrDepth, vm.ErrInsufficientBalance,
+		vm.ErrContractAddressCollision, vm.ErrExecutionReverted, vm.ErrMaxCodeSizeExceeded,
+		vm.ErrInvalidJump, vm.ErrWriteProtection, vm.ErrReturnDataOutOfBounds,
+		vm.ErrGasUintOverflow, vm.ErrInvalidCode,
+	} {
+		if errors.Is(err, known) {
+			return known.Error()
+		}
+	}
+	return err.Error()
+}
+
+// ValueDiff is a differing value of a call frame or of the outcome.
+type ValueDiff struct {
+	Field string `json:"field"`
+	FieldDiff
+}
+
+// DivergenceError is returned by Differential if the execution of an
+// interpreter differs from the one of the reference interpreter.
+type DivergenceError struct {
+	Reference   string        `json:"reference"`
+	Interpreter string        `json:"interpreter"`
+	FrameIndex  int           `json:"frameIndex"`      // index of the call frame in execution order, -1 for the outcome
+	Frame       *CallFrame    `json:"frame,omitempty"` // differing call frame of the reference
+	Values      []ValueDiff   `json:"values,omitempty"`
+	Accounts    []AccountDiff `json:"accounts,omitempty"`
+}
+
+// Error implements error, listing every difference on its own line.
+func (e *DivergenceError) Error() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "interpreter %s diverges from %s", e.Interpreter, e.Reference)
+	switch {
+	case e.Frame != nil:
+		fmt.Fprintf(&buf, " in call frame %d (%s)", e.FrameIndex, e.Frame)
+	case e.FrameIndex >= 0:
+		fmt.Fprintf(&buf, " in call frame %d", e.FrameIndex)
+	default:
+		fmt.Fprint(&buf, " in the outcome")
+	}
+	for _, v := range e.Values {
+		fmt.Fprintf(&buf, "\n  %s: expected %s, got %s", v.Field, v.Expected, v.Actual)
+	}
+	if len(e.Accounts) > 0 {
+		report := strings.TrimRight((&Diff{Accounts: e.Accounts}).String(), "\n")
+		fmt.Fprintf(&buf, "\n  %s", strings.ReplaceAll(report, "\n", "\n  "))
+	}
+	return buf.String()
+}
+
+// Differential executes exec once with each of the given interpreters and
+// compares every execution with the one of the first interpreter, the
+// reference. The call frames are compared while executing, so a diverging
+// execution is cancelled at its first differing call frame. Afterwards, the
+// refund, gas usage, return data, error, logs and resulting state are
+// compared. The first divergence is returned as *DivergenceError.
+func Differential(exec Execution, interpreters []string) error {
+	if len(interpreters) < 2 {
+		return errors.New("differential execution requires at least two interpreters")
+	}
+	for _, name := range interpreters {
+		if !vm.HasInterpreterFactory(name) {
+			return fmt.Errorf("no interpreter %q registered", name)
+		}
+	}
+	reference := newFrameRecorder(nil)
+	expected, err := exec(vm.Config{InterpreterImpl: interpreters[0], Debug: true, Tracer: reference})
+	if err != nil {
+		return fmt.Errorf("interpreter %s: %v", interpreters[0], err)
+	}
+	for _, name := range interpreters[1:] {
+		recorder := newFrameRecorder(reference)
+		actual, err := exec(vm.Config{InterpreterImpl: name, Debug: true, Tracer: recorder})
+		if err != nil {
+			return fmt.Errorf("interpreter %s: %v", name, err)
+		}
+		if recorder.divergence == nil {
+			recorder.compareOutcome(expected, actual)
+		}
+		if d := recorder.divergence; d != nil {
+			d.Reference, d.Interpreter = interpreters[0], name
+			return d
+		}
+	}
+	return nil
+}
+
+// frameRecorder is a tracer recording the call frames of an execution and
+// comparing them with the frames of a reference execution.
+type frameRecorder struct {
+	reference *frameRecorder // recorder of the reference execution, nil for the reference itself
+
+	env    *vm.EVM
+	frames []*CallFrame
+	open   []int  // indices of the unfinished frames
+	refund uint64 // refund counter at the end of the execution
+
+	divergence *DivergenceError
+}
+
+func newFrameRecorder(reference *frameRecorder) *frameRecorder {
+	return &frameRecorder{reference: reference}
+}
+
+// diverge records the first divergence and cancels the execution.
+func (r *frameRecorder) diverge(index int, frame *CallFrame, values []ValueDiff) {
+	r.divergence = &DivergenceError{FrameIndex: index, Frame: frame, Values: values}
+	if r.env != nil {
+		r.env.Cancel()
+	}
+}
+
+// comparing reports whether frames still have to be compared.
+func (r *frameRecorder) comparing() bool {
+	return r.reference != nil && r.divergence == nil
+}
+
+func (r *frameRecorder) enter(frame *CallFrame) {
+	index := len(r.frames)
+	r.frames = append(r.frames, frame)
+	r.open = append(r.open, index)
+	if !r.comparing() {
+		return
+	}
+	if index >= len(r.reference.frames) {
+		r.diverge(index, nil, []ValueDiff{{"frame", FieldDiff{"<none>", frame.String()}}})
+		return
+	}
+	expected := r.reference.frames[index]
+	var diffs []ValueDiff
+	compare := func(field string, equal bool, x, y interface{}) {
+		if !equal {
+			diffs = append(diffs, ValueDiff{field, FieldDiff{fmt.Sprint(x), fmt.Sprint(y)}})
+		}
+	}
+	compare("type", expected.Type == frame.Type, expected.Type, frame.Type)
+	compare("depth", expected.Depth == frame.Depth, expected.Depth, frame.Depth)
+	compare("from", expected.From == frame.From, expected.From.Hex(), frame.From.Hex())
+	compare("to", expected.To == frame.To, expected.To.Hex(), frame.To.Hex())
+	compare("input", bytes.Equal(expected.Input, frame.Input), expected.Input, frame.Input)
+	compare("gas", expected.Gas == frame.Gas, expected.Gas, frame.Gas)
+	compare("value", bigString(expected.Value) == bigString(frame.Value), bigString(expected.Value), bigString(frame.Value))
+	if len(diffs) > 0 {
+		r.diverge(index, expected, diffs)
+	}
+}
+
+func (r *frameRecorder) exit(output []byte, gasUsed uint64, err error) {
+	index := r.open[len(r.open)-1]
+	r.open = r.open[:len(r.open)-1]
+	frame := r.frames[index]
+	frame.Output, frame.GasUsed, frame.Error = common.CopyBytes(output), gasUsed, ErrorClass(err)
+	if !r.comparing() {
+		return
+	}
+	expected := r.reference.frames[index]
+	var diffs []ValueDiff
+	if !bytes.Equal(expected.Output, frame.Output) {
+		diffs = append(diffs, ValueDiff{"output", FieldDiff{expected.Output.String(), frame.Output.String()}})
+	}
+	if expected.GasUsed != frame.GasUsed {
+		diffs = append(diffs, ValueDiff{"gasUsed", FieldDiff{fmt.Sprint(expected.GasUsed), fmt.Sprint(frame.GasUsed)}})
+	}
+	if expected.Error != frame.Error {
+		diffs = append(diffs, ValueDiff{"error", FieldDiff{errorString(expected.Error), errorString(frame.Error)}})
+	}
+	if len(diffs) > 0 {
+		r.diverge(index, expected, diffs)
+	}
+}
+
+// compareOutcome compares the outcome of a completed execution with the
+// outcome of the reference.
+func (r *frameRecorder) compareOutcome(expected, actual *Outcome) {
+	if n := len(r.frames); n < len(r.reference.frames) {
+		missing := r.reference.frames[n]
+		r.diverge(n, missing, []ValueDiff{{"frame", FieldDiff{missing.String(), "<none>"}}})
+		return
+	}
+	var diffs []ValueDiff
+	if r.reference.refund != r.refund {
+		diffs = append(diffs, ValueDiff{"refund", FieldDiff{fmt.Sprint(r.reference.refund), fmt.Sprint(r.refund)}})
+	}
+	if expected.GasUsed != actual.GasUsed {
+		diffs = append(diffs, ValueDiff{"gasUsed", FieldDiff{fmt.Sprint(expected.GasUsed), fmt.Sprint(actual.GasUsed)}})
+	}
+	if !bytes.Equal(expected.ReturnData, actual.ReturnData) {
+		diffs = append(diffs, ValueDiff{"returnData", FieldDiff{hexutil.Encode(expected.ReturnData), hexutil.Encode(actual.ReturnData)}})
+	}
+	if class, actualClass := ErrorClass(expected.Err), ErrorClass(actual.Err); class != actualClass {
+		diffs = append(diffs, ValueDiff{"error", FieldDiff{errorString(class), errorString(actualClass)}})
+	}
+	for _, log := range diffLogs(expected.Logs, actual.Logs) {
+		diffs = append(diffs, ValueDiff{fmt.Sprintf("log %d %s", log.Index, log.Field), log.FieldDiff})
+	}
+	var accounts []AccountDiff
+	if expected.Root != actual.Root {
+		diffs = append(diffs, ValueDiff{"root", FieldDiff{expected.Root.Hex(), actual.Root.Hex()}})
+		touched := touchedSlots(expected.State.GetSubstateAccessSet(), actual.State.GetSubstateAccessSet())
+		accounts = DiffAlloc(touchedAlloc(expected.State, touched), touchedAlloc(actual.State, touched))
+	}
+	if len(diffs) > 0 {
+		r.diverge(-1, nil, diffs)
+		r.divergence.Accounts = accounts
+	}
+}
+
+// touchedSlots returns the accounts accessed in any of the access sets, along
+// with the storage slots written in any of them. Other slots hold their
+// original value in every execution.
+func touchedSlots(sets ...state.AccessSet) map[common.Address]map[common.Hash]struct{} {
+	touched := make(map[common.Address]map[common.Hash]struct{})
+	for _, set := range sets {
+		for addr, access := range set {
+			if touched[addr] == nil {
+				touched[addr] = make(map[common.Hash]struct{})
+			}
+			for slot := range access.WriteStorage {
+				touched[addr][slot] = struct{}{}
+			}
+		}
+	}
+	return touched
+}
+
+// touchedAlloc returns the given accounts and slots of a state. Accounts
+// which do not exist are omitted.
+func touchedAlloc(statedb *state.StateDB, accounts map[common.Address]map[common.Hash]struct{}) substate.SubstateAlloc {
+	alloc := make(substate.SubstateAlloc)
+	for addr, slots := range accounts {
+		if !statedb.Exist(addr) {
+			continue
+		}
+		account := substate.NewSubstateAccount(statedb.GetNonce(addr), statedb.GetBalance(addr), statedb.GetCode(addr))
+		for slot := range slots {
+			account.Storage[slot] = statedb.GetState(addr, slot)
+		}
+		alloc[addr] = account
+	}
+	return alloc
+}
+
+func bigString(x *big.Int) string {
+	if x == nil {
+		return "0"
+	}
+	return x.String()
+}
+
+func errorString(class string) string {
+	if class == "" {
+		return "<none>"
+	}
+	return class
+}
+
+func (r *frameRecorder) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	r.env = env
+	typ := vm.CALL
+	if create {
+		typ = vm.CREATE
+	}
+	r.enter(&CallFrame{Type: typ.String(), Depth: 0, From: from, To: to, Input: common.CopyBytes(input), Gas: gas, Value: value})
+}
+
+func (r *frameRecorder) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+}
+
+func (r *frameRecorder) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	r.enter(&CallFrame{Type: typ.String(), Depth: len(r.open), From: from, To: to, Input: common.CopyBytes(input), Gas: gas, Value: value})
+}
+
+func (r *frameRecorder) CaptureExit(output []byte, gasUsed uint64, err error) {
+	r.exit(output, gasUsed, err)
+}
+
+func (r *frameRecorder) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+}
+
+func (r *frameRecorder) CaptureEnd(output []byte, gasUsed uint64, _ time.Duration, err error) {
+	r.refund = r.env.StateDB.GetRefund()
+	r.exit(output, gasUsed, err)
+}
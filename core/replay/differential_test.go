@@ -0,0 +1,138 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package replay
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// faultyInterpreter wraps the geth interpreter and corrupts the execution of
+// the test contract after it finished.
+type faultyInterpreter struct {
+	vm.EVMInterpreter
+	evm     *vm.EVM
+	corrupt func(evm *vm.EVM, contract *vm.Contract)
+}
+
+func (in *faultyInterpreter) Run(contract *vm.Contract, input []byte, readOnly bool) ([]byte, error) {
+	ret, err := in.EVMInterpreter.Run(contract, input, readOnly)
+	if contract.Address() == common.HexToAddress("0xc0de") {
+		in.corrupt(in.evm, contract)
+	}
+	return ret, err
+}
+
+func registerFaultyInterpreter(name string, corrupt func(evm *vm.EVM, contract *vm.Contract)) {
+	vm.RegisterInterpreterFactory(name, func(evm *vm.EVM, cfg vm.Config) vm.EVMInterpreter {
+		return &faultyInterpreter{vm.NewEVMInterpreter(evm, cfg), evm, corrupt}
+	})
+}
+
+func init() {
+	registerFaultyInterpreter("test-gas", func(evm *vm.EVM, contract *vm.Contract) {
+		contract.Gas--
+	})
+	registerFaultyInterpreter("test-nonce", func(evm *vm.EVM, contract *vm.Contract) {
+		evm.StateDB.SetNonce(contract.Address(), evm.StateDB.GetNonce(contract.Address())+1)
+	})
+}
+
+func TestDifferentialEqual(t *testing.T) {
+	recorder, config := recordTestChain(t)
+	exec := SubstateExecution(0, recorder[2][0], &Config{ChainConfig: config})
+	if err := Differential(exec, []string{"geth", ""}); err != nil {
+		t.Fatalf("identical interpreters diverged: %v", err)
+	}
+	if err := Differential(exec, []string{"geth"}); err == nil {
+		t.Error("single interpreter accepted")
+	}
+	if err := Differential(exec, []string{"geth", "nosuchinterpreter"}); err == nil {
+		t.Error("unknown interpreter accepted")
+	}
+}
+
+func TestDifferentialFrameDivergence(t *testing.T) {
+	recorder, config := recordTestChain(t)
+	exec := SubstateExecution(0, recorder[2][0], &Config{ChainConfig: config})
+	err := Differential(exec, []string{"geth", "test-gas"})
+	var divergence *DivergenceError
+	if !errors.As(err, &divergence) {
+		t.Fatalf("expected divergence error, got %v", err)
+	}
+	if divergence.Interpreter != "test-gas" || divergence.FrameIndex != 0 || divergence.Frame == nil {
+		t.Fatalf("wrong divergence: %v", err)
+	}
+	if len(divergence.Values) != 1 || divergence.Values[0].Field != "gasUsed" {
+		t.Errorf("wrong differing values: %+v", divergence.Values)
+	}
+}
+
+func TestDifferentialStateDivergence(t *testing.T) {
+	recorder, config := recordTestChain(t)
+	exec := SubstateExecution(0, recorder[2][0], &Config{ChainConfig: config})
+	err := Differential(exec, []string{"geth", "test-nonce"})
+	var divergence *DivergenceError
+	if !errors.As(err, &divergence) {
+		t.Fatalf("expected divergence error, got %v", err)
+	}
+	if divergence.FrameIndex != -1 || len(divergence.Values) != 1 || divergence.Values[0].Field != "root" {
+		t.Fatalf("wrong divergence: %v", err)
+	}
+	if len(divergence.Accounts) != 1 || divergence.Accounts[0].Address != common.HexToAddress("0xc0de") || divergence.Accounts[0].Nonce == nil {
+		t.Errorf("wrong account diffs: %+v", divergence.Accounts)
+	}
+}
+
+func TestDifferentialResultDivergence(t *testing.T) {
+	recorder, config := recordTestChain(t)
+	exec := SubstateExecution(0, recorder[2][0], &Config{ChainConfig: config})
+	// an execution whose result differs with identical call frames
+	corrupted := func(cfg vm.Config) (*Outcome, error) {
+		outcome, err := exec(cfg)
+		if err == nil && cfg.InterpreterImpl == "" {
+			outcome.ReturnData, outcome.Err = []byte{1}, vm.ErrExecutionReverted
+		}
+		return outcome, err
+	}
+	err := Differential(corrupted, []string{"geth", ""})
+	var divergence *DivergenceError
+	if !errors.As(err, &divergence) {
+		t.Fatalf("expected divergence error, got %v", err)
+	}
+	if divergence.FrameIndex != -1 || len(divergence.Values) != 2 || divergence.Values[0].Field != "returnData" || divergence.Values[1].Field != "error" {
+		t.Fatalf("wrong divergence: %v", err)
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	wrapped := &wrappedError{vm.ErrOutOfGas}
+	if class := ErrorClass(wrapped); class != vm.ErrOutOfGas.Error() {
+		t.Errorf("wrong class of wrapped error: %q", class)
+	}
+	if class := ErrorClass(nil); class != "" {
+		t.Errorf("wrong class of nil: %q", class)
+	}
+}
+
+type wrappedError struct{ err error }
+
+func (e *wrappedError) Error() string { return "wrapped: " + e.err.Error() }
+func (e *wrappedError) Unwrap() error { return e.err }
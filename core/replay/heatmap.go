@@ -0,0 +1,406 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package replay
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	substate "github.com/Fantom-foundation/Substate"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Files written by Heatmap.Write.
+const (
+	HeatmapAccountsFile = "accounts.csv"
+	HeatmapSlotsFile    = "slots.csv"
+)
+
+var (
+	// HeatmapSortKeys lists the columns a heatmap report can be sorted by.
+	HeatmapSortKeys = []string{"txs", "reads", "writes", "cold", "warm"}
+
+	// heatmapColumns are the counter columns of the heatmap reports.
+	heatmapColumns = []string{"txs", "reads", "writes", "cold", "warm", "firstblock", "lastblock"}
+)
+
+// AccessCounts counts the transactions accessing an account or a storage
+// slot. A transaction both reading and writing is counted as a read and as a
+// write. Since Berlin, every transaction is also counted as cold or warm
+// depending on whether the item was warm at the start of the transaction
+// according to EIP-2929, i.e. whether it was the sender, the recipient, a
+// precompile or in the access list of the transaction.
+type AccessCounts struct {
+	Txs        uint64 `json:"txs"`
+	Reads      uint64 `json:"reads"`
+	Writes     uint64 `json:"writes"`
+	Cold       uint64 `json:"cold"`
+	Warm       uint64 `json:"warm"`
+	FirstBlock uint64 `json:"firstBlock"`
+	LastBlock  uint64 `json:"lastBlock"`
+}
+
+// add counts an access of a transaction in the given block. warm is nil
+// before Berlin.
+func (c *AccessCounts) add(block uint64, read, write bool, warm *bool) {
+	if c.Txs == 0 || block < c.FirstBlock {
+		c.FirstBlock = block
+	}
+	if block > c.LastBlock {
+		c.LastBlock = block
+	}
+	c.Txs++
+	if read {
+		c.Reads++
+	}
+	if write {
+		c.Writes++
+	}
+	if warm != nil {
+		if *warm {
+			c.Warm++
+		} else {
+			c.Cold++
+		}
+	}
+}
+
+// get returns the counter of the given sort key.
+func (c *AccessCounts) get(key string) uint64 {
+	switch key {
+	case "reads":
+		return c.Reads
+	case "writes":
+		return c.Writes
+	case "cold":
+		return c.Cold
+	case "warm":
+		return c.Warm
+	}
+	return c.Txs
+}
+
+// AccountHeat holds the accesses of an account's fields. The storage
+// accesses of the account are summed up in SlotReads and SlotWrites. Fees
+// counts the transactions whose fee the account paid as sender or received as
+// coinbase. These fee payments are not counted as accesses, see Record.
+type AccountHeat struct {
+	Address common.Address `json:"address"`
+	AccessCounts
+	SlotReads  uint64 `json:"slotReads"`
+	SlotWrites uint64 `json:"slotWrites"`
+	Fees       uint64 `json:"fees"`
+}
+
+// SlotHeat holds the accesses of a storage slot.
+type SlotHeat struct {
+	Address common.Address `json:"address"`
+	Key     common.Hash    `json:"key"`
+	AccessCounts
+}
+
+type slotKey struct {
+	addr common.Address
+	key  common.Hash
+}
+
+// Heatmap aggregates the accounts and storage slots accessed by replayed
+// transactions. It is safe for concurrent use.
+type Heatmap struct {
+	accounts map[common.Address]*AccountHeat
+	slots    map[slotKey]*SlotHeat
+	lock     sync.Mutex
+}
+
+// NewHeatmap creates an empty heatmap.
+func NewHeatmap() *Heatmap {
+	return &Heatmap{
+		accounts: make(map[common.Address]*AccountHeat),
+		slots:    make(map[slotKey]*SlotHeat),
+	}
+}
+
+// Record executes the given substate and adds its accesses to the heatmap.
+// Like Replay, it returns a *MismatchError if the outcome differs from the
+// recorded one, the accesses of the transaction are not added then. It is a
+// TaskFunc, so it can be run by a Scheduler.
+//
+// Every transaction accesses the balance, nonce and code of its sender and
+// the balance of the coinbase to pay the fee. These accesses are counted in
+// AccountHeat.Fees instead of the access counters, which therefore leave out
+// accesses of these fields by the executed code as well, e.g. the value
+// transferred by the sender.
+func (h *Heatmap) Record(block uint64, tx int, s *substate.Substate, cfg *Config) error {
+	statedb, evm, msgResult, err := execute(tx, s, cfg)
+	if err != nil {
+		return err
+	}
+	alloc, result := finalise(s, cfg, statedb, evm, msgResult)
+	if err := verify(block, tx, s, alloc, result); err != nil {
+		return err
+	}
+	var (
+		sender   = evm.TxContext.Origin
+		coinbase = evm.Context.Coinbase
+		warm     map[common.Address]map[common.Hash]bool
+	)
+	if rules := evm.ChainConfig().Rules(evm.Context.BlockNumber); rules.IsBerlin {
+		warm = prewarmed(s, sender, vm.ActiveChainPrecompiles(evm.ChainConfig(), evm.Context.BlockNumber))
+	}
+	h.Add(block, withoutFees(statedb.GetSubstateAccessSet(), sender, coinbase), warm)
+	h.addFees(sender, coinbase)
+	return nil
+}
+
+// withoutFees removes the fee payment of sender to coinbase from the given
+// access set. Accounts without any remaining access are removed.
+func withoutFees(access state.AccessSet, sender, coinbase common.Address) state.AccessSet {
+	if acc, ok := access[sender]; ok {
+		acc.Read &^= state.BalanceField | state.NonceField | state.CodeField
+		acc.Write &^= state.BalanceField | state.NonceField
+		acc.Credit = false
+	}
+	if acc, ok := access[coinbase]; ok {
+		acc.Read &^= state.BalanceField
+		acc.Write &^= state.BalanceField
+		acc.Credit = false
+	}
+	for _, addr := range []common.Address{sender, coinbase} {
+		if acc, ok := access[addr]; ok && acc.Read == 0 && acc.Write == 0 && !acc.Credit && len(acc.ReadStorage) == 0 && len(acc.WriteStorage) == 0 {
+			delete(access, addr)
+		}
+	}
+	return access
+}
+
+// addFees counts the fee payment of a transaction from sender to coinbase.
+func (h *Heatmap) addFees(sender, coinbase common.Address) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for _, addr := range []common.Address{sender, coinbase} {
+		account := h.accounts[addr]
+		if account == nil {
+			account = &AccountHeat{Address: addr}
+			h.accounts[addr] = account
+		}
+		account.Fees++
+		if sender == coinbase {
+			break
+		}
+	}
+}
+
+// prewarmed returns the accounts and storage slots which are warm at the
+// start of the transaction of the given substate.
+func prewarmed(s *substate.Substate, sender common.Address, precompiles []common.Address) map[common.Address]map[common.Hash]bool {
+	warm := make(map[common.Address]map[common.Hash]bool)
+	warm[sender] = make(map[common.Hash]bool)
+	if to := s.Message.To; to != nil {
+		warm[*to] = make(map[common.Hash]bool)
+	} else {
+		warm[crypto.CreateAddress(sender, s.Message.Nonce)] = make(map[common.Hash]bool)
+	}
+	for _, addr := range precompiles {
+		warm[addr] = make(map[common.Hash]bool)
+	}
+	for _, tuple := range s.Message.AccessList {
+		if warm[tuple.Address] == nil {
+			warm[tuple.Address] = make(map[common.Hash]bool)
+		}
+		for _, key := range tuple.StorageKeys {
+			warm[tuple.Address][key] = true
+		}
+	}
+	return warm
+}
+
+// Add counts the accesses of a transaction in the given block. warm holds the
+// accounts and slots warm at the start of the transaction, it is nil before
+// Berlin.
+func (h *Heatmap) Add(block uint64, access state.AccessSet, warm map[common.Address]map[common.Hash]bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for addr, acc := range access {
+		account := h.accounts[addr]
+		if account == nil {
+			account = &AccountHeat{Address: addr}
+			h.accounts[addr] = account
+		}
+		var accountWarm *bool
+		if warm != nil {
+			_, ok := warm[addr]
+			accountWarm = &ok
+		}
+		account.add(block, acc.Read != 0, acc.Write != 0 || acc.Credit, accountWarm)
+		account.SlotReads += uint64(len(acc.ReadStorage))
+		account.SlotWrites += uint64(len(acc.WriteStorage))
+
+		keys := make(map[common.Hash]struct{}, len(acc.ReadStorage)+len(acc.WriteStorage))
+		for key := range acc.ReadStorage {
+			keys[key] = struct{}{}
+		}
+		for key := range acc.WriteStorage {
+			keys[key] = struct{}{}
+		}
+		for key := range keys {
+			sk := slotKey{addr, key}
+			slot := h.slots[sk]
+			if slot == nil {
+				slot = &SlotHeat{Address: addr, Key: key}
+				h.slots[sk] = slot
+			}
+			_, read := acc.ReadStorage[key]
+			_, write := acc.WriteStorage[key]
+
+			var slotWarm *bool
+			if warm != nil {
+				ok := warm[addr][key]
+				slotWarm = &ok
+			}
+			slot.add(block, read, write, slotWarm)
+		}
+	}
+}
+
+// Len returns the number of accessed accounts and storage slots.
+func (h *Heatmap) Len() (int, int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return len(h.accounts), len(h.slots)
+}
+
+// Accounts returns the accessed accounts in descending order of the given
+// sort key, ties are ordered by address.
+func (h *Heatmap) Accounts(sortKey string) []*AccountHeat {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	accounts := make([]*AccountHeat, 0, len(h.accounts))
+	for _, account := range h.accounts {
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		x, y := accounts[i].get(sortKey), accounts[j].get(sortKey)
+		if x != y {
+			return x > y
+		}
+		return bytes.Compare(accounts[i].Address[:], accounts[j].Address[:]) < 0
+	})
+	return accounts
+}
+
+// Slots returns the accessed storage slots in descending order of the given
+// sort key, ties are ordered by address and key.
+func (h *Heatmap) Slots(sortKey string) []*SlotHeat {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	slots := make([]*SlotHeat, 0, len(h.slots))
+	for _, slot := range h.slots {
+		slots = append(slots, slot)
+	}
+	sort.Slice(slots, func(i, j int) bool {
+		x, y := slots[i].get(sortKey), slots[j].get(sortKey)
+		if x != y {
+			return x > y
+		}
+		if c := bytes.Compare(slots[i].Address[:], slots[j].Address[:]); c != 0 {
+			return c < 0
+		}
+		return bytes.Compare(slots[i].Key[:], slots[j].Key[:]) < 0
+	})
+	return slots
+}
+
+// Write writes the accounts and storage slots of the heatmap as CSV files
+// into the given directory, sorted by the given key. If top is not zero, only
+// the top entries of each report are written.
+func (h *Heatmap) Write(dir string, sortKey string, top int) error {
+	if !ValidHeatmapSortKey(sortKey) {
+		return fmt.Errorf("invalid sort key %q, want one of %v", sortKey, HeatmapSortKeys)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	accounts := h.Accounts(sortKey)
+	if top > 0 && len(accounts) > top {
+		accounts = accounts[:top]
+	}
+	rows := [][]string{append(append([]string{"address"}, heatmapColumns...), "slotreads", "slotwrites", "fees")}
+	for _, account := range accounts {
+		row := append([]string{account.Address.Hex()}, account.columns()...)
+		rows = append(rows, append(row, strconv.FormatUint(account.SlotReads, 10), strconv.FormatUint(account.SlotWrites, 10), strconv.FormatUint(account.Fees, 10)))
+	}
+	if err := writeCSV(filepath.Join(dir, HeatmapAccountsFile), rows); err != nil {
+		return err
+	}
+	slots := h.Slots(sortKey)
+	if top > 0 && len(slots) > top {
+		slots = slots[:top]
+	}
+	rows = [][]string{append([]string{"address", "key"}, heatmapColumns...)}
+	for _, slot := range slots {
+		rows = append(rows, append([]string{slot.Address.Hex(), slot.Key.Hex()}, slot.columns()...))
+	}
+	return writeCSV(filepath.Join(dir, HeatmapSlotsFile), rows)
+}
+
+// columns returns the counters in the column order of the heatmap reports.
+func (c *AccessCounts) columns() []string {
+	values := []uint64{c.Txs, c.Reads, c.Writes, c.Cold, c.Warm, c.FirstBlock, c.LastBlock}
+	columns := make([]string, len(values))
+	for i, v := range values {
+		columns[i] = strconv.FormatUint(v, 10)
+	}
+	return columns
+}
+
+// ValidHeatmapSortKey reports whether the heatmap reports can be sorted by key.
+func ValidHeatmapSortKey(key string) bool {
+	for _, k := range HeatmapSortKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// writeCSV writes the given rows into a CSV file.
+func writeCSV(file string, rows [][]string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	if err := w.WriteAll(rows); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
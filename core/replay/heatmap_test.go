@@ -0,0 +1,163 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package replay
+
+import (
+	"encoding/csv"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+)
+
+func TestHeatmapAdd(t *testing.T) {
+	var (
+		addr = common.HexToAddress("0xc0de")
+		hot  = common.HexToHash("0x01")
+		cold = common.HexToHash("0x02")
+	)
+	access := state.AccessSet{
+		addr: &state.AccountAccess{
+			Read:         state.CodeField,
+			ReadStorage:  map[common.Hash]struct{}{hot: {}, cold: {}},
+			WriteStorage: map[common.Hash]struct{}{hot: {}},
+		},
+	}
+	warm := map[common.Address]map[common.Hash]bool{addr: {hot: true}}
+
+	h := NewHeatmap()
+	h.Add(7, access, nil)  // before Berlin
+	h.Add(5, access, warm) // out of order, like concurrent workers
+	h.Add(9, access, warm)
+
+	accounts := h.Accounts("txs")
+	if len(accounts) != 1 {
+		t.Fatalf("wrong number of accounts: have %d, want 1", len(accounts))
+	}
+	want := AccessCounts{Txs: 3, Reads: 3, Writes: 0, Cold: 0, Warm: 2, FirstBlock: 5, LastBlock: 9}
+	if accounts[0].AccessCounts != want {
+		t.Errorf("wrong account counts: have %+v, want %+v", accounts[0].AccessCounts, want)
+	}
+	if accounts[0].SlotReads != 6 || accounts[0].SlotWrites != 3 {
+		t.Errorf("wrong account slot counts: have %d/%d, want 6/3", accounts[0].SlotReads, accounts[0].SlotWrites)
+	}
+	slots := h.Slots("writes")
+	if len(slots) != 2 || slots[0].Key != hot || slots[1].Key != cold {
+		t.Fatalf("wrong slot order: %+v", slots)
+	}
+	if want := (AccessCounts{Txs: 3, Reads: 3, Writes: 3, Warm: 2, FirstBlock: 5, LastBlock: 9}); slots[0].AccessCounts != want {
+		t.Errorf("wrong counts of warm slot: have %+v, want %+v", slots[0].AccessCounts, want)
+	}
+	if want := (AccessCounts{Txs: 3, Reads: 3, Cold: 2, FirstBlock: 5, LastBlock: 9}); slots[1].AccessCounts != want {
+		t.Errorf("wrong counts of cold slot: have %+v, want %+v", slots[1].AccessCounts, want)
+	}
+}
+
+func TestHeatmapRecord(t *testing.T) {
+	recorder, config := recordTestChain(t)
+	h := NewHeatmap()
+	for block, txs := range recorder {
+		for tx, s := range txs {
+			if err := h.Record(block, tx, s, &Config{ChainConfig: config}); err != nil {
+				t.Fatalf("failed to record transaction %d_%d: %v", block, tx, err)
+			}
+		}
+	}
+	// The contract is the recipient of every transaction, so it is warm.
+	var contract *AccountHeat
+	for _, account := range h.Accounts("txs") {
+		if account.Address == common.HexToAddress("0xc0de") {
+			contract = account
+		}
+	}
+	if contract == nil {
+		t.Fatal("contract missing from heatmap")
+	}
+	if contract.Txs != 3 || contract.Warm != 3 || contract.Cold != 0 || contract.FirstBlock != 1 || contract.LastBlock != 3 {
+		t.Errorf("wrong contract counts: %+v", contract.AccessCounts)
+	}
+	// The sender and the coinbase are only accessed by the fee payments.
+	sender, coinbase := recorder[1][0].Message.From, recorder[1][0].Env.Coinbase
+	fees := 0
+	for _, account := range h.Accounts("txs") {
+		if account.Address == sender || account.Address == coinbase {
+			fees++
+			if account.Txs != 0 || account.Fees != 3 {
+				t.Errorf("wrong counts of fee account %x: %+v, fees %d", account.Address, account.AccessCounts, account.Fees)
+			}
+		}
+	}
+	if fees != 2 {
+		t.Errorf("wrong number of fee accounts: have %d, want 2", fees)
+	}
+	// Every transaction writes the slot of its block number, which is cold.
+	slots := h.Slots("writes")
+	if len(slots) != 3 {
+		t.Fatalf("wrong number of slots: have %d, want 3", len(slots))
+	}
+	for _, slot := range slots {
+		block := slot.Key.Big().Uint64()
+		if slot.Writes != 1 || slot.Cold != 1 || slot.FirstBlock != block || slot.LastBlock != block {
+			t.Errorf("wrong counts of slot %x: %+v", slot.Key, slot.AccessCounts)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "heatmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := h.Write(dir, "cold", 2); err != nil {
+		t.Fatalf("failed to write heatmap: %v", err)
+	}
+	f, err := os.Open(filepath.Join(dir, HeatmapSlotsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read slot report: %v", err)
+	}
+	if len(rows) != 3 || rows[0][0] != "address" || rows[0][1] != "key" {
+		t.Errorf("wrong slot report: %v", rows)
+	}
+	if err := h.Write(dir, "foo", 0); err == nil {
+		t.Error("expected error for invalid sort key")
+	}
+}
+
+func TestHeatmapRecordMismatch(t *testing.T) {
+	recorder, config := recordTestChain(t)
+	s := recorder[1][0]
+	for _, account := range s.OutputAlloc {
+		account.Nonce++
+	}
+	h := NewHeatmap()
+	err := h.Record(1, 0, s, &Config{ChainConfig: config})
+	var mismatch *MismatchError
+	if !errors.As(err, &mismatch) || mismatch.Diff == nil {
+		t.Fatalf("expected mismatch error, got %v", err)
+	}
+	if accounts, slots := h.Len(); accounts != 0 || slots != 0 {
+		t.Errorf("accesses of mismatching transaction recorded: %d accounts, %d slots", accounts, slots)
+	}
+}
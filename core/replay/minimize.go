@@ -0,0 +1,268 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package replay
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	substate "github.com/Fantom-foundation/Substate"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ErrNotReproducible is returned by Minimize if the failure does not
+// reproduce on the original substate.
+var ErrNotReproducible = errors.New("failure does not reproduce")
+
+// Reproducer reports whether a failure reproduces on the given substate.
+type Reproducer func(s *substate.Substate) bool
+
+// FailsWith returns a Reproducer executing the substate with the given
+// configuration. The failure reproduces if the execution returns an error or
+// panics and the message of the error or panic equals msg. Panics are
+// reported as "panic: <value>".
+func FailsWith(tx int, cfg *Config, msg string) Reproducer {
+	return func(s *substate.Substate) bool {
+		err := ExecuteRecover(tx, s, cfg)
+		return err != nil && err.Error() == msg
+	}
+}
+
+// Diverges returns a Reproducer executing the substate with each of the
+// given interpreters. The failure reproduces if the executions diverge.
+func Diverges(tx int, cfg *Config, interpreters []string) Reproducer {
+	return func(s *substate.Substate) (diverged bool) {
+		defer func() {
+			if recover() != nil {
+				diverged = false
+			}
+		}()
+		var divergence *DivergenceError
+		return errors.As(Differential(SubstateExecution(tx, s, cfg), interpreters), &divergence)
+	}
+}
+
+// Mismatches returns a Reproducer replaying the substate with the given
+// configuration against its recorded output. The failure reproduces if the
+// replay reports every difference the replay of the original substate s
+// reports. Additional differences, e.g. of accounts removed from the input
+// alloc, are tolerated.
+func Mismatches(block uint64, tx int, s *substate.Substate, cfg *Config) Reproducer {
+	expected := mismatch(block, tx, s, cfg)
+	return func(s *substate.Substate) bool {
+		if expected == nil {
+			return false
+		}
+		diff := mismatch(block, tx, s, cfg)
+		return diff != nil && diff.contains(expected)
+	}
+}
+
+// mismatch replays the substate and returns the difference to its recorded
+// output, or nil if the replay fails otherwise or matches the output.
+func mismatch(block uint64, tx int, s *substate.Substate, cfg *Config) (diff *Diff) {
+	defer func() {
+		if recover() != nil {
+			diff = nil
+		}
+	}()
+	var mismatch *MismatchError
+	if err := Replay(block, tx, s, cfg); !errors.As(err, &mismatch) {
+		return nil
+	}
+	if mismatch.Diff == nil {
+		return new(Diff)
+	}
+	return mismatch.Diff
+}
+
+// ExecuteRecover executes the substate like Execute, but turns a panic of the
+// execution into an error.
+func ExecuteRecover(tx int, s *substate.Substate, cfg *Config) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	_, _, err = Execute(tx, s, cfg)
+	return err
+}
+
+// Minimize reduces the input alloc and the block hashes of the given substate
+// while the failure still reproduces. Whole accounts are removed first, then
+// the storage slots of the remaining accounts and finally the block hashes,
+// each by delta debugging. The message, env and output alloc are kept as is.
+func Minimize(s *substate.Substate, reproduces Reproducer) (*substate.Substate, error) {
+	if !reproduces(s) {
+		return nil, ErrNotReproducible
+	}
+	// Remove whole accounts.
+	addrs := make([]common.Address, 0, len(s.InputAlloc))
+	for addr := range s.InputAlloc {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return bytes.Compare(addrs[i][:], addrs[j][:]) < 0 })
+
+	withAccounts := func(keep []int) *substate.Substate {
+		alloc := make(substate.SubstateAlloc, len(keep))
+		for _, i := range keep {
+			alloc[addrs[i]] = s.InputAlloc[addrs[i]]
+		}
+		return withInput(s, alloc, s.Env.BlockHashes)
+	}
+	s = withAccounts(ddmin(len(addrs), func(keep []int) bool { return reproduces(withAccounts(keep)) }))
+
+	// Remove storage slots of the remaining accounts.
+	type slot struct {
+		addr common.Address
+		key  common.Hash
+	}
+	var slots []slot
+	for _, addr := range addrs {
+		account, ok := s.InputAlloc[addr]
+		if !ok {
+			continue
+		}
+		keys := make([]common.Hash, 0, len(account.Storage))
+		for key := range account.Storage {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i][:], keys[j][:]) < 0 })
+		for _, key := range keys {
+			slots = append(slots, slot{addr, key})
+		}
+	}
+	withSlots := func(keep []int) *substate.Substate {
+		alloc := make(substate.SubstateAlloc, len(s.InputAlloc))
+		for addr, account := range s.InputAlloc {
+			stripped := substate.NewSubstateAccount(account.Nonce, account.Balance, account.Code)
+			stripped.Storage = make(map[common.Hash]common.Hash)
+			alloc[addr] = stripped
+		}
+		for _, i := range keep {
+			alloc[slots[i].addr].Storage[slots[i].key] = s.InputAlloc[slots[i].addr].Storage[slots[i].key]
+		}
+		return withInput(s, alloc, s.Env.BlockHashes)
+	}
+	s = withSlots(ddmin(len(slots), func(keep []int) bool { return reproduces(withSlots(keep)) }))
+
+	// Remove block hashes.
+	nums := make([]uint64, 0, len(s.Env.BlockHashes))
+	for num := range s.Env.BlockHashes {
+		nums = append(nums, num)
+	}
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+
+	withHashes := func(keep []int) *substate.Substate {
+		hashes := make(map[uint64]common.Hash, len(keep))
+		for _, i := range keep {
+			hashes[nums[i]] = s.Env.BlockHashes[nums[i]]
+		}
+		return withInput(s, s.InputAlloc, hashes)
+	}
+	return withHashes(ddmin(len(nums), func(keep []int) bool { return reproduces(withHashes(keep)) })), nil
+}
+
+// contains reports whether d includes the result and every account
+// difference of o.
+func (d *Diff) contains(o *Diff) bool {
+	if o.Result != nil && !reflect.DeepEqual(d.Result, o.Result) {
+		return false
+	}
+	accounts := make(map[common.Address]*AccountDiff, len(d.Accounts))
+	for i := range d.Accounts {
+		accounts[d.Accounts[i].Address] = &d.Accounts[i]
+	}
+	for i := range o.Accounts {
+		account, ok := accounts[o.Accounts[i].Address]
+		if !ok || !reflect.DeepEqual(*account, o.Accounts[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// withInput returns a copy of s with the given input alloc and block hashes.
+func withInput(s *substate.Substate, alloc substate.SubstateAlloc, hashes map[uint64]common.Hash) *substate.Substate {
+	env := *s.Env
+	env.BlockHashes = hashes
+	return substate.NewSubstate(alloc, s.OutputAlloc, &env, s.Message, s.Result)
+}
+
+// ddmin returns a 1-minimal subset of the indices [0, n) for which test holds,
+// assuming it holds for all of them. It implements the ddmin algorithm of
+// Zeller and Hildebrandt, "Simplifying and Isolating Failure-Inducing Input".
+func ddmin(n int, test func(keep []int) bool) []int {
+	keep := make([]int, n)
+	for i := range keep {
+		keep[i] = i
+	}
+	if n == 0 || test(nil) {
+		return nil
+	}
+	for granularity := 2; len(keep) >= 2; {
+		var (
+			chunks  = split(keep, granularity)
+			reduced = false
+		)
+		// Try to reduce to a single chunk.
+		for _, chunk := range chunks {
+			if test(chunk) {
+				keep, granularity, reduced = chunk, 2, true
+				break
+			}
+		}
+		// Try to remove a single chunk.
+		if !reduced && granularity > 2 {
+			for i := range chunks {
+				complement := make([]int, 0, len(keep)-len(chunks[i]))
+				for j, chunk := range chunks {
+					if j != i {
+						complement = append(complement, chunk...)
+					}
+				}
+				if test(complement) {
+					keep, granularity, reduced = complement, granularity-1, true
+					break
+				}
+			}
+		}
+		if !reduced {
+			if granularity >= len(keep) {
+				break
+			}
+			if granularity *= 2; granularity > len(keep) {
+				granularity = len(keep)
+			}
+		}
+	}
+	return keep
+}
+
+// split splits the indices into n chunks of almost equal size.
+func split(indices []int, n int) [][]int {
+	chunks := make([][]int, 0, n)
+	for start, i := 0, 0; i < n; i++ {
+		end := start + (len(indices)-start)/(n-i)
+		chunks = append(chunks, indices[start:end])
+		start = end
+	}
+	return chunks
+}
@@ -0,0 +1,107 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package replay
+
+import (
+	"math/big"
+	"testing"
+
+	substate "github.com/Fantom-foundation/Substate"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestMinimize(t *testing.T) {
+	var (
+		alloc  = make(substate.SubstateAlloc)
+		hashes = make(map[uint64]common.Hash)
+		needed = common.BigToAddress(big.NewInt(7))
+	)
+	for i := int64(0); i < 20; i++ {
+		account := substate.NewSubstateAccount(uint64(i), big.NewInt(i), nil)
+		account.Storage = make(map[common.Hash]common.Hash)
+		for j := int64(0); j < 10; j++ {
+			account.Storage[common.BigToHash(big.NewInt(j))] = common.Hash{0x01}
+		}
+		alloc[common.BigToAddress(big.NewInt(i))] = account
+		hashes[uint64(i)] = common.Hash{byte(i)}
+	}
+	s := substate.NewSubstate(alloc, make(substate.SubstateAlloc), &substate.SubstateEnv{BlockHashes: hashes},
+		&substate.SubstateMessage{}, &substate.SubstateResult{})
+
+	// The failure needs two slots of a single account and one block hash.
+	reproduces := func(s *substate.Substate) bool {
+		account, ok := s.InputAlloc[needed]
+		if !ok {
+			return false
+		}
+		_, ok1 := account.Storage[common.BigToHash(big.NewInt(2))]
+		_, ok2 := account.Storage[common.BigToHash(big.NewInt(9))]
+		_, ok3 := s.Env.BlockHashes[13]
+		return ok1 && ok2 && ok3
+	}
+	min, err := Minimize(s, reproduces)
+	if err != nil {
+		t.Fatalf("failed to minimize: %v", err)
+	}
+	if len(min.InputAlloc) != 1 || len(min.InputAlloc[needed].Storage) != 2 || len(min.Env.BlockHashes) != 1 {
+		t.Errorf("substate not minimal: %d accounts, %d slots, %d block hashes",
+			len(min.InputAlloc), len(min.InputAlloc[needed].Storage), len(min.Env.BlockHashes))
+	}
+	if !reproduces(min) {
+		t.Errorf("failure does not reproduce on minimized substate")
+	}
+	// The original substate must be left untouched.
+	if len(s.InputAlloc) != 20 || len(s.InputAlloc[needed].Storage) != 10 || len(s.Env.BlockHashes) != 20 {
+		t.Errorf("original substate modified")
+	}
+	if _, err := Minimize(s, func(*substate.Substate) bool { return false }); err != ErrNotReproducible {
+		t.Errorf("wrong error for non-reproducible failure: have %v, want %v", err, ErrNotReproducible)
+	}
+}
+
+func TestMinimizeMismatch(t *testing.T) {
+	recorder, config := recordTestChain(t)
+	var (
+		s        = recorder[2][0]
+		contract = common.HexToAddress("0xc0de")
+		unused   = common.HexToAddress("0xdead")
+		cfg      = &Config{ChainConfig: config}
+	)
+	// The recorded output holds a different value of the written slot and
+	// the input an account the transaction does not touch.
+	s.OutputAlloc[contract].Storage[common.BigToHash(big.NewInt(2))] = common.Hash{0x02}
+	s.InputAlloc[unused] = substate.NewSubstateAccount(0, big.NewInt(1), nil)
+
+	reproduces := Mismatches(2, 0, s, cfg)
+	min, err := Minimize(s, reproduces)
+	if err != nil {
+		t.Fatalf("failed to minimize: %v", err)
+	}
+	if _, ok := min.InputAlloc[unused]; ok {
+		t.Error("untouched account not removed")
+	}
+	if _, ok := min.InputAlloc[contract]; !ok {
+		t.Error("contract producing the mismatch removed")
+	}
+	if !reproduces(min) {
+		t.Errorf("mismatch does not reproduce on minimized substate")
+	}
+	// a substate matching its output does not reproduce
+	if _, err := Minimize(recorder[1][0], Mismatches(1, 0, recorder[1][0], cfg)); err != ErrNotReproducible {
+		t.Errorf("wrong error for matching substate: have %v, want %v", err, ErrNotReproducible)
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package replay re-executes recorded substates and checks the outcome against
+// the recorded output. It also compares the executions of a message under
+// different interpreters.
+package replay
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	substate "github.com/Fantom-foundation/Substate"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+var (
+	// blockHash and txHash are the placeholder hashes used for the replayed
+	// transaction, the substate does not record either of them.
+	blockHash = common.Hash{0x01}
+	txHash    = common.Hash{0x02}
+)
+
+// DefaultChainConfig returns the chain configuration substates are replayed
+// with unless a different one is given. It is the mainnet configuration with
+// the DAO fork disabled, since the fork would overwrite recorded accounts.
+func DefaultChainConfig() *params.ChainConfig {
+	config := *params.MainnetChainConfig
+	config.DAOForkSupport = false
+	return &config
+}
+
+// Config contains the parameters for replaying substates.
+type Config struct {
+	ChainConfig *params.ChainConfig // Chain rules, DefaultChainConfig if nil
+	VMConfig    vm.Config           // EVM configuration, selects the interpreter
+}
+
+// chainConfig returns the configured chain configuration or the default one.
+func (c *Config) chainConfig() *params.ChainConfig {
+	if c.ChainConfig == nil {
+		return DefaultChainConfig()
+	}
+	return c.ChainConfig
+}
+
+// MakeStateDB creates an in-memory StateDB holding the accounts of alloc. The
+// alloc is committed, so it is treated as the original state of the
+// transaction, and substate recording is enabled.
+func MakeStateDB(alloc substate.SubstateAlloc) *state.StateDB {
+	sdb := state.NewDatabase(rawdb.NewMemoryDatabase())
+	statedb, _ := state.New(common.Hash{}, sdb, nil)
+	for addr, account := range alloc {
+		statedb.SetCode(addr, account.Code)
+		statedb.SetNonce(addr, account.Nonce)
+		statedb.SetBalance(addr, account.Balance)
+		for key, value := range account.Storage {
+			statedb.SetState(addr, key, value)
+		}
+	}
+	// Commit and re-open to start with a clean state.
+	root, _ := statedb.Commit(false)
+	statedb, _ = state.New(root, sdb, nil)
+	statedb.EnableSubstateRecording()
+	return statedb
+}
+
+// execute applies the message of the given substate to a StateDB holding its
+// input alloc. The returned error is only non-nil if the message could not be
+// applied at all.
+func execute(tx int, s *substate.Substate, cfg *Config) (*state.StateDB, *vm.EVM, *core.ExecutionResult, error) {
+	var (
+		env         = s.Env
+		chainConfig = cfg.chainConfig()
+		statedb     = MakeStateDB(s.InputAlloc)
+		gaspool     = new(core.GasPool).AddGas(env.GasLimit)
+		evm         *vm.EVM
+		hashErr     error
+	)
+	// A missing block hash means the substate is incomplete, abort the
+	// execution right away instead of replaying with a zero hash.
+	getHash := func(num uint64) common.Hash {
+		h, ok := env.BlockHashes[num]
+		if !ok && hashErr == nil {
+			hashErr = &MissingBlockHashError{Number: num}
+			evm.Cancel()
+		}
+		return h
+	}
+	blockCtx := vm.BlockContext{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		GetHash:     getHash,
+		Coinbase:    env.Coinbase,
+		GasLimit:    env.GasLimit,
+		BlockNumber: new(big.Int).SetUint64(env.Number),
+		Time:        new(big.Int).SetUint64(env.Timestamp),
+		Difficulty:  env.Difficulty,
+	}
+	if env.BaseFee != nil {
+		blockCtx.BaseFee = new(big.Int).Set(env.BaseFee)
+	}
+	msg := s.Message.AsMessage()
+	statedb.Prepare(txHash, tx)
+
+	evm = vm.NewEVM(blockCtx, core.NewEVMTxContext(msg), statedb, chainConfig, cfg.VMConfig)
+	snapshot := statedb.Snapshot()
+	msgResult, err := core.ApplyMessage(evm, msg, gaspool)
+	if hashErr != nil {
+		return nil, nil, nil, hashErr
+	}
+	if err != nil {
+		statedb.RevertToSnapshot(snapshot)
+		return nil, nil, nil, err
+	}
+	return statedb, evm, msgResult, nil
+}
+
+// Execute runs the message of the given substate on its input alloc and
+// returns the resulting output alloc and result. The returned error is only
+// non-nil if the message could not be applied at all (e.g. nonce mismatch or
+// a missing block hash), a failing transaction is reported in the result.
+func Execute(tx int, s *substate.Substate, cfg *Config) (substate.SubstateAlloc, *substate.SubstateResult, error) {
+	statedb, evm, msgResult, err := execute(tx, s, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	alloc, result := finalise(s, cfg, statedb, evm, msgResult)
+	return alloc, result, nil
+}
+
+// finalise ends the transaction executed by execute and returns its output
+// alloc and result.
+func finalise(s *substate.Substate, cfg *Config, statedb *state.StateDB, evm *vm.EVM, msgResult *core.ExecutionResult) (substate.SubstateAlloc, *substate.SubstateResult) {
+	blockNumber := evm.Context.BlockNumber
+	if cfg.chainConfig().IsByzantium(blockNumber) {
+		statedb.Finalise(true)
+	} else {
+		statedb.IntermediateRoot(cfg.chainConfig().IsEIP158(blockNumber))
+	}
+	result := &substate.SubstateResult{
+		Status:  types.ReceiptStatusSuccessful,
+		Logs:    statedb.GetLogs(txHash, blockHash),
+		GasUsed: msgResult.UsedGas,
+	}
+	if msgResult.Failed() {
+		result.Status = types.ReceiptStatusFailed
+	}
+	result.Bloom = types.BytesToBloom(types.LogsBloom(result.Logs))
+	if msg := s.Message; msg.To == nil {
+		result.ContractAddress = crypto.CreateAddress(evm.TxContext.Origin, msg.Nonce)
+	}
+	return statedb.GetSubstatePostAlloc(), result
+}
+
+// MissingBlockHashError is returned if the replayed transaction requested a
+// block hash which is not part of the recorded substate.
+type MissingBlockHashError struct {
+	Number uint64
+}
+
+func (e *MissingBlockHashError) Error() string {
+	return fmt.Sprintf("block hash of block %d requested but not recorded", e.Number)
+}
+
+// MismatchError is returned by Replay if the replayed transaction produced a
+// different output alloc or result than the recorded one. Diff is nil if the
+// outputs differ in a way the structured diff does not describe.
+type MismatchError struct {
+	Block uint64 `json:"block"`
+	Tx    int    `json:"tx"`
+	Diff  *Diff  `json:"diff,omitempty"`
+}
+
+// Error implements error, listing every difference on its own line.
+func (e *MismatchError) Error() string {
+	if e.Diff == nil {
+		return fmt.Sprintf("inconsistent output of transaction %d_%d", e.Block, e.Tx)
+	}
+	report := strings.TrimRight(e.Diff.String(), "\n")
+	return fmt.Sprintf("inconsistent output of transaction %d_%d\n  %s", e.Block, e.Tx, strings.ReplaceAll(report, "\n", "\n  "))
+}
+
+// Replay executes the given substate and compares the outcome with the
+// recorded output alloc and result. A *MismatchError is returned if they
+// differ.
+func Replay(block uint64, tx int, s *substate.Substate, cfg *Config) error {
+	alloc, result, err := Execute(tx, s, cfg)
+	if err != nil {
+		return err
+	}
+	return verify(block, tx, s, alloc, result)
+}
+
+// verify compares the replayed output alloc and result with the recorded ones
+// and returns a *MismatchError if they differ.
+func verify(block uint64, tx int, s *substate.Substate, alloc substate.SubstateAlloc, result *substate.SubstateResult) error {
+	if s.Result.Equal(result) && s.OutputAlloc.Equal(alloc) {
+		return nil
+	}
+	mismatch := &MismatchError{Block: block, Tx: tx}
+	if diff := Compare(s.OutputAlloc, alloc, s.Result, result); !diff.Empty() {
+		mismatch.Diff = diff
+	}
+	return mismatch
+}
@@ -0,0 +1,128 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package replay
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	substate "github.com/Fantom-foundation/Substate"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+type testRecorder map[uint64]map[int]*substate.Substate
+
+func (r testRecorder) RecordSubstate(block uint64, tx int, record *substate.Substate) error {
+	if r[block] == nil {
+		r[block] = make(map[int]*substate.Substate)
+	}
+	r[block][tx] = record
+	return nil
+}
+
+// recordTestChain records the substates of a short chain calling a contract
+// that writes storage, reads a block hash and emits a log.
+func recordTestChain(t *testing.T) (testRecorder, *params.ChainConfig) {
+	var (
+		config = params.AllEthashProtocolChanges
+		signer = types.LatestSigner(config)
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		from   = crypto.PubkeyToAddress(key.PublicKey)
+		to     = common.HexToAddress("0xc0de")
+		gspec  = &core.Genesis{
+			Config: config,
+			Alloc: core.GenesisAlloc{
+				from: {Balance: big.NewInt(1000000000000000000)},
+				// SSTORE(NUMBER, 1); POP(BLOCKHASH(NUMBER-1)); LOG0(0, 0)
+				to: {Code: common.FromHex("6001435560014303405060006000a0"), Balance: common.Big0},
+			},
+		}
+		db      = rawdb.NewMemoryDatabase()
+		genesis = gspec.MustCommit(db)
+	)
+	blocks, _ := core.GenerateChain(config, genesis, ethash.NewFaker(), db, 3, func(i int, b *core.BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(from), to, common.Big0, 100000, b.BaseFee(), nil), signer, key)
+		b.AddTx(tx)
+	})
+	recorder := make(testRecorder)
+	db = rawdb.NewMemoryDatabase()
+	gspec.MustCommit(db)
+	chain, _ := core.NewBlockChain(db, nil, config, ethash.NewFaker(), vm.Config{SubstateRecorder: recorder}, nil, nil)
+	defer chain.Stop()
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	return recorder, config
+}
+
+func TestReplayRecordedSubstates(t *testing.T) {
+	recorder, config := recordTestChain(t)
+	if len(recorder) != 3 {
+		t.Fatalf("wrong number of recorded blocks: have %d, want 3", len(recorder))
+	}
+	for block, txs := range recorder {
+		for tx, s := range txs {
+			if err := Replay(block, tx, s, &Config{ChainConfig: config}); err != nil {
+				t.Errorf("replay failed: %v", err)
+			}
+		}
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	recorder, config := recordTestChain(t)
+	s := recorder[1][0]
+	for _, account := range s.OutputAlloc {
+		account.Nonce++
+	}
+	err := Replay(1, 0, s, &Config{ChainConfig: config})
+	var mismatch *MismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected mismatch error, got %v", err)
+	}
+	if len(mismatch.Diff.Accounts) != len(s.OutputAlloc) {
+		t.Fatalf("wrong number of account diffs: have %d, want %d", len(mismatch.Diff.Accounts), len(s.OutputAlloc))
+	}
+	for _, diff := range mismatch.Diff.Accounts {
+		if diff.Nonce == nil || diff.Balance != nil || len(diff.Storage) != 0 {
+			t.Errorf("wrong account diff: %+v", diff)
+		}
+	}
+}
+
+func TestReplayMissingBlockHash(t *testing.T) {
+	recorder, config := recordTestChain(t)
+	s := recorder[2][0]
+	if len(s.Env.BlockHashes) != 1 {
+		t.Fatalf("wrong number of recorded block hashes: have %d, want 1", len(s.Env.BlockHashes))
+	}
+	s.Env.BlockHashes = nil
+
+	err := Replay(2, 0, s, &Config{ChainConfig: config})
+	var missing *MissingBlockHashError
+	if !errors.As(err, &missing) || missing.Number != 1 {
+		t.Fatalf("expected missing block hash error for block 1, got %v", err)
+	}
+}
@@ -0,0 +1,426 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package replay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	substate "github.com/Fantom-foundation/Substate"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+var (
+	replayTxMeter    = metrics.NewRegisteredMeter("replay/txs", nil)
+	replayGasMeter   = metrics.NewRegisteredMeter("replay/gas", nil)
+	replayBlockMeter = metrics.NewRegisteredMeter("replay/blocks", nil)
+)
+
+const (
+	// DefaultShardSize is the number of blocks a worker replays at once if the
+	// shard size of the scheduler is not set.
+	DefaultShardSize = 1000
+
+	// progressInterval is the interval between two progress reports.
+	progressInterval = 8 * time.Second
+)
+
+// errAborted is returned by a worker stopped because another one failed.
+var errAborted = errors.New("replay aborted")
+
+// SubstateSource provides the recorded substates of a block, it is
+// implemented by *substate.SubstateDB.
+type SubstateSource interface {
+	GetBlockSubstates(block uint64) map[int]*substate.Substate
+}
+
+// TaskFunc executes a recorded substate with the configuration of a worker.
+type TaskFunc func(block uint64, tx int, s *substate.Substate, cfg *Config) error
+
+// Range is an inclusive range of blocks.
+type Range struct {
+	First uint64 `json:"first"`
+	Last  uint64 `json:"last"`
+}
+
+// Checkpoint records the block ranges of a replay that have been completed,
+// along with the range and options of the replay, so the progress of a
+// different replay is never taken as its own.
+type Checkpoint struct {
+	Range   *Range            `json:"range,omitempty"`   // range of the replay, nil for a new checkpoint
+	Options map[string]string `json:"options,omitempty"` // options of the replay, see Scheduler
+	Done    []Range           `json:"done"`              // sorted, non-overlapping and non-adjacent
+}
+
+// check returns an error if the checkpoint belongs to a replay of a different
+// range or with different options.
+func (cp *Checkpoint) check(r Range, options map[string]string) error {
+	if cp.Range == nil && len(cp.Done) == 0 {
+		return nil
+	}
+	if cp.Range == nil || *cp.Range != r {
+		return fmt.Errorf("checkpoint of blocks %v does not match blocks %v", cp.Range, r)
+	}
+	if len(cp.Options) != len(options) {
+		return fmt.Errorf("checkpoint options %v do not match %v", cp.Options, options)
+	}
+	for key, value := range options {
+		if v, ok := cp.Options[key]; !ok || v != value {
+			return fmt.Errorf("checkpoint options %v do not match %v", cp.Options, options)
+		}
+	}
+	return nil
+}
+
+// LoadCheckpoint reads a checkpoint from the given file. An empty checkpoint
+// is returned if the file does not exist.
+func LoadCheckpoint(file string) (*Checkpoint, error) {
+	data, err := ioutil.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return new(Checkpoint), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cp := new(Checkpoint)
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %s: %v", file, err)
+	}
+	return cp, nil
+}
+
+// Save writes the checkpoint to the given file. The file is replaced
+// atomically, so a crash never leaves a partial checkpoint behind.
+func (cp *Checkpoint) Save(file string) error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// Add marks the given range as completed.
+func (cp *Checkpoint) Add(r Range) {
+	done := append(cp.Done, r)
+	sort.Slice(done, func(i, j int) bool { return done[i].First < done[j].First })
+
+	merged := done[:1]
+	for _, r := range done[1:] {
+		last := &merged[len(merged)-1]
+		if r.First <= last.Last+1 {
+			if r.Last > last.Last {
+				last.Last = r.Last
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	cp.Done = merged
+}
+
+// Blocks returns the number of blocks in the range.
+func (r Range) Blocks() uint64 {
+	return r.Last - r.First + 1
+}
+
+// String implements fmt.Stringer.
+func (r Range) String() string {
+	return fmt.Sprintf("%d-%d", r.First, r.Last)
+}
+
+// Remaining returns the parts of the given range which are not completed.
+func (cp *Checkpoint) Remaining(r Range) []Range {
+	var remaining []Range
+	next := r.First
+	for _, done := range cp.Done {
+		if done.Last < next || done.First > r.Last {
+			continue
+		}
+		if done.First > next {
+			remaining = append(remaining, Range{next, done.First - 1})
+		}
+		if done.Last >= r.Last {
+			return remaining
+		}
+		next = done.Last + 1
+	}
+	return append(remaining, Range{next, r.Last})
+}
+
+// Progress holds the throughput of a replay. Skipped transactions are not
+// counted.
+type Progress struct {
+	Blocks  uint64        // number of replayed blocks
+	Txs     uint64        // number of replayed transactions
+	Gas     uint64        // recorded gas used by the replayed transactions
+	Elapsed time.Duration // time since the start of the replay
+}
+
+// TxRate returns the number of transactions replayed per second.
+func (p *Progress) TxRate() float64 {
+	return float64(p.Txs) / p.Elapsed.Seconds()
+}
+
+// GasRate returns the gas replayed per second.
+func (p *Progress) GasRate() float64 {
+	return float64(p.Gas) / p.Elapsed.Seconds()
+}
+
+// Scheduler replays the substates of a block range. The range is split into
+// shards of consecutive blocks which are replayed by concurrent workers, the
+// transactions of a shard are replayed in order. Completed shards are stored
+// in a checkpoint, which allows resuming an interrupted replay.
+type Scheduler struct {
+	Source     SubstateSource
+	Range      Range
+	Workers    int    // number of concurrent workers, 1 if zero
+	ShardSize  uint64 // number of blocks per shard, DefaultShardSize if zero
+	Checkpoint string // file storing the completed shards, none if empty
+
+	// Options describe the settings affecting the outcome of the replay, e.g.
+	// the interpreter. They are stored in the checkpoint, which is rejected
+	// by replays of a different range or with different options.
+	Options map[string]string
+
+	// Skip reports whether a substate is left out of the replay. Skipped
+	// transactions are not counted in the progress. Nothing is skipped if
+	// nil.
+	Skip func(s *substate.Substate) bool
+
+	// NewConfig returns the replay configuration of a worker. Every worker
+	// gets its own configuration, so e.g. tracers are never shared. An empty
+	// configuration is used if nil.
+	NewConfig func(worker int) *Config
+
+	// Task executes a substate, Replay if nil.
+	Task TaskFunc
+
+	blocks, txs, gas uint64 // progress counters, accessed atomically
+}
+
+// Run replays the block range of the scheduler, skipping the blocks already
+// completed according to the checkpoint. The first error of a task aborts
+// the replay, the shard it belongs to is not marked as completed. The
+// returned progress only covers the blocks replayed by this run.
+func (s *Scheduler) Run() (*Progress, error) {
+	if s.Range.First > s.Range.Last {
+		return nil, fmt.Errorf("first block %d is larger than last block %d", s.Range.First, s.Range.Last)
+	}
+	atomic.StoreUint64(&s.blocks, 0)
+	atomic.StoreUint64(&s.txs, 0)
+	atomic.StoreUint64(&s.gas, 0)
+
+	cp := new(Checkpoint)
+	if s.Checkpoint != "" {
+		var err error
+		if cp, err = LoadCheckpoint(s.Checkpoint); err != nil {
+			return nil, err
+		}
+		if err := cp.check(s.Range, s.Options); err != nil {
+			return nil, fmt.Errorf("cannot resume from %s: %v", s.Checkpoint, err)
+		}
+		cp.Range, cp.Options = &s.Range, s.Options
+	}
+	var (
+		remaining = cp.Remaining(s.Range)
+		shards    = s.shards(remaining)
+		total     uint64
+		workers   = s.Workers
+		start     = mclock.Now()
+		queue     = make(chan Range)
+		abort     = make(chan struct{})
+		done      = make(chan struct{})
+		wg        sync.WaitGroup
+		errOnce   sync.Once
+		failure   error
+		cpLock    sync.Mutex
+		cpFailure error
+	)
+	if workers <= 0 {
+		workers = 1
+	}
+	for _, r := range remaining {
+		total += r.Blocks()
+	}
+	fail := func(err error) {
+		errOnce.Do(func() {
+			failure = err
+			close(abort)
+		})
+	}
+	for i := 0; i < workers; i++ {
+		cfg := new(Config)
+		if s.NewConfig != nil {
+			cfg = s.NewConfig(i)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for shard := range queue {
+				if err := s.replayShard(shard, cfg, abort); err != nil {
+					if err != errAborted {
+						fail(err)
+					}
+					return
+				}
+				if s.Checkpoint == "" {
+					continue
+				}
+				cpLock.Lock()
+				cp.Add(shard)
+				if err := cp.Save(s.Checkpoint); err != nil && cpFailure == nil {
+					cpFailure = err
+				}
+				cpLock.Unlock()
+			}
+		}()
+	}
+	go s.report(start, total, done)
+
+	log.Info("Replaying substates", "first", s.Range.First, "last", s.Range.Last, "completed", s.Range.Blocks()-total,
+		"shards", len(shards), "workers", workers)
+feed:
+	for _, shard := range shards {
+		select {
+		case queue <- shard:
+		case <-abort:
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+	close(done)
+
+	progress := s.progress(start)
+	if failure != nil {
+		return progress, failure
+	}
+	if cpFailure != nil {
+		return progress, fmt.Errorf("failed to save checkpoint: %v", cpFailure)
+	}
+	log.Info("Replayed substates", "blocks", progress.Blocks, "txs", progress.Txs,
+		"tx/s", fmt.Sprintf("%.2f", progress.TxRate()), "mgas/s", fmt.Sprintf("%.2f", progress.GasRate()/1e6),
+		"elapsed", common.PrettyDuration(progress.Elapsed))
+	return progress, nil
+}
+
+// shards splits the given ranges into shards of at most ShardSize blocks.
+func (s *Scheduler) shards(ranges []Range) []Range {
+	size := s.ShardSize
+	if size == 0 {
+		size = DefaultShardSize
+	}
+	var shards []Range
+	for _, r := range ranges {
+		for first := r.First; ; first += size {
+			last := first + size - 1
+			if last >= r.Last || last < first {
+				shards = append(shards, Range{first, r.Last})
+				break
+			}
+			shards = append(shards, Range{first, last})
+		}
+	}
+	return shards
+}
+
+// replayShard replays the transactions of the given shard in order.
+func (s *Scheduler) replayShard(shard Range, cfg *Config, abort chan struct{}) error {
+	task := s.Task
+	if task == nil {
+		task = func(block uint64, tx int, sub *substate.Substate, cfg *Config) error {
+			return Replay(block, tx, sub, cfg)
+		}
+	}
+	for block := shard.First; ; block++ {
+		select {
+		case <-abort:
+			return errAborted
+		default:
+		}
+		substates := s.Source.GetBlockSubstates(block)
+		txs := make([]int, 0, len(substates))
+		for tx := range substates {
+			txs = append(txs, tx)
+		}
+		sort.Ints(txs)
+		for _, tx := range txs {
+			sub := substates[tx]
+			if s.Skip != nil && s.Skip(sub) {
+				continue
+			}
+			if err := task(block, tx, sub, cfg); err != nil {
+				return fmt.Errorf("block %d tx %d: %v", block, tx, err)
+			}
+			atomic.AddUint64(&s.txs, 1)
+			replayTxMeter.Mark(1)
+			if sub.Result != nil {
+				atomic.AddUint64(&s.gas, sub.Result.GasUsed)
+				replayGasMeter.Mark(int64(sub.Result.GasUsed))
+			}
+		}
+		atomic.AddUint64(&s.blocks, 1)
+		replayBlockMeter.Mark(1)
+
+		if block == shard.Last {
+			return nil
+		}
+	}
+}
+
+// progress returns the progress of the replay started at the given time.
+func (s *Scheduler) progress(start mclock.AbsTime) *Progress {
+	return &Progress{
+		Blocks:  atomic.LoadUint64(&s.blocks),
+		Txs:     atomic.LoadUint64(&s.txs),
+		Gas:     atomic.LoadUint64(&s.gas),
+		Elapsed: time.Duration(mclock.Now() - start),
+	}
+}
+
+// report logs the progress of the replay periodically until done is closed.
+// total is the number of blocks left to replay when the replay started.
+func (s *Scheduler) report(start mclock.AbsTime, total uint64, done chan struct{}) {
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p := s.progress(start)
+			log.Info("Replaying substates", "blocks", fmt.Sprintf("%d/%d", p.Blocks, total), "txs", p.Txs,
+				"tx/s", fmt.Sprintf("%.2f", p.TxRate()), "mgas/s", fmt.Sprintf("%.2f", p.GasRate()/1e6),
+				"elapsed", common.PrettyDuration(p.Elapsed))
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package replay
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	substate "github.com/Fantom-foundation/Substate"
+)
+
+func (r testRecorder) GetBlockSubstates(block uint64) map[int]*substate.Substate {
+	return r[block]
+}
+
+func TestCheckpointRanges(t *testing.T) {
+	cp := new(Checkpoint)
+	for _, r := range []Range{{10, 19}, {30, 39}, {20, 29}, {50, 59}, {45, 52}} {
+		cp.Add(r)
+	}
+	if want := []Range{{10, 39}, {45, 59}}; !reflect.DeepEqual(cp.Done, want) {
+		t.Errorf("wrong completed ranges: have %v, want %v", cp.Done, want)
+	}
+	tests := []struct {
+		r    Range
+		want []Range
+	}{
+		{Range{0, 100}, []Range{{0, 9}, {40, 44}, {60, 100}}},
+		{Range{10, 39}, nil},
+		{Range{15, 50}, []Range{{40, 44}}},
+		{Range{0, 5}, []Range{{0, 5}}},
+		{Range{55, 70}, []Range{{60, 70}}},
+	}
+	for i, tt := range tests {
+		if have := cp.Remaining(tt.r); !reflect.DeepEqual(have, tt.want) {
+			t.Errorf("test %d: wrong remaining ranges of %v: have %v, want %v", i, tt.r, have, tt.want)
+		}
+	}
+}
+
+func TestSchedulerResume(t *testing.T) {
+	recorder, config := recordTestChain(t)
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	// Fail on the second block, only the first one is completed.
+	errFail := errors.New("fail")
+	sched := &Scheduler{
+		Source:     recorder,
+		Range:      Range{1, 3},
+		ShardSize:  1,
+		Checkpoint: checkpoint,
+		Options:    map[string]string{"interpreter": "geth"},
+		NewConfig:  func(int) *Config { return &Config{ChainConfig: config} },
+		Task: func(block uint64, tx int, s *substate.Substate, cfg *Config) error {
+			if block == 2 {
+				return errFail
+			}
+			return Replay(block, tx, s, cfg)
+		},
+	}
+	if _, err := sched.Run(); err == nil || err.Error() != "block 2 tx 0: fail" {
+		t.Fatalf("wrong error: %v", err)
+	}
+	cp, err := LoadCheckpoint(checkpoint)
+	if err != nil {
+		t.Fatalf("failed to load checkpoint: %v", err)
+	}
+	if want := []Range{{1, 1}}; !reflect.DeepEqual(cp.Done, want) {
+		t.Fatalf("wrong checkpoint: have %v, want %v", cp.Done, want)
+	}
+	// Replays of a different range or with different options do not resume.
+	other := *sched
+	other.Range = Range{1, 4}
+	if _, err := other.Run(); err == nil {
+		t.Error("resumed replay of a different range")
+	}
+	other = *sched
+	other.Options = map[string]string{"interpreter": "other"}
+	if _, err := other.Run(); err == nil {
+		t.Error("resumed replay with different options")
+	}
+	// Resume with several workers, the first block must not be replayed again.
+	sched.Workers, sched.Task = 2, func(block uint64, tx int, s *substate.Substate, cfg *Config) error {
+		if block == 1 {
+			t.Errorf("completed block %d replayed again", block)
+		}
+		return Replay(block, tx, s, cfg)
+	}
+	progress, err := sched.Run()
+	if err != nil {
+		t.Fatalf("failed to resume: %v", err)
+	}
+	if progress.Blocks != 2 || progress.Txs != 2 || progress.Gas == 0 {
+		t.Errorf("wrong progress: %+v", progress)
+	}
+	if cp, _ = LoadCheckpoint(checkpoint); !reflect.DeepEqual(cp.Done, []Range{{1, 3}}) {
+		t.Errorf("wrong checkpoint after resumption: %v", cp.Done)
+	}
+}
+
+func TestSchedulerSkip(t *testing.T) {
+	recorder, config := recordTestChain(t)
+	sched := &Scheduler{
+		Source:    recorder,
+		Range:     Range{1, 3},
+		NewConfig: func(int) *Config { return &Config{ChainConfig: config} },
+		Skip:      func(s *substate.Substate) bool { return s.Env.Number == 2 },
+		Task: func(block uint64, tx int, s *substate.Substate, cfg *Config) error {
+			if block == 2 {
+				t.Errorf("skipped block %d replayed", block)
+			}
+			return Replay(block, tx, s, cfg)
+		},
+	}
+	progress, err := sched.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Blocks != 3 || progress.Txs != 2 {
+		t.Errorf("wrong progress: %+v", progress)
+	}
+}
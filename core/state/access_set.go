@@ -0,0 +1,192 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	substate "github.com/Fantom-foundation/Substate"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// AccountFields is a bit set of account fields accessed by a transaction.
+type AccountFields uint8
+
+const (
+	BalanceField AccountFields = 1 << iota
+	NonceField
+	CodeField
+
+	// AllFields is accessed by operations depending on the whole account,
+	// such as existence and emptiness checks.
+	AllFields = BalanceField | NonceField | CodeField
+)
+
+// Has returns whether all of the given fields are in the set.
+func (f AccountFields) Has(fields AccountFields) bool {
+	return f&fields == fields
+}
+
+// AccountAccess records which fields and storage slots of an account were read
+// and written by a transaction. Credit records balance increases, such as
+// received values and fee payments. They are kept apart from the balance
+// reads and writes since increases commute with each other.
+type AccountAccess struct {
+	Read         AccountFields
+	Write        AccountFields
+	Credit       bool
+	ReadStorage  map[common.Hash]struct{}
+	WriteStorage map[common.Hash]struct{}
+}
+
+// AccessSet records the read set and the write set of a transaction, keyed by
+// account. Accesses of reverted call frames are included, so the sets are a
+// conservative superset of the accesses taking effect.
+type AccessSet map[common.Address]*AccountAccess
+
+// account returns the access record of addr, creating it if needed.
+func (a AccessSet) account(addr common.Address) *AccountAccess {
+	access, ok := a[addr]
+	if !ok {
+		access = &AccountAccess{
+			ReadStorage:  make(map[common.Hash]struct{}),
+			WriteStorage: make(map[common.Hash]struct{}),
+		}
+		a[addr] = access
+	}
+	return access
+}
+
+// Copy returns a deep copy of the access set.
+func (a AccessSet) Copy() AccessSet {
+	cpy := make(AccessSet, len(a))
+	for addr, access := range a {
+		c := cpy.account(addr)
+		c.Read, c.Write, c.Credit = access.Read, access.Write, access.Credit
+		for key := range access.ReadStorage {
+			c.ReadStorage[key] = struct{}{}
+		}
+		for key := range access.WriteStorage {
+			c.WriteStorage[key] = struct{}{}
+		}
+	}
+	return cpy
+}
+
+// Conflicts returns whether a transaction with access set a and one with
+// access set b touch the same account field or storage slot with at least one
+// of them writing it, i.e. whether their relative order matters. Balance
+// credits only conflict with reads and writes of the balance, so transactions
+// paying the fee to the same coinbase do not conflict because of it.
+func (a AccessSet) Conflicts(b AccessSet) bool {
+	for addr, x := range a {
+		y, ok := b[addr]
+		if !ok {
+			continue
+		}
+		if x.Write&(y.Read|y.Write) != 0 || y.Write&x.Read != 0 {
+			return true
+		}
+		if x.Credit && (y.Read|y.Write)&BalanceField != 0 || y.Credit && (x.Read|x.Write)&BalanceField != 0 {
+			return true
+		}
+		for key := range x.WriteStorage {
+			if _, ok := y.ReadStorage[key]; ok {
+				return true
+			}
+			if _, ok := y.WriteStorage[key]; ok {
+				return true
+			}
+		}
+		for key := range y.WriteStorage {
+			if _, ok := x.ReadStorage[key]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MinimalInputAlloc returns a copy of the input alloc pre restricted to the
+// storage slots in the read and write sets. Written slots are kept since their
+// original value can influence the execution even if it is never loaded, e.g.
+// the gas cost of SSTORE depends on it. Accounts are kept in full since the
+// output alloc contains all of their fields.
+func (a AccessSet) MinimalInputAlloc(pre substate.SubstateAlloc) substate.SubstateAlloc {
+	alloc := make(substate.SubstateAlloc, len(pre))
+	for addr, account := range pre {
+		if account == nil {
+			continue
+		}
+		min := substate.NewSubstateAccount(account.Nonce, account.Balance, account.Code)
+		if access, ok := a[addr]; ok {
+			for _, keys := range []map[common.Hash]struct{}{access.ReadStorage, access.WriteStorage} {
+				for key := range keys {
+					if value, ok := account.Storage[key]; ok {
+						min.Storage[key] = value
+					}
+				}
+			}
+		}
+		alloc[addr] = min
+	}
+	return alloc
+}
+
+// markRead records a read of the given account fields if substate recording
+// is enabled.
+func (s *StateDB) markRead(addr common.Address, fields AccountFields) {
+	if s.recordSubstate {
+		s.substateAccess.account(addr).Read |= fields
+	}
+}
+
+// markWrite records a write of the given account fields if substate recording
+// is enabled.
+func (s *StateDB) markWrite(addr common.Address, fields AccountFields) {
+	if s.recordSubstate {
+		s.substateAccess.account(addr).Write |= fields
+	}
+}
+
+// markCredit records an increase of the balance if substate recording is
+// enabled.
+func (s *StateDB) markCredit(addr common.Address) {
+	if s.recordSubstate {
+		s.substateAccess.account(addr).Credit = true
+	}
+}
+
+// markStorageRead records a read of a storage slot if substate recording is
+// enabled.
+func (s *StateDB) markStorageRead(addr common.Address, key common.Hash) {
+	if s.recordSubstate {
+		s.substateAccess.account(addr).ReadStorage[key] = struct{}{}
+	}
+}
+
+// markStorageWrite records a write of a storage slot if substate recording is
+// enabled.
+func (s *StateDB) markStorageWrite(addr common.Address, key common.Hash) {
+	if s.recordSubstate {
+		s.substateAccess.account(addr).WriteStorage[key] = struct{}{}
+	}
+}
+
+// GetSubstateAccessSet returns the read and write set of the current
+// transaction.
+func (s *StateDB) GetSubstateAccessSet() AccessSet {
+	return s.substateAccess
+}
@@ -0,0 +1,144 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+func TestSubstateAccessSet(t *testing.T) {
+	var (
+		addr1 = common.HexToAddress("0x01")
+		addr2 = common.HexToAddress("0x02")
+		slot1 = common.HexToHash("0x01")
+		slot2 = common.HexToHash("0x02")
+	)
+	sdb := NewDatabase(rawdb.NewMemoryDatabase())
+	state, _ := New(common.Hash{}, sdb, nil)
+	state.SetBalance(addr1, big.NewInt(10))
+	state.SetNonce(addr2, 1)
+	state.SetState(addr2, slot1, common.HexToHash("0xaa"))
+	state.SetState(addr2, slot2, common.HexToHash("0xbb"))
+	root, _ := state.Commit(false)
+
+	state, _ = New(root, sdb, nil)
+	state.EnableSubstateRecording()
+	state.Prepare(common.Hash{}, 0)
+
+	state.GetBalance(addr1)
+	state.SetNonce(addr1, 1)
+	state.GetState(addr2, slot1)
+	state.SetState(addr2, slot2, common.HexToHash("0xcc"))
+	state.Finalise(true)
+
+	set := state.GetSubstateAccessSet()
+	if access := set[addr1]; access == nil || access.Read != BalanceField || access.Write != NonceField {
+		t.Fatalf("wrong account access: %+v", access)
+	}
+	access := set[addr2]
+	if _, ok := access.ReadStorage[slot1]; !ok || len(access.ReadStorage) != 1 {
+		t.Errorf("wrong storage read set: %v", access.ReadStorage)
+	}
+	if _, ok := access.WriteStorage[slot2]; !ok || len(access.WriteStorage) != 1 {
+		t.Errorf("wrong storage write set: %v", access.WriteStorage)
+	}
+	// The written slot is part of the output alloc and, since its original
+	// value influences the gas of SSTORE, of the minimal input as well.
+	if value := state.GetSubstatePostAlloc()[addr2].Storage[slot2]; value != common.HexToHash("0xcc") {
+		t.Errorf("written slot missing in post alloc: %x", value)
+	}
+	min := set.MinimalInputAlloc(state.GetSubstatePreAlloc())
+	if value := min[addr2].Storage[slot2]; value != common.HexToHash("0xbb") || len(min[addr2].Storage) != 2 {
+		t.Errorf("wrong minimal input storage: %v", min[addr2].Storage)
+	}
+
+	// The next transaction starts with an empty access set.
+	state.Prepare(common.Hash{}, 1)
+	if len(state.GetSubstateAccessSet()) != 0 {
+		t.Errorf("access set not reset by Prepare")
+	}
+}
+
+func TestAccessSetConflicts(t *testing.T) {
+	var (
+		addr = common.HexToAddress("0x01")
+		slot = common.HexToHash("0x01")
+	)
+	reader, writer, other := make(AccessSet), make(AccessSet), make(AccessSet)
+	reader.account(addr).ReadStorage[slot] = struct{}{}
+	writer.account(addr).WriteStorage[slot] = struct{}{}
+	other.account(addr).Read = BalanceField
+
+	if !reader.Conflicts(writer) || !writer.Conflicts(reader) {
+		t.Errorf("read-write conflict not detected")
+	}
+	if reader.Conflicts(other) || other.Conflicts(writer) {
+		t.Errorf("false conflict detected")
+	}
+}
+
+func TestAccessSetCreditConflicts(t *testing.T) {
+	var (
+		coinbase = common.HexToAddress("0xc0")
+		alice    = common.HexToAddress("0xa1")
+		bob      = common.HexToAddress("0xb0")
+		carol    = common.HexToAddress("0xca")
+		dave     = common.HexToAddress("0xda")
+	)
+	sdb := NewDatabase(rawdb.NewMemoryDatabase())
+	state, _ := New(common.Hash{}, sdb, nil)
+	state.SetBalance(alice, big.NewInt(100))
+	state.SetBalance(carol, big.NewInt(100))
+	root, _ := state.Commit(false)
+
+	state, _ = New(root, sdb, nil)
+	state.EnableSubstateRecording()
+
+	// transfer buys the gas, moves the value and pays the fee to the
+	// coinbase the way a plain value transfer does.
+	transfer := func(tx int, from, to common.Address) AccessSet {
+		state.Prepare(common.Hash{byte(tx)}, tx)
+		state.SubBalance(from, big.NewInt(10))
+		state.SetNonce(from, state.GetNonce(from)+1)
+		state.SubBalance(from, big.NewInt(5))
+		state.AddBalance(to, big.NewInt(5))
+		state.AddBalance(from, big.NewInt(4))
+		state.AddBalance(coinbase, big.NewInt(6))
+		state.Finalise(true)
+		return state.GetSubstateAccessSet().Copy()
+	}
+	first := transfer(0, alice, bob)
+	second := transfer(1, carol, dave)
+	if first.Conflicts(second) || second.Conflicts(first) {
+		t.Errorf("independent transfers conflict")
+	}
+	// Paying to the sender of another transfer changes its balance checks.
+	third := transfer(2, dave, alice)
+	if !first.Conflicts(third) || !third.Conflicts(first) {
+		t.Errorf("dependent transfers do not conflict")
+	}
+	// Reading the coinbase balance depends on the fees paid before.
+	state.Prepare(common.Hash{3}, 3)
+	state.GetBalance(coinbase)
+	if reader := state.GetSubstateAccessSet(); !reader.Conflicts(first) || !first.Conflicts(reader) {
+		t.Errorf("coinbase balance read does not conflict with fee payment")
+	}
+}
@@ -84,6 +84,25 @@ func (j *journal) length() int {
 	return len(j.entries)
 }
 
+// copy returns a copy of the journal for the given StateDB. The replaced state
+// objects recorded by the journal are deep copied, all other entries are
+// immutable.
+func (j *journal) copy(db *StateDB) *journal {
+	entries := make([]journalEntry, len(j.entries))
+	for i, entry := range j.entries {
+		if ch, ok := entry.(resetObjectChange); ok {
+			ch.prev = ch.prev.deepCopy(db)
+			entry = ch
+		}
+		entries[i] = entry
+	}
+	dirties := make(map[common.Address]int, len(j.dirties))
+	for addr, count := range j.dirties {
+		dirties[addr] = count
+	}
+	return &journal{entries: entries, dirties: dirties}
+}
+
 type (
 	// Changes to the account trie.
 	createObjectChange struct {
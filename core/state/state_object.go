@@ -19,7 +19,6 @@ package state
 import (
 	"bytes"
 	"fmt"
-	substate "github.com/Fantom-foundation/Substate"
 	"io"
 	"math/big"
 	"time"
@@ -92,7 +91,8 @@ type stateObject struct {
 	suicided  bool
 	deleted   bool
 
-	// Accessed storage addresses
+	// Accessed storage addresses, the union of the read and write set of the
+	// current transaction used to collect the substate storage in Finalise
 	AccessedStorage map[common.Hash]struct{}
 }
 
@@ -183,7 +183,7 @@ func (s *stateObject) getTrie(db Database) Trie {
 
 // GetState retrieves a value from the account storage trie.
 func (s *stateObject) GetState(db Database, key common.Hash) common.Hash {
-	if substate.RecordReplay {
+	if s.db.recordSubstate {
 		// mark keys touched by GetState
 		if _, exist := s.AccessedStorage[key]; !exist {
 			s.AccessedStorage[key] = struct{}{}
@@ -335,7 +335,7 @@ func (s *stateObject) finalise(prefetch bool) {
 		s.dirtyStorage = make(Storage)
 	}
 
-	if substate.RecordReplay {
+	if s.db.recordSubstate {
 		// clear stateObject.AccessedStorage
 		s.AccessedStorage = make(map[common.Hash]struct{})
 	}
@@ -479,7 +479,7 @@ func (s *stateObject) deepCopy(db *StateDB) *stateObject {
 	stateObject.dirtyCode = s.dirtyCode
 	stateObject.deleted = s.deleted
 
-	if substate.RecordReplay {
+	if s.db.recordSubstate {
 		// deepCopy stateObject.AccessedStorage
 		stateObject.AccessedStorage = make(map[common.Hash]struct{})
 		for key := range s.AccessedStorage {
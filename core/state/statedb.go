@@ -120,10 +120,13 @@ type StateDB struct {
 	SnapshotStorageReads time.Duration
 	SnapshotCommits      time.Duration
 
-	// record-replay: SubstatePreAlloc, SubstatePostAlloc, SubstateBlockHashes of StateDB
-	SubstatePreAlloc    substate.SubstateAlloc
-	SubstatePostAlloc   substate.SubstateAlloc
-	SubstateBlockHashes map[uint64]common.Hash
+	// record-replay: input/output allocs and block hashes of the current
+	// transaction, only maintained while recordSubstate is set
+	recordSubstate      bool
+	substatePreAlloc    substate.SubstateAlloc
+	substatePostAlloc   substate.SubstateAlloc
+	substateBlockHashes map[uint64]common.Hash
+	substateAccess      AccessSet
 }
 
 // New creates a new state from a given trie.
@@ -150,6 +153,7 @@ func NewWithSnapLayers(root common.Hash, db Database, snaps *snapshot.Tree, laye
 		accessList:          newAccessList(),
 		hasher:              crypto.NewKeccakState(),
 		snapMaxLayers:       layers,
+		recordSubstate:      substate.RecordReplay,
 	}
 	if sdb.snaps != nil {
 		if sdb.snap = sdb.snaps.Snapshot(root); sdb.snap != nil {
@@ -159,11 +163,8 @@ func NewWithSnapLayers(root common.Hash, db Database, snaps *snapshot.Tree, laye
 		}
 	}
 
-	if substate.RecordReplay {
-		// init StateDB.Substate*
-		sdb.SubstatePreAlloc = make(substate.SubstateAlloc)
-		sdb.SubstatePostAlloc = make(substate.SubstateAlloc)
-		sdb.SubstateBlockHashes = make(map[uint64]common.Hash)
+	if sdb.recordSubstate {
+		sdb.resetSubstate()
 	}
 
 	return sdb, nil
@@ -262,18 +263,21 @@ func (s *StateDB) SubRefund(gas uint64) {
 // Exist reports whether the given account address exists in the state.
 // Notably this also returns true for suicided accounts.
 func (s *StateDB) Exist(addr common.Address) bool {
+	s.markRead(addr, AllFields)
 	return s.getStateObject(addr) != nil
 }
 
 // Empty returns whether the state object is either non-existent
 // or empty according to the EIP161 specification (balance = nonce = code = 0)
 func (s *StateDB) Empty(addr common.Address) bool {
+	s.markRead(addr, AllFields)
 	so := s.getStateObject(addr)
 	return so == nil || so.empty()
 }
 
 // GetBalance retrieves the balance from the given address or 0 if object not found
 func (s *StateDB) GetBalance(addr common.Address) *big.Int {
+	s.markRead(addr, BalanceField)
 	stateObject := s.getStateObject(addr)
 	if stateObject != nil {
 		return stateObject.Balance()
@@ -282,6 +286,7 @@ func (s *StateDB) GetBalance(addr common.Address) *big.Int {
 }
 
 func (s *StateDB) GetNonce(addr common.Address) uint64 {
+	s.markRead(addr, NonceField)
 	stateObject := s.getStateObject(addr)
 	if stateObject != nil {
 		return stateObject.Nonce()
@@ -296,6 +301,7 @@ func (s *StateDB) TxIndex() int {
 }
 
 func (s *StateDB) GetCode(addr common.Address) []byte {
+	s.markRead(addr, CodeField)
 	stateObject := s.getStateObject(addr)
 	if stateObject != nil {
 		return stateObject.Code(s.db)
@@ -304,6 +310,7 @@ func (s *StateDB) GetCode(addr common.Address) []byte {
 }
 
 func (s *StateDB) GetCodeSize(addr common.Address) int {
+	s.markRead(addr, CodeField)
 	stateObject := s.getStateObject(addr)
 	if stateObject != nil {
 		return stateObject.CodeSize(s.db)
@@ -312,6 +319,7 @@ func (s *StateDB) GetCodeSize(addr common.Address) int {
 }
 
 func (s *StateDB) GetCodeHash(addr common.Address) common.Hash {
+	s.markRead(addr, CodeField)
 	stateObject := s.getStateObject(addr)
 	if stateObject == nil {
 		return common.Hash{}
@@ -321,6 +329,7 @@ func (s *StateDB) GetCodeHash(addr common.Address) common.Hash {
 
 // GetState retrieves a value from the given account's storage trie.
 func (s *StateDB) GetState(addr common.Address, hash common.Hash) common.Hash {
+	s.markStorageRead(addr, hash)
 	stateObject := s.getStateObject(addr)
 	if stateObject != nil {
 		return stateObject.GetState(s.db, hash)
@@ -353,6 +362,7 @@ func (s *StateDB) GetStorageProof(a common.Address, key common.Hash) ([][]byte,
 
 // GetCommittedState retrieves a value from the given account's committed storage trie.
 func (s *StateDB) GetCommittedState(addr common.Address, hash common.Hash) common.Hash {
+	s.markStorageRead(addr, hash)
 	stateObject := s.getStateObject(addr)
 	if stateObject != nil {
 		return stateObject.GetCommittedState(s.db, hash)
@@ -391,6 +401,7 @@ func (s *StateDB) HasSuicided(addr common.Address) bool {
 
 // AddBalance adds amount to the account associated with addr.
 func (s *StateDB) AddBalance(addr common.Address, amount *big.Int) {
+	s.markCredit(addr)
 	stateObject := s.GetOrNewStateObject(addr)
 	if stateObject != nil {
 		stateObject.AddBalance(amount)
@@ -399,6 +410,8 @@ func (s *StateDB) AddBalance(addr common.Address, amount *big.Int) {
 
 // SubBalance subtracts amount from the account associated with addr.
 func (s *StateDB) SubBalance(addr common.Address, amount *big.Int) {
+	s.markRead(addr, BalanceField)
+	s.markWrite(addr, BalanceField)
 	stateObject := s.GetOrNewStateObject(addr)
 	if stateObject != nil {
 		stateObject.SubBalance(amount)
@@ -406,6 +419,7 @@ func (s *StateDB) SubBalance(addr common.Address, amount *big.Int) {
 }
 
 func (s *StateDB) SetBalance(addr common.Address, amount *big.Int) {
+	s.markWrite(addr, BalanceField)
 	stateObject := s.GetOrNewStateObject(addr)
 	if stateObject != nil {
 		stateObject.SetBalance(amount)
@@ -413,6 +427,7 @@ func (s *StateDB) SetBalance(addr common.Address, amount *big.Int) {
 }
 
 func (s *StateDB) SetNonce(addr common.Address, nonce uint64) {
+	s.markWrite(addr, NonceField)
 	stateObject := s.GetOrNewStateObject(addr)
 	if stateObject != nil {
 		stateObject.SetNonce(nonce)
@@ -420,6 +435,7 @@ func (s *StateDB) SetNonce(addr common.Address, nonce uint64) {
 }
 
 func (s *StateDB) SetCode(addr common.Address, code []byte) {
+	s.markWrite(addr, CodeField)
 	stateObject := s.GetOrNewStateObject(addr)
 	if stateObject != nil {
 		stateObject.SetCode(crypto.Keccak256Hash(code), code)
@@ -427,6 +443,7 @@ func (s *StateDB) SetCode(addr common.Address, code []byte) {
 }
 
 func (s *StateDB) SetPrehashedCode(addr common.Address, hash common.Hash, code []byte) {
+	s.markWrite(addr, CodeField)
 	stateObject := s.GetOrNewStateObject(addr)
 	if stateObject != nil {
 		stateObject.SetCode(hash, code)
@@ -434,6 +451,7 @@ func (s *StateDB) SetPrehashedCode(addr common.Address, hash common.Hash, code [
 }
 
 func (s *StateDB) SetState(addr common.Address, key, value common.Hash) {
+	s.markStorageWrite(addr, key)
 	stateObject := s.GetOrNewStateObject(addr)
 	if stateObject != nil {
 		stateObject.SetState(s.db, key, value)
@@ -455,6 +473,7 @@ func (s *StateDB) SetStorage(addr common.Address, storage map[common.Hash]common
 // The account's state object is still available until the state is committed,
 // getStateObject will return a non-nil account after Suicide.
 func (s *StateDB) Suicide(addr common.Address) bool {
+	s.markWrite(addr, BalanceField)
 	stateObject := s.getStateObject(addr)
 	if stateObject == nil {
 		return false
@@ -519,21 +538,21 @@ func (s *StateDB) deleteStateObject(obj *stateObject) {
 func (s *StateDB) getStateObject(addr common.Address) *stateObject {
 	if obj := s.getDeletedStateObject(addr); obj != nil && !obj.deleted {
 
-		if substate.RecordReplay {
-			// insert the account in StateDB.SubstatePreAlloc
-			if _, exist := s.SubstatePreAlloc[addr]; !exist {
-				s.SubstatePreAlloc[addr] = substate.NewSubstateAccount(obj.Nonce(), obj.Balance(), obj.Code(s.db))
+		if s.recordSubstate {
+			// insert the account in StateDB.substatePreAlloc
+			if _, exist := s.substatePreAlloc[addr]; !exist {
+				s.substatePreAlloc[addr] = substate.NewSubstateAccount(obj.Nonce(), obj.Balance(), obj.Code(s.db))
 			}
 		}
 
 		return obj
 	}
 
-	if substate.RecordReplay {
-		// insert empty account in StateDB.SubstatePreAlloc
+	if s.recordSubstate {
+		// insert empty account in StateDB.substatePreAlloc
 		// This will prevent insertion of new account created in txs
-		if _, exist := s.SubstatePreAlloc[addr]; !exist {
-			s.SubstatePreAlloc[addr] = nil
+		if _, exist := s.substatePreAlloc[addr]; !exist {
+			s.substatePreAlloc[addr] = nil
 		}
 	}
 
@@ -651,6 +670,8 @@ func (s *StateDB) createObject(addr common.Address) (newobj, prev *stateObject)
 //
 // Carrying over the balance ensures that Ether doesn't disappear.
 func (s *StateDB) CreateAccount(addr common.Address) {
+	s.markRead(addr, BalanceField)
+	s.markWrite(addr, AllFields)
 	newObj, prev := s.createObject(addr)
 	if prev != nil {
 		newObj.setBalance(prev.data.Balance)
@@ -746,19 +767,25 @@ func (s *StateDB) Copy() *StateDB {
 		state.preimages[hash] = preimage
 	}
 
-	if substate.RecordReplay {
-		// copy StateDB.Substate*
-		state.SubstatePreAlloc = make(substate.SubstateAlloc)
-		state.SubstatePostAlloc = make(substate.SubstateAlloc)
-		state.SubstateBlockHashes = make(map[uint64]common.Hash)
-		for addr, account := range s.SubstatePreAlloc {
-			state.SubstatePreAlloc[addr] = account.Copy()
+	if s.recordSubstate {
+		// copy StateDB.substate*
+		state.recordSubstate = true
+		state.substatePreAlloc = make(substate.SubstateAlloc)
+		state.substatePostAlloc = make(substate.SubstateAlloc)
+		state.substateBlockHashes = make(map[uint64]common.Hash)
+		state.substateAccess = s.substateAccess.Copy()
+		for addr, account := range s.substatePreAlloc {
+			if account == nil {
+				state.substatePreAlloc[addr] = nil
+				continue
+			}
+			state.substatePreAlloc[addr] = account.Copy()
 		}
-		for addr, account := range s.SubstatePostAlloc {
-			state.SubstatePostAlloc[addr] = account.Copy()
+		for addr, account := range s.substatePostAlloc {
+			state.substatePostAlloc[addr] = account.Copy()
 		}
-		for num64, bhash := range s.SubstateBlockHashes {
-			state.SubstateBlockHashes[num64] = bhash
+		for num64, bhash := range s.substateBlockHashes {
+			state.substateBlockHashes[num64] = bhash
 		}
 	}
 
@@ -803,6 +830,70 @@ func (s *StateDB) Copy() *StateDB {
 	return state
 }
 
+// JournalPosition is a position in the journal of a StateDB, see ResetTo.
+type JournalPosition struct {
+	entries        int // number of journal entries
+	revisions      int // number of valid revisions
+	nextRevisionId int
+}
+
+// JournalPosition returns the current position of the journal.
+func (s *StateDB) JournalPosition() JournalPosition {
+	return JournalPosition{
+		entries:        s.journal.length(),
+		revisions:      len(s.validRevisions),
+		nextRevisionId: s.nextRevisionId,
+	}
+}
+
+// CopyWithJournal creates a deep copy of the state in the middle of a
+// transaction. Unlike Copy, it retains the journal and the valid revisions,
+// so the changes of the transaction can still be reverted on the copy.
+func (s *StateDB) CopyWithJournal() *StateDB {
+	state := s.Copy()
+	for addr, object := range s.stateObjects {
+		if _, exist := state.stateObjects[addr]; !exist {
+			state.stateObjects[addr] = object.deepCopy(state)
+		}
+	}
+	// Copy marks the dirty objects as pending, which is wrong for objects
+	// whose changes are reverted on the copy.
+	state.stateObjectsPending = make(map[common.Address]struct{}, len(s.stateObjectsPending))
+	for addr := range s.stateObjectsPending {
+		state.stateObjectsPending[addr] = struct{}{}
+	}
+	state.stateObjectsDirty = make(map[common.Address]struct{}, len(s.stateObjectsDirty))
+	for addr := range s.stateObjectsDirty {
+		state.stateObjectsDirty[addr] = struct{}{}
+	}
+	state.originalRoot = s.originalRoot
+	state.snapMaxLayers = s.snapMaxLayers
+	state.dbErr = s.dbErr
+	state.thash, state.txIndex = s.thash, s.txIndex
+	state.journal = s.journal.copy(state)
+	state.validRevisions = append([]revision(nil), s.validRevisions...)
+	state.nextRevisionId = s.nextRevisionId
+	return state
+}
+
+// ResetTo replaces the state by a copy of src with all changes made after
+// the journal position pos reverted. The position has to be taken from src,
+// or from the StateDB src was copied from by CopyWithJournal, before the copy.
+func (s *StateDB) ResetTo(src *StateDB, pos JournalPosition) {
+	*s = *src.CopyWithJournal()
+	for _, object := range s.stateObjects {
+		object.db = s
+	}
+	for _, entry := range s.journal.entries {
+		if ch, ok := entry.(resetObjectChange); ok {
+			ch.prev.db = s
+		}
+	}
+	s.journal.revert(s, pos.entries)
+	s.validRevisions = s.validRevisions[:pos.revisions]
+	s.nextRevisionId = pos.nextRevisionId
+}
+
 // Snapshot returns an identifier for the current revision of the state.
 func (s *StateDB) Snapshot() int {
 	id := s.nextRevisionId
@@ -837,11 +928,11 @@ func (s *StateDB) GetRefund() uint64 {
 // into the tries just yet. Only IntermediateRoot or Commit will do that.
 func (s *StateDB) Finalise(deleteEmptyObjects bool) {
 
-	if substate.RecordReplay {
+	if s.recordSubstate {
 		// copy original storage values to Prestate and Poststate
-		for addr, sa := range s.SubstatePreAlloc {
+		for addr, sa := range s.substatePreAlloc {
 			if sa == nil {
-				delete(s.SubstatePreAlloc, addr)
+				delete(s.substatePreAlloc, addr)
 				continue
 			}
 
@@ -849,7 +940,7 @@ func (s *StateDB) Finalise(deleteEmptyObjects bool) {
 			for key := range obj.AccessedStorage {
 				sa.Storage[key] = obj.GetCommittedState(s.db, key)
 			}
-			s.SubstatePostAlloc[addr] = sa.Copy()
+			s.substatePostAlloc[addr] = sa.Copy()
 		}
 	}
 
@@ -877,18 +968,18 @@ func (s *StateDB) Finalise(deleteEmptyObjects bool) {
 				delete(s.snapAccounts, obj.addrHash)       // Clear out any previously updated account data (may be recreated via a ressurrect)
 				delete(s.snapStorage, obj.addrHash)        // Clear out any previously updated storage data (may be recreated via a ressurrect)
 			}
-			if substate.RecordReplay {
-				// delete account from StateDB.SubstatePostAlloc
-				delete(s.SubstatePostAlloc, addr)
+			if s.recordSubstate {
+				// delete account from StateDB.substatePostAlloc
+				delete(s.substatePostAlloc, addr)
 			}
 		} else {
-			if substate.RecordReplay {
-				// copy dirty account to StateDB.SubstatePostAlloc
+			if s.recordSubstate {
+				// copy dirty account to StateDB.substatePostAlloc
 				sa := substate.NewSubstateAccount(obj.Nonce(), obj.Balance(), obj.Code(s.db))
 				for key := range obj.AccessedStorage {
 					sa.Storage[key] = obj.GetState(s.db, key)
 				}
-				s.SubstatePostAlloc[addr] = sa
+				s.substatePostAlloc[addr] = sa
 			}
 			obj.finalise(true) // Prefetch slots in the background
 		}
@@ -974,14 +1065,8 @@ func (s *StateDB) Prepare(thash common.Hash, ti int) {
 	s.thash = thash
 	s.txIndex = ti
 
-	if substate.RecordReplay {
-		// reset StateDB.Substate* and stateObject.Substate*
-		s.SubstatePreAlloc = make(substate.SubstateAlloc)
-		s.SubstatePostAlloc = make(substate.SubstateAlloc)
-		s.SubstateBlockHashes = make(map[uint64]common.Hash)
-		for _, obj := range s.stateObjects {
-			obj.AccessedStorage = make(map[common.Hash]struct{})
-		}
+	if s.recordSubstate {
+		s.resetSubstate()
 	}
 
 	s.accessList = newAccessList()
@@ -1130,6 +1215,56 @@ func (s *StateDB) SlotInAccessList(addr common.Address, slot common.Hash) (addre
 	return s.accessList.Contains(addr, slot)
 }
 
+// EnableSubstateRecording turns on substate recording for this StateDB only.
+// From the next transaction on, every account and storage slot accessed is
+// tracked so that GetSubstatePreAlloc and GetSubstatePostAlloc describe the
+// complete input and output of the transaction after Finalise.
+func (s *StateDB) EnableSubstateRecording() {
+	if s.recordSubstate {
+		return
+	}
+	s.recordSubstate = true
+	s.resetSubstate()
+}
+
+// SubstateRecording returns whether substate recording is enabled.
+func (s *StateDB) SubstateRecording() bool {
+	return s.recordSubstate
+}
+
+// resetSubstate clears the recorded substate of the previous transaction.
+func (s *StateDB) resetSubstate() {
+	s.substatePreAlloc = make(substate.SubstateAlloc)
+	s.substatePostAlloc = make(substate.SubstateAlloc)
+	s.substateBlockHashes = make(map[uint64]common.Hash)
+	s.substateAccess = make(AccessSet)
+	for _, obj := range s.stateObjects {
+		obj.AccessedStorage = make(map[common.Hash]struct{})
+	}
+}
+
+// GetSubstatePreAlloc returns the accounts and storage slots read by the
+// current transaction with their values before the transaction.
+func (s *StateDB) GetSubstatePreAlloc() substate.SubstateAlloc {
+	return s.substatePreAlloc
+}
+
+// GetSubstatePostAlloc returns the accounts and storage slots touched by the
+// current transaction with their values after the transaction.
 func (s *StateDB) GetSubstatePostAlloc() substate.SubstateAlloc {
-	return s.SubstatePostAlloc
+	return s.substatePostAlloc
+}
+
+// GetSubstateBlockHashes returns the block hashes requested by the current
+// transaction via BLOCKHASH.
+func (s *StateDB) GetSubstateBlockHashes() map[uint64]common.Hash {
+	return s.substateBlockHashes
+}
+
+// AddSubstateBlockHash records a block hash requested by the current
+// transaction. It is a no-op if substate recording is disabled.
+func (s *StateDB) AddSubstateBlockHash(num uint64, hash common.Hash) {
+	if s.recordSubstate {
+		s.substateBlockHashes[num] = hash
+	}
 }
@@ -695,6 +695,59 @@ func TestDeleteCreateRevert(t *testing.T) {
 	}
 }
 
+// TestResetTo tests that a state reset to a journaled copy can be reverted to
+// revisions taken before the copy.
+func TestResetTo(t *testing.T) {
+	state, _ := New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	a, b := common.BytesToAddress([]byte("a")), common.BytesToAddress([]byte("b"))
+	slot := common.HexToHash("0x01")
+	state.SetBalance(a, big.NewInt(1))
+	root, _ := state.Commit(false)
+	state, _ = New(root, state.db, state.snaps)
+
+	state.SetBalance(a, big.NewInt(2))
+	id := state.Snapshot()
+	pos := state.JournalPosition()
+	state.CreateAccount(a)
+	state.SetState(a, slot, common.HexToHash("0x2a"))
+	state.SetBalance(b, big.NewInt(5))
+	cpy := state.CopyWithJournal()
+	state.SetBalance(a, big.NewInt(100))
+
+	// reset to the copied state and revert to a revision before the copy
+	reset, _ := New(root, state.db, state.snaps)
+	reset.ResetTo(cpy, cpy.JournalPosition())
+	if balance := reset.GetBalance(a); balance.Int64() != 2 {
+		t.Errorf("balance mismatch: have %v, want 2", balance)
+	}
+	if value := reset.GetState(a, slot); value != common.HexToHash("0x2a") {
+		t.Errorf("slot mismatch: have %x, want 0x2a", value)
+	}
+	reset.RevertToSnapshot(id)
+	if reset.Exist(b) || reset.GetState(a, slot) != (common.Hash{}) {
+		t.Error("changes after the revision not reverted")
+	}
+	if next := reset.Snapshot(); next != id+1 {
+		t.Errorf("revision mismatch: have %d, want %d", next, id+1)
+	}
+	if !cpy.Exist(b) {
+		t.Error("reverting the reset state modified the copy")
+	}
+
+	// reset to an earlier position of the copy
+	reset.ResetTo(cpy, pos)
+	if reset.Exist(b) || reset.GetBalance(a).Int64() != 2 {
+		t.Error("changes after the position not reverted")
+	}
+	reset.RevertToSnapshot(id)
+	if balance := reset.GetBalance(a); balance.Int64() != 2 {
+		t.Errorf("balance mismatch: have %v, want 2", balance)
+	}
+	if balance := cpy.GetBalance(a); balance.Int64() != 2 {
+		t.Errorf("copy balance mismatch: have %v, want 2", balance)
+	}
+}
+
 // TestMissingTrieNodes tests that if the StateDB fails to load parts of the trie,
 // the Commit operation fails with an error
 // If we are missing trie nodes, we should not continue writing to the trie
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"math/big"
 
+	substate "github.com/Fantom-foundation/Substate"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/consensus/misc"
@@ -27,6 +28,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/params"
 )
 
@@ -70,7 +72,16 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg
 	if p.config.DAOForkSupport && p.config.DAOForkBlock != nil && p.config.DAOForkBlock.Cmp(block.Number()) == 0 {
 		misc.ApplyDAOHardFork(statedb)
 	}
+	vm.ActivateStatePrecompiles(p.config, blockNumber, statedb)
+
 	blockContext := NewEVMBlockContext(header, p.bc, nil)
+	if cfg.SubstateRecorder != nil {
+		statedb.EnableSubstateRecording()
+	}
+	// Recording may also be enabled globally through substate.RecordReplay.
+	if statedb.SubstateRecording() {
+		blockContext.OnBlockHash = recordBlockHash(statedb)
+	}
 	vmenv := vm.NewEVM(blockContext, vm.TxContext{}, statedb, p.config, cfg)
 	// Iterate over and process the individual transactions
 	for i, tx := range block.Transactions() {
@@ -83,6 +94,9 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg
 		if err != nil {
 			return nil, nil, 0, fmt.Errorf("could not apply tx %d [%v]: %w", i, tx.Hash().Hex(), err)
 		}
+		if cfg.SubstateRecorder != nil {
+			recordSubstate(cfg.SubstateRecorder, vmenv, statedb, msg, receipt)
+		}
 		receipts = append(receipts, receipt)
 		allLogs = append(allLogs, receipt.Logs...)
 	}
@@ -137,6 +151,63 @@ func applyTransaction(msg types.Message, config *params.ChainConfig, bc ChainCon
 	return receipt, err
 }
 
+// recordSubstate hands the substate of the transaction just applied to the
+// recorder. Recording failures must not invalidate the block, so they are
+// logged instead of returned.
+func recordSubstate(recorder vm.SubstateRecorder, evm *vm.EVM, statedb *state.StateDB, msg types.Message, receipt *types.Receipt) {
+	block, tx := receipt.BlockNumber.Uint64(), statedb.TxIndex()
+	if err := recorder.RecordSubstate(block, tx, newSubstate(evm, statedb, msg, receipt)); err != nil {
+		log.Error("Failed to record substate", "block", block, "tx", tx, "err", err)
+	}
+	if accessRecorder, ok := recorder.(vm.SubstateAccessRecorder); ok {
+		if err := accessRecorder.RecordAccessSet(block, tx, statedb.GetSubstateAccessSet().Copy()); err != nil {
+			log.Error("Failed to record access set", "block", block, "tx", tx, "err", err)
+		}
+	}
+}
+
+// recordBlockHash returns a BLOCKHASH observer adding every requested block
+// number and the returned hash to the substate of the current transaction.
+// Requests outside of the 256 block window are recorded with the zero hash
+// they yield. Requests overflowing 64 bits are skipped, they cannot be keyed
+// by block number and always yield zero.
+func recordBlockHash(statedb *state.StateDB) vm.BlockHashFunc {
+	return func(req vm.BlockHashRequest) {
+		if !req.Overflow {
+			statedb.AddSubstateBlockHash(req.Number, req.Hash)
+		}
+	}
+}
+
+// newSubstate assembles the substate of the transaction just applied to
+// statedb. It must be called after the state has been finalised.
+func newSubstate(evm *vm.EVM, statedb *state.StateDB, msg types.Message, receipt *types.Receipt) *substate.Substate {
+	blockHashes := make(map[uint64]common.Hash)
+	for num, hash := range statedb.GetSubstateBlockHashes() {
+		blockHashes[num] = hash
+	}
+	env := &substate.SubstateEnv{
+		Coinbase:    evm.Context.Coinbase,
+		Difficulty:  new(big.Int).Set(evm.Context.Difficulty),
+		GasLimit:    evm.Context.GasLimit,
+		Number:      evm.Context.BlockNumber.Uint64(),
+		Timestamp:   evm.Context.Time.Uint64(),
+		BlockHashes: blockHashes,
+	}
+	if evm.Context.BaseFee != nil {
+		env.BaseFee = new(big.Int).Set(evm.Context.BaseFee)
+	}
+	inputAlloc := make(substate.SubstateAlloc)
+	for addr, account := range statedb.GetSubstatePreAlloc() {
+		inputAlloc[addr] = account.Copy()
+	}
+	outputAlloc := make(substate.SubstateAlloc)
+	for addr, account := range statedb.GetSubstatePostAlloc() {
+		outputAlloc[addr] = account.Copy()
+	}
+	return substate.NewSubstate(inputAlloc, outputAlloc, env, substate.NewSubstateMessage(&msg), substate.NewSubstateResult(receipt))
+}
+
 // ApplyTransaction attempts to apply a transaction to the given state database
 // and uses the input parameters for its environment. It returns the receipt
 // for the transaction, gas used and an error if the transaction failed,
@@ -148,6 +219,9 @@ func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *commo
 	}
 	// Create a new context to be used in the EVM environment
 	blockContext := NewEVMBlockContext(header, bc, author)
+	if statedb.SubstateRecording() {
+		blockContext.OnBlockHash = recordBlockHash(statedb)
+	}
 	vmenv := vm.NewEVM(blockContext, vm.TxContext{}, statedb, config, cfg)
 	return applyTransaction(msg, config, bc, author, gp, statedb, header.Number, header.Hash(), tx, usedGas, vmenv)
 }
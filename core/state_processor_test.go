@@ -17,14 +17,18 @@
 package core
 
 import (
+	"errors"
 	"math/big"
+	"reflect"
 	"testing"
 
+	substate "github.com/Fantom-foundation/Substate"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/consensus/misc"
 	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -327,3 +331,154 @@ func GenerateBadBlock(parent *types.Block, engine consensus.Engine, txs types.Tr
 	// Assemble and return the final block for sealing
 	return types.NewBlock(header, txs, nil, receipts, trie.NewStackTrie(nil))
 }
+
+type testSubstateRecorder struct {
+	records map[uint64]map[int]*substate.Substate
+	err     error // returned by RecordSubstate if set
+}
+
+func (r *testSubstateRecorder) RecordSubstate(block uint64, tx int, record *substate.Substate) error {
+	if r.err != nil {
+		return r.err
+	}
+	if r.records[block] == nil {
+		r.records[block] = make(map[int]*substate.Substate)
+	}
+	r.records[block][tx] = record
+	return nil
+}
+
+// TestStateProcessorSubstateRecorder tests that a SubstateRecorder configured
+// on one blockchain receives the complete substate of every transaction.
+func TestStateProcessorSubstateRecorder(t *testing.T) {
+	var (
+		config = params.AllEthashProtocolChanges
+		signer = types.LatestSigner(config)
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		from   = crypto.PubkeyToAddress(key.PublicKey)
+		to     = common.HexToAddress("0xc0de")
+		gspec  = &Genesis{
+			Config: config,
+			Alloc: GenesisAlloc{
+				from: {Balance: big.NewInt(1000000000000000000)},
+				// SSTORE(0, 1); POP(BLOCKHASH(0)); STOP
+				to: {Code: common.FromHex("600160005560004050"), Balance: common.Big0},
+			},
+		}
+		genDb   = rawdb.NewMemoryDatabase()
+		genesis = gspec.MustCommit(genDb)
+	)
+	blocks, _ := GenerateChain(config, genesis, ethash.NewFaker(), genDb, 1, func(i int, b *BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(from), to, common.Big0, 100000, b.BaseFee(), nil), signer, key)
+		b.AddTx(tx)
+	})
+	recorder := &testSubstateRecorder{records: make(map[uint64]map[int]*substate.Substate)}
+
+	db := rawdb.NewMemoryDatabase()
+	gspec.MustCommit(db)
+	chain, _ := NewBlockChain(db, nil, config, ethash.NewFaker(), vm.Config{SubstateRecorder: recorder}, nil, nil)
+	defer chain.Stop()
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	record := recorder.records[1][0]
+	if record == nil {
+		t.Fatalf("no substate recorded for transaction 1_0")
+	}
+	if record.Message.From != from || *record.Message.To != to {
+		t.Errorf("wrong message recorded: from %x to %x", record.Message.From, *record.Message.To)
+	}
+	if record.Env.Number != 1 || record.Env.BlockHashes[0] != genesis.Hash() {
+		t.Errorf("wrong env recorded: number %d, block hashes %v", record.Env.Number, record.Env.BlockHashes)
+	}
+	if in := record.InputAlloc[to]; in == nil || len(in.Storage) != 1 || in.Storage[common.Hash{}] != (common.Hash{}) {
+		t.Errorf("wrong input alloc for contract: %v", in)
+	}
+	if out := record.OutputAlloc[to]; out == nil || out.Storage[common.Hash{}] != common.BigToHash(common.Big1) {
+		t.Errorf("wrong output alloc for contract: %v", out)
+	}
+	if record.Result.Status != types.ReceiptStatusSuccessful {
+		t.Errorf("wrong result status: %d", record.Result.Status)
+	}
+
+	// A second chain in the same process without a recorder must not record.
+	db2 := rawdb.NewMemoryDatabase()
+	gspec.MustCommit(db2)
+	chain2, _ := NewBlockChain(db2, nil, config, ethash.NewFaker(), vm.Config{}, nil, nil)
+	defer chain2.Stop()
+	if _, err := chain2.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	statedb, _ := chain2.State()
+	if statedb.SubstateRecording() {
+		t.Errorf("substate recording enabled on chain without recorder")
+	}
+
+	// A failing recorder must not reject the block.
+	db3 := rawdb.NewMemoryDatabase()
+	gspec.MustCommit(db3)
+	failing := &testSubstateRecorder{err: errors.New("disk full")}
+	chain3, _ := NewBlockChain(db3, nil, config, ethash.NewFaker(), vm.Config{SubstateRecorder: failing}, nil, nil)
+	defer chain3.Stop()
+	if _, err := chain3.InsertChain(blocks); err != nil {
+		t.Fatalf("recording failure rejected the block: %v", err)
+	}
+
+	// Transactions applied outside of block processing, as by the miner, are
+	// not recorded.
+	pending := &testSubstateRecorder{records: make(map[uint64]map[int]*substate.Substate)}
+	statedb, _ = state.New(genesis.Root(), state.NewDatabase(db2), nil)
+	statedb.Prepare(blocks[0].Transactions()[0].Hash(), 0)
+	header := types.CopyHeader(blocks[0].Header())
+	if _, err := ApplyTransaction(config, chain2, &header.Coinbase, new(GasPool).AddGas(header.GasLimit), statedb, header, blocks[0].Transactions()[0], new(uint64), vm.Config{SubstateRecorder: pending}); err != nil {
+		t.Fatalf("failed to apply transaction: %v", err)
+	}
+	if len(pending.records) != 0 {
+		t.Errorf("substate of a pending transaction recorded: %v", pending.records)
+	}
+}
+
+// TestApplyTransactionBlockHashes tests that the block hashes requested by a
+// transaction are recorded, including out-of-range requests, if substate
+// recording is enabled on the StateDB only, as by substate.RecordReplay.
+func TestApplyTransactionBlockHashes(t *testing.T) {
+	var (
+		config = params.AllEthashProtocolChanges
+		signer = types.LatestSigner(config)
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		from   = crypto.PubkeyToAddress(key.PublicKey)
+		to     = common.HexToAddress("0xc0de")
+		gspec  = &Genesis{
+			Config: config,
+			Alloc: GenesisAlloc{
+				from: {Balance: big.NewInt(1000000000000000000)},
+				// POP(BLOCKHASH(0)); POP(BLOCKHASH(NUMBER+1)); STOP
+				to: {Code: common.FromHex("6000405060014301405000"), Balance: common.Big0},
+			},
+		}
+		db      = rawdb.NewMemoryDatabase()
+		genesis = gspec.MustCommit(db)
+	)
+	chain, _ := NewBlockChain(db, nil, config, ethash.NewFaker(), vm.Config{}, nil, nil)
+	defer chain.Stop()
+
+	statedb, _ := state.New(genesis.Root(), state.NewDatabase(db), nil)
+	statedb.EnableSubstateRecording()
+
+	header := &types.Header{
+		ParentHash: genesis.Hash(),
+		Number:     big.NewInt(1),
+		GasLimit:   genesis.GasLimit(),
+		Difficulty: big.NewInt(1),
+		BaseFee:    misc.CalcBaseFee(config, genesis.Header()),
+	}
+	tx, _ := types.SignTx(types.NewTransaction(0, to, common.Big0, 100000, header.BaseFee, nil), signer, key)
+	statedb.Prepare(tx.Hash(), 0)
+	if _, err := ApplyTransaction(config, chain, &common.Address{}, new(GasPool).AddGas(header.GasLimit), statedb, header, tx, new(uint64), vm.Config{}); err != nil {
+		t.Fatalf("failed to apply transaction: %v", err)
+	}
+	want := map[uint64]common.Hash{0: genesis.Hash(), 2: {}}
+	if have := statedb.GetSubstateBlockHashes(); !reflect.DeepEqual(have, want) {
+		t.Errorf("wrong block hashes recorded: have %v, want %v", have, want)
+	}
+}
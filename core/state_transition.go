@@ -308,7 +308,10 @@ func (st *StateTransition) TransitionDb() (*ExecutionResult, error) {
 
 	// Set up the initial access list.
 	if rules := st.evm.ChainConfig().Rules(st.evm.Context.BlockNumber); rules.IsBerlin {
-		st.state.PrepareAccessList(msg.From(), msg.To(), vm.ActivePrecompiles(rules), msg.AccessList())
+		st.state.PrepareAccessList(msg.From(), msg.To(), vm.ActiveChainPrecompiles(st.evm.ChainConfig(), st.evm.Context.BlockNumber), msg.AccessList())
+	}
+	if tracer, ok := st.evm.Config.Tracer.(vm.TxStartTracer); ok && st.evm.Config.Debug {
+		tracer.CaptureTxStart(st.initialGas)
 	}
 	var (
 		ret   []byte
@@ -17,36 +17,33 @@
 package vm
 
 import (
-	"context"
-	"database/sql"
-	_ "github.com/mattn/go-sqlite3"
-	"log"
 	"encoding/hex"
+	"sort"
+	"strconv"
+	"sync"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	lru "github.com/hashicorp/golang-lru"
 )
 
-// Basic-block profiling flag controlled by cli
-var BasicBlockProfiling bool
-
-// Maximal number of records per SQLITE3 transaction for writing
-const BasicBlockMaxNumRecords = 1000
-
-// Buffer size for micro-profiling channel
-var BasicBlockProfilingBufferSize int
-
-// Name of SQLITE3 database
-var BasicBlockProfilingDB string
+// graphCacheSize is the number of control-flow graphs cached by a basic-block
+// profiler for the interpreters. Graphs of codes evicted from the cache are
+// rebuilt when the code is executed again.
+const graphCacheSize = 1024
 
 // Basic-block data record for a single smart contract invocation
 type BasicBlockProfileData struct {
 	Contract            common.Address      // contract in hex format
+	CodeHash            common.Hash         // hash of the executed code
 	BasicBlockFrequency map[uint]BasicBlock // basic block frequency
+	Graph               *ControlFlowGraph   // static control-flow graph of the code
 }
 
 // Basic-block data record for a single smart contract invocation
 type BasicBlockKey struct {
 	Contract     string // contract in hex format
+	Code         string // code hash in hex format
 	Instructions string // instructions in hex format
 	Address      uint   // basic-block start address
 }
@@ -54,44 +51,143 @@ type BasicBlockKey struct {
 // Basic-block statistic
 type BasicBlockProfileStatistic struct {
 	basicBlockFrequency map[BasicBlockKey]uint64 // basic block statistics
+	blocks              map[string][]*CFGBlock   // static basic blocks of profiled code by code hash
 }
 
-// Basic-Block Profiling channel
-var bbpChannel chan *BasicBlockProfileData = make(chan *BasicBlockProfileData, BasicBlockProfilingBufferSize)
+// Basic-block coverage of a code. The code is identified by its hash, since
+// the code at an address changes between constructor and runtime code and
+// with every redeployment.
+type BasicBlockCoverage struct {
+	Code      string // code hash in hex format
+	Blocks    int    // number of static basic blocks
+	Reachable int    // number of reachable static basic blocks
+	Executed  int    // number of executed basic blocks
+}
 
 // Create new micro-profiling statistic
 func NewBasicBlockProfileStatistic() *BasicBlockProfileStatistic {
 	p := new(BasicBlockProfileStatistic)
 	p.basicBlockFrequency = make(map[BasicBlockKey]uint64)
+	p.blocks = make(map[string][]*CFGBlock)
 	return p
 }
 
-// The data collector checks for a stopping signal and processes
-// the workers' records via a channel. A data collector is a background task.
-func BasicBlockProfilingCollector(ctx context.Context, done chan struct{}, bbps *BasicBlockProfileStatistic) {
-	defer close(done)
-	for {
-		select {
+// BasicBlockProfiler collects the basic-block frequencies of all
+// interpreters configured with it. Records are passed through a buffered
+// channel to a background collector.
+type BasicBlockProfiler struct {
+	records   chan *BasicBlockProfileData // queue of unprocessed records
+	stats     *BasicBlockProfileStatistic // accumulated statistic
+	done      chan struct{}               // closed when the collector terminated
+	closeOnce sync.Once
 
-		// receive a new data record from a worker?
-		case bbpd := <-bbpChannel:
-			for addr, bb := range bbpd.BasicBlockFrequency {
-				bkey := BasicBlockKey{Contract: bbpd.Contract.String(), Address: addr, Instructions: hex.EncodeToString(bb.Instructions)}
-				bbps.basicBlockFrequency[bkey] += bb.Frequency
-			}
+	graphs *lru.Cache // cache of recently used control-flow graphs by code hash
+}
 
-		// receive stop signal?
-		case <-ctx.Done():
-			if len(bbpChannel) == 0 {
-				return
-			}
+// Create new basic-block profiler and start its collector. The buffer size
+// is the number of records which can be queued before interpreters block.
+func NewBasicBlockProfiler(bufferSize int) *BasicBlockProfiler {
+	graphs, _ := lru.New(graphCacheSize)
+	p := &BasicBlockProfiler{
+		records: make(chan *BasicBlockProfileData, bufferSize),
+		stats:   NewBasicBlockProfileStatistic(),
+		done:    make(chan struct{}),
+		graphs:  graphs,
+	}
+	go p.collect()
+	return p
+}
+
+// The data collector processes the interpreters' records until the profiler
+// is closed. A data collector is a background task.
+func (p *BasicBlockProfiler) collect() {
+	defer close(p.done)
+	for bbpd := range p.records {
+		code := bbpd.CodeHash.String()
+		// The statistic keeps the static blocks of the code for the
+		// coverage, not the graph, which is dropped once evicted from the
+		// cache.
+		if _, ok := p.stats.blocks[code]; !ok && bbpd.Graph != nil {
+			p.stats.blocks[code] = bbpd.Graph.Blocks
+		}
+		for addr, bb := range bbpd.BasicBlockFrequency {
+			bkey := BasicBlockKey{Contract: bbpd.Contract.String(), Code: code, Address: addr, Instructions: hex.EncodeToString(bb.Instructions)}
+			p.stats.basicBlockFrequency[bkey] += bb.Frequency
 		}
 	}
 }
 
-// put micro profiling data into the processing queue
-func ProcessBasicBlockProfileData(bbpd *BasicBlockProfileData) {
-	bbpChannel <- bbpd
+// profiledCodeHash returns the hash of the code executed by contract.
+func profiledCodeHash(contract *Contract) common.Hash {
+	if contract.CodeHash == (common.Hash{}) {
+		return crypto.Keccak256Hash(contract.Code)
+	}
+	return contract.CodeHash
+}
+
+// controlFlowGraph returns the control-flow graph of the code with the given
+// hash, building it if it is not cached.
+func (p *BasicBlockProfiler) controlFlowGraph(hash common.Hash, contract *Contract) *ControlFlowGraph {
+	if graph, ok := p.graphs.Get(hash); ok {
+		return graph.(*ControlFlowGraph)
+	}
+	graph := BuildControlFlowGraph(contract.Code)
+	p.graphs.Add(hash, graph)
+	return graph
+}
+
+// put basic-block profiling data into the processing queue
+func (p *BasicBlockProfiler) Process(bbpd *BasicBlockProfileData) {
+	p.records <- bbpd
+}
+
+// basicBlockProfileHooks counts the basic blocks entered by a single run of
+// the interpreter loop.
+type basicBlockProfileHooks struct {
+	profiler *BasicBlockProfiler
+	data     BasicBlockProfileData
+}
+
+func newBasicBlockProfileHooks(in *GethEVMInterpreter, contract *Contract) *basicBlockProfileHooks {
+	hash := profiledCodeHash(contract)
+	return &basicBlockProfileHooks{
+		profiler: in.cfg.BasicBlockProfiler,
+		data: BasicBlockProfileData{
+			Contract:            profiledContract(contract),
+			CodeHash:            hash,
+			BasicBlockFrequency: make(map[uint]BasicBlock),
+			Graph:               in.cfg.BasicBlockProfiler.controlFlowGraph(hash, contract),
+		},
+	}
+}
+
+func (h *basicBlockProfileHooks) fetch(pc uint64, op OpCode) bool { return true }
+
+func (h *basicBlockProfileHooks) preOp(pc uint64, op OpCode, cost uint64) {}
+
+// blockEntry counts the execution of the static basic block starting at pc.
+func (h *basicBlockProfileHooks) blockEntry(pc uint64) {
+	if block := h.data.Graph.Block(pc); block != nil {
+		bb := h.data.BasicBlockFrequency[uint(pc)]
+		bb.Instructions = block.Instructions
+		bb.Frequency++
+		h.data.BasicBlockFrequency[uint(pc)] = bb
+	}
+}
+
+func (h *basicBlockProfileHooks) postOp(op OpCode) {}
+
+func (h *basicBlockProfileHooks) exit(op OpCode, cost uint64, err error) {
+	h.profiler.Process(&h.data)
+}
+
+// Close stops the profiler once all queued records have been processed and
+// returns the accumulated statistic. No interpreter may use the profiler
+// after it has been closed.
+func (p *BasicBlockProfiler) Close() *BasicBlockProfileStatistic {
+	p.closeOnce.Do(func() { close(p.records) })
+	<-p.done
+	return p.stats
 }
 
 // Merge two basic-block profiling statistics
@@ -100,76 +196,135 @@ func (bbps *BasicBlockProfileStatistic) Merge(src *BasicBlockProfileStatistic) {
 	for bb, freq := range src.basicBlockFrequency {
 		bbps.basicBlockFrequency[bb] += freq
 	}
-}
-
-// dump basic block frequency stats into a SQLITE3 database
-func (bbps *BasicBlockProfileStatistic) Dump() {
-	// Dump basic-block frequency statistics into a SQLITE3 database
 
-	// open sqlite3 database
-	db, err := sql.Open("sqlite3", BasicBlockProfilingDB) // Open the created SQLite File
-	if err != nil {
-		log.Fatal(err.Error())
+	// keep the static blocks of all codes
+	for code, blocks := range src.blocks {
+		bbps.blocks[code] = blocks
 	}
-	defer db.Close()
+}
 
-	// drop basic-block frequency table
-	const dropBasicBlockFrequency string = `DROP TABLE IF EXISTS BasicBlockFrequency;`
-	_, err = db.Exec(dropBasicBlockFrequency)
-	if err != nil {
-		log.Fatalln(err.Error())
+// Coverage returns the static and executed basic blocks of all profiled
+// codes, ordered by code hash. A block executed by several contracts sharing
+// the code is counted once.
+func (bbps *BasicBlockProfileStatistic) Coverage() []BasicBlockCoverage {
+	type codeBlock struct {
+		code    string
+		address uint
 	}
-
-	// create new table
-	const createBasicBlockFrequency string = `
-	CREATE TABLE BasicBlockFrequency (
-	 contract TEXT,
-	 address NUMERIC,
-	 instructions TEXT,
-	 frequency NUMERIC
-	);`
-	_, err = db.Exec(createBasicBlockFrequency)
-	if err != nil {
-		log.Fatalln(err.Error())
+	blocks := make(map[codeBlock]struct{})
+	executed := make(map[string]int)
+	for bkey, freq := range bbps.basicBlockFrequency {
+		block := codeBlock{bkey.Code, bkey.Address}
+		if _, ok := blocks[block]; ok || freq == 0 {
+			continue
+		}
+		blocks[block] = struct{}{}
+		executed[bkey.Code]++
 	}
-
-	// switch synchronous mode off, enable memory journaling,
-	// and start a new transaction
-	_, err = db.Exec("PRAGMA synchronous = OFF;PRAGMA journal_mode = MEMORY;BEGIN TRANSACTION")
-	if err != nil {
-		log.Fatalln(err.Error())
+	coverage := make([]BasicBlockCoverage, 0, len(bbps.blocks))
+	for code, static := range bbps.blocks {
+		c := BasicBlockCoverage{Code: code, Blocks: len(static), Executed: executed[code]}
+		for _, block := range static {
+			if block.Reachable {
+				c.Reachable++
+			}
+		}
+		coverage = append(coverage, c)
 	}
+	sort.Slice(coverage, func(i, j int) bool { return coverage[i].Code < coverage[j].Code })
+	return coverage
+}
 
-	// prepare the insert statement for faster inserts
-	insertFrequency := `INSERT INTO BasicBlockFrequency(contract, address, instructions, frequency) VALUES (?, ?, ?, ?)`
-	statement, err := db.Prepare(insertFrequency)
-	if err != nil {
-		log.Fatalln(err.Error())
+// Table returns the basic-block statistic as a profiling table.
+func (bbps *BasicBlockProfileStatistic) Table() *ProfileTable {
+	t := &ProfileTable{
+		Name: "BasicBlockFrequency",
+		Keys: []ProfileColumn{
+			{Name: "contract", Type: ProfileText},
+			{Name: "address", Type: ProfileInteger},
+			{Name: "instructions", Type: ProfileText},
+		},
+		Values: []string{"frequency"},
 	}
+	// the blocks of all codes executed at a contract address are summed up
+	rows := make(map[BasicBlockKey]uint64)
+	for bkey, freq := range bbps.basicBlockFrequency {
+		bkey.Code = ""
+		rows[bkey] += freq
+	}
+	for bkey, freq := range rows {
+		t.Rows = append(t.Rows, ProfileRow{
+			Keys:   []string{bkey.Contract, strconv.FormatUint(uint64(bkey.Address), 10), bkey.Instructions},
+			Values: []uint64{freq},
+		})
+	}
+	t.Sort()
+	return t
+}
 
-	// populate all values into the DB
-	ctr := 1
+// CodeTable returns the basic-block statistic by code hash as a profiling
+// table, the blocks of all contracts sharing a code are summed up.
+func (bbps *BasicBlockProfileStatistic) CodeTable() *ProfileTable {
+	t := &ProfileTable{
+		Name: "BasicBlockCodeFrequency",
+		Keys: []ProfileColumn{
+			{Name: "code", Type: ProfileText},
+			{Name: "address", Type: ProfileInteger},
+		},
+		Values: []string{"frequency"},
+	}
+	type codeBlock struct {
+		code    string
+		address uint
+	}
+	rows := make(map[codeBlock]uint64)
 	for bkey, freq := range bbps.basicBlockFrequency {
-		// commit dataset when record threshold is reached
-		if ctr >= BasicBlockMaxNumRecords {
-			ctr = 1
-			_, err = db.Exec("END TRANSACTION; BEGIN TRANSACTION;")
-			if err != nil {
-				log.Fatalln(err.Error())
+		rows[codeBlock{bkey.Code, bkey.Address}] += freq
+	}
+	for block, freq := range rows {
+		t.Rows = append(t.Rows, ProfileRow{
+			Keys:   []string{block.code, strconv.FormatUint(uint64(block.address), 10)},
+			Values: []uint64{freq},
+		})
+	}
+	t.Sort()
+	return t
+}
+
+// StaticTable returns the static basic blocks of all profiled codes as a
+// profiling table. Joined with the code table on the code hash and block
+// address, it yields the coverage of every code.
+func (bbps *BasicBlockProfileStatistic) StaticTable() *ProfileTable {
+	t := &ProfileTable{
+		Name: "BasicBlock",
+		Keys: []ProfileColumn{
+			{Name: "code", Type: ProfileText},
+			{Name: "address", Type: ProfileInteger},
+			{Name: "instructions", Type: ProfileText},
+			{Name: "reachable", Type: ProfileInteger},
+		},
+	}
+	for code, static := range bbps.blocks {
+		for _, block := range static {
+			reachable := "0"
+			if block.Reachable {
+				reachable = "1"
 			}
-		} else {
-			ctr++
-		}
-		_, err = statement.Exec(bkey.Contract, bkey.Address, bkey.Instructions, freq)
-		if err != nil {
-			log.Fatalln(err.Error())
+			t.Rows = append(t.Rows, ProfileRow{
+				Keys: []string{code, strconv.FormatUint(block.Start, 10), hex.EncodeToString(block.Instructions), reachable},
+			})
 		}
-
 	}
+	t.Sort()
+	return t
+}
 
-	// end transaction
-	_, err = db.Exec("END TRANSACTION;")
-	if err != nil {
-		log.Fatalln(err.Error())
+// dump basic block frequency stats into a profile sink
+func (bbps *BasicBlockProfileStatistic) Dump(sink ProfileSink) error {
+	for _, t := range []*ProfileTable{bbps.Table(), bbps.CodeTable()} {
+		if err := sink.WriteTable(t); err != nil {
+			return err
+		}
 	}
+	return sink.WriteTable(bbps.StaticTable())
 }
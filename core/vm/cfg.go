@@ -0,0 +1,294 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// CFGEdgeKind classifies the edges of a control-flow graph.
+type CFGEdgeKind int
+
+const (
+	FallthroughEdge CFGEdgeKind = iota // execution continues with the next block
+	JumpEdge                           // statically resolved JUMP or JUMPI target
+)
+
+// CFGEdge is a control-flow edge between two basic blocks, identified by the
+// pc of their first instruction.
+type CFGEdge struct {
+	From, To uint64
+	Kind     CFGEdgeKind
+}
+
+// CFGBlock is a static basic block. The pc of its first instruction is the
+// block's ID, which is also used by the basic-block profiler.
+type CFGBlock struct {
+	Start        uint64 // pc of the first instruction
+	End          uint64 // pc following the last instruction
+	Instructions []byte // opcodes without the data of PUSHx
+	Last         OpCode // last opcode of the block
+	DynamicJump  bool   // block ends with a jump whose target is not a constant
+	InvalidJump  bool   // block ends with a jump to a constant which is no JUMPDEST
+	Reachable    bool   // block may be executed
+}
+
+// ControlFlowGraph is the static control-flow graph of a contract's code.
+// Blocks start at pc 0, at every JUMPDEST and after every instruction ending
+// a block (JUMP, JUMPI, STOP, RETURN, REVERT, SELFDESTRUCT, INVALID and any
+// undefined opcode).
+// Jumps are resolved if their target is pushed by the preceding instruction.
+//
+// Reachability is conservative: if a reachable block ends with an unresolved
+// jump, every JUMPDEST block is considered reachable.
+type ControlFlowGraph struct {
+	Code   []byte      // analysed code
+	Blocks []*CFGBlock // blocks ordered by their start
+	Edges  []CFGEdge   // edges ordered by source and target
+
+	blocks map[uint64]*CFGBlock
+}
+
+// endsBasicBlock reports whether op terminates a basic block. Opcodes which
+// are undefined in the latest instruction set abort the execution like
+// INVALID.
+func endsBasicBlock(op OpCode) bool {
+	switch op {
+	case STOP, JUMP, JUMPI, RETURN, REVERT, SELFDESTRUCT, INVALID:
+		return true
+	}
+	return londonInstructionSet[op] == nil
+}
+
+// BuildControlFlowGraph builds the control-flow graph of code.
+func BuildControlFlowGraph(code []byte) *ControlFlowGraph {
+	g := &ControlFlowGraph{Code: code, blocks: make(map[uint64]*CFGBlock)}
+	if len(code) == 0 {
+		return g
+	}
+	analysis := codeBitmap(code)
+	isJumpDest := func(dest *big.Int) bool {
+		if !dest.IsUint64() {
+			return false
+		}
+		udest := dest.Uint64()
+		return udest < uint64(len(code)) && OpCode(code[udest]) == JUMPDEST && analysis.codeSegment(udest)
+	}
+
+	// split the code into blocks
+	var (
+		block    *CFGBlock
+		prevPush []byte // data of the previous instruction if it was a PUSHx
+		dests    = make(map[uint64][]uint64)
+	)
+	for pc := uint64(0); pc < uint64(len(code)); {
+		op := OpCode(code[pc])
+		if block == nil || op == JUMPDEST {
+			if block != nil {
+				block.End = pc
+				g.Edges = append(g.Edges, CFGEdge{From: block.Start, To: pc, Kind: FallthroughEdge})
+			}
+			block = &CFGBlock{Start: pc}
+			g.Blocks = append(g.Blocks, block)
+			g.blocks[pc] = block
+		}
+		block.Instructions = append(block.Instructions, byte(op))
+		block.Last = op
+
+		next := pc + 1
+		var push []byte
+		if op >= PUSH1 && op <= PUSH32 {
+			next += uint64(op - PUSH1 + 1)
+			end := next
+			if end > uint64(len(code)) {
+				end = uint64(len(code))
+			}
+			push = code[pc+1 : end]
+		}
+		if op == JUMP || op == JUMPI {
+			switch {
+			case prevPush == nil:
+				block.DynamicJump = true
+			case isJumpDest(new(big.Int).SetBytes(prevPush)):
+				dests[block.Start] = append(dests[block.Start], new(big.Int).SetBytes(prevPush).Uint64())
+			default:
+				block.InvalidJump = true
+			}
+		}
+		if endsBasicBlock(op) {
+			block.End = next
+			if op == JUMPI && next < uint64(len(code)) {
+				g.Edges = append(g.Edges, CFGEdge{From: block.Start, To: next, Kind: FallthroughEdge})
+			}
+			block = nil
+		}
+		prevPush = push
+		pc = next
+	}
+	if block != nil {
+		block.End = uint64(len(code))
+	}
+	for from, targets := range dests {
+		for _, to := range targets {
+			g.Edges = append(g.Edges, CFGEdge{From: from, To: to, Kind: JumpEdge})
+		}
+	}
+	sort.Slice(g.Edges, func(i, j int) bool {
+		a, b := g.Edges[i], g.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.Kind < b.Kind
+	})
+	g.markReachable()
+	return g
+}
+
+// markReachable marks all blocks reachable from the entry block.
+func (g *ControlFlowGraph) markReachable() {
+	successors := make(map[uint64][]uint64)
+	for _, e := range g.Edges {
+		successors[e.From] = append(successors[e.From], e.To)
+	}
+	var (
+		queue   = []uint64{0}
+		dynamic = false
+	)
+	g.blocks[0].Reachable = true
+	for len(queue) > 0 {
+		block := g.blocks[queue[0]]
+		queue = queue[1:]
+		if block.DynamicJump && !dynamic {
+			// any JUMPDEST may be the target of an unresolved jump
+			dynamic = true
+			for _, b := range g.Blocks {
+				if OpCode(b.Instructions[0]) == JUMPDEST && !b.Reachable {
+					b.Reachable = true
+					queue = append(queue, b.Start)
+				}
+			}
+		}
+		for _, to := range successors[block.Start] {
+			if next := g.blocks[to]; !next.Reachable {
+				next.Reachable = true
+				queue = append(queue, to)
+			}
+		}
+	}
+}
+
+// Block returns the block starting at pc, or nil if no block starts there.
+func (g *ControlFlowGraph) Block(pc uint64) *CFGBlock {
+	return g.blocks[pc]
+}
+
+// Successors returns the static successors of a block.
+func (g *ControlFlowGraph) Successors(block *CFGBlock) []CFGEdge {
+	var edges []CFGEdge
+	for _, e := range g.Edges {
+		if e.From == block.Start {
+			edges = append(edges, e)
+		}
+	}
+	return edges
+}
+
+// UnresolvedJumps returns the blocks ending with a dynamic jump.
+func (g *ControlFlowGraph) UnresolvedJumps() []*CFGBlock {
+	var blocks []*CFGBlock
+	for _, b := range g.Blocks {
+		if b.DynamicJump {
+			blocks = append(blocks, b)
+		}
+	}
+	return blocks
+}
+
+// DeadBlocks returns the blocks which can never be executed.
+func (g *ControlFlowGraph) DeadBlocks() []*CFGBlock {
+	var blocks []*CFGBlock
+	for _, b := range g.Blocks {
+		if !b.Reachable {
+			blocks = append(blocks, b)
+		}
+	}
+	return blocks
+}
+
+// disassemble returns the instructions of a block in human-readable format.
+func (g *ControlFlowGraph) disassemble(block *CFGBlock) []string {
+	var lines []string
+	for pc := block.Start; pc < block.End; {
+		op := OpCode(g.Code[pc])
+		next := pc + 1
+		if op >= PUSH1 && op <= PUSH32 {
+			next += uint64(op - PUSH1 + 1)
+			end := next
+			if end > uint64(len(g.Code)) {
+				end = uint64(len(g.Code))
+			}
+			lines = append(lines, fmt.Sprintf("%05x: %v 0x%x", pc, op, g.Code[pc+1:end]))
+		} else {
+			lines = append(lines, fmt.Sprintf("%05x: %v", pc, op))
+		}
+		pc = next
+	}
+	return lines
+}
+
+// WriteDOT writes the control-flow graph in the Graphviz DOT format. Dead
+// blocks are drawn dashed, unresolved jumps lead to a common "dynamic" node.
+func (g *ControlFlowGraph) WriteDOT(w io.Writer, name string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %q {\n", name)
+	b.WriteString("\tnode [shape=box fontname=\"monospace\"];\n")
+	dynamic := false
+	for _, block := range g.Blocks {
+		label := strings.Join(g.disassemble(block), "\\l") + "\\l"
+		style := ""
+		if !block.Reachable {
+			style = " style=dashed color=gray"
+		} else if block.InvalidJump {
+			style = " color=red"
+		}
+		fmt.Fprintf(&b, "\tb%d [label=\"%s\"%s];\n", block.Start, label, style)
+		dynamic = dynamic || block.DynamicJump
+	}
+	if dynamic {
+		b.WriteString("\tdynamic [shape=ellipse style=dotted label=\"dynamic jump\"];\n")
+	}
+	for _, e := range g.Edges {
+		if e.Kind == JumpEdge {
+			fmt.Fprintf(&b, "\tb%d -> b%d [label=\"jump\"];\n", e.From, e.To)
+		} else {
+			fmt.Fprintf(&b, "\tb%d -> b%d;\n", e.From, e.To)
+		}
+	}
+	for _, block := range g.UnresolvedJumps() {
+		fmt.Fprintf(&b, "\tb%d -> dynamic [style=dotted];\n", block.Start)
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,121 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestControlFlowGraph(t *testing.T) {
+	code := []byte{
+		byte(PUSH1), 0x04, byte(JUMP), // 0: static jump to 4
+		byte(STOP),                                            // 3: dead code
+		byte(JUMPDEST), byte(PUSH1), 0x00, byte(CALLDATALOAD), // 4
+		byte(PUSH1), 0x0d, byte(JUMPI), // 8: conditional jump to 13
+		byte(PUSH1), 0x5b, // 11: PUSH1 with a JUMPDEST as data
+		byte(JUMPDEST), byte(PUSH1), 0x0c, byte(JUMP), // 13: jump into push data
+		byte(JUMPDEST), byte(CALLER), byte(JUMP), // 17: dynamic jump
+	}
+	g := BuildControlFlowGraph(code)
+
+	var starts []uint64
+	for _, b := range g.Blocks {
+		starts = append(starts, b.Start)
+	}
+	if want := []uint64{0, 3, 4, 11, 13, 17}; !reflect.DeepEqual(starts, want) {
+		t.Fatalf("block starts mismatch: have %v, want %v", starts, want)
+	}
+	wantEdges := []CFGEdge{
+		{From: 0, To: 4, Kind: JumpEdge},
+		{From: 4, To: 11, Kind: FallthroughEdge},
+		{From: 4, To: 13, Kind: JumpEdge},
+		{From: 11, To: 13, Kind: FallthroughEdge},
+	}
+	if !reflect.DeepEqual(g.Edges, wantEdges) {
+		t.Errorf("edges mismatch:\nhave %v\nwant %v", g.Edges, wantEdges)
+	}
+	if b := g.Block(13); !b.InvalidJump || b.DynamicJump {
+		t.Errorf("jump into push data not flagged as invalid")
+	}
+	if b := g.Block(17); !b.DynamicJump || len(g.UnresolvedJumps()) != 1 {
+		t.Errorf("dynamic jump not flagged")
+	}
+	if have, want := g.Block(4).Instructions, []byte{byte(JUMPDEST), byte(PUSH1), byte(CALLDATALOAD), byte(PUSH1), byte(JUMPI)}; !bytes.Equal(have, want) {
+		t.Errorf("instructions mismatch: have %x, want %x", have, want)
+	}
+	// nothing leads to block 17 since the jump of block 13 is invalid, so it
+	// is dead along with the STOP after the first jump
+	dead := g.DeadBlocks()
+	if len(dead) != 2 || dead[0].Start != 3 || dead[1].Start != 17 {
+		t.Errorf("dead blocks mismatch: %v", dead)
+	}
+
+	var dot bytes.Buffer
+	if err := g.WriteDOT(&dot, "test"); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`digraph "test" {`,
+		`b0 -> b4 [label="jump"];`,
+		`b4 -> b11;`,
+		`b17 -> dynamic [style=dotted];`,
+		`b3 [label="00003: STOP\l" style=dashed color=gray];`,
+	} {
+		if !strings.Contains(dot.String(), line) {
+			t.Errorf("missing %q in DOT output:\n%s", line, dot.String())
+		}
+	}
+}
+
+func TestControlFlowGraphDynamicReachability(t *testing.T) {
+	// a reachable dynamic jump makes every JUMPDEST reachable
+	code := []byte{
+		byte(CALLER), byte(JUMP),
+		byte(STOP),
+		byte(JUMPDEST), byte(STOP),
+	}
+	g := BuildControlFlowGraph(code)
+	if dead := g.DeadBlocks(); len(dead) != 1 || dead[0].Start != 2 {
+		t.Errorf("dead blocks mismatch: %v", dead)
+	}
+	if g := BuildControlFlowGraph(nil); len(g.Blocks) != 0 {
+		t.Errorf("blocks in empty code")
+	}
+}
+
+func TestControlFlowGraphUndefinedOpCode(t *testing.T) {
+	code := []byte{
+		byte(PUSH1), 0x00, 0x0c, // 0: undefined opcode ends the block
+		byte(CALLER), byte(INVALID), // 3
+		byte(STOP), // 5
+	}
+	g := BuildControlFlowGraph(code)
+
+	var starts []uint64
+	for _, b := range g.Blocks {
+		starts = append(starts, b.Start)
+	}
+	if want := []uint64{0, 3, 5}; !reflect.DeepEqual(starts, want) {
+		t.Fatalf("block starts mismatch: have %v, want %v", starts, want)
+	}
+	if len(g.Edges) != 0 {
+		t.Errorf("unexpected edges: %v", g.Edges)
+	}
+}
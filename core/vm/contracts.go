@@ -133,6 +133,8 @@ func init() {
 }
 
 // ActivePrecompiles returns the precompiles enabled with the current configuration.
+// The stateful precompiles of the chain configuration are returned by
+// ActiveChainPrecompiles.
 func ActivePrecompiles(rules params.Rules) []common.Address {
 	switch {
 	case rules.IsBerlin:
@@ -146,6 +148,20 @@ func ActivePrecompiles(rules params.Rules) []common.Address {
 	}
 }
 
+// precompiledContracts returns the precompiles enabled with the given rules.
+func precompiledContracts(rules params.Rules) map[common.Address]PrecompiledContract {
+	switch {
+	case rules.IsBerlin:
+		return PrecompiledContractsBerlin
+	case rules.IsIstanbul:
+		return PrecompiledContractsIstanbul
+	case rules.IsByzantium:
+		return PrecompiledContractsByzantium
+	default:
+		return PrecompiledContractsHomestead
+	}
+}
+
 // RunPrecompiledContract runs and evaluates the output of a precompiled contract.
 // It returns
 // - the returned bytes,
@@ -40,8 +40,20 @@ type (
 	// GetHashFunc returns the n'th block hash in the blockchain
 	// and is used by the BLOCKHASH EVM op code.
 	GetHashFunc func(uint64) common.Hash
+	// BlockHashFunc is notified of every execution of the BLOCKHASH EVM op code.
+	BlockHashFunc func(BlockHashRequest)
 )
 
+// BlockHashRequest describes a single execution of the BLOCKHASH op code.
+// GetHash is only consulted for requests in the window of the 256 most recent
+// blocks, all others yield the zero hash.
+type BlockHashRequest struct {
+	Number   uint64      // Requested block number, truncated if it overflows
+	Overflow bool        // Whether the requested number does not fit into 64 bits
+	InWindow bool        // Whether the requested block is one of the 256 most recent ones
+	Hash     common.Hash // Hash returned to the contract
+}
+
 func (evm *EVM) precompile(addr common.Address) (PrecompiledContract, bool) {
 	var precompiles map[common.Address]PrecompiledContract
 	switch {
@@ -58,14 +70,52 @@ func (evm *EVM) precompile(addr common.Address) (PrecompiledContract, bool) {
 	return p, ok
 }
 
+// statePrecompile returns the stateful precompile at addr, the precompiles of
+// the vm config take precedence over the ones of the chain configuration.
 func (evm *EVM) statePrecompile(addr common.Address) (PrecompiledStateContract, bool) {
-	if evm.Config.StatePrecompiles == nil {
+	if p, ok := evm.Config.StatePrecompiles[addr]; ok {
+		return p, true
+	}
+	if evm.statePrecompiles == nil {
+		return nil, false
+	}
+	p, ok := evm.statePrecompiles.contracts[addr]
+	return p, ok
+}
+
+// callPrecompile returns the stateful precompile at addr if it is executed
+// by all call opcodes, see PrecompiledCallContract.
+func (evm *EVM) callPrecompile(addr common.Address) (PrecompiledCallContract, bool) {
+	sp, ok := evm.statePrecompile(addr)
+	if !ok {
 		return nil, false
 	}
-	p, ok := evm.Config.StatePrecompiles[addr]
+	p, ok := sp.(PrecompiledCallContract)
 	return p, ok
 }
 
+// runStatePrecompile executes a stateful precompile called by caller,
+// accessing the state of address.
+func (evm *EVM) runStatePrecompile(p PrecompiledStateContract, caller, address common.Address, input []byte, gas uint64, value *big.Int) ([]byte, uint64, error) {
+	cp, ok := p.(PrecompiledCallContract)
+	if !ok {
+		return p.Run(evm.StateDB, evm.Context, evm.TxContext, caller, input, gas)
+	}
+	if value == nil {
+		value = new(big.Int)
+	}
+	call := &PrecompileCall{
+		StateDB:  evm.StateDB,
+		BlockCtx: evm.Context,
+		TxCtx:    evm.TxContext,
+		Caller:   caller,
+		Address:  address,
+		Value:    value,
+		ReadOnly: evm.readOnly,
+	}
+	return cp.RunCall(call, input, gas)
+}
+
 // BlockContext provides the EVM with auxiliary information. Once provided
 // it shouldn't be modified.
 type BlockContext struct {
@@ -76,6 +126,12 @@ type BlockContext struct {
 	Transfer TransferFunc
 	// GetHash returns the hash corresponding to n
 	GetHash GetHashFunc
+	// OnBlockHash, if set, observes every BLOCKHASH request, regardless of
+	// the StateDB implementation (e.g. to record the hashes a transaction
+	// depends on). NewEVM wraps GetHash to report the requests in the window
+	// of recent blocks with every interpreter, the others are only reported
+	// by the geth interpreter since they do not reach GetHash.
+	OnBlockHash BlockHashFunc
 
 	// Block information
 	Coinbase    common.Address // Provides information for COINBASE
@@ -131,23 +187,45 @@ type EVM struct {
 	callGasTemp uint64
 	// An optional override to intercept EVM calls.
 	CallContext CallContext
+	// readOnly is set while executing a static call, it is forwarded to
+	// stateful precompiles
+	readOnly bool
+	// statePrecompiles are the stateful precompiles the chain configuration
+	// activates at the current block
+	statePrecompiles *statePrecompileStage
+	// resumed is the number of frames resumed from Config.Resume
+	resumed int
 }
 
 // NewEVM returns a new EVM. The returned EVM is not thread safe and should
 // only ever be used *once*.
 func NewEVM(blockCtx BlockContext, txCtx TxContext, statedb StateDB, chainConfig *params.ChainConfig, config Config) *EVM {
 	evm := &EVM{
-		Context:     blockCtx,
-		TxContext:   txCtx,
-		StateDB:     statedb,
-		Config:      config,
-		chainConfig: chainConfig,
-		chainRules:  chainConfig.Rules(blockCtx.BlockNumber),
+		Context:          blockCtx,
+		TxContext:        txCtx,
+		StateDB:          statedb,
+		Config:           config,
+		chainConfig:      chainConfig,
+		chainRules:       chainConfig.Rules(blockCtx.BlockNumber),
+		statePrecompiles: activeStatePrecompiles(chainConfig, blockCtx.BlockNumber),
+	}
+	if blockCtx.GetHash != nil && blockCtx.OnBlockHash != nil {
+		evm.Context.GetHash = observeGetHash(blockCtx.GetHash, blockCtx.OnBlockHash)
 	}
 	evm.interpreter = NewInterpreter(config.InterpreterImpl, evm, config)
 	return evm
 }
 
+// observeGetHash returns a GetHashFunc reporting every lookup of getHash to
+// onBlockHash as a request in the window of recent blocks.
+func observeGetHash(getHash GetHashFunc, onBlockHash BlockHashFunc) GetHashFunc {
+	return func(n uint64) common.Hash {
+		hash := getHash(n)
+		onBlockHash(BlockHashRequest{Number: n, InWindow: true, Hash: hash})
+		return hash
+	}
+}
+
 // Reset resets the EVM with a new transaction context.Reset
 // This is not threadsafe and should only be done very cautiously.
 func (evm *EVM) Reset(txCtx TxContext, statedb StateDB) {
@@ -226,7 +304,7 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 	if isPrecompile {
 		ret, gas, err = RunPrecompiledContract(p, input, gas)
 	} else if isStatePrecompile {
-		ret, gas, err = sp.Run(evm.StateDB, evm.Context, evm.TxContext, caller.Address(), input, gas)
+		ret, gas, err = evm.runStatePrecompile(sp, caller.Address(), addr, input, gas, value)
 	} else {
 		// Initialise a new contract and set the code that is to be used by the EVM.
 		// The contract is a scoped environment for this execution context only.
@@ -296,6 +374,8 @@ func (evm *EVM) CallCode(caller ContractRef, addr common.Address, input []byte,
 	// It is allowed to call precompiles, even via delegatecall
 	if p, isPrecompile := evm.precompile(addr); isPrecompile {
 		ret, gas, err = RunPrecompiledContract(p, input, gas)
+	} else if sp, isStatePrecompile := evm.callPrecompile(addr); isStatePrecompile {
+		ret, gas, err = evm.runStatePrecompile(sp, caller.Address(), caller.Address(), input, gas, value)
 	} else {
 		addrCopy := addr
 		// Initialise a new contract and set the code that is to be used by the EVM.
@@ -343,6 +423,13 @@ func (evm *EVM) DelegateCall(caller ContractRef, addr common.Address, input []by
 	// It is allowed to call precompiles, even via delegatecall
 	if p, isPrecompile := evm.precompile(addr); isPrecompile {
 		ret, gas, err = RunPrecompiledContract(p, input, gas)
+	} else if sp, isStatePrecompile := evm.callPrecompile(addr); isStatePrecompile {
+		// The delegated call keeps the caller and value of the calling frame
+		from, value := caller.Address(), new(big.Int)
+		if parent, ok := caller.(*Contract); ok {
+			from, value = parent.CallerAddress, parent.value
+		}
+		ret, gas, err = evm.runStatePrecompile(sp, from, caller.Address(), input, gas, value)
 	} else {
 		addrCopy := addr
 		// Initialise a new contract and make initialise the delegate values
@@ -395,9 +482,17 @@ func (evm *EVM) StaticCall(caller ContractRef, addr common.Address, input []byte
 			evm.Config.Tracer.CaptureExit(ret, startGas-gas, err)
 		}(gas)
 	}
+	// Make sure the readOnly is only set if we aren't in readOnly yet, like
+	// the interpreter does.
+	if !evm.readOnly {
+		evm.readOnly = true
+		defer func() { evm.readOnly = false }()
+	}
 
 	if p, isPrecompile := evm.precompile(addr); isPrecompile {
 		ret, gas, err = RunPrecompiledContract(p, input, gas)
+	} else if sp, isStatePrecompile := evm.callPrecompile(addr); isStatePrecompile {
+		ret, gas, err = evm.runStatePrecompile(sp, caller.Address(), addr, input, gas, nil)
 	} else {
 		// At this point, we use a copy of address. If we don't, the go compiler will
 		// leak the 'contract' to the outer scope, and make allocation for 'contract'
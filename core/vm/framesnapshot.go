@@ -0,0 +1,87 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+)
+
+// FrameSnapshot is the interpreter state of a call frame before the execution
+// of an instruction whose gas was already charged.
+//
+// An execution configured with Config.Resume continues from snapshots of all
+// its open frames, the outermost first, instead of executing the frames from
+// their start: every frame at the depth of a snapshot continues with the
+// snapshot's instruction. All but the innermost snapshot have to be taken at
+// the call or create instruction which opened the next frame, which is then
+// executed again to enter it. The resumed instructions are reported to the
+// tracer as usual. Restoring the StateDB is up to the caller; the tracer can
+// replace the state of the frames when their instructions are reported, see
+// EVM.ResumedFrames. Only the geth interpreter supports resuming executions.
+type FrameSnapshot struct {
+	PC         uint64
+	Gas        uint64 // gas before the instruction
+	Cost       uint64 // gas charged for the instruction
+	CallGas    uint64 // gas a call instruction passes to the callee
+	Stack      []uint256.Int
+	Memory     []byte
+	MemoryCost uint64 // gas charged for the memory size
+	ReturnData []byte
+}
+
+// SnapshotFrame captures the state of the frame executing the instruction
+// reported to Tracer.CaptureState. The arguments are the ones passed to
+// CaptureState for an instruction without error.
+func SnapshotFrame(env *EVM, pc uint64, gas, cost uint64, scope *ScopeContext, rData []byte) *FrameSnapshot {
+	return &FrameSnapshot{
+		PC:         pc,
+		Gas:        gas,
+		Cost:       cost,
+		CallGas:    env.callGasTemp,
+		Stack:      append([]uint256.Int(nil), scope.Stack.data...),
+		Memory:     common.CopyBytes(scope.Memory.store),
+		MemoryCost: scope.Memory.lastGasCost,
+		ReturnData: common.CopyBytes(rData),
+	}
+}
+
+// ResumedFrames returns the number of frames resumed from Config.Resume so
+// far. While the execution is resumed, the instruction of the frame at depth
+// ResumedFrames is the resumed instruction of its snapshot.
+func (evm *EVM) ResumedFrames() int {
+	return evm.resumed
+}
+
+// resume restores the state of a frame at its first instruction if the
+// execution resumes from a snapshot at the frame's depth.
+func (in *GethEVMInterpreter) resume(pc *uint64, scope *ScopeContext) *FrameSnapshot {
+	frames := in.cfg.Resume
+	if in.evm.resumed >= len(frames) || in.evm.Depth != in.evm.resumed+1 {
+		return nil
+	}
+	f := frames[in.evm.resumed]
+	in.evm.resumed++
+
+	*pc = f.PC
+	scope.Contract.Gas = f.Gas
+	scope.Stack.data = append(scope.Stack.data[:0], f.Stack...)
+	scope.Memory.store, scope.Memory.lastGasCost = common.CopyBytes(f.Memory), f.MemoryCost
+	in.returnData = common.CopyBytes(f.ReturnData)
+	in.evm.callGasTemp = f.CallGas
+	return f
+}
@@ -17,11 +17,7 @@
 package vm
 
 import (
-	substate "github.com/Fantom-foundation/Substate"
-	"time"
-
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/params"
 	"github.com/holiman/uint256"
@@ -445,32 +441,31 @@ func opBlockhash(pc *uint64, interpreter *GethEVMInterpreter, scope *ScopeContex
 	num := scope.Stack.peek()
 	num64, overflow := num.Uint64WithOverflow()
 
-	if substate.RecordReplay {
-		// convert vm.StateDB to state.StateDB and save block hash
-		defer func() {
-			statedb, ok := interpreter.evm.StateDB.(*state.StateDB)
-			if ok {
-				statedb.SubstateBlockHashes[num64] = common.BytesToHash(num.Bytes())
-			}
-		}()
-	}
-
-	if overflow {
-		num.Clear()
-		return nil, nil
-	}
-	var upper, lower uint64
-	upper = interpreter.evm.Context.BlockNumber.Uint64()
-	if upper < 257 {
-		lower = 0
-	} else {
-		lower = upper - 256
+	var inWindow bool
+	if !overflow {
+		var upper, lower uint64
+		upper = interpreter.evm.Context.BlockNumber.Uint64()
+		if upper < 257 {
+			lower = 0
+		} else {
+			lower = upper - 256
+		}
+		inWindow = num64 >= lower && num64 < upper
 	}
-	if num64 >= lower && num64 < upper {
+	if inWindow {
 		num.SetBytes(interpreter.evm.Context.GetHash(num64).Bytes())
 	} else {
 		num.Clear()
 	}
+	// Requests in the window are reported by the GetHash wrapper of NewEVM.
+	if onBlockHash := interpreter.evm.Context.OnBlockHash; onBlockHash != nil && !inWindow {
+		onBlockHash(BlockHashRequest{
+			Number:   num64,
+			Overflow: overflow,
+			InWindow: inWindow,
+			Hash:     common.Hash(num.Bytes32()),
+		})
+	}
 	return nil, nil
 }
 
@@ -626,50 +621,6 @@ func opCreate(pc *uint64, interpreter *GethEVMInterpreter, scope *ScopeContext)
 	return nil, nil
 }
 
-func opTimedCreate(pc *uint64, interpreter *GethEVMInterpreter, scope *ScopeContext) ([]byte, time.Duration, error) {
-	var (
-		value        = scope.Stack.pop()
-		offset, size = scope.Stack.pop(), scope.Stack.pop()
-		input        = scope.Memory.GetCopy(int64(offset.Uint64()), int64(size.Uint64()))
-		gas          = scope.Contract.Gas
-	)
-	if interpreter.evm.chainRules.IsEIP150 {
-		gas -= gas / 64
-	}
-	// reuse size int for stackvalue
-	stackvalue := size
-
-	scope.Contract.UseGas(gas)
-	//TODO: use uint256.Int instead of converting with toBig()
-	var bigVal = big0
-	if !value.IsZero() {
-		bigVal = value.ToBig()
-	}
-
-	start := time.Now()
-	res, addr, returnGas, suberr := interpreter.evm.Create(scope.Contract, input, gas, bigVal)
-	elapsed := time.Since(start)
-
-	// Push item on the stack based on the returned error. If the ruleset is
-	// homestead we must check for CodeStoreOutOfGasError (homestead only
-	// rule) and treat as an error, if the ruleset is frontier we must
-	// ignore this error and pretend the operation was successful.
-	if interpreter.evm.chainRules.IsHomestead && suberr == ErrCodeStoreOutOfGas {
-		stackvalue.Clear()
-	} else if suberr != nil && suberr != ErrCodeStoreOutOfGas {
-		stackvalue.Clear()
-	} else {
-		stackvalue.SetBytes(addr.Bytes())
-	}
-	scope.Stack.push(&stackvalue)
-	scope.Contract.Gas += returnGas
-
-	if suberr == ErrExecutionReverted {
-		return res, 0, nil
-	}
-	return nil, elapsed, nil
-}
-
 func opCreate2(pc *uint64, interpreter *GethEVMInterpreter, scope *ScopeContext) ([]byte, error) {
 	var (
 		endowment    = scope.Stack.pop()
@@ -707,45 +658,6 @@ func opCreate2(pc *uint64, interpreter *GethEVMInterpreter, scope *ScopeContext)
 	return nil, nil
 }
 
-func opTimedCreate2(pc *uint64, interpreter *GethEVMInterpreter, scope *ScopeContext) ([]byte, time.Duration, error) {
-	var (
-		endowment    = scope.Stack.pop()
-		offset, size = scope.Stack.pop(), scope.Stack.pop()
-		salt         = scope.Stack.pop()
-		input        = scope.Memory.GetCopy(int64(offset.Uint64()), int64(size.Uint64()))
-		gas          = scope.Contract.Gas
-	)
-
-	// Apply EIP150
-	gas -= gas / 64
-	scope.Contract.UseGas(gas)
-	// reuse size int for stackvalue
-	stackvalue := size
-	//TODO: use uint256.Int instead of converting with toBig()
-	bigEndowment := big0
-	if !endowment.IsZero() {
-		bigEndowment = endowment.ToBig()
-	}
-
-	start := time.Now()
-	res, addr, returnGas, suberr := interpreter.evm.Create2(scope.Contract, input, gas, bigEndowment, &salt)
-	elapsed := time.Since(start)
-
-	// Push item on the stack based on the returned error.
-	if suberr != nil {
-		stackvalue.Clear()
-	} else {
-		stackvalue.SetBytes(addr.Bytes())
-	}
-	scope.Stack.push(&stackvalue)
-	scope.Contract.Gas += returnGas
-
-	if suberr == ErrExecutionReverted {
-		return res, 0, nil
-	}
-	return nil, elapsed, nil
-}
-
 func opCall(pc *uint64, interpreter *GethEVMInterpreter, scope *ScopeContext) ([]byte, error) {
 	stack := scope.Stack
 	// Pop gas. The actual gas in interpreter.evm.callGasTemp.
@@ -784,46 +696,6 @@ func opCall(pc *uint64, interpreter *GethEVMInterpreter, scope *ScopeContext) ([
 	return ret, nil
 }
 
-func opTimedCall(pc *uint64, interpreter *GethEVMInterpreter, scope *ScopeContext) ([]byte, time.Duration, error) {
-	stack := scope.Stack
-	// Pop gas. The actual gas in interpreter.evm.callGasTemp.
-	// We can use this as a temporary value
-	temp := stack.pop()
-	gas := interpreter.evm.callGasTemp
-	// Pop other call parameters.
-	addr, value, inOffset, inSize, retOffset, retSize := stack.pop(), stack.pop(), stack.pop(), stack.pop(), stack.pop(), stack.pop()
-	toAddr := common.Address(addr.Bytes20())
-	// Get the arguments from the memory.
-	args := scope.Memory.GetPtr(int64(inOffset.Uint64()), int64(inSize.Uint64()))
-
-	var bigVal = big0
-	//TODO: use uint256.Int instead of converting with toBig()
-	// By using big0 here, we save an alloc for the most common case (non-ether-transferring contract calls),
-	// but it would make more sense to extend the usage of uint256.Int
-	if !value.IsZero() {
-		gas += params.CallStipend
-		bigVal = value.ToBig()
-	}
-
-	start := time.Now()
-	ret, returnGas, err := interpreter.evm.Call(scope.Contract, toAddr, args, gas, bigVal)
-	elapsed := time.Since(start)
-
-	if err != nil {
-		temp.Clear()
-	} else {
-		temp.SetOne()
-	}
-	stack.push(&temp)
-	if err == nil || err == ErrExecutionReverted {
-		ret = common.CopyBytes(ret)
-		scope.Memory.Set(retOffset.Uint64(), retSize.Uint64(), ret)
-	}
-	scope.Contract.Gas += returnGas
-
-	return ret, elapsed, nil
-}
-
 func opCallCode(pc *uint64, interpreter *GethEVMInterpreter, scope *ScopeContext) ([]byte, error) {
 	// Pop gas. The actual gas is in interpreter.evm.callGasTemp.
 	stack := scope.Stack
@@ -860,44 +732,6 @@ func opCallCode(pc *uint64, interpreter *GethEVMInterpreter, scope *ScopeContext
 	return ret, nil
 }
 
-func opTimedCallCode(pc *uint64, interpreter *GethEVMInterpreter, scope *ScopeContext) ([]byte, time.Duration, error) {
-	// Pop gas. The actual gas is in interpreter.evm.callGasTemp.
-	stack := scope.Stack
-	// We use it as a temporary value
-	temp := stack.pop()
-	gas := interpreter.evm.callGasTemp
-	// Pop other call parameters.
-	addr, value, inOffset, inSize, retOffset, retSize := stack.pop(), stack.pop(), stack.pop(), stack.pop(), stack.pop(), stack.pop()
-	toAddr := common.Address(addr.Bytes20())
-	// Get arguments from the memory.
-	args := scope.Memory.GetPtr(int64(inOffset.Uint64()), int64(inSize.Uint64()))
-
-	//TODO: use uint256.Int instead of converting with toBig()
-	var bigVal = big0
-	if !value.IsZero() {
-		gas += params.CallStipend
-		bigVal = value.ToBig()
-	}
-
-	start := time.Now()
-	ret, returnGas, err := interpreter.evm.CallCode(scope.Contract, toAddr, args, gas, bigVal)
-	elapsed := time.Since(start)
-
-	if err != nil {
-		temp.Clear()
-	} else {
-		temp.SetOne()
-	}
-	stack.push(&temp)
-	if err == nil || err == ErrExecutionReverted {
-		ret = common.CopyBytes(ret)
-		scope.Memory.Set(retOffset.Uint64(), retSize.Uint64(), ret)
-	}
-	scope.Contract.Gas += returnGas
-
-	return ret, elapsed, nil
-}
-
 func opDelegateCall(pc *uint64, interpreter *GethEVMInterpreter, scope *ScopeContext) ([]byte, error) {
 	stack := scope.Stack
 	// Pop gas. The actual gas is in interpreter.evm.callGasTemp.
@@ -927,37 +761,6 @@ func opDelegateCall(pc *uint64, interpreter *GethEVMInterpreter, scope *ScopeCon
 	return ret, nil
 }
 
-func opTimedDelegateCall(pc *uint64, interpreter *GethEVMInterpreter, scope *ScopeContext) ([]byte, time.Duration, error) {
-	stack := scope.Stack
-	// Pop gas. The actual gas is in interpreter.evm.callGasTemp.
-	// We use it as a temporary value
-	temp := stack.pop()
-	gas := interpreter.evm.callGasTemp
-	// Pop other call parameters.
-	addr, inOffset, inSize, retOffset, retSize := stack.pop(), stack.pop(), stack.pop(), stack.pop(), stack.pop()
-	toAddr := common.Address(addr.Bytes20())
-	// Get arguments from the memory.
-	args := scope.Memory.GetPtr(int64(inOffset.Uint64()), int64(inSize.Uint64()))
-
-	start := time.Now()
-	ret, returnGas, err := interpreter.evm.DelegateCall(scope.Contract, toAddr, args, gas)
-	elapsed := time.Since(start)
-
-	if err != nil {
-		temp.Clear()
-	} else {
-		temp.SetOne()
-	}
-	stack.push(&temp)
-	if err == nil || err == ErrExecutionReverted {
-		ret = common.CopyBytes(ret)
-		scope.Memory.Set(retOffset.Uint64(), retSize.Uint64(), ret)
-	}
-	scope.Contract.Gas += returnGas
-
-	return ret, elapsed, nil
-}
-
 func opStaticCall(pc *uint64, interpreter *GethEVMInterpreter, scope *ScopeContext) ([]byte, error) {
 	// Pop gas. The actual gas is in interpreter.evm.callGasTemp.
 	stack := scope.Stack
@@ -987,37 +790,6 @@ func opStaticCall(pc *uint64, interpreter *GethEVMInterpreter, scope *ScopeConte
 	return ret, nil
 }
 
-func opTimedStaticCall(pc *uint64, interpreter *GethEVMInterpreter, scope *ScopeContext) ([]byte, time.Duration, error) {
-	// Pop gas. The actual gas is in interpreter.evm.callGasTemp.
-	stack := scope.Stack
-	// We use it as a temporary value
-	temp := stack.pop()
-	gas := interpreter.evm.callGasTemp
-	// Pop other call parameters.
-	addr, inOffset, inSize, retOffset, retSize := stack.pop(), stack.pop(), stack.pop(), stack.pop(), stack.pop()
-	toAddr := common.Address(addr.Bytes20())
-	// Get arguments from the memory.
-	args := scope.Memory.GetPtr(int64(inOffset.Uint64()), int64(inSize.Uint64()))
-
-	start := time.Now()
-	ret, returnGas, err := interpreter.evm.StaticCall(scope.Contract, toAddr, args, gas)
-	elapsed := time.Since(start)
-
-	if err != nil {
-		temp.Clear()
-	} else {
-		temp.SetOne()
-	}
-	stack.push(&temp)
-	if err == nil || err == ErrExecutionReverted {
-		ret = common.CopyBytes(ret)
-		scope.Memory.Set(retOffset.Uint64(), retSize.Uint64(), ret)
-	}
-	scope.Contract.Gas += returnGas
-
-	return ret, elapsed, nil
-}
-
 func opReturn(pc *uint64, interpreter *GethEVMInterpreter, scope *ScopeContext) ([]byte, error) {
 	offset, size := scope.Stack.pop(), scope.Stack.pop()
 	ret := scope.Memory.GetPtr(int64(offset.Uint64()), int64(size.Uint64()))
@@ -21,6 +21,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math/big"
+	"reflect"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -650,3 +652,40 @@ func TestCreate2Addreses(t *testing.T) {
 		}
 	}
 }
+
+func TestOpBlockhashObserver(t *testing.T) {
+	var (
+		requests []BlockHashRequest
+		blockCtx = BlockContext{
+			BlockNumber: big.NewInt(300),
+			GetHash:     func(n uint64) common.Hash { return common.BigToHash(new(big.Int).SetUint64(n)) },
+			OnBlockHash: func(req BlockHashRequest) { requests = append(requests, req) },
+		}
+		env            = NewEVM(blockCtx, TxContext{}, nil, params.TestChainConfig, Config{})
+		stack          = newstack()
+		pc             = uint64(0)
+		evmInterpreter = env.interpreter.(*GethEVMInterpreter)
+	)
+	overflow := new(uint256.Int).Lsh(uint256.NewInt(1), 64)
+	overflow.AddUint64(overflow, 299)
+	for _, num := range []*uint256.Int{uint256.NewInt(299), uint256.NewInt(10), overflow} {
+		stack.push(num)
+		opBlockhash(&pc, evmInterpreter, &ScopeContext{nil, stack, nil})
+		stack.pop()
+	}
+	want := []BlockHashRequest{
+		{Number: 299, InWindow: true, Hash: common.BigToHash(big.NewInt(299))},
+		{Number: 10},
+		{Number: 299, Overflow: true},
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Fatalf("wrong block hash requests:\nhave %+v\nwant %+v", requests, want)
+	}
+	// Other interpreters look up hashes through the context directly.
+	requests = nil
+	env.Context.GetHash(298)
+	want = []BlockHashRequest{{Number: 298, InWindow: true, Hash: common.BigToHash(big.NewInt(298))}}
+	if !reflect.DeepEqual(requests, want) {
+		t.Fatalf("wrong block hash requests of GetHash:\nhave %+v\nwant %+v", requests, want)
+	}
+}
@@ -21,7 +21,6 @@ import (
 	syslog "log"
 	"strings"
 	"sync/atomic"
-	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/math"
@@ -43,6 +42,13 @@ type Config struct {
 	StatePrecompiles map[common.Address]PrecompiledStateContract
 
 	InterpreterImpl string
+
+	SubstateRecorder SubstateRecorder // Receives the substate of every transaction of processed blocks
+
+	MicroProfiler      *MicroProfiler      // Collects opcode statistics if set
+	BasicBlockProfiler *BasicBlockProfiler // Collects basic-block frequencies if set
+
+	Resume []*FrameSnapshot // Resumes the execution from snapshots of its frames, see FrameSnapshot
 }
 
 // ScopeContext contains the things that are per-call, such as stack and memory,
@@ -82,6 +88,12 @@ func RegisterInterpreterFactory(name string, factory InterpreterFactory) {
 	interpreter_registry[strings.ToLower(name)] = factory
 }
 
+// HasInterpreterFactory reports whether an interpreter is registered under name.
+func HasInterpreterFactory(name string) bool {
+	_, found := interpreter_registry[strings.ToLower(name)]
+	return found
+}
+
 func NewInterpreter(name string, evm *EVM, cfg Config) EVMInterpreter {
 	factory, found := interpreter_registry[strings.ToLower(name)]
 	if !found {
@@ -100,6 +112,8 @@ type GethEVMInterpreter struct {
 
 	readOnly   bool   // Whether to throw on stateful modifications
 	returnData []byte // Last CALL's return data for subsequent reuse
+
+	microProfile *microProfileHooks // micro-profiling hooks of the innermost frame
 }
 
 func init() {
@@ -177,6 +191,7 @@ func (in *GethEVMInterpreter) Start(contract *Contract, input []byte, readOnly b
 		next:     make(chan int),
 		done:     make(chan int),
 	}
+	state.step = &stepHooks{state: &state}
 	go in.run(&state, input, readOnly)
 	return &state
 }
@@ -190,6 +205,7 @@ type InterpreterState struct {
 
 	next chan int
 	done chan int
+	step *stepHooks // suspends the run between steps
 }
 
 func (s *InterpreterState) IsDone() bool {
@@ -217,26 +233,9 @@ func (s *InterpreterState) Stop() {
 	}
 }
 
-// Proxy run function
+// run executes the contract's code. Profilers, tracers and the stepping API
+// instrument the loop through hooks, see interpreterHooks.
 func (in *GethEVMInterpreter) run(state *InterpreterState, input []byte, readOnly bool) (ret []byte, err error) {
-	if MicroProfiling {
-		return in.runMicroProfiling(state, input, readOnly)
-	} else if (BasicBlockProfiling) {
-		return in.runBasicBlockProfiling(state, input, readOnly)
-	} else {
-		return in.runPlain(state, input, readOnly)
-	}
-}
-
-// run with profiling op-codes enabled
-func (in *GethEVMInterpreter) runMicroProfiling(state *InterpreterState, input []byte, readOnly bool) (ret []byte, err error) {
-
-	defer func() {
-		state.finished = true
-		if state.done != nil {
-			close(state.done)
-		}
-	}()
 	// Increment the call depth which is restricted to 1024
 	in.evm.Depth++
 	defer func() { in.evm.Depth-- }()
@@ -254,254 +253,10 @@ func (in *GethEVMInterpreter) runMicroProfiling(state *InterpreterState, input [
 
 	// Don't bother with the execution if there's no code.
 	if len(state.Contract.Code) == 0 {
-		return nil, nil
-	}
-
-	var (
-		contract    = state.Contract // processed contract
-		op          OpCode           // current opcode
-		mem         = state.Memory   // bound memory
-		stack       = state.Stack    // local stack
-		callContext = &ScopeContext{
-			Memory:   mem,
-			Stack:    stack,
-			Contract: contract,
-		}
-		// For optimisation reason we're using uint64 as the program counter.
-		// It's theoretically possible to go above 2^64. The YP defines the PC
-		// to be uint256. Practically much less so feasible.
-		pc   = uint64(0) // program counter
-		cost uint64
-		// copies used by tracer
-		pcCopy             uint64                       // needed for the deferred Tracer
-		gasCopy            uint64                       // for Tracer to log gas remaining before execution
-		logged             bool                         // deferred Tracer should ignore already logged steps
-		res                []byte                       // result of the opcode execution function
-		opCodeFrequency    = map[OpCode]uint64{}        // op-code frequency stats
-		opCodeDuration     = map[OpCode]time.Duration{} // op-code duration stats (accumulated)
-		pcCounterFrequency = map[uint64]uint64{}        // pc-counter frequency stats
-
-	)
-
-	// Don't move this deferrred function, it's placed before the capturestate-deferred method,
-	// so that it get's executed _after_: the capturestate needs the stacks before
-	// they are returned to the pools
-	contract.Input = input
-
-	if in.cfg.Debug {
-		defer func() {
-			if err != nil {
-				if !logged {
-					in.cfg.Tracer.CaptureState(in.evm, pcCopy, op, gasCopy, cost, callContext, in.returnData, in.evm.Depth, err)
-				} else {
-					in.cfg.Tracer.CaptureFault(in.evm, pcCopy, op, gasCopy, cost, callContext, in.evm.Depth, err)
-				}
-			}
-		}()
-	}
-	// The Interpreter main run loop (contextual). This loop runs until either an
-	// explicit STOP, RETURN or SELFDESTRUCT is executed, an error occurred during
-	// the execution of one of the operations or until the done flag is set by the
-	// parent context.
-	steps := 0
-
-	// record the opcodes counts and length
-	defer func() {
-		// compute frequency statistics for instructions
-		instructionFrequency := map[uint64]uint64{}
-		for _, ctr := range pcCounterFrequency {
-			instructionFrequency[ctr]++
-		}
-
-		// construct statistical observation
-		mpd := MicroProfileData{
-			OpCodeFrequency:      opCodeFrequency,
-			OpCodeDuration:       opCodeDuration,
-			InstructionFrequency: instructionFrequency,
-			StepLength:           steps}
-
-		// process statistical observation
-		ProcessMicroProfileData(&mpd)
-	}()
-
-	for {
-		// Block until next step should be processed.
-		if state.next != nil {
-			state.pc = pc
-			// Signal completion of previous step.
-			if steps != 0 {
-				state.done <- 0
-			}
-			// Wait for processing of next step
-			_, open := <-state.next
-			if !open {
-				return
-			}
-		}
-		steps++
-		if steps%1000 == 0 && atomic.LoadInt32(&in.evm.abort) != 0 {
-			break
-		}
-		if in.cfg.Debug {
-			// Capture pre-execution values for tracing.
-			logged, pcCopy, gasCopy = false, pc, contract.Gas
-		}
-
-		// Get the operation from the jump table and validate the stack to ensure there are
-		// enough stack items available to perform the operation.
-		op = contract.GetOp(pc)
-		opCodeFrequency[op]++
-		pcCounterFrequency[pc]++
-		operation := in.cfg.JumpTable[op]
-		if operation == nil {
-			return nil, &ErrInvalidOpCode{opcode: op}
-		}
-		// Validate stack
-		if sLen := stack.len(); sLen < operation.minStack {
-			return nil, &ErrStackUnderflow{stackLen: sLen, required: operation.minStack}
-		} else if sLen > operation.maxStack {
-			return nil, &ErrStackOverflow{stackLen: sLen, limit: operation.maxStack}
-		}
-		// If the operation is valid, enforce write restrictions
-		if in.readOnly && in.evm.chainRules.IsByzantium {
-			// If the interpreter is operating in readonly mode, make sure no
-			// state-modifying operation is performed. The 3rd stack item
-			// for a call operation is the value. Transferring value from one
-			// account to the others means the state is modified and should also
-			// return with an error.
-			if operation.writes || (op == CALL && stack.Back(2).Sign() != 0) {
-				return nil, ErrWriteProtection
-			}
-		}
-		// Static portion of gas
-		cost = operation.constantGas // For tracing
-		if !contract.UseGas(operation.constantGas) {
-			return nil, ErrOutOfGas
-		}
-
-		var memorySize uint64
-		// calculate the new memory size and expand the memory to fit
-		// the operation
-		// Memory check needs to be done prior to evaluating the dynamic gas portion,
-		// to detect calculation overflows
-		if operation.memorySize != nil {
-			memSize, overflow := operation.memorySize(stack)
-			if overflow {
-				return nil, ErrGasUintOverflow
-			}
-			// memory is expanded in words of 32 bytes. Gas
-			// is also calculated in words.
-			if memorySize, overflow = math.SafeMul(toWordSize(memSize), 32); overflow {
-				return nil, ErrGasUintOverflow
-			}
-		}
-		// Dynamic portion of gas
-		// consume the gas and return an error if not enough gas is available.
-		// cost is explicitly set so that the capture state defer method can get the proper cost
-		if operation.dynamicGas != nil {
-			var dynamicCost uint64
-			dynamicCost, err = operation.dynamicGas(in.evm, contract, stack, mem, memorySize)
-			cost += dynamicCost // total cost, for debug tracing
-			if err != nil || !contract.UseGas(dynamicCost) {
-				return nil, ErrOutOfGas
-			}
-		}
-		if memorySize > 0 {
-			mem.Resize(memorySize)
-		}
-
-		if in.cfg.Debug {
-			in.cfg.Tracer.CaptureState(in.evm, pc, op, gasCopy, cost, callContext, in.returnData, in.evm.Depth, err)
-			logged = true
-		}
-
-		// execute the operation
-		var (
-			start           time.Time
-			instructionTime time.Duration
-		)
-
-		if op == CREATE {
-			start = time.Now()
-			res, instructionTime, err = opTimedCreate(&pc, in, callContext)
-			elapsed := time.Since(start)
-			opCodeDuration[op] += elapsed - instructionTime
-		} else if op == CREATE2 {
-			start = time.Now()
-			res, instructionTime, err = opTimedCreate2(&pc, in, callContext)
-			elapsed := time.Since(start)
-			opCodeDuration[op] += elapsed - instructionTime
-		} else if op == CALL {
-			start = time.Now()
-			res, instructionTime, err = opTimedCall(&pc, in, callContext)
-			elapsed := time.Since(start)
-			opCodeDuration[op] += elapsed - instructionTime
-		} else if op == CALLCODE {
-			start = time.Now()
-			res, instructionTime, err = opTimedCallCode(&pc, in, callContext)
-			elapsed := time.Since(start)
-			opCodeDuration[op] += elapsed - instructionTime
-		} else if op == DELEGATECALL {
-			start = time.Now()
-			res, instructionTime, err = opTimedDelegateCall(&pc, in, callContext)
-			elapsed := time.Since(start)
-			opCodeDuration[op] += elapsed - instructionTime
-		} else if op == STATICCALL {
-			start = time.Now()
-			res, instructionTime, err = opTimedStaticCall(&pc, in, callContext)
-			elapsed := time.Since(start)
-			opCodeDuration[op] += elapsed - instructionTime
-		} else {
-			start = time.Now()
-			res, err = operation.execute(&pc, in, callContext)
-			elapsed := time.Since(start)
-			opCodeDuration[op] += elapsed
-		}
-
-		// if the operation clears the return data (e.g. it has returning data)
-		// set the last return to the result of the operation.
-		if operation.returns {
-			in.returnData = res
-		}
-
-		switch {
-		case err != nil:
-			return nil, err
-		case operation.reverts:
-			return res, ErrExecutionReverted
-		case operation.halts:
-			return res, nil
-		case !operation.jumps:
-			pc++
-		}
-	}
-	return nil, nil
-}
-
-func (in *GethEVMInterpreter) runBasicBlockProfiling(state *InterpreterState, input []byte, readOnly bool) (ret []byte, err error) {
-	defer func() {
-		state.finished = true
 		if state.done != nil {
+			state.finished = true
 			close(state.done)
 		}
-	}()
-	// Increment the call depth which is restricted to 1024
-	in.evm.Depth++
-	defer func() { in.evm.Depth-- }()
-
-	// Make sure the readOnly is only set if we aren't in readOnly yet.
-	// This also makes sure that the readOnly flag isn't removed for child calls.
-	if readOnly && !in.readOnly {
-		in.readOnly = true
-		defer func() { in.readOnly = false }()
-	}
-
-	// Reset the previous call's return data. It's unimportant to preserve the old buffer
-	// as every returning call will return new data anyway.
-	in.returnData = nil
-
-	// Don't bother with the execution if there's no code.
-	if len(state.Contract.Code) == 0 {
 		return nil, nil
 	}
 
@@ -520,384 +275,108 @@ func (in *GethEVMInterpreter) runBasicBlockProfiling(state *InterpreterState, in
 		// to be uint256. Practically much less so feasible.
 		pc   = uint64(0) // program counter
 		cost uint64
-		// copies used by tracer
-		pcCopy  uint64 // needed for the deferred Tracer
-		gasCopy uint64 // for Tracer to log gas remaining before execution
-		logged  bool   // deferred Tracer should ignore already logged steps
-		res     []byte // result of the opcode execution function
-		basicBlockFrequency = map[uint]BasicBlock{}    // basic block map that translates an address to a basic block
-
+		res  []byte // result of the opcode execution function
 	)
 	// Don't move this deferrred function, it's placed before the capturestate-deferred method,
 	// so that it get's executed _after_: the capturestate needs the stacks before
 	// they are returned to the pools
 	contract.Input = input
 
-	if in.cfg.Debug {
-		defer func() {
-			if err != nil {
-				if !logged {
-					in.cfg.Tracer.CaptureState(in.evm, pcCopy, op, gasCopy, cost, callContext, in.returnData, in.evm.Depth, err)
-				} else {
-					in.cfg.Tracer.CaptureFault(in.evm, pcCopy, op, gasCopy, cost, callContext, in.evm.Depth, err)
-				}
-			}
-		}()
+	hooks := in.newInterpreterHooks(state, callContext)
+	if hooks != nil {
+		defer func() { hooks.exit(op, cost, err) }()
 	}
+	resumed := in.resume(&pc, callContext)
 	// The Interpreter main run loop (contextual). This loop runs until either an
 	// explicit STOP, RETURN or SELFDESTRUCT is executed, an error occurred during
 	// the execution of one of the operations or until the done flag is set by the
 	// parent context.
 	steps := 0
-
-	defer func() {
-		// process basic block frequencies
-		bbpd := BasicBlockProfileData{
-			Contract: *contract.CodeAddr,
-			BasicBlockFrequency:  basicBlockFrequency}
-		ProcessBasicBlockProfileData(&bbpd)
-	}()
-
 	for {
-		// Block until next step should be processed.
-		if state.next != nil {
-			state.pc = pc
-			// Signal completion of previous step.
-			if steps != 0 {
-				state.done <- 0
-			}
-			// Wait for processing of next step
-			_, open := <-state.next
-			if !open {
-				return
-			}
-		}
 		steps++
 		if steps%1000 == 0 && atomic.LoadInt32(&in.evm.abort) != 0 {
 			break
 		}
-		if in.cfg.Debug {
-			// Capture pre-execution values for tracing.
-			logged, pcCopy, gasCopy = false, pc, contract.Gas
-		}
-
 		// Get the operation from the jump table and validate the stack to ensure there are
 		// enough stack items available to perform the operation.
 		op = contract.GetOp(pc)
+		if hooks != nil && !hooks.fetch(pc, op) {
+			return nil, nil
+		}
 		operation := in.cfg.JumpTable[op]
 		if operation == nil {
 			return nil, &ErrInvalidOpCode{opcode: op}
 		}
-		// Validate stack
-		if sLen := stack.len(); sLen < operation.minStack {
-			return nil, &ErrStackUnderflow{stackLen: sLen, required: operation.minStack}
-		} else if sLen > operation.maxStack {
-			return nil, &ErrStackOverflow{stackLen: sLen, limit: operation.maxStack}
-		}
-		// If the operation is valid, enforce write restrictions
-		if in.readOnly && in.evm.chainRules.IsByzantium {
-			// If the interpreter is operating in readonly mode, make sure no
-			// state-modifying operation is performed. The 3rd stack item
-			// for a call operation is the value. Transferring value from one
-			// account to the others means the state is modified and should also
-			// return with an error.
-			if operation.writes || (op == CALL && stack.Back(2).Sign() != 0) {
-				return nil, ErrWriteProtection
-			}
-		}
-		// Static portion of gas
-		cost = operation.constantGas // For tracing
-		if !contract.UseGas(operation.constantGas) {
-			return nil, ErrOutOfGas
-		}
-
-		var memorySize uint64
-		// calculate the new memory size and expand the memory to fit
-		// the operation
-		// Memory check needs to be done prior to evaluating the dynamic gas portion,
-		// to detect calculation overflows
-		if operation.memorySize != nil {
-			memSize, overflow := operation.memorySize(stack)
-			if overflow {
-				return nil, ErrGasUintOverflow
+		if resumed != nil {
+			// The instruction was validated and charged before the snapshot
+			// of the frame was taken.
+			cost = resumed.Cost
+			contract.Gas -= cost
+			resumed = nil
+		} else {
+			// Validate stack
+			if sLen := stack.len(); sLen < operation.minStack {
+				return nil, &ErrStackUnderflow{stackLen: sLen, required: operation.minStack}
+			} else if sLen > operation.maxStack {
+				return nil, &ErrStackOverflow{stackLen: sLen, limit: operation.maxStack}
 			}
-			// memory is expanded in words of 32 bytes. Gas
-			// is also calculated in words.
-			if memorySize, overflow = math.SafeMul(toWordSize(memSize), 32); overflow {
-				return nil, ErrGasUintOverflow
+			// If the operation is valid, enforce write restrictions
+			if in.readOnly && in.evm.chainRules.IsByzantium {
+				// If the interpreter is operating in readonly mode, make sure no
+				// state-modifying operation is performed. The 3rd stack item
+				// for a call operation is the value. Transferring value from one
+				// account to the others means the state is modified and should also
+				// return with an error.
+				if operation.writes || (op == CALL && stack.Back(2).Sign() != 0) {
+					return nil, ErrWriteProtection
+				}
 			}
-		}
-		// Dynamic portion of gas
-		// consume the gas and return an error if not enough gas is available.
-		// cost is explicitly set so that the capture state defer method can get the proper cost
-		if operation.dynamicGas != nil {
-			var dynamicCost uint64
-			dynamicCost, err = operation.dynamicGas(in.evm, contract, stack, mem, memorySize)
-			cost += dynamicCost // total cost, for debug tracing
-			if err != nil || !contract.UseGas(dynamicCost) {
+			// Static portion of gas
+			cost = operation.constantGas // For tracing
+			if !contract.UseGas(operation.constantGas) {
 				return nil, ErrOutOfGas
 			}
-		}
-		if memorySize > 0 {
-			mem.Resize(memorySize)
-		}
-
-		if in.cfg.Debug {
-			in.cfg.Tracer.CaptureState(in.evm, pc, op, gasCopy, cost, callContext, in.returnData, in.evm.Depth, err)
-			logged = true
-		}
 
-		if op == JUMPDEST {
-			if _, ok := basicBlockFrequency[uint(pc)]; !ok {
-				// basic block not found in frequency map
-				// => create new one
-				idx := pc
-				instructions := []byte{}
-				length := uint64(len(contract.Code))
-				for {
-
-					// exceed code size
-					if idx >= length {
-						break
-					}
-
-					// fetch op-code
-					op := contract.GetOp(idx)
-					instructions = append(instructions, byte(op))
-
-					// end of basic block?
-					if op == JUMP ||
-						op == JUMPI ||
-						op == STOP ||
-						op == RETURN ||
-						op == REVERT ||
-						op == SELFDESTRUCT {
-						break
-					}
-
-					// skip constant of a push operation
-					if op >= PUSH1 && op <= PUSH32 {
-						numbits := op - PUSH1 + 1
-						if numbits >= 8 {
-							for ; numbits >= 16; numbits -= 16 {
-								idx += 16
-							}
-							for ; numbits >= 8; numbits -= 8 {
-								idx += 8
-							}
-						}
-						switch numbits {
-						case 1:
-							idx += 1
-						case 2:
-							idx += 2
-						case 3:
-							idx += 3
-						case 4:
-							idx += 4
-						case 5:
-							idx += 5
-						case 6:
-							idx += 6
-						case 7:
-							idx += 7
-						}
-
-					}
-
-					// skip to next instruction
-					idx++
+			var memorySize uint64
+			// calculate the new memory size and expand the memory to fit
+			// the operation
+			// Memory check needs to be done prior to evaluating the dynamic gas portion,
+			// to detect calculation overflows
+			if operation.memorySize != nil {
+				memSize, overflow := operation.memorySize(stack)
+				if overflow {
+					return nil, ErrGasUintOverflow
 				}
-				basicBlockFrequency[uint(pc)] = BasicBlock{Instructions: instructions, Frequency: 1}
-			} else {
-				bb := basicBlockFrequency[uint(pc)]
-				bb.Frequency++
-				basicBlockFrequency[uint(pc)] = bb
-			}
-		}
-		res, err = operation.execute(&pc, in, callContext)
-
-		// if the operation clears the return data (e.g. it has returning data)
-		// set the last return to the result of the operation.
-		if operation.returns {
-			in.returnData = res
-		}
-
-		switch {
-		case err != nil:
-			return nil, err
-		case operation.reverts:
-			return res, ErrExecutionReverted
-		case operation.halts:
-			return res, nil
-		case !operation.jumps:
-			pc++
-		}
-	}
-	return nil, nil
-}
-
-func (in *GethEVMInterpreter) runPlain(state *InterpreterState, input []byte, readOnly bool) (ret []byte, err error) {
-	defer func() {
-		state.finished = true
-		if state.done != nil {
-			close(state.done)
-		}
-	}()
-	// Increment the call depth which is restricted to 1024
-	in.evm.Depth++
-	defer func() { in.evm.Depth-- }()
-
-	// Make sure the readOnly is only set if we aren't in readOnly yet.
-	// This also makes sure that the readOnly flag isn't removed for child calls.
-	if readOnly && !in.readOnly {
-		in.readOnly = true
-		defer func() { in.readOnly = false }()
-	}
-
-	// Reset the previous call's return data. It's unimportant to preserve the old buffer
-	// as every returning call will return new data anyway.
-	in.returnData = nil
-
-	// Don't bother with the execution if there's no code.
-	if len(state.Contract.Code) == 0 {
-		return nil, nil
-	}
-
-	var (
-		contract    = state.Contract // processed contract
-		op          OpCode           // current opcode
-		mem         = state.Memory   // bound memory
-		stack       = state.Stack    // local stack
-		callContext = &ScopeContext{
-			Memory:   mem,
-			Stack:    stack,
-			Contract: contract,
-		}
-		// For optimisation reason we're using uint64 as the program counter.
-		// It's theoretically possible to go above 2^64. The YP defines the PC
-		// to be uint256. Practically much less so feasible.
-		pc   = uint64(0) // program counter
-		cost uint64
-		// copies used by tracer
-		pcCopy  uint64 // needed for the deferred Tracer
-		gasCopy uint64 // for Tracer to log gas remaining before execution
-		logged  bool   // deferred Tracer should ignore already logged steps
-		res     []byte // result of the opcode execution function
-
-	)
-	// Don't move this deferrred function, it's placed before the capturestate-deferred method,
-	// so that it get's executed _after_: the capturestate needs the stacks before
-	// they are returned to the pools
-	contract.Input = input
-
-	if in.cfg.Debug {
-		defer func() {
-			if err != nil {
-				if !logged {
-					in.cfg.Tracer.CaptureState(in.evm, pcCopy, op, gasCopy, cost, callContext, in.returnData, in.evm.Depth, err)
-				} else {
-					in.cfg.Tracer.CaptureFault(in.evm, pcCopy, op, gasCopy, cost, callContext, in.evm.Depth, err)
+				// memory is expanded in words of 32 bytes. Gas
+				// is also calculated in words.
+				if memorySize, overflow = math.SafeMul(toWordSize(memSize), 32); overflow {
+					return nil, ErrGasUintOverflow
 				}
 			}
-		}()
-	}
-	// The Interpreter main run loop (contextual). This loop runs until either an
-	// explicit STOP, RETURN or SELFDESTRUCT is executed, an error occurred during
-	// the execution of one of the operations or until the done flag is set by the
-	// parent context.
-	steps := 0
-
-	for {
-		// Block until next step should be processed.
-		if state.next != nil {
-			state.pc = pc
-			// Signal completion of previous step.
-			if steps != 0 {
-				state.done <- 0
-			}
-			// Wait for processing of next step
-			_, open := <-state.next
-			if !open {
-				return
-			}
-		}
-		steps++
-		if steps%1000 == 0 && atomic.LoadInt32(&in.evm.abort) != 0 {
-			break
-		}
-		if in.cfg.Debug {
-			// Capture pre-execution values for tracing.
-			logged, pcCopy, gasCopy = false, pc, contract.Gas
-		}
-
-		// Get the operation from the jump table and validate the stack to ensure there are
-		// enough stack items available to perform the operation.
-		op = contract.GetOp(pc)
-		operation := in.cfg.JumpTable[op]
-		if operation == nil {
-			return nil, &ErrInvalidOpCode{opcode: op}
-		}
-		// Validate stack
-		if sLen := stack.len(); sLen < operation.minStack {
-			return nil, &ErrStackUnderflow{stackLen: sLen, required: operation.minStack}
-		} else if sLen > operation.maxStack {
-			return nil, &ErrStackOverflow{stackLen: sLen, limit: operation.maxStack}
-		}
-		// If the operation is valid, enforce write restrictions
-		if in.readOnly && in.evm.chainRules.IsByzantium {
-			// If the interpreter is operating in readonly mode, make sure no
-			// state-modifying operation is performed. The 3rd stack item
-			// for a call operation is the value. Transferring value from one
-			// account to the others means the state is modified and should also
-			// return with an error.
-			if operation.writes || (op == CALL && stack.Back(2).Sign() != 0) {
-				return nil, ErrWriteProtection
-			}
-		}
-		// Static portion of gas
-		cost = operation.constantGas // For tracing
-		if !contract.UseGas(operation.constantGas) {
-			return nil, ErrOutOfGas
-		}
-
-		var memorySize uint64
-		// calculate the new memory size and expand the memory to fit
-		// the operation
-		// Memory check needs to be done prior to evaluating the dynamic gas portion,
-		// to detect calculation overflows
-		if operation.memorySize != nil {
-			memSize, overflow := operation.memorySize(stack)
-			if overflow {
-				return nil, ErrGasUintOverflow
-			}
-			// memory is expanded in words of 32 bytes. Gas
-			// is also calculated in words.
-			if memorySize, overflow = math.SafeMul(toWordSize(memSize), 32); overflow {
-				return nil, ErrGasUintOverflow
+			// Dynamic portion of gas
+			// consume the gas and return an error if not enough gas is available.
+			// cost is explicitly set so that the capture state defer method can get the proper cost
+			if operation.dynamicGas != nil {
+				var dynamicCost uint64
+				dynamicCost, err = operation.dynamicGas(in.evm, contract, stack, mem, memorySize)
+				cost += dynamicCost // total cost, for debug tracing
+				if err != nil || !contract.UseGas(dynamicCost) {
+					return nil, ErrOutOfGas
+				}
 			}
-		}
-		// Dynamic portion of gas
-		// consume the gas and return an error if not enough gas is available.
-		// cost is explicitly set so that the capture state defer method can get the proper cost
-		if operation.dynamicGas != nil {
-			var dynamicCost uint64
-			dynamicCost, err = operation.dynamicGas(in.evm, contract, stack, mem, memorySize)
-			cost += dynamicCost // total cost, for debug tracing
-			if err != nil || !contract.UseGas(dynamicCost) {
-				return nil, ErrOutOfGas
+			if memorySize > 0 {
+				mem.Resize(memorySize)
 			}
 		}
-		if memorySize > 0 {
-			mem.Resize(memorySize)
-		}
 
-		if in.cfg.Debug {
-			in.cfg.Tracer.CaptureState(in.evm, pc, op, gasCopy, cost, callContext, in.returnData, in.evm.Depth, err)
-			logged = true
+		if hooks != nil {
+			hooks.preOp(pc, op, cost)
 		}
-
+		// execute the operation
 		res, err = operation.execute(&pc, in, callContext)
+		if hooks != nil {
+			hooks.postOp(op)
+		}
 
 		// if the operation clears the return data (e.g. it has returning data)
 		// set the last return to the result of the operation.
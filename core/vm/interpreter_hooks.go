@@ -0,0 +1,182 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+// interpreterHooks instruments a single run of the interpreter loop. The loop
+// only invokes hooks if any instrumentation is configured, so the plain path
+// pays one nil check per hook site and is otherwise unchanged. Block entries
+// are detected by blockEntryHooks, outside of the loop. Profilers, the
+// stepping API and tracers are implemented as hooks and share the loop.
+type interpreterHooks interface {
+	// fetch is called before the instruction at pc is validated and its gas
+	// is charged. Returning false ends the run without an error.
+	fetch(pc uint64, op OpCode) bool
+	// preOp is called right before the instruction at pc is executed. The
+	// instruction's gas, cost, is charged and the memory is expanded.
+	preOp(pc uint64, op OpCode, cost uint64)
+	// blockEntry is called after preOp if the instruction starts a basic
+	// block, i.e. it is the first instruction, a JUMPDEST or follows a JUMPI.
+	blockEntry(pc uint64)
+	// postOp is called after the instruction was executed.
+	postOp(op OpCode)
+	// exit is called when the run ends. op and cost describe the last
+	// instruction and err is the error of the run.
+	exit(op OpCode, cost uint64, err error)
+}
+
+// multiHooks invokes several hooks in order.
+type multiHooks []interpreterHooks
+
+func (h multiHooks) fetch(pc uint64, op OpCode) bool {
+	for _, hooks := range h {
+		if !hooks.fetch(pc, op) {
+			return false
+		}
+	}
+	return true
+}
+
+func (h multiHooks) preOp(pc uint64, op OpCode, cost uint64) {
+	for _, hooks := range h {
+		hooks.preOp(pc, op, cost)
+	}
+}
+
+func (h multiHooks) blockEntry(pc uint64) {
+	for _, hooks := range h {
+		hooks.blockEntry(pc)
+	}
+}
+
+func (h multiHooks) postOp(op OpCode) {
+	for _, hooks := range h {
+		hooks.postOp(op)
+	}
+}
+
+func (h multiHooks) exit(op OpCode, cost uint64, err error) {
+	for i := len(h) - 1; i >= 0; i-- {
+		h[i].exit(op, cost, err)
+	}
+}
+
+// newInterpreterHooks returns the hooks instrumenting a run of the given
+// interpreter state, or nil if the run is not instrumented.
+func (in *GethEVMInterpreter) newInterpreterHooks(state *InterpreterState, scope *ScopeContext) interpreterHooks {
+	var hooks multiHooks
+	if state.step != nil {
+		hooks = append(hooks, state.step)
+	}
+	if in.cfg.Debug {
+		hooks = append(hooks, &tracerHooks{in: in, scope: scope})
+	}
+	if in.cfg.MicroProfiler != nil {
+		hooks = append(hooks, newMicroProfileHooks(in, scope))
+	}
+	if in.cfg.BasicBlockProfiler != nil {
+		hooks = append(hooks, newBasicBlockProfileHooks(in, scope.Contract))
+	}
+	switch len(hooks) {
+	case 0:
+		return nil
+	case 1:
+		return &blockEntryHooks{interpreterHooks: hooks[0]}
+	}
+	return &blockEntryHooks{interpreterHooks: hooks}
+}
+
+// blockEntryHooks reports the entries of basic blocks to the wrapped hooks.
+type blockEntryHooks struct {
+	interpreterHooks
+	prevOp OpCode // previously executed opcode
+}
+
+func (h *blockEntryHooks) preOp(pc uint64, op OpCode, cost uint64) {
+	h.interpreterHooks.preOp(pc, op, cost)
+	if pc == 0 || op == JUMPDEST || h.prevOp == JUMPI {
+		h.interpreterHooks.blockEntry(pc)
+	}
+	h.prevOp = op
+}
+
+// stepHooks suspends the run before every instruction until the next step
+// of the interpreter state is requested.
+type stepHooks struct {
+	state *InterpreterState
+	steps int
+}
+
+func (h *stepHooks) fetch(pc uint64, op OpCode) bool {
+	h.state.pc = pc
+	// Signal completion of previous step.
+	if h.steps != 0 {
+		h.state.done <- 0
+	}
+	h.steps++
+	// Wait for processing of next step
+	_, open := <-h.state.next
+	return open
+}
+
+func (h *stepHooks) preOp(pc uint64, op OpCode, cost uint64) {}
+
+func (h *stepHooks) blockEntry(pc uint64) {}
+
+func (h *stepHooks) postOp(op OpCode) {}
+
+func (h *stepHooks) exit(op OpCode, cost uint64, err error) {
+	h.state.finished = true
+	close(h.state.done)
+}
+
+// tracerHooks reports every instruction and fault to the configured tracer.
+type tracerHooks struct {
+	in    *GethEVMInterpreter
+	scope *ScopeContext
+
+	pc     uint64 // pc of the current instruction
+	gas    uint64 // gas remaining before the current instruction
+	logged bool   // the current instruction was reported by preOp
+}
+
+func (h *tracerHooks) fetch(pc uint64, op OpCode) bool {
+	// Capture pre-execution values for tracing.
+	h.logged, h.pc, h.gas = false, pc, h.scope.Contract.Gas
+	return true
+}
+
+func (h *tracerHooks) preOp(pc uint64, op OpCode, cost uint64) {
+	in := h.in
+	in.cfg.Tracer.CaptureState(in.evm, pc, op, h.gas, cost, h.scope, in.returnData, in.evm.Depth, nil)
+	h.logged = true
+}
+
+func (h *tracerHooks) blockEntry(pc uint64) {}
+
+func (h *tracerHooks) postOp(op OpCode) {}
+
+func (h *tracerHooks) exit(op OpCode, cost uint64, err error) {
+	if err == nil {
+		return
+	}
+	in := h.in
+	if !h.logged {
+		in.cfg.Tracer.CaptureState(in.evm, h.pc, op, h.gas, cost, h.scope, in.returnData, in.evm.Depth, err)
+	} else {
+		in.cfg.Tracer.CaptureFault(in.evm, h.pc, op, h.gas, cost, h.scope, in.evm.Depth, err)
+	}
+}
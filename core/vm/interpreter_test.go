@@ -0,0 +1,136 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+var loopAddress = common.HexToAddress("0x1001")
+
+// loopCode returns a contract storing a counter in memory while counting it
+// down from n to zero.
+func loopCode(n uint16) []byte {
+	return []byte{
+		byte(PUSH2), byte(n >> 8), byte(n), // 0
+		byte(JUMPDEST),                           // 3
+		byte(DUP1), byte(PUSH1), 0, byte(MSTORE), // 4
+		byte(PUSH1), 1, byte(SWAP1), byte(SUB), // 8
+		byte(DUP1), byte(PUSH1), 3, byte(JUMPI), // 12
+		byte(STOP), // 16
+	}
+}
+
+// newLoopEVM returns an EVM whose state contains the loop contract.
+func newLoopEVM(n uint16, cfg Config) *EVM {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedb.SetCode(loopAddress, loopCode(n))
+	blockCtx := BlockContext{
+		CanTransfer: func(StateDB, common.Address, *big.Int) bool { return true },
+		Transfer:    func(StateDB, common.Address, common.Address, *big.Int) {},
+		BlockNumber: big.NewInt(0),
+	}
+	return NewEVM(blockCtx, TxContext{}, statedb, params.TestChainConfig, cfg)
+}
+
+func TestInterpreterStepping(t *testing.T) {
+	evm := newLoopEVM(3, Config{})
+	code := loopCode(3)
+	contract := NewContract(AccountRef(common.Address{}), AccountRef(loopAddress), new(big.Int), 100000)
+	contract.SetCallCode(&loopAddress, evm.StateDB.GetCodeHash(loopAddress), code)
+
+	state := evm.interpreter.(*GethEVMInterpreter).Start(contract, nil, false)
+	var ops []OpCode
+	for !state.IsDone() {
+		ops = append(ops, state.GetCurrentOpCode())
+		state.Step()
+	}
+	// the counter is initialised, the loop body runs three times and stops
+	if len(ops) != 2+3*10 {
+		t.Fatalf("step count mismatch: have %d, want %d", len(ops), 2+3*10)
+	}
+	if ops[0] != PUSH2 || ops[1] != JUMPDEST || ops[len(ops)-1] != STOP {
+		t.Errorf("unexpected opcodes %v", ops)
+	}
+	// the counter is stored before it is decremented
+	if have := new(big.Int).SetBytes(state.Memory.GetCopy(0, 32)); have.Cmp(big.NewInt(1)) != 0 {
+		t.Errorf("counter mismatch: have %v, want 1", have)
+	}
+}
+
+func TestInterpreterProfilers(t *testing.T) {
+	mp := NewMicroProfiler(16)
+	bbp := NewBasicBlockProfiler(16)
+	evm := newLoopEVM(5, Config{MicroProfiler: mp, BasicBlockProfiler: bbp})
+	if _, _, err := evm.Call(AccountRef(common.Address{}), loopAddress, nil, 100000, new(big.Int)); err != nil {
+		t.Fatal(err)
+	}
+	mps, bbps := mp.Close(), bbp.Close()
+	for op, want := range map[OpCode]uint64{PUSH2: 1, JUMPDEST: 5, MSTORE: 5, JUMPI: 5, STOP: 1} {
+		if have := mps.opCodeFrequency[op]; have != want {
+			t.Errorf("%v frequency mismatch: have %d, want %d", op, have, want)
+		}
+	}
+	blocks := make(map[uint]uint64)
+	for key, freq := range bbps.basicBlockFrequency {
+		blocks[key.Address] += freq
+	}
+	for addr, want := range map[uint]uint64{0: 1, 3: 5, 16: 1} {
+		if have := blocks[addr]; have != want {
+			t.Errorf("block %d frequency mismatch: have %d, want %d", addr, have, want)
+		}
+	}
+}
+
+func benchmarkInterpreter(b *testing.B, cfg Config) {
+	evm := newLoopEVM(1000, cfg)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := evm.Call(AccountRef(common.Address{}), loopAddress, nil, 10000000, new(big.Int)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkInterpreterPlain measures the loop without any instrumentation,
+// the path taken by block processing.
+func BenchmarkInterpreterPlain(b *testing.B) {
+	benchmarkInterpreter(b, Config{})
+}
+
+func BenchmarkInterpreterTracer(b *testing.B) {
+	tracer := NewStructLogger(&LogConfig{DisableMemory: true, DisableStack: true, DisableStorage: true, Limit: 1})
+	benchmarkInterpreter(b, Config{Debug: true, Tracer: tracer})
+}
+
+func BenchmarkInterpreterMicroProfiler(b *testing.B) {
+	mp := NewMicroProfiler(1024)
+	defer mp.Close()
+	benchmarkInterpreter(b, Config{MicroProfiler: mp})
+}
+
+func BenchmarkInterpreterBasicBlockProfiler(b *testing.B) {
+	bbp := NewBasicBlockProfiler(1024)
+	defer bbp.Close()
+	benchmarkInterpreter(b, Config{BasicBlockProfiler: bbp})
+}
@@ -112,6 +112,13 @@ type Tracer interface {
 	CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error)
 }
 
+// TxStartTracer is implemented by tracers which need the gas limit of the
+// traced transaction. CaptureTxStart is called by the state transition once
+// the gas is bought, before CaptureStart.
+type TxStartTracer interface {
+	CaptureTxStart(gasLimit uint64)
+}
+
 // StructLogger is an EVM state logger and implements Tracer.
 //
 // StructLogger can capture state based on the given Log configuration and also keeps
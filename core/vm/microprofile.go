@@ -17,11 +17,12 @@
 package vm
 
 import (
-	"context"
-	"database/sql"
-	_ "github.com/mattn/go-sqlite3"
-	"log"
+	"fmt"
+	"strconv"
+	"sync"
 	"time"
+
+	"github.com/ethereum/go-ethereum/common"
 )
 
 // Micro-Profiling data record for a single smart contract invocation
@@ -30,27 +31,43 @@ type MicroProfileData struct {
 	OpCodeDuration       map[OpCode]time.Duration // opcode durations stats
 	InstructionFrequency map[uint64]uint64        // instruction frequency stats
 	StepLength           int                      // number of executed instructions
+	Contract             common.Address           // address of the executed code
+	OpCodeGas            map[OpCode]OpCodeGas     // opcode gas stats
+	NGramFrequency       map[OpCodeNGram]uint64   // opcode sequence frequency stats
 }
 
-// Micro-profiling statistic
-type MicroProfileStatistic struct {
-	opCodeFrequency      map[OpCode]uint64 // opcode frequency statistics
-	opCodeDuration       map[OpCode]uint64 // accumulated duration of opcodes
-	instructionFrequency map[uint64]uint64 // instruction frequency statistics
-	stepLengthFrequency  map[int]uint64    // smart contract length frequency
+// Gas charged for an opcode, split by its origin
+type OpCodeGas struct {
+	Constant       uint64 // constant gas of the jump table
+	Memory         uint64 // memory expansion gas
+	Dynamic        uint64 // remaining dynamic gas, excluding gas forwarded to callees
+	Refund         uint64 // gas added to the refund counter
+	RefundReverted uint64 // gas removed from the refund counter
 }
 
-// Micro profiling flag controlled by cli
-var MicroProfiling bool
-
-// Buffer size for micro-profiling channel
-var MicroProfilingBufferSize int
+// Opcode executed by a contract
+type ContractOpCode struct {
+	Contract common.Address
+	OpCode   OpCode
+}
 
-// Name of micro-profiling SQLITE3 database
-var MicroProfilingDB string
+// Micro-profiling statistic of an opcode executed by a contract
+type ContractOpCodeStatistic struct {
+	Frequency uint64    // opcode frequency
+	Duration  uint64    // accumulated duration
+	Gas       OpCodeGas // accumulated gas
+}
 
-// Micro-Profiling channel
-var mpChannel chan *MicroProfileData = make(chan *MicroProfileData, MicroProfilingBufferSize)
+// Micro-profiling statistic
+type MicroProfileStatistic struct {
+	opCodeFrequency      map[OpCode]uint64                          // opcode frequency statistics
+	opCodeDuration       map[OpCode]uint64                          // accumulated duration of opcodes
+	instructionFrequency map[uint64]uint64                          // instruction frequency statistics
+	stepLengthFrequency  map[int]uint64                             // smart contract length frequency
+	opCodeGas            map[OpCode]OpCodeGas                       // accumulated gas of opcodes
+	contractOpCode       map[ContractOpCode]ContractOpCodeStatistic // per-contract opcode statistics
+	nGramFrequency       map[OpCodeNGram]uint64                     // opcode sequence frequency
+}
 
 // Create new micro-profiling statistic
 func NewMicroProfileStatistic() *MicroProfileStatistic {
@@ -59,50 +76,236 @@ func NewMicroProfileStatistic() *MicroProfileStatistic {
 	p.opCodeDuration = make(map[OpCode]uint64)
 	p.instructionFrequency = make(map[uint64]uint64)
 	p.stepLengthFrequency = make(map[int]uint64)
+	p.opCodeGas = make(map[OpCode]OpCodeGas)
+	p.contractOpCode = make(map[ContractOpCode]ContractOpCodeStatistic)
+	p.nGramFrequency = make(map[OpCodeNGram]uint64)
+	return p
+}
+
+// add accumulates the gas of another record.
+func (g *OpCodeGas) add(src OpCodeGas) {
+	g.Constant += src.Constant
+	g.Memory += src.Memory
+	g.Dynamic += src.Dynamic
+	g.Refund += src.Refund
+	g.RefundReverted += src.RefundReverted
+}
+
+// attributeDynamic splits the dynamic gas of an opcode into memory expansion
+// gas and the remaining dynamic gas. The gas which call operations forward to
+// their callee is charged by the callee's opcodes and is therefore excluded.
+func (g *OpCodeGas) attributeDynamic(op OpCode, dynamicCost, memoryCost, callGas uint64) {
+	if memoryCost > dynamicCost {
+		memoryCost = dynamicCost
+	}
+	g.Memory += memoryCost
+	dynamicCost -= memoryCost
+	switch op {
+	case CALL, CALLCODE, DELEGATECALL, STATICCALL:
+		if callGas > dynamicCost {
+			callGas = dynamicCost
+		}
+		dynamicCost -= callGas
+	}
+	g.Dynamic += dynamicCost
+}
+
+// attributeRefund records the change of the refund counter.
+func (g *OpCodeGas) attributeRefund(before, after uint64) {
+	if after >= before {
+		g.Refund += after - before
+	} else {
+		g.RefundReverted += before - after
+	}
+}
+
+// profiledContract returns the address of the code executed by contract.
+func profiledContract(contract *Contract) common.Address {
+	if contract.CodeAddr != nil {
+		return *contract.CodeAddr
+	}
+	return contract.Address()
+}
+
+// MicroProfiler collects the micro-profiling data of all interpreters
+// configured with it. Records are passed through a buffered channel to a
+// background collector, so concurrent EVM instances (e.g. parallel replay
+// workers) may share a profiler or use one profiler each.
+type MicroProfiler struct {
+	records   chan *MicroProfileData // queue of unprocessed records
+	stats     *MicroProfileStatistic // accumulated statistic
+	done      chan struct{}          // closed when the collector terminated
+	closeOnce sync.Once
+
+	maxNGramLength int // maximal length of profiled opcode sequences
+}
+
+// Create new micro-profiler and start its collector. The buffer size is the
+// number of records which can be queued before interpreters block.
+func NewMicroProfiler(bufferSize int) *MicroProfiler {
+	p := &MicroProfiler{
+		records: make(chan *MicroProfileData, bufferSize),
+		stats:   NewMicroProfileStatistic(),
+		done:    make(chan struct{}),
+	}
+	go p.collect()
 	return p
 }
 
-// The data collector checks for a stopping signal and processes
-// the workers' records via a channel. A data collector is a background task.
-func MicroProfilingCollector(ctx context.Context, done chan struct{}, mps *MicroProfileStatistic) {
-	defer close(done)
-	for {
-		select {
-
-		// receive a new data record from a worker?
-		case mpd := <- mpChannel:
-			// process the data record and update the statistic
-
-			// update op-code frequency
-			for opCode, freq := range mpd.OpCodeFrequency {
-				mps.opCodeFrequency[opCode] += freq
-			}
-
-			// update op-code duration
-			for opCode, duration := range mpd.OpCodeDuration {
-				mps.opCodeDuration[opCode] += uint64(duration)
-			}
-
-			// update instruction frequency
-			for instructions, freq := range mpd.InstructionFrequency {
-				mps.instructionFrequency[instructions] += freq
-			}
-
-			// step length frequency
-			mps.stepLengthFrequency[mpd.StepLength]++
-
-		// receive stop signal?
-		case <-ctx.Done():
-			if len(mpChannel) == 0 {
-				return
-			}
+// The data collector processes the interpreters' records until the profiler
+// is closed. A data collector is a background task.
+func (p *MicroProfiler) collect() {
+	defer close(p.done)
+	for mpd := range p.records {
+		// update op-code frequency
+		for opCode, freq := range mpd.OpCodeFrequency {
+			p.stats.opCodeFrequency[opCode] += freq
+		}
+
+		// update op-code duration
+		for opCode, duration := range mpd.OpCodeDuration {
+			p.stats.opCodeDuration[opCode] += uint64(duration)
+		}
+
+		// update instruction frequency
+		for instructions, freq := range mpd.InstructionFrequency {
+			p.stats.instructionFrequency[instructions] += freq
+		}
+
+		// step length frequency
+		p.stats.stepLengthFrequency[mpd.StepLength]++
+
+		// update op-code gas, overall and per contract
+		for opCode, gas := range mpd.OpCodeGas {
+			total := p.stats.opCodeGas[opCode]
+			total.add(gas)
+			p.stats.opCodeGas[opCode] = total
+		}
+		for opCode, freq := range mpd.OpCodeFrequency {
+			key := ContractOpCode{Contract: mpd.Contract, OpCode: opCode}
+			cs := p.stats.contractOpCode[key]
+			cs.Frequency += freq
+			cs.Duration += uint64(mpd.OpCodeDuration[opCode])
+			cs.Gas.add(mpd.OpCodeGas[opCode])
+			p.stats.contractOpCode[key] = cs
+		}
+
+		// update opcode sequence frequency
+		for g, freq := range mpd.NGramFrequency {
+			p.stats.nGramFrequency[g] += freq
 		}
 	}
 }
 
+// SetMaxNGramLength enables profiling of opcode sequences of length 2..length.
+// It must be called before the profiler is attached to an interpreter.
+func (p *MicroProfiler) SetMaxNGramLength(length int) error {
+	if length < 2 {
+		return fmt.Errorf("n-gram length %d below minimum of 2", length)
+	}
+	if length > MaxNGramLength {
+		return fmt.Errorf("n-gram length %d exceeds maximum of %d", length, MaxNGramLength)
+	}
+	p.maxNGramLength = length
+	return nil
+}
+
 // put micro profiling data into the processing queue
-func ProcessMicroProfileData(mpd *MicroProfileData) {
-	mpChannel <- mpd
+func (p *MicroProfiler) Process(mpd *MicroProfileData) {
+	p.records <- mpd
+}
+
+// microProfileHooks collects the micro-profiling data of a single run of the
+// interpreter loop. The duration of an instruction excludes the time spent
+// in the frames it called.
+type microProfileHooks struct {
+	in     *GethEVMInterpreter
+	scope  *ScopeContext
+	parent *microProfileHooks // hooks of the calling frame
+
+	data        MicroProfileData
+	pcFrequency map[uint64]uint64 // pc-counter frequency stats
+	nGrams      *nGramRecorder
+
+	memoryCost uint64        // memory gas charged before the current instruction
+	refund     uint64        // refund counter before the current instruction
+	start      time.Time     // start of the run
+	opStart    time.Time     // start of the current instruction
+	nested     time.Duration // time spent in callees of the current instruction
+}
+
+func newMicroProfileHooks(in *GethEVMInterpreter, scope *ScopeContext) *microProfileHooks {
+	h := &microProfileHooks{
+		in:     in,
+		scope:  scope,
+		parent: in.microProfile,
+		data: MicroProfileData{
+			OpCodeFrequency: make(map[OpCode]uint64),
+			OpCodeDuration:  make(map[OpCode]time.Duration),
+			Contract:        profiledContract(scope.Contract),
+			OpCodeGas:       make(map[OpCode]OpCodeGas),
+		},
+		pcFrequency: make(map[uint64]uint64),
+		nGrams:      newNGramRecorder(in.cfg.MicroProfiler.maxNGramLength),
+		start:       time.Now(),
+	}
+	in.microProfile = h
+	return h
+}
+
+func (h *microProfileHooks) fetch(pc uint64, op OpCode) bool {
+	h.data.StepLength++
+	h.data.OpCodeFrequency[op]++
+	h.pcFrequency[pc]++
+	if h.nGrams != nil {
+		h.nGrams.record(op)
+	}
+	h.memoryCost, h.refund = h.scope.Memory.lastGasCost, h.in.evm.StateDB.GetRefund()
+	return true
+}
+
+func (h *microProfileHooks) preOp(pc uint64, op OpCode, cost uint64) {
+	operation := h.in.cfg.JumpTable[op]
+	gas := h.data.OpCodeGas[op]
+	gas.Constant += operation.constantGas
+	if operation.dynamicGas != nil {
+		gas.attributeDynamic(op, cost-operation.constantGas, h.scope.Memory.lastGasCost-h.memoryCost, h.in.evm.callGasTemp)
+		gas.attributeRefund(h.refund, h.in.evm.StateDB.GetRefund())
+	}
+	h.data.OpCodeGas[op] = gas
+	h.nested = 0
+	h.opStart = time.Now()
+}
+
+func (h *microProfileHooks) blockEntry(pc uint64) {}
+
+func (h *microProfileHooks) postOp(op OpCode) {
+	h.data.OpCodeDuration[op] += time.Since(h.opStart) - h.nested
+}
+
+func (h *microProfileHooks) exit(op OpCode, cost uint64, err error) {
+	h.in.microProfile = h.parent
+	if h.parent != nil {
+		h.parent.nested += time.Since(h.start)
+	}
+	// compute frequency statistics for instructions
+	h.data.InstructionFrequency = make(map[uint64]uint64)
+	for _, ctr := range h.pcFrequency {
+		h.data.InstructionFrequency[ctr]++
+	}
+	if h.nGrams != nil {
+		h.data.NGramFrequency = h.nGrams.frequency
+	}
+	h.in.cfg.MicroProfiler.Process(&h.data)
+}
+
+// Close stops the profiler once all queued records have been processed and
+// returns the accumulated statistic. No interpreter may use the profiler
+// after it has been closed.
+func (p *MicroProfiler) Close() *MicroProfileStatistic {
+	p.closeOnce.Do(func() { close(p.records) })
+	<-p.done
+	return p.stats
 }
 
 // Merge two micro-profiling statistics
@@ -126,138 +329,125 @@ func (mps *MicroProfileStatistic) Merge(src *MicroProfileStatistic) {
 	for length, freq := range src.stepLengthFrequency {
 		mps.stepLengthFrequency[length] += freq
 	}
-}
 
-// dump opcode frequency stats into a SQLITE3 database
-func (mps *MicroProfileStatistic) dumpOpCodeFrequency(db *sql.DB) {
-	// drop old frequency table and create new one
-	_, err := db.Exec("DROP TABLE IF EXISTS OpCodeFrequency;CREATE TABLE OpCodeFrequency ( opcode TEXT NOT NULL, frequency INTEGER NOT NULL, PRIMARY KEY (opcode));")
-	if err != nil {
-		log.Fatalln(err.Error())
+	// update opcode gas
+	for opCode, gas := range src.opCodeGas {
+		total := mps.opCodeGas[opCode]
+		total.add(gas)
+		mps.opCodeGas[opCode] = total
 	}
 
-	// prepare an insert statement for faster inserts and insert frequencies
-	statement, err := db.Prepare("INSERT INTO OpCodeFrequency(opcode, frequency) VALUES (?, ?)")
-	if err != nil {
-		log.Fatalln(err.Error())
+	// update per-contract opcode statistics
+	for key, src := range src.contractOpCode {
+		cs := mps.contractOpCode[key]
+		cs.Frequency += src.Frequency
+		cs.Duration += src.Duration
+		cs.Gas.add(src.Gas)
+		mps.contractOpCode[key] = cs
 	}
-	for opCode, freq := range mps.opCodeFrequency {
-		_, err = statement.Exec(opCodeToString[opCode], freq)
-		if err != nil {
-			log.Fatalln(err.Error())
-		}
 
+	// update opcode sequence frequency
+	for g, freq := range src.nGramFrequency {
+		mps.nGramFrequency[g] += freq
 	}
 }
 
-// dump opcode duration statistic
-func (mps *MicroProfileStatistic) dumpOpCodeDuration(db *sql.DB) {
-	// drop old frequency table and create new one
-	_, err := db.Exec("DROP TABLE IF EXISTS OpCodeDuration;CREATE TABLE OpCodeDuration ( opcode TEXT NOT NULL, duration NUMERIC NOT NULL, PRIMARY KEY (opcode));")
-	if err != nil {
-		log.Fatalln(err.Error())
+// Tables returns the micro-profiling statistic as profiling tables.
+func (mps *MicroProfileStatistic) Tables(version string) []*ProfileTable {
+	opCodeFrequency := &ProfileTable{
+		Name:   "OpCodeFrequency",
+		Keys:   []ProfileColumn{{Name: "opcode", Type: ProfileText}},
+		Values: []string{"frequency"},
 	}
-
-	// prepare an insert statement for faster inserts and insert frequencies
-	statement, err := db.Prepare("INSERT INTO OpCodeDuration(opcode, duration) VALUES (?, ?)")
-	if err != nil {
-		log.Fatalln(err.Error())
+	for opCode, freq := range mps.opCodeFrequency {
+		opCodeFrequency.Rows = append(opCodeFrequency.Rows, ProfileRow{Keys: []string{opCode.String()}, Values: []uint64{freq}})
 	}
-	for opCode, duration := range mps.opCodeDuration {
-		_, err = statement.Exec(opCodeToString[opCode], duration)
-		if err != nil {
-			log.Fatalln(err.Error())
-		}
 
+	opCodeDuration := &ProfileTable{
+		Name:   "OpCodeDuration",
+		Keys:   []ProfileColumn{{Name: "opcode", Type: ProfileText}},
+		Values: []string{"duration"},
 	}
-}
-
-// dump instruction frequency statistic
-func (mps *MicroProfileStatistic) dumpInstructionFrequency(db *sql.DB) {
-	// drop old frequency table and create new one
-	_, err := db.Exec("DROP TABLE IF EXISTS InstructionFrequency;CREATE TABLE InstructionFrequency ( instructions INTEGER NOT NULL, frequency INTEGER NOT NULL, PRIMARY KEY (instructions));")
-	if err != nil {
-		log.Fatalln(err.Error())
+	for opCode, duration := range mps.opCodeDuration {
+		opCodeDuration.Rows = append(opCodeDuration.Rows, ProfileRow{Keys: []string{opCode.String()}, Values: []uint64{duration}})
 	}
 
-	// prepare an insert statement for faster inserts and insert frequencies
-	statement, err := db.Prepare("INSERT INTO InstructionFrequency(instructions, frequency) VALUES (?, ?)")
-	if err != nil {
-		log.Fatalln(err.Error())
+	instructionFrequency := &ProfileTable{
+		Name:   "InstructionFrequency",
+		Keys:   []ProfileColumn{{Name: "instructions", Type: ProfileInteger}},
+		Values: []string{"frequency"},
 	}
 	for instructions, freq := range mps.instructionFrequency {
-		_, err = statement.Exec(instructions, freq)
-		if err != nil {
-			log.Fatalln(err.Error())
-		}
-
-	}
-}
-
-// dump step-length frequency statistic
-func (mps *MicroProfileStatistic) dumpStepLengthFrequency(db *sql.DB) {
-	// drop old frequency table and create new one
-	_, err := db.Exec("DROP TABLE IF EXISTS StepLengthFrequency;CREATE TABLE StepLengthFrequency ( steplength INTEGER NOT NULL, frequency INTEGER NOT NULL, PRIMARY KEY (steplength));")
-	if err != nil {
-		log.Fatalln(err.Error())
+		instructionFrequency.Rows = append(instructionFrequency.Rows, ProfileRow{Keys: []string{strconv.FormatUint(instructions, 10)}, Values: []uint64{freq}})
 	}
 
-	// prepare an insert statement for faster inserts and insert frequencies
-	statement, err := db.Prepare("INSERT INTO StepLengthFrequency(steplength, frequency) VALUES (?, ?)")
-	if err != nil {
-		log.Fatalln(err.Error())
+	stepLengthFrequency := &ProfileTable{
+		Name:   "StepLengthFrequency",
+		Keys:   []ProfileColumn{{Name: "steplength", Type: ProfileInteger}},
+		Values: []string{"frequency"},
 	}
 	for length, freq := range mps.stepLengthFrequency {
-		_, err = statement.Exec(length, freq)
-		if err != nil {
-			log.Fatalln(err.Error())
-		}
-
+		stepLengthFrequency.Rows = append(stepLengthFrequency.Rows, ProfileRow{Keys: []string{strconv.Itoa(length)}, Values: []uint64{freq}})
 	}
-}
 
-
-// dump micro-profiling statistic into a sqlite3 database
-func (mps *MicroProfileStatistic) Dump(version string) {
-
-	// open sqlite3 database
-	// TODO: have parameters for sqlite3 database name
-	db, err := sql.Open("sqlite3", MicroProfilingDB) // Open the created SQLite File
-	if err != nil {
-		log.Fatal(err.Error())
+	gasColumns := []string{"constant", "memory", "dynamic", "refund", "refundreverted"}
+	opCodeGas := &ProfileTable{
+		Name:   "OpCodeGas",
+		Keys:   []ProfileColumn{{Name: "opcode", Type: ProfileText}},
+		Values: gasColumns,
 	}
-	defer db.Close()
-
-	// switch synchronous mode off, enable memory journaling,
-	_, err = db.Exec("PRAGMA synchronous = OFF;PRAGMA journal_mode = MEMORY;")
-	if err != nil {
-		log.Fatalln(err.Error())
+	for opCode, gas := range mps.opCodeGas {
+		opCodeGas.Rows = append(opCodeGas.Rows, ProfileRow{Keys: []string{opCode.String()}, Values: gas.values()})
 	}
 
-	_, err = db.Exec("PRAGMA synchronous = OFF;PRAGMA journal_mode = MEMORY;")
-	if err != nil {
-		log.Fatalln(err.Error())
+	contractOpCode := &ProfileTable{
+		Name: "ContractOpCodeGas",
+		Keys: []ProfileColumn{
+			{Name: "contract", Type: ProfileText},
+			{Name: "opcode", Type: ProfileText},
+		},
+		Values: append([]string{"frequency", "duration"}, gasColumns...),
 	}
-
-	_, err = db.Exec("CREATE TABLE IF NOT EXISTS Information ( version TEXT );")
-	if err != nil {
-		log.Fatalln(err.Error())
+	for key, cs := range mps.contractOpCode {
+		contractOpCode.Rows = append(contractOpCode.Rows, ProfileRow{
+			Keys:   []string{key.Contract.Hex(), key.OpCode.String()},
+			Values: append([]uint64{cs.Frequency, cs.Duration}, cs.Gas.values()...),
+		})
 	}
 
-	_, err = db.Exec("INSERT INTO Information (version) VALUES (\"" + version + "\")")
-	if err != nil {
-		log.Fatalln(err.Error())
+	nGramFrequency := &ProfileTable{
+		Name:   "OpCodeNGramFrequency",
+		Keys:   []ProfileColumn{{Name: "ngram", Type: ProfileText}},
+		Values: []string{"frequency"},
+	}
+	for g, freq := range mps.nGramFrequency {
+		nGramFrequency.Rows = append(nGramFrequency.Rows, ProfileRow{Keys: []string{g.String()}, Values: []uint64{freq}})
 	}
 
-	// dump op-code frequencies
-	mps.dumpOpCodeFrequency(db)
+	information := &ProfileTable{
+		Name: "Information",
+		Keys: []ProfileColumn{{Name: "version", Type: ProfileText}},
+		Rows: []ProfileRow{{Keys: []string{version}}},
+	}
 
-	// dump op-code durations
-	mps.dumpOpCodeDuration(db)
+	tables := []*ProfileTable{information, opCodeFrequency, opCodeDuration, instructionFrequency, stepLengthFrequency, opCodeGas, contractOpCode, nGramFrequency}
+	for _, t := range tables {
+		t.Sort()
+	}
+	return tables
+}
 
-	// dump instruction frequency
-	mps.dumpInstructionFrequency(db)
+// values returns the gas counters in the column order of the gas tables.
+func (g OpCodeGas) values() []uint64 {
+	return []uint64{g.Constant, g.Memory, g.Dynamic, g.Refund, g.RefundReverted}
+}
 
-	// dump step-length frequency
-	mps.dumpStepLengthFrequency(db)
+// dump micro-profiling statistic into a profile sink
+func (mps *MicroProfileStatistic) Dump(sink ProfileSink, version string) error {
+	for _, t := range mps.Tables(version) {
+		if err := sink.WriteTable(t); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,172 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Maximal length of profiled opcode sequences
+const MaxNGramLength = 8
+
+// OpCodeNGram is a sequence of up to MaxNGramLength opcodes. The opcodes are
+// packed into a single word, the most recent opcode in the lowest byte, so
+// n-grams can be used as map keys without allocations.
+type OpCodeNGram struct {
+	Ops    uint64 // packed opcodes
+	Length uint8  // number of opcodes
+}
+
+// NewOpCodeNGram creates an n-gram from a sequence of opcodes.
+func NewOpCodeNGram(ops ...OpCode) OpCodeNGram {
+	var g OpCodeNGram
+	for _, op := range ops {
+		g = g.push(op, MaxNGramLength)
+	}
+	return g
+}
+
+// push appends op to the n-gram and drops the oldest opcode if the n-gram
+// would exceed limit opcodes.
+func (g OpCodeNGram) push(op OpCode, limit int) OpCodeNGram {
+	g.Ops = g.Ops<<8 | uint64(op)
+	if int(g.Length) < limit {
+		g.Length++
+	}
+	return g.suffix(int(g.Length))
+}
+
+// suffix returns the n-gram of the n most recent opcodes.
+func (g OpCodeNGram) suffix(n int) OpCodeNGram {
+	if n < MaxNGramLength {
+		g.Ops &= 1<<(8*uint(n)) - 1
+	}
+	g.Length = uint8(n)
+	return g
+}
+
+// OpCodes returns the opcodes of the n-gram in execution order.
+func (g OpCodeNGram) OpCodes() []OpCode {
+	ops := make([]OpCode, g.Length)
+	for i := range ops {
+		ops[i] = OpCode(g.Ops >> (8 * uint(len(ops)-1-i)))
+	}
+	return ops
+}
+
+// String returns the space separated opcode names of the n-gram.
+func (g OpCodeNGram) String() string {
+	names := make([]string, g.Length)
+	for i, op := range g.OpCodes() {
+		names[i] = op.String()
+	}
+	return strings.Join(names, " ")
+}
+
+// nGramRecorder counts the opcode sequences of a single contract invocation.
+// Sequences never extend past a jump nor into a jump destination, so every
+// n-gram is contiguous in the code and could be fused into a super-instruction.
+type nGramRecorder struct {
+	length    int                    // maximal n-gram length
+	window    OpCodeNGram            // most recently executed opcodes
+	frequency map[OpCodeNGram]uint64 // n-gram frequency
+}
+
+// newNGramRecorder returns a recorder for n-grams of length 2..length, or nil
+// if n-gram profiling is disabled.
+func newNGramRecorder(length int) *nGramRecorder {
+	if length < 2 {
+		return nil
+	}
+	return &nGramRecorder{length: length, frequency: make(map[OpCodeNGram]uint64)}
+}
+
+// record counts all n-grams ending with the executed opcode op.
+func (r *nGramRecorder) record(op OpCode) {
+	// A jump destination may be entered from a jump, so it starts a new
+	// sequence just like the opcode following a jump.
+	if op == JUMPDEST {
+		r.window = OpCodeNGram{}
+	}
+	r.window = r.window.push(op, r.length)
+	for n := 2; n <= int(r.window.Length); n++ {
+		r.frequency[r.window.suffix(n)]++
+	}
+	if op == JUMP || op == JUMPI {
+		r.window = OpCodeNGram{}
+	}
+}
+
+// SuperInstructionCandidate is an opcode sequence which may be worth fusing
+// into a single instruction.
+type SuperInstructionCandidate struct {
+	NGram     OpCodeNGram // opcode sequence
+	Frequency uint64      // dynamic frequency of the sequence
+	Savings   uint64      // instruction dispatches saved by fusing the sequence
+}
+
+// SuperInstructionCandidates ranks the profiled opcode sequences by their
+// frequency times the number of dispatches a fused instruction would save,
+// and returns at most limit candidates (all if limit is not positive).
+func (mps *MicroProfileStatistic) SuperInstructionCandidates(limit int) []SuperInstructionCandidate {
+	candidates := make([]SuperInstructionCandidate, 0, len(mps.nGramFrequency))
+	for g, freq := range mps.nGramFrequency {
+		candidates = append(candidates, SuperInstructionCandidate{
+			NGram:     g,
+			Frequency: freq,
+			Savings:   freq * uint64(g.Length-1),
+		})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.Savings != b.Savings {
+			return a.Savings > b.Savings
+		}
+		if a.NGram.Length != b.NGram.Length {
+			return a.NGram.Length < b.NGram.Length
+		}
+		return a.NGram.Ops < b.NGram.Ops
+	})
+	if limit > 0 && len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	return candidates
+}
+
+// WriteSuperInstructionReport writes the ranked super-instruction candidates
+// as a table. The share is the candidate's dispatch savings relative to all
+// executed instructions.
+func (mps *MicroProfileStatistic) WriteSuperInstructionReport(w io.Writer, limit int) error {
+	var steps uint64
+	for _, freq := range mps.opCodeFrequency {
+		steps += freq
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "RANK\tSEQUENCE\tFREQUENCY\tSAVED DISPATCHES\tSHARE")
+	for i, c := range mps.SuperInstructionCandidates(limit) {
+		share := 0.0
+		if steps > 0 {
+			share = 100 * float64(c.Savings) / float64(steps)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%.2f%%\n", i+1, c.NGram, c.Frequency, c.Savings, share)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,90 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOpCodeNGram(t *testing.T) {
+	g := NewOpCodeNGram(PUSH1, PUSH1, ADD)
+	if have, want := g.OpCodes(), []OpCode{PUSH1, PUSH1, ADD}; !reflect.DeepEqual(have, want) {
+		t.Errorf("opcodes mismatch: have %v, want %v", have, want)
+	}
+	if have, want := g.String(), "PUSH1 PUSH1 ADD"; have != want {
+		t.Errorf("string mismatch: have %q, want %q", have, want)
+	}
+	if have, want := g.suffix(2), NewOpCodeNGram(PUSH1, ADD); have != want {
+		t.Errorf("suffix mismatch: have %v, want %v", have, want)
+	}
+	// the oldest opcodes are dropped once the maximal length is reached
+	long := NewOpCodeNGram(STOP, ADD, MUL, SUB, DIV, SDIV, MOD, SMOD, ADDMOD)
+	if have, want := long.OpCodes(), []OpCode{ADD, MUL, SUB, DIV, SDIV, MOD, SMOD, ADDMOD}; !reflect.DeepEqual(have, want) {
+		t.Errorf("opcodes mismatch: have %v, want %v", have, want)
+	}
+}
+
+func TestNGramRecorder(t *testing.T) {
+	if newNGramRecorder(1) != nil {
+		t.Fatal("n-gram recorder enabled for unigrams")
+	}
+	r := newNGramRecorder(3)
+	for _, op := range []OpCode{PUSH1, PUSH1, ADD, PUSH1, JUMP, JUMPDEST, PUSH1, PUSH1, ADD} {
+		r.record(op)
+	}
+	want := map[OpCodeNGram]uint64{
+		NewOpCodeNGram(PUSH1, PUSH1):           2,
+		NewOpCodeNGram(PUSH1, ADD):             2,
+		NewOpCodeNGram(PUSH1, PUSH1, ADD):      2,
+		NewOpCodeNGram(ADD, PUSH1):             1,
+		NewOpCodeNGram(PUSH1, ADD, PUSH1):      1,
+		NewOpCodeNGram(PUSH1, JUMP):            1,
+		NewOpCodeNGram(ADD, PUSH1, JUMP):       1,
+		NewOpCodeNGram(JUMPDEST, PUSH1):        1,
+		NewOpCodeNGram(JUMPDEST, PUSH1, PUSH1): 1,
+	}
+	if !reflect.DeepEqual(r.frequency, want) {
+		t.Errorf("n-gram frequency mismatch:\nhave %v\nwant %v", r.frequency, want)
+	}
+	// a jump destination reached by falling through starts a new sequence too
+	fallThrough := newNGramRecorder(3)
+	for _, op := range []OpCode{PUSH1, JUMPDEST, PUSH1} {
+		fallThrough.record(op)
+	}
+	if want := map[OpCodeNGram]uint64{NewOpCodeNGram(JUMPDEST, PUSH1): 1}; !reflect.DeepEqual(fallThrough.frequency, want) {
+		t.Errorf("n-gram frequency mismatch:\nhave %v\nwant %v", fallThrough.frequency, want)
+	}
+
+	mps := NewMicroProfileStatistic()
+	mps.nGramFrequency = r.frequency
+	mps.opCodeFrequency[PUSH1] = 5
+	mps.opCodeFrequency[ADD] = 2
+	candidates := mps.SuperInstructionCandidates(2)
+	if len(candidates) != 2 || candidates[0].NGram != NewOpCodeNGram(PUSH1, PUSH1, ADD) || candidates[0].Savings != 4 {
+		t.Fatalf("unexpected candidates %v", candidates)
+	}
+	var report bytes.Buffer
+	if err := mps.WriteSuperInstructionReport(&report, 1); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(report.String(), "PUSH1 PUSH1 ADD") || strings.Count(report.String(), "\n") != 2 {
+		t.Errorf("unexpected report:\n%s", report.String())
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// StatePrecompileFactory creates a stateful precompile at address, configured
+// by the precompile specific params of the chain configuration, which may be
+// empty. The returned contract is shared by all EVM instances, so it must be
+// safe for concurrent use and keep its state in the StateDB.
+type StatePrecompileFactory func(address common.Address, params json.RawMessage) (PrecompiledStateContract, error)
+
+var (
+	statePrecompileRegistry = map[string]StatePrecompileFactory{}
+
+	// statePrecompileTables maps the StatePrecompiles of chain configurations,
+	// see tableKey, to their *statePrecompileTable.
+	statePrecompileTables sync.Map
+)
+
+// RegisterStatePrecompile registers a stateful precompile under name, so it
+// can be activated by the StatePrecompiles of a chain configuration.
+func RegisterStatePrecompile(name string, factory StatePrecompileFactory) {
+	statePrecompileRegistry[strings.ToLower(name)] = factory
+}
+
+// statePrecompileStage holds the stateful precompiles active from block on.
+type statePrecompileStage struct {
+	block     *big.Int
+	contracts map[common.Address]PrecompiledStateContract
+	addresses []common.Address // sorted keys of contracts
+}
+
+// statePrecompileTable holds the stateful precompiles of a chain
+// configuration, created once and ordered by activation block. It is never
+// modified, so it is shared by all EVMs of the configuration.
+type statePrecompileTable struct {
+	stages []statePrecompileStage
+}
+
+// tableKey identifies the StatePrecompiles of a chain configuration by their
+// backing array, so copies of a configuration share a table, while replacing
+// or extending the slice yields a new one.
+type tableKey struct {
+	first *params.StatePrecompileConfig
+	n     int
+}
+
+// checkShadowing checks that no stateful precompile of config shadows a
+// built-in precompile at its activation block or later. Since the built-in
+// precompiles only grow with the forks, the ones active once all forks of the
+// configuration are activated are checked.
+func checkShadowing(config *params.ChainConfig) error {
+	builtin := precompiledContracts(config.Rules(new(big.Int).SetUint64(math.MaxUint64)))
+	for i := range config.StatePrecompiles {
+		if p := &config.StatePrecompiles[i]; builtin[p.Address] != nil {
+			return fmt.Errorf("stateful precompile %s at %x shadows a built-in precompile", p.Name, p.Address)
+		}
+	}
+	return nil
+}
+
+// newStatePrecompileTable creates the stateful precompiles of config.
+func newStatePrecompileTable(config *params.ChainConfig) (*statePrecompileTable, error) {
+	contracts := make([]PrecompiledStateContract, len(config.StatePrecompiles))
+	for i := range config.StatePrecompiles {
+		p := &config.StatePrecompiles[i]
+		factory, ok := statePrecompileRegistry[strings.ToLower(p.Name)]
+		if !ok {
+			return nil, fmt.Errorf("no stateful precompile %s registered", p.Name)
+		}
+		contract, err := factory(p.Address, p.Params)
+		if err != nil {
+			return nil, fmt.Errorf("stateful precompile %s at %x: %v", p.Name, p.Address, err)
+		}
+		contracts[i] = contract
+	}
+	var blocks []*big.Int
+	for i := range config.StatePrecompiles {
+		blocks = append(blocks, config.StatePrecompiles[i].Activation())
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].Cmp(blocks[j]) < 0 })
+
+	table := new(statePrecompileTable)
+	for i, block := range blocks {
+		if i > 0 && blocks[i-1].Cmp(block) == 0 {
+			continue
+		}
+		stage := statePrecompileStage{block: block, contracts: make(map[common.Address]PrecompiledStateContract)}
+		for j := range config.StatePrecompiles {
+			if p := &config.StatePrecompiles[j]; p.IsActive(block) {
+				stage.contracts[p.Address] = contracts[j]
+				stage.addresses = append(stage.addresses, p.Address)
+			}
+		}
+		sort.Slice(stage.addresses, func(i, j int) bool {
+			return bytes.Compare(stage.addresses[i][:], stage.addresses[j][:]) < 0
+		})
+		table.stages = append(table.stages, stage)
+	}
+	return table, nil
+}
+
+// active returns the stage active at block num, nil if none is.
+func (t *statePrecompileTable) active(num *big.Int) *statePrecompileStage {
+	for i := len(t.stages) - 1; i >= 0; i-- {
+		if t.stages[i].block.Cmp(num) <= 0 {
+			return &t.stages[i]
+		}
+	}
+	return nil
+}
+
+// CheckStatePrecompiles checks that all stateful precompiles of the chain
+// configuration are registered and never shadow built-in precompiles, and
+// creates them. It is called when a configuration is loaded, the
+// StatePrecompiles of the configuration must not be modified afterwards.
+func CheckStatePrecompiles(config *params.ChainConfig) error {
+	if len(config.StatePrecompiles) == 0 {
+		return nil
+	}
+	if err := checkShadowing(config); err != nil {
+		return err
+	}
+	key := tableKey{&config.StatePrecompiles[0], len(config.StatePrecompiles)}
+	if table, ok := statePrecompileTables.Load(key); ok && len(table.(*statePrecompileTable).stages) > 0 {
+		return nil
+	}
+	table, err := newStatePrecompileTable(config)
+	if err != nil {
+		return err
+	}
+	statePrecompileTables.Store(key, table)
+	return nil
+}
+
+// activeStatePrecompiles returns the stateful precompiles the chain
+// configuration activates at block num. Configurations not checked by
+// CheckStatePrecompiles are checked on first use, an unusable configuration
+// is reported once and runs without stateful precompiles.
+func activeStatePrecompiles(config *params.ChainConfig, num *big.Int) *statePrecompileStage {
+	if len(config.StatePrecompiles) == 0 {
+		return nil
+	}
+	key := tableKey{&config.StatePrecompiles[0], len(config.StatePrecompiles)}
+	table, ok := statePrecompileTables.Load(key)
+	if !ok {
+		t, err := newStatePrecompileTable(config)
+		if err == nil {
+			err = checkShadowing(config)
+		}
+		if err != nil {
+			log.Error("Unusable stateful precompiles", "err", err)
+			t = new(statePrecompileTable)
+		}
+		table, _ = statePrecompileTables.LoadOrStore(key, t)
+	}
+	return table.(*statePrecompileTable).active(num)
+}
+
+// ActivateStatePrecompiles activates the accounts of the stateful precompiles
+// the chain configuration activates at block num, see ActivateStatePrecompile.
+// It is applied to the state at the start of every block, like the DAO hard
+// fork. Activated accounts never become empty again, so the state is only
+// modified at the activation blocks.
+func ActivateStatePrecompiles(config *params.ChainConfig, num *big.Int, statedb StateDB) {
+	stage := activeStatePrecompiles(config, num)
+	if stage == nil {
+		return
+	}
+	for _, addr := range stage.addresses {
+		ActivateStatePrecompile(statedb, addr)
+	}
+}
+
+// ActiveChainPrecompiles returns the built-in precompiles and the stateful
+// precompiles the chain configuration activates at block num, e.g. to warm
+// them up according to EIP-2929.
+func ActiveChainPrecompiles(config *params.ChainConfig, num *big.Int) []common.Address {
+	precompiles := ActivePrecompiles(config.Rules(num))
+	stage := activeStatePrecompiles(config, num)
+	if stage == nil {
+		return precompiles
+	}
+	active := make([]common.Address, 0, len(precompiles)+len(stage.addresses))
+	active = append(active, precompiles...)
+	return append(active, stage.addresses...)
+}
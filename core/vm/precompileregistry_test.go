@@ -0,0 +1,112 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// echoPrecompile returns the configured params followed by the input.
+type echoPrecompile struct{ params []byte }
+
+func (p *echoPrecompile) Run(stateDB StateDB, blockCtx BlockContext, txCtx TxContext, caller common.Address, input []byte, suppliedGas uint64) ([]byte, uint64, error) {
+	return append(append([]byte{}, p.params...), input...), suppliedGas, nil
+}
+
+func init() {
+	RegisterStatePrecompile("Echo", func(address common.Address, params json.RawMessage) (PrecompiledStateContract, error) {
+		return &echoPrecompile{params: params}, nil
+	})
+}
+
+func TestStatePrecompileActivation(t *testing.T) {
+	addr := common.HexToAddress("0x1000")
+	config := *params.TestChainConfig
+	config.StatePrecompiles = []params.StatePrecompileConfig{
+		{Name: "echo", Address: addr, Block: big.NewInt(5), Params: json.RawMessage(`1`)},
+	}
+	if err := CheckStatePrecompiles(&config); err != nil {
+		t.Fatal(err)
+	}
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	for _, num := range []int64{4, 5} {
+		// The account of the precompile is kept alive from its activation on.
+		ActivateStatePrecompiles(&config, big.NewInt(num), statedb)
+		if activated := statedb.GetNonce(addr) == 1; activated != (num >= 5) {
+			t.Errorf("block %d: precompile account activated %v", num, activated)
+		}
+		blockCtx := BlockContext{
+			CanTransfer: func(StateDB, common.Address, *big.Int) bool { return true },
+			Transfer:    func(StateDB, common.Address, common.Address, *big.Int) {},
+			BlockNumber: big.NewInt(num),
+		}
+		evm := NewEVM(blockCtx, TxContext{}, statedb, &config, Config{})
+		ret, _, err := evm.Call(AccountRef(common.Address{}), addr, []byte{2}, 10000, new(big.Int))
+		if err != nil {
+			t.Fatalf("block %d: %v", num, err)
+		}
+		want := []byte{}
+		if num >= 5 {
+			want = []byte{'1', 2}
+		}
+		if string(ret) != string(want) {
+			t.Errorf("block %d: output mismatch: have %x, want %x", num, ret, want)
+		}
+		active := false
+		for _, a := range ActiveChainPrecompiles(&config, big.NewInt(num)) {
+			active = active || a == addr
+		}
+		if active != (num >= 5) {
+			t.Errorf("block %d: precompile active %v", num, active)
+		}
+	}
+}
+
+func TestCheckStatePrecompiles(t *testing.T) {
+	for _, p := range []params.StatePrecompileConfig{
+		{Name: "unknown", Address: common.HexToAddress("0x1000")},
+		{Name: "echo", Address: common.BytesToAddress([]byte{1})},
+	} {
+		config := &params.ChainConfig{StatePrecompiles: []params.StatePrecompileConfig{p}}
+		if err := CheckStatePrecompiles(config); err == nil {
+			t.Errorf("invalid precompile %s at %x accepted", p.Name, p.Address)
+		}
+	}
+	// built-in precompiles are only shadowed if their fork is configured
+	blake2F := params.StatePrecompileConfig{Name: "echo", Address: common.BytesToAddress([]byte{9})}
+	config := &params.ChainConfig{ByzantiumBlock: big.NewInt(0), StatePrecompiles: []params.StatePrecompileConfig{blake2F}}
+	if err := CheckStatePrecompiles(config); err != nil {
+		t.Errorf("precompile at unused built-in address rejected: %v", err)
+	}
+	config = &params.ChainConfig{ByzantiumBlock: big.NewInt(0), IstanbulBlock: big.NewInt(10), StatePrecompiles: []params.StatePrecompileConfig{blake2F}}
+	if err := CheckStatePrecompiles(config); err == nil {
+		t.Error("precompile shadowing a later built-in precompile accepted")
+	}
+	// unchecked unusable configurations run without stateful precompiles
+	config = &params.ChainConfig{StatePrecompiles: []params.StatePrecompileConfig{{Name: "unknown"}}}
+	evm := NewEVM(BlockContext{BlockNumber: big.NewInt(0)}, TxContext{}, nil, config, Config{})
+	if _, ok := evm.statePrecompile(common.Address{}); ok {
+		t.Error("unknown precompile activated")
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Supported profile sink formats
+const (
+	SQLiteProfileFormat     = "sqlite"     // single SQLITE3 database file
+	CSVProfileFormat        = "csv"        // directory with one CSV file per table
+	JSONLinesProfileFormat  = "jsonl"      // directory with one JSON-lines file per table
+	PrometheusProfileFormat = "prometheus" // single Prometheus text exposition file
+)
+
+// ProfileSink persists profiling tables. In merge mode, records are added to
+// the records already present in the sink's destination, so profiles of
+// different block ranges can be combined; otherwise existing tables are replaced.
+type ProfileSink interface {
+	// WriteTable persists a profiling table.
+	WriteTable(t *ProfileTable) error
+
+	// Close flushes pending records and releases the sink's resources.
+	Close() error
+}
+
+// NewProfileSink creates a profile sink of the given format writing to path.
+func NewProfileSink(format string, path string, merge bool) (ProfileSink, error) {
+	switch format {
+	case SQLiteProfileFormat:
+		return NewSQLiteProfileSink(path, merge)
+	case CSVProfileFormat:
+		return NewCSVProfileSink(path, merge)
+	case JSONLinesProfileFormat:
+		return NewJSONLinesProfileSink(path, merge)
+	case PrometheusProfileFormat:
+		return NewPrometheusProfileSink(path, merge)
+	}
+	return nil, fmt.Errorf("unknown profile sink format %q", format)
+}
+
+// ProfileColumnType is the storage type of a key column
+type ProfileColumnType int
+
+const (
+	ProfileText    ProfileColumnType = iota // textual key
+	ProfileInteger                          // unsigned integer key
+)
+
+// ProfileColumn describes a key column of a profiling table
+type ProfileColumn struct {
+	Name string
+	Type ProfileColumnType
+}
+
+// ProfileRow is a single record of a profiling table
+type ProfileRow struct {
+	Keys   []string // key values in the order of the table's key columns
+	Values []uint64 // counters in the order of the table's value columns
+}
+
+// ProfileTable is a named set of profiling records. A record is identified by
+// its key columns; the value columns are counters which are summed when
+// records with identical keys are merged. Tables without value columns are
+// sets of keys.
+type ProfileTable struct {
+	Name   string          // table name
+	Keys   []ProfileColumn // key columns
+	Values []string        // names of counter columns
+	Rows   []ProfileRow    // records
+}
+
+// Columns returns the names of all columns, key columns first.
+func (t *ProfileTable) Columns() []string {
+	columns := make([]string, 0, len(t.Keys)+len(t.Values))
+	for _, key := range t.Keys {
+		columns = append(columns, key.Name)
+	}
+	return append(columns, t.Values...)
+}
+
+// Merge adds rows to the table. Counters of rows whose keys are already
+// present in the table are summed.
+func (t *ProfileTable) Merge(rows []ProfileRow) {
+	index := make(map[string]int, len(t.Rows))
+	for i, row := range t.Rows {
+		index[rowKey(row)] = i
+	}
+	for _, row := range rows {
+		key := rowKey(row)
+		if i, ok := index[key]; ok {
+			for j, value := range row.Values {
+				t.Rows[i].Values[j] += value
+			}
+			continue
+		}
+		index[key] = len(t.Rows)
+		t.Rows = append(t.Rows, ProfileRow{
+			Keys:   append([]string(nil), row.Keys...),
+			Values: append([]uint64(nil), row.Values...),
+		})
+	}
+}
+
+// Sort orders the rows by their keys so that the output of sinks is deterministic.
+func (t *ProfileTable) Sort() {
+	sort.SliceStable(t.Rows, func(i, j int) bool {
+		a, b := t.Rows[i].Keys, t.Rows[j].Keys
+		for k, column := range t.Keys {
+			if a[k] == b[k] {
+				continue
+			}
+			if column.Type == ProfileInteger {
+				x, errx := strconv.ParseUint(a[k], 10, 64)
+				y, erry := strconv.ParseUint(b[k], 10, 64)
+				if errx == nil && erry == nil {
+					return x < y
+				}
+			}
+			return a[k] < b[k]
+		}
+		return false
+	})
+}
+
+// rowKey returns a map key identifying the row's key columns.
+func rowKey(row ProfileRow) string {
+	return strings.Join(row.Keys, "\x00")
+}
+
+// parseProfileRow converts the textual columns of a stored record into a row
+// of table t.
+func parseProfileRow(t *ProfileTable, record []string) (ProfileRow, error) {
+	if len(record) != len(t.Keys)+len(t.Values) {
+		return ProfileRow{}, fmt.Errorf("table %s: record has %d columns, want %d", t.Name, len(record), len(t.Keys)+len(t.Values))
+	}
+	row := ProfileRow{
+		Keys:   append([]string(nil), record[:len(t.Keys)]...),
+		Values: make([]uint64, len(t.Values)),
+	}
+	for i, field := range record[len(t.Keys):] {
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return ProfileRow{}, fmt.Errorf("table %s: invalid %s value %q", t.Name, t.Values[i], field)
+		}
+		row.Values[i] = value
+	}
+	return row, nil
+}
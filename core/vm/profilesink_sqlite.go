@@ -0,0 +1,150 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Maximal number of records per SQLITE3 transaction for writing
+const SQLiteProfileSinkMaxNumRecords = 1000
+
+// SQLiteProfileSink writes profiling tables into a SQLITE3 database.
+type SQLiteProfileSink struct {
+	db    *sql.DB
+	merge bool
+}
+
+// NewSQLiteProfileSink opens (or creates) the SQLITE3 database at path.
+func NewSQLiteProfileSink(path string, merge bool) (*SQLiteProfileSink, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	// switch synchronous mode off and enable memory journaling
+	if _, err := db.Exec("PRAGMA synchronous = OFF;PRAGMA journal_mode = MEMORY;"); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open profiling database %s: %v", path, err)
+	}
+	return &SQLiteProfileSink{db: db, merge: merge}, nil
+}
+
+// WriteTable writes t into the table of the same name. Counters of existing
+// records are incremented in merge mode; otherwise the table is recreated.
+func (s *SQLiteProfileSink) WriteTable(t *ProfileTable) error {
+	if !s.merge {
+		if _, err := s.db.Exec("DROP TABLE IF EXISTS " + t.Name + ";"); err != nil {
+			return err
+		}
+	}
+	keys := make([]string, len(t.Keys))
+	definitions := make([]string, 0, len(t.Keys)+len(t.Values))
+	for i, key := range t.Keys {
+		keys[i] = key.Name
+		if key.Type == ProfileInteger {
+			definitions = append(definitions, key.Name+" INTEGER NOT NULL")
+		} else {
+			definitions = append(definitions, key.Name+" TEXT NOT NULL")
+		}
+	}
+	for _, value := range t.Values {
+		definitions = append(definitions, value+" INTEGER NOT NULL")
+	}
+	columns := t.Columns()
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+
+	var insert string
+	if len(t.Values) == 0 {
+		// tables without counters are sets, duplicates are skipped
+		if _, err := s.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s ( %s );", t.Name, strings.Join(definitions, ", "))); err != nil {
+			return err
+		}
+		conditions := make([]string, len(keys))
+		for i, key := range keys {
+			conditions[i] = key + " = ?"
+		}
+		insert = fmt.Sprintf("INSERT INTO %s(%s) SELECT %s WHERE NOT EXISTS (SELECT 1 FROM %s WHERE %s)",
+			t.Name, strings.Join(columns, ", "), placeholders, t.Name, strings.Join(conditions, " AND "))
+	} else {
+		// the unique index allows upserts into tables created without a primary key
+		if _, err := s.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s ( %s, PRIMARY KEY (%s) );CREATE UNIQUE INDEX IF NOT EXISTS %s_key ON %s(%s);",
+			t.Name, strings.Join(definitions, ", "), strings.Join(keys, ", "), t.Name, t.Name, strings.Join(keys, ", "))); err != nil {
+			return err
+		}
+		updates := make([]string, len(t.Values))
+		for i, value := range t.Values {
+			updates[i] = fmt.Sprintf("%s = %s + excluded.%s", value, value, value)
+		}
+		insert = fmt.Sprintf("INSERT INTO %s(%s) VALUES (%s) ON CONFLICT(%s) DO UPDATE SET %s",
+			t.Name, strings.Join(columns, ", "), placeholders, strings.Join(keys, ", "), strings.Join(updates, ", "))
+	}
+
+	// populate all records, committing every SQLiteProfileSinkMaxNumRecords records
+	for start := 0; start < len(t.Rows); start += SQLiteProfileSinkMaxNumRecords {
+		end := start + SQLiteProfileSinkMaxNumRecords
+		if end > len(t.Rows) {
+			end = len(t.Rows)
+		}
+		if err := s.insert(insert, t, t.Rows[start:end]); err != nil {
+			return fmt.Errorf("table %s: %v", t.Name, err)
+		}
+	}
+	return nil
+}
+
+// insert executes the insert statement for rows in a single transaction.
+func (s *SQLiteProfileSink) insert(insert string, t *ProfileTable, rows []ProfileRow) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	statement, err := tx.Prepare(insert)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer statement.Close()
+	for _, row := range rows {
+		args := make([]interface{}, 0, 2*len(row.Keys)+len(row.Values))
+		for _, key := range row.Keys {
+			args = append(args, key)
+		}
+		for _, value := range row.Values {
+			args = append(args, int64(value))
+		}
+		if len(t.Values) == 0 {
+			// the keys are bound a second time for the existence check
+			for _, key := range row.Keys {
+				args = append(args, key)
+			}
+		}
+		if _, err := statement.Exec(args...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Close closes the database.
+func (s *SQLiteProfileSink) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,209 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testMicroProfileStatistic() *MicroProfileStatistic {
+	mps := NewMicroProfileStatistic()
+	mps.opCodeFrequency[ADD] = 3
+	mps.opCodeFrequency[SSTORE] = 1
+	mps.opCodeDuration[ADD] = 30
+	mps.instructionFrequency[12] = 2
+	mps.stepLengthFrequency[4] = 1
+	return mps
+}
+
+// readTable reads a table written by a file based sink.
+func readTable(t *testing.T, path string, table *ProfileTable, read func(*os.File, *ProfileTable) ([]ProfileRow, error)) []ProfileRow {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := read(f, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+func TestFileProfileSinkMerge(t *testing.T) {
+	for _, format := range []string{CSVProfileFormat, JSONLinesProfileFormat} {
+		dir, err := ioutil.TempDir("", "profilesink")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		// dump the same statistic twice in merge mode
+		for i, merge := range []bool{true, true} {
+			sink, err := NewProfileSink(format, dir, merge)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := testMicroProfileStatistic().Dump(sink, "v1"); err != nil {
+				t.Fatalf("%s: dump %d failed: %v", format, i, err)
+			}
+			if err := sink.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tables := testMicroProfileStatistic().Tables("v1")
+		read := func(f *os.File, table *ProfileTable) ([]ProfileRow, error) {
+			if format == CSVProfileFormat {
+				return readCSVTable(f, table)
+			}
+			return readJSONLinesTable(f, table)
+		}
+		freq := readTable(t, filepath.Join(dir, "OpCodeFrequency."+format), tables[1], read)
+		want := []ProfileRow{
+			{Keys: []string{"ADD"}, Values: []uint64{6}},
+			{Keys: []string{"SSTORE"}, Values: []uint64{2}},
+		}
+		if !reflect.DeepEqual(freq, want) {
+			t.Errorf("%s: merged opcode frequency mismatch: have %v, want %v", format, freq, want)
+		}
+		info := readTable(t, filepath.Join(dir, "Information."+format), tables[0], read)
+		if len(info) != 1 {
+			t.Errorf("%s: information not deduplicated: %v", format, info)
+		}
+
+		// replace mode drops the previous records
+		sink, _ := NewProfileSink(format, dir, false)
+		if err := testMicroProfileStatistic().Dump(sink, "v1"); err != nil {
+			t.Fatal(err)
+		}
+		freq = readTable(t, filepath.Join(dir, "OpCodeFrequency."+format), tables[1], read)
+		if freq[0].Values[0] != 3 {
+			t.Errorf("%s: replaced opcode frequency mismatch: have %d, want 3", format, freq[0].Values[0])
+		}
+	}
+}
+
+func TestSQLiteProfileSinkMerge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profilesink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "profile.db")
+
+	// create a basic-block table in the layout of older databases without a primary key
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("CREATE TABLE BasicBlockFrequency ( contract TEXT, address NUMERIC, instructions TEXT, frequency NUMERIC );" +
+		"INSERT INTO BasicBlockFrequency VALUES ('0x01', 2, '6001', 5);"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	bbps := NewBasicBlockProfileStatistic()
+	bbps.basicBlockFrequency[BasicBlockKey{Contract: "0x01", Address: 2, Instructions: "6001"}] = 7
+	bbps.basicBlockFrequency[BasicBlockKey{Contract: "0x01", Address: 4, Instructions: "00"}] = 1
+	for i := 0; i < 2; i++ {
+		sink, err := NewSQLiteProfileSink(path, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := testMicroProfileStatistic().Dump(sink, "v1"); err != nil {
+			t.Fatalf("dump %d failed: %v", i, err)
+		}
+		if err := bbps.Dump(sink); err != nil {
+			t.Fatalf("dump %d failed: %v", i, err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err = sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	queries := map[string]uint64{
+		"SELECT frequency FROM OpCodeFrequency WHERE opcode = 'ADD'":         6,
+		"SELECT frequency FROM InstructionFrequency WHERE instructions = 12": 4,
+		"SELECT frequency FROM BasicBlockFrequency WHERE address = 2":        19,
+		"SELECT frequency FROM BasicBlockFrequency WHERE address = 4":        2,
+		"SELECT COUNT(*) FROM Information WHERE version = 'v1'":              1,
+		"SELECT COUNT(*) FROM BasicBlockFrequency WHERE contract = '0x01'":   2,
+	}
+	for query, want := range queries {
+		var have uint64
+		if err := db.QueryRow(query).Scan(&have); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		if have != want {
+			t.Errorf("%s: have %d, want %d", query, have, want)
+		}
+	}
+}
+
+func TestPrometheusProfileSinkMerge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profilesink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "profile.prom")
+
+	for i := 0; i < 2; i++ {
+		sink, err := NewPrometheusProfileSink(path, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := testMicroProfileStatistic().Dump(sink, "v1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"# TYPE evm_op_code_frequency gauge",
+		`evm_op_code_frequency{opcode="ADD"} 6`,
+		`evm_op_code_duration{opcode="ADD"} 60`,
+		`evm_step_length_frequency{steplength="4"} 2`,
+		`evm_information_info{version="v1"} 1`,
+	} {
+		if !strings.Contains(string(data), line+"\n") {
+			t.Errorf("missing line %q in\n%s", line, data)
+		}
+	}
+}
+
+func TestNewProfileSinkUnknownFormat(t *testing.T) {
+	if _, err := NewProfileSink("xml", "profile.xml", false); err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+}
@@ -0,0 +1,390 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// CSVProfileSink writes every profiling table into a CSV file <Table>.csv
+// in a directory. The first line of a file holds the column names.
+type CSVProfileSink struct {
+	dir   string
+	merge bool
+}
+
+// NewCSVProfileSink creates a CSV sink writing into directory dir.
+func NewCSVProfileSink(dir string, merge bool) (*CSVProfileSink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &CSVProfileSink{dir: dir, merge: merge}, nil
+}
+
+// WriteTable writes t into <Table>.csv.
+func (s *CSVProfileSink) WriteTable(t *ProfileTable) error {
+	path := filepath.Join(s.dir, t.Name+".csv")
+	return writeTableFile(path, t, s.merge, readCSVTable, writeCSVTable)
+}
+
+// Close is a no-op, tables are written immediately.
+func (s *CSVProfileSink) Close() error {
+	return nil
+}
+
+// readCSVTable reads the records of table t from a CSV file.
+func readCSVTable(r io.Reader, t *ProfileTable) ([]ProfileRow, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	if header := strings.Join(records[0], ","); header != strings.Join(t.Columns(), ",") {
+		return nil, fmt.Errorf("table %s: unexpected columns %q", t.Name, header)
+	}
+	rows := make([]ProfileRow, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row, err := parseProfileRow(t, record)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// writeCSVTable writes table t as CSV.
+func writeCSVTable(w io.Writer, t *ProfileTable) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(t.Columns()); err != nil {
+		return err
+	}
+	for _, row := range t.Rows {
+		record := append([]string(nil), row.Keys...)
+		for _, value := range row.Values {
+			record = append(record, strconv.FormatUint(value, 10))
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// JSONLinesProfileSink writes every profiling table into a file <Table>.jsonl
+// in a directory, one JSON object per record.
+type JSONLinesProfileSink struct {
+	dir   string
+	merge bool
+}
+
+// NewJSONLinesProfileSink creates a JSON-lines sink writing into directory dir.
+func NewJSONLinesProfileSink(dir string, merge bool) (*JSONLinesProfileSink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &JSONLinesProfileSink{dir: dir, merge: merge}, nil
+}
+
+// WriteTable writes t into <Table>.jsonl.
+func (s *JSONLinesProfileSink) WriteTable(t *ProfileTable) error {
+	path := filepath.Join(s.dir, t.Name+".jsonl")
+	return writeTableFile(path, t, s.merge, readJSONLinesTable, writeJSONLinesTable)
+}
+
+// Close is a no-op, tables are written immediately.
+func (s *JSONLinesProfileSink) Close() error {
+	return nil
+}
+
+// readJSONLinesTable reads the records of table t from a JSON-lines file.
+func readJSONLinesTable(r io.Reader, t *ProfileTable) ([]ProfileRow, error) {
+	var (
+		rows    []ProfileRow
+		columns = t.Columns()
+		dec     = json.NewDecoder(r)
+	)
+	dec.UseNumber()
+	for {
+		var object map[string]interface{}
+		if err := dec.Decode(&object); err == io.EOF {
+			return rows, nil
+		} else if err != nil {
+			return nil, err
+		}
+		record := make([]string, len(columns))
+		for i, column := range columns {
+			switch field := object[column].(type) {
+			case string:
+				record[i] = field
+			case json.Number:
+				record[i] = field.String()
+			default:
+				return nil, fmt.Errorf("table %s: invalid %s field %v", t.Name, column, object[column])
+			}
+		}
+		row, err := parseProfileRow(t, record)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+}
+
+// writeJSONLinesTable writes table t as JSON lines. Integer keys and
+// counters are written as JSON numbers.
+func writeJSONLinesTable(w io.Writer, t *ProfileTable) error {
+	bw := bufio.NewWriter(w)
+	for _, row := range t.Rows {
+		var line bytes.Buffer
+		t.writeJSONRow(&line, row)
+		line.WriteByte('\n')
+		if _, err := bw.Write(line.Bytes()); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// writeJSONRow writes a row of table t as a JSON object keyed by the column
+// names.
+func (t *ProfileTable) writeJSONRow(buf *bytes.Buffer, row ProfileRow) {
+	buf.WriteByte('{')
+	for i, key := range t.Keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(key.Name)
+		buf.Write(name)
+		buf.WriteByte(':')
+		if key.Type == ProfileInteger {
+			buf.WriteString(row.Keys[i])
+		} else {
+			value, _ := json.Marshal(row.Keys[i])
+			buf.Write(value)
+		}
+	}
+	for i, column := range t.Values {
+		if i > 0 || len(t.Keys) > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(column)
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.WriteString(strconv.FormatUint(row.Values[i], 10))
+	}
+	buf.WriteByte('}')
+}
+
+// MarshalJSON encodes the table as an array of its rows, in the format of
+// the JSON-lines sink.
+func (t *ProfileTable) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, row := range t.Rows {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		t.writeJSONRow(&buf, row)
+	}
+	buf.WriteByte(']')
+	return buf.Bytes(), nil
+}
+
+// writeTableFile writes table t into the file at path. In merge mode, the
+// records already stored in the file are read first and merged with t.
+// The file is replaced atomically.
+func writeTableFile(path string, t *ProfileTable, merge bool,
+	read func(io.Reader, *ProfileTable) ([]ProfileRow, error),
+	write func(io.Writer, *ProfileTable) error) error {
+
+	out := &ProfileTable{Name: t.Name, Keys: t.Keys, Values: t.Values}
+	if merge {
+		f, err := os.Open(path)
+		if err == nil {
+			rows, err := read(f, t)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("failed to merge into %s: %v", path, err)
+			}
+			out.Merge(rows)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	out.Merge(t.Rows)
+	out.Sort()
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := write(f, out); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// PrometheusProfileSink writes profiling tables into a single file in the
+// Prometheus text exposition format, e.g. for the node exporter's textfile
+// collector. Every counter column becomes a gauge evm_<table>[_<column>]
+// labelled with the key columns; tables without counters become info
+// metrics evm_<table>_info with value 1.
+type PrometheusProfileSink struct {
+	path   string
+	series map[string]uint64 // accumulated value of every series
+}
+
+// NewPrometheusProfileSink creates a Prometheus sink writing to path. In merge
+// mode, the series already present in the file are loaded.
+func NewPrometheusProfileSink(path string, merge bool) (*PrometheusProfileSink, error) {
+	s := &PrometheusProfileSink{path: path, series: make(map[string]uint64)}
+	if !merge {
+		return s, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		if i < 0 {
+			return nil, fmt.Errorf("failed to merge into %s: invalid line %q", path, line)
+		}
+		value, err := strconv.ParseUint(line[i+1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to merge into %s: invalid line %q", path, line)
+		}
+		s.add(line[:i], value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// WriteTable adds the records of t to the sink's series.
+func (s *PrometheusProfileSink) WriteTable(t *ProfileTable) error {
+	metric := "evm_" + snakeCase(t.Name)
+	for _, row := range t.Rows {
+		var labels strings.Builder
+		for i, key := range t.Keys {
+			if i > 0 {
+				labels.WriteByte(',')
+			}
+			labels.WriteString(snakeCase(key.Name))
+			labels.WriteString(`="`)
+			labels.WriteString(escapeLabelValue(row.Keys[i]))
+			labels.WriteByte('"')
+		}
+		if len(t.Values) == 0 {
+			s.add(metric+"_info{"+labels.String()+"}", 1)
+			continue
+		}
+		for i, column := range t.Values {
+			name := metric
+			if !strings.HasSuffix(metric, "_"+snakeCase(column)) {
+				name += "_" + snakeCase(column)
+			}
+			s.add(name+"{"+labels.String()+"}", row.Values[i])
+		}
+	}
+	return nil
+}
+
+// add accumulates a value of a series. Info series are never accumulated.
+func (s *PrometheusProfileSink) add(series string, value uint64) {
+	if name := series[:strings.IndexByte(series+"{", '{')]; strings.HasSuffix(name, "_info") {
+		s.series[series] = 1
+		return
+	}
+	s.series[series] += value
+}
+
+// Close writes all series into the file.
+func (s *PrometheusProfileSink) Close() error {
+	keys := make([]string, 0, len(s.series))
+	for series := range s.series {
+		keys = append(keys, series)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	last := ""
+	for _, series := range keys {
+		name := series[:strings.IndexByte(series+"{", '{')]
+		if name != last {
+			fmt.Fprintf(&buf, "# TYPE %s gauge\n", name)
+			last = name
+		}
+		fmt.Fprintf(&buf, "%s %d\n", series, s.series[series])
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// snakeCase converts a CamelCase identifier into snake_case.
+func snakeCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// escapeLabelValue escapes a Prometheus label value.
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,152 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package runtime
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// ProxyAddress is the address of the contract forwarding the calls of
+// PrecompileTester.CallVia.
+var ProxyAddress = common.BytesToAddress([]byte("precompile-proxy"))
+
+// PrecompileTester executes calls of a stateful precompile in an in-memory
+// environment. Calls are made by the configured origin, either directly or
+// through a proxy contract forwarding them with a given call opcode.
+type PrecompileTester struct {
+	Config     *Config
+	precompile *vm.StatefulPrecompile
+	calls      int // number of executed calls, used as transaction index
+}
+
+// PrecompileResult is the outcome of a call made by a PrecompileTester.
+type PrecompileResult struct {
+	Output  []interface{} // unpacked outputs of a successful call
+	Return  []byte        // raw return data
+	GasUsed uint64        // gas used by the call, including the proxy's
+	Logs    []*types.Log  // logs emitted by the call
+	Reason  string        // revert reason of a reverted call, if any
+	Err     error         // error of the call
+}
+
+// NewPrecompileTester registers the precompile in the EVM configuration of
+// cfg, activates its account in the state of cfg and creates a tester
+// executing calls with it.
+func NewPrecompileTester(p *vm.StatefulPrecompile, cfg *Config) *PrecompileTester {
+	if cfg == nil {
+		cfg = new(Config)
+	}
+	setDefaults(cfg)
+	if cfg.State == nil {
+		cfg.State, _ = state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	}
+	precompiles := map[common.Address]vm.PrecompiledStateContract{p.Address(): p}
+	for addr, sp := range cfg.EVMConfig.StatePrecompiles {
+		if addr != p.Address() {
+			precompiles[addr] = sp
+		}
+	}
+	cfg.EVMConfig.StatePrecompiles = precompiles
+	vm.ActivateStatePrecompile(cfg.State, p.Address())
+	return &PrecompileTester{Config: cfg, precompile: p}
+}
+
+// Storage returns a storage slot of an account.
+func (t *PrecompileTester) Storage(addr common.Address, key common.Hash) common.Hash {
+	return t.Config.State.GetState(addr, key)
+}
+
+// Call calls a method of the precompile directly.
+func (t *PrecompileTester) Call(method string, args ...interface{}) *PrecompileResult {
+	return t.call(t.precompile.Address(), method, args)
+}
+
+// CallVia calls a method of the precompile through the proxy contract at
+// ProxyAddress, which forwards the call with op (CALL, CALLCODE,
+// DELEGATECALL or STATICCALL) and passes the result through.
+func (t *PrecompileTester) CallVia(op vm.OpCode, method string, args ...interface{}) *PrecompileResult {
+	code, err := proxyCode(op, t.precompile.Address())
+	if err != nil {
+		return &PrecompileResult{Err: err}
+	}
+	t.Config.State.SetCode(ProxyAddress, code)
+	return t.call(ProxyAddress, method, args)
+}
+
+func (t *PrecompileTester) call(to common.Address, method string, args []interface{}) *PrecompileResult {
+	contractABI := t.precompile.ABI()
+	input, err := contractABI.Pack(method, args...)
+	if err != nil {
+		return &PrecompileResult{Err: err}
+	}
+	// every call is a separate transaction, to tell their logs apart
+	t.calls++
+	thash := common.BigToHash(big.NewInt(int64(t.calls)))
+	t.Config.State.Prepare(thash, t.calls)
+
+	ret, leftOverGas, err := Call(to, input, t.Config)
+	result := &PrecompileResult{
+		Return:  ret,
+		GasUsed: t.Config.GasLimit - leftOverGas,
+		Logs:    t.Config.State.GetLogs(thash, common.Hash{}),
+		Err:     err,
+	}
+	switch {
+	case err == vm.ErrExecutionReverted:
+		result.Reason, _ = abi.UnpackRevert(ret)
+	case err == nil:
+		result.Output, result.Err = contractABI.Unpack(method, ret)
+	}
+	return result
+}
+
+// proxyCode returns the code of a contract forwarding its input to target
+// with the call opcode op. The contract returns the returned data of the
+// call, or reverts with it.
+func proxyCode(op vm.OpCode, target common.Address) ([]byte, error) {
+	code := []byte{
+		byte(vm.CALLDATASIZE), byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.CALLDATACOPY),
+		byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.CALLDATASIZE), byte(vm.PUSH1), 0,
+	}
+	switch op {
+	case vm.CALL, vm.CALLCODE:
+		code = append(code, byte(vm.CALLVALUE))
+	case vm.DELEGATECALL, vm.STATICCALL:
+	default:
+		return nil, fmt.Errorf("%v is not a call opcode", op)
+	}
+	code = append(code, byte(vm.PUSH20))
+	code = append(code, target.Bytes()...)
+	code = append(code,
+		byte(vm.GAS), byte(op),
+		byte(vm.RETURNDATASIZE), byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.RETURNDATACOPY),
+	)
+	dest := len(code) + 7
+	return append(code,
+		byte(vm.PUSH1), byte(dest), byte(vm.JUMPI),
+		byte(vm.RETURNDATASIZE), byte(vm.PUSH1), 0, byte(vm.REVERT),
+		byte(vm.JUMPDEST), byte(vm.RETURNDATASIZE), byte(vm.PUSH1), 0, byte(vm.RETURN),
+	), nil
+}
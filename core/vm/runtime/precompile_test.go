@@ -0,0 +1,172 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package runtime
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+const counterABI = `[
+	{"type":"function","name":"get","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"uint256"}]},
+	{"type":"function","name":"add","stateMutability":"nonpayable","inputs":[{"name":"delta","type":"uint256"}],"outputs":[{"name":"","type":"uint256"}]},
+	{"type":"function","name":"deposit","stateMutability":"payable","inputs":[],"outputs":[]},
+	{"type":"event","name":"Added","inputs":[{"name":"caller","type":"address","indexed":true},{"name":"total","type":"uint256","indexed":false}]}
+]`
+
+var (
+	counterAddress = common.BytesToAddress([]byte{0xfe})
+	counterLimit   = big.NewInt(100)
+)
+
+// newCounter returns a precompile keeping a counter in slot 0, which must
+// not exceed counterLimit.
+func newCounter(t *testing.T) *vm.StatefulPrecompile {
+	contractABI, err := abi.JSON(strings.NewReader(counterABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(ctx *vm.PrecompileContext, args []interface{}) ([]interface{}, error) {
+		return []interface{}{ctx.GetState(common.Hash{}).Big()}, nil
+	}
+	add := func(ctx *vm.PrecompileContext, args []interface{}) ([]interface{}, error) {
+		total := new(big.Int).Add(ctx.GetState(common.Hash{}).Big(), args[0].(*big.Int))
+		if err := ctx.SetState(common.Hash{}, common.BigToHash(total)); err != nil {
+			return nil, err
+		}
+		if err := ctx.Emit("Added", ctx.Caller, total); err != nil {
+			return nil, err
+		}
+		// the modifications above are reverted
+		if total.Cmp(counterLimit) > 0 {
+			return nil, errors.New("limit exceeded")
+		}
+		return []interface{}{total}, ctx.UseGas(total.Uint64())
+	}
+	deposit := func(ctx *vm.PrecompileContext, args []interface{}) ([]interface{}, error) {
+		return nil, nil
+	}
+	p, err := vm.NewStatefulPrecompile(counterAddress, contractABI, map[string]vm.PrecompileMethod{
+		"get":     {Gas: 200, Run: get},
+		"add":     {Gas: 5000, Run: add},
+		"deposit": {Gas: 100, Run: deposit},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestStatefulPrecompile(t *testing.T) {
+	tester := NewPrecompileTester(newCounter(t), nil)
+	origin := tester.Config.Origin
+
+	res := tester.Call("add", big.NewInt(5))
+	if res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	if res.Output[0].(*big.Int).Int64() != 5 || res.GasUsed != 5005 {
+		t.Errorf("unexpected result %v, gas %d", res.Output, res.GasUsed)
+	}
+	if len(res.Logs) != 1 || len(res.Logs[0].Topics) != 2 || res.Logs[0].Topics[1] != common.BytesToHash(origin.Bytes()) {
+		t.Fatalf("unexpected logs %v", res.Logs)
+	}
+	if res.Logs[0].Address != counterAddress || new(big.Int).SetBytes(res.Logs[0].Data).Int64() != 5 {
+		t.Errorf("unexpected log %+v", res.Logs[0])
+	}
+	// the precompile's account survives the end of the transaction
+	if tester.Config.State.Empty(counterAddress) {
+		t.Error("precompile account is empty")
+	}
+
+	// a failing method reverts its state changes and logs
+	res = tester.Call("add", big.NewInt(100))
+	if res.Err != vm.ErrExecutionReverted || res.Reason != "limit exceeded" || len(res.Logs) != 0 {
+		t.Errorf("unexpected result %+v", res)
+	}
+	if res.GasUsed != 5000 {
+		t.Errorf("gas mismatch: have %d, want 5000", res.GasUsed)
+	}
+	if have := tester.Storage(counterAddress, common.Hash{}).Big(); have.Int64() != 5 {
+		t.Errorf("counter mismatch: have %v, want 5", have)
+	}
+
+	res = tester.CallVia(vm.STATICCALL, "get")
+	if res.Err != nil || res.Output[0].(*big.Int).Int64() != 5 {
+		t.Errorf("unexpected result %+v", res)
+	}
+	// state modifications are rejected in static calls, consuming all gas
+	res = tester.CallVia(vm.STATICCALL, "add", big.NewInt(1))
+	if res.Err != vm.ErrExecutionReverted || len(res.Return) != 0 || res.GasUsed < 1000000 {
+		t.Errorf("unexpected result %+v", res)
+	}
+
+	// delegated calls access the state of the caller, but leave its nonce
+	proxyNonce := tester.Config.State.GetNonce(ProxyAddress)
+	res = tester.CallVia(vm.DELEGATECALL, "add", big.NewInt(7))
+	if res.Err != nil || res.Output[0].(*big.Int).Int64() != 7 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	if res.Logs[0].Address != ProxyAddress || res.Logs[0].Topics[1] != common.BytesToHash(origin.Bytes()) {
+		t.Errorf("unexpected log %+v", res.Logs[0])
+	}
+	if have := tester.Storage(ProxyAddress, common.Hash{}).Big(); have.Int64() != 7 {
+		t.Errorf("proxy counter mismatch: have %v, want 7", have)
+	}
+	if have := tester.Config.State.GetNonce(ProxyAddress); have != proxyNonce {
+		t.Errorf("proxy nonce mismatch: have %d, want %d", have, proxyNonce)
+	}
+	if have := tester.Storage(counterAddress, common.Hash{}).Big(); have.Int64() != 5 {
+		t.Errorf("counter mismatch: have %v, want 5", have)
+	}
+}
+
+func TestStatefulPrecompileCalls(t *testing.T) {
+	tester := NewPrecompileTester(newCounter(t), &Config{GasLimit: 4000})
+
+	if res := tester.Call("add", big.NewInt(1)); res.Err != vm.ErrOutOfGas {
+		t.Errorf("unexpected result %+v", res)
+	}
+	tester.Config.GasLimit = 100000
+	if _, _, err := Call(counterAddress, []byte{1, 2, 3, 4}, tester.Config); err != vm.ErrExecutionReverted {
+		t.Errorf("unknown selector accepted: %v", err)
+	}
+
+	// value can only be sent to payable methods
+	tester.Config.Value = big.NewInt(1)
+	tester.Config.State.AddBalance(tester.Config.Origin, big.NewInt(10))
+	if res := tester.Call("add", big.NewInt(1)); res.Err != vm.ErrExecutionReverted || res.Reason != "non-payable method add" {
+		t.Errorf("unexpected result %+v", res)
+	}
+	if res := tester.CallVia(vm.CALL, "deposit"); res.Err != nil {
+		t.Errorf("unexpected result %+v", res)
+	}
+	if have := tester.Config.State.GetBalance(counterAddress); have.Int64() != 1 {
+		t.Errorf("balance mismatch: have %v, want 1", have)
+	}
+
+	contractABI := newCounter(t).ABI()
+	if _, err := vm.NewStatefulPrecompile(counterAddress, contractABI, nil); err == nil {
+		t.Error("precompile without implementations accepted")
+	}
+}
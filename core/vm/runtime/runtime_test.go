@@ -20,7 +20,9 @@ import (
 	"fmt"
 	"math/big"
 	"os"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -33,6 +35,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/eth/tracers"
 	"github.com/ethereum/go-ethereum/params"
 )
@@ -102,6 +105,222 @@ func TestExecute(t *testing.T) {
 	}
 }
 
+// profileTable returns the rows of the named table as a map from the first
+// key column to the first counter.
+func profileTable(tables []*vm.ProfileTable, name string) map[string]uint64 {
+	rows := make(map[string]uint64)
+	for _, t := range tables {
+		if t.Name == name {
+			for _, row := range t.Rows {
+				rows[row.Keys[0]] = row.Values[0]
+			}
+		}
+	}
+	return rows
+}
+
+func TestConcurrentProfilers(t *testing.T) {
+	code := []byte{
+		byte(vm.JUMPDEST),
+		byte(vm.PUSH1), 1,
+		byte(vm.PUSH1), 2,
+		byte(vm.ADD),
+		byte(vm.POP),
+		byte(vm.STOP),
+	}
+	const workers, runs = 4, 10
+
+	// every worker owns a micro-profiler, all workers share a basic-block profiler
+	var (
+		shared = vm.NewBasicBlockProfiler(0)
+		owned  = make([]*vm.MicroProfiler, workers)
+		wg     sync.WaitGroup
+	)
+	for i := 0; i < workers; i++ {
+		owned[i] = vm.NewMicroProfiler(runs)
+		wg.Add(2)
+		go func(p *vm.MicroProfiler) {
+			defer wg.Done()
+			for j := 0; j < runs; j++ {
+				if _, _, err := Execute(code, nil, &Config{EVMConfig: vm.Config{MicroProfiler: p}}); err != nil {
+					t.Error(err)
+				}
+			}
+		}(owned[i])
+		go func() {
+			defer wg.Done()
+			for j := 0; j < runs; j++ {
+				if _, _, err := Execute(code, nil, &Config{EVMConfig: vm.Config{BasicBlockProfiler: shared}}); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// closing flushes all queued records
+	for i, p := range owned {
+		stats := p.Close()
+		if have := profileTable(stats.Tables(""), "OpCodeFrequency")["ADD"]; have != runs {
+			t.Errorf("profiler %d: ADD frequency mismatch: have %d, want %d", i, have, runs)
+		}
+		if have := profileTable(stats.Tables(""), "StepLengthFrequency")["6"]; have != runs {
+			t.Errorf("profiler %d: step length frequency mismatch: have %d, want %d", i, have, runs)
+		}
+	}
+	blocks := shared.Close().Table()
+	if len(blocks.Rows) != 1 || blocks.Rows[0].Values[0] != workers*runs {
+		t.Errorf("basic-block frequency mismatch: have %v, want one block executed %d times", blocks.Rows, workers*runs)
+	}
+	// closing twice is harmless
+	shared.Close()
+}
+
+func TestMicroProfilerGasAttribution(t *testing.T) {
+	code := []byte{
+		// cold SSTORE setting a slot, then a warm SSTORE clearing it for a refund
+		byte(vm.PUSH1), 1, byte(vm.PUSH1), 0, byte(vm.SSTORE),
+		byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.SSTORE),
+		// MSTORE expanding memory by one word
+		byte(vm.PUSH1), 1, byte(vm.PUSH1), 0, byte(vm.MSTORE),
+		// CALL to a cold account forwarding all gas
+		byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0,
+		byte(vm.PUSH1), 0, byte(vm.PUSH1), 0xff, byte(vm.GAS), byte(vm.CALL),
+		byte(vm.STOP),
+	}
+	p := vm.NewMicroProfiler(1)
+	if _, _, err := Execute(code, nil, &Config{EVMConfig: vm.Config{MicroProfiler: p}}); err != nil {
+		t.Fatal(err)
+	}
+	tables := p.Close().Tables("")
+
+	gas := make(map[string][]uint64)
+	for _, table := range tables {
+		if table.Name == "OpCodeGas" {
+			for _, row := range table.Rows {
+				gas[row.Keys[0]] = row.Values
+			}
+		}
+	}
+	// constant, memory, dynamic, refund, refund reverted
+	want := map[string][]uint64{
+		"SSTORE": {0, 0, params.ColdSloadCostEIP2929 + params.SstoreSetGasEIP2200 + params.WarmStorageReadCostEIP2929, params.SstoreSetGasEIP2200 - params.WarmStorageReadCostEIP2929, 0},
+		"MSTORE": {vm.GasFastestStep, params.MemoryGas, 0, 0, 0},
+		"CALL":   {params.WarmStorageReadCostEIP2929, 0, params.ColdAccountAccessCostEIP2929 - params.WarmStorageReadCostEIP2929, 0, 0},
+		"PUSH1":  {12 * vm.GasFastestStep, 0, 0, 0, 0},
+	}
+	for op, values := range want {
+		if !reflect.DeepEqual(gas[op], values) {
+			t.Errorf("%s: gas mismatch: have %v, want %v", op, gas[op], values)
+		}
+	}
+
+	// the per-contract table attributes the same gas to the executed contract
+	for _, table := range tables {
+		if table.Name != "ContractOpCodeGas" {
+			continue
+		}
+		for _, row := range table.Rows {
+			if row.Keys[1] == "CALL" && (row.Keys[0] != common.BytesToAddress([]byte("contract")).Hex() || row.Values[0] != 1 || !reflect.DeepEqual(row.Values[2:], want["CALL"])) {
+				t.Errorf("per-contract CALL statistic mismatch: %v", row)
+			}
+		}
+	}
+}
+
+func TestMicroProfilerNGrams(t *testing.T) {
+	code := []byte{
+		byte(vm.PUSH1), 1, byte(vm.PUSH1), 2, byte(vm.ADD), byte(vm.POP),
+		byte(vm.PUSH1), 1, byte(vm.PUSH1), 2, byte(vm.ADD), byte(vm.POP),
+		byte(vm.STOP),
+	}
+	p := vm.NewMicroProfiler(1)
+	if err := p.SetMaxNGramLength(vm.MaxNGramLength + 1); err == nil {
+		t.Fatal("expected error for excessive n-gram length")
+	}
+	if err := p.SetMaxNGramLength(1); err == nil {
+		t.Fatal("expected error for n-gram length below 2")
+	}
+	if err := p.SetMaxNGramLength(3); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, _, err := Execute(code, nil, &Config{EVMConfig: vm.Config{MicroProfiler: p}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := p.Close()
+	if have := profileTable(stats.Tables(""), "OpCodeNGramFrequency")["PUSH1 PUSH1 ADD"]; have != 4 {
+		t.Errorf("n-gram frequency mismatch: have %d, want 4", have)
+	}
+	// every trigram of the repeated sequence saves two dispatches four times
+	best := stats.SuperInstructionCandidates(1)
+	if len(best) != 1 || best[0].NGram.Length != 3 || best[0].Savings != 8 {
+		t.Errorf("unexpected best candidate %v", best)
+	}
+}
+
+func TestBasicBlockProfilerCoverage(t *testing.T) {
+	code := []byte{
+		byte(vm.PUSH1), 0, byte(vm.CALLDATALOAD), byte(vm.PUSH1), 8, byte(vm.JUMPI), // 0
+		byte(vm.PUSH1), 1, // 6: executed without call data
+		byte(vm.JUMPDEST), byte(vm.STOP), // 8
+		byte(vm.JUMPDEST), byte(vm.STOP), // 10: dead
+	}
+	p := vm.NewBasicBlockProfiler(1)
+	for i := 0; i < 2; i++ {
+		if _, _, err := Execute(code, nil, &Config{EVMConfig: vm.Config{BasicBlockProfiler: p}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := p.Close()
+
+	freq := make(map[string]uint64)
+	for _, row := range stats.Table().Rows {
+		freq[row.Keys[1]] = row.Values[0]
+	}
+	if want := map[string]uint64{"0": 2, "6": 2, "8": 2}; !reflect.DeepEqual(freq, want) {
+		t.Errorf("block frequency mismatch: have %v, want %v", freq, want)
+	}
+	coverage := stats.Coverage()
+	want := []vm.BasicBlockCoverage{{
+		Code:      crypto.Keccak256Hash(code).String(),
+		Blocks:    4,
+		Reachable: 3,
+		Executed:  3,
+	}}
+	if !reflect.DeepEqual(coverage, want) {
+		t.Errorf("coverage mismatch: have %v, want %v", coverage, want)
+	}
+	if static := stats.StaticTable(); len(static.Rows) != 4 {
+		t.Errorf("static block table mismatch: %v", static.Rows)
+	}
+}
+
+// Tests that different codes executed at the same address, like constructor
+// and runtime code, are covered separately.
+func TestBasicBlockProfilerCoverageByCode(t *testing.T) {
+	codes := [][]byte{
+		{byte(vm.PUSH1), 4, byte(vm.JUMP), byte(vm.STOP), byte(vm.JUMPDEST), byte(vm.STOP)},
+		{byte(vm.STOP)},
+	}
+	p := vm.NewBasicBlockProfiler(1)
+	for _, code := range codes {
+		if _, _, err := Execute(code, nil, &Config{EVMConfig: vm.Config{BasicBlockProfiler: p}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	coverage := p.Close().Coverage()
+	if len(coverage) != len(codes) {
+		t.Fatalf("wrong number of covered codes: have %d, want %d", len(coverage), len(codes))
+	}
+	for _, c := range coverage {
+		if c.Executed > c.Blocks {
+			t.Errorf("code %s: executed %d of %d blocks", c.Code, c.Executed, c.Blocks)
+		}
+	}
+}
+
 func TestCall(t *testing.T) {
 	state, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
 	address := common.HexToAddress("0x0a")
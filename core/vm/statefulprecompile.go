@@ -0,0 +1,281 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// PrecompileCall describes a call of a stateful precompiled contract.
+type PrecompileCall struct {
+	StateDB  StateDB
+	BlockCtx BlockContext
+	TxCtx    TxContext
+	Caller   common.Address // account calling the precompile
+	Address  common.Address // account whose state is accessed, the caller's for CALLCODE and DELEGATECALL
+	Value    *big.Int       // value transferred with the call
+	ReadOnly bool           // whether state modifications are prohibited (STATICCALL)
+}
+
+// PrecompiledCallContract is a stateful precompiled contract receiving the
+// context of its calls. Plain PrecompiledStateContracts are only executed by
+// CALL, contracts implementing this interface by all call opcodes.
+type PrecompiledCallContract interface {
+	PrecompiledStateContract
+	RunCall(call *PrecompileCall, input []byte, suppliedGas uint64) ([]byte, uint64, error)
+}
+
+// revertSelector is the selector of Error(string), the ABI encoding of
+// revert reasons.
+var revertSelector = crypto.Keccak256([]byte("Error(string)"))[:4]
+
+// PrecompileMethodFunc implements a method of a StatefulPrecompile. It
+// receives the unpacked arguments of the call and returns the values of the
+// method's outputs.
+type PrecompileMethodFunc func(ctx *PrecompileContext, args []interface{}) ([]interface{}, error)
+
+// PrecompileMethod is the implementation of an ABI method.
+type PrecompileMethod struct {
+	Gas uint64               // gas charged before the method is executed
+	Run PrecompileMethodFunc // implementation of the method
+}
+
+type precompileMethod struct {
+	method abi.Method
+	impl   PrecompileMethod
+}
+
+// StatefulPrecompile is a precompiled contract dispatching calls to native
+// methods by the selectors of its ABI. The framework
+//   - charges the declared gas of a method before executing it,
+//   - rejects calls of non-constant methods in static calls and of
+//     non-payable methods transferring value,
+//   - reverts the state changes of failed calls and returns the error of a
+//     method as revert reason, unless it is ErrOutOfGas or ErrWriteProtection,
+//     which consume all gas like the equivalent EVM exceptions.
+//
+// The state of the precompile is kept in the StateDB, which journals all
+// modifications. They are thus reverted along with the calling frame. The
+// account of the precompile has to be activated, see
+// ActivateStatePrecompile, before its storage is used.
+type StatefulPrecompile struct {
+	address common.Address
+	abi     abi.ABI
+	methods map[string]*precompileMethod // methods by selector
+}
+
+// NewStatefulPrecompile creates a precompile at address implementing the
+// given ABI. Every method of the ABI requires an implementation.
+func NewStatefulPrecompile(address common.Address, contractABI abi.ABI, methods map[string]PrecompileMethod) (*StatefulPrecompile, error) {
+	p := &StatefulPrecompile{
+		address: address,
+		abi:     contractABI,
+		methods: make(map[string]*precompileMethod),
+	}
+	for name, impl := range methods {
+		method, ok := contractABI.Methods[name]
+		if !ok {
+			return nil, fmt.Errorf("method %s not in ABI", name)
+		}
+		if impl.Run == nil {
+			return nil, fmt.Errorf("method %s has no implementation", name)
+		}
+		p.methods[string(method.ID)] = &precompileMethod{method: method, impl: impl}
+	}
+	for name := range contractABI.Methods {
+		if _, ok := methods[name]; !ok {
+			return nil, fmt.Errorf("method %s not implemented", name)
+		}
+	}
+	return p, nil
+}
+
+// Address returns the address of the precompile.
+func (p *StatefulPrecompile) Address() common.Address {
+	return p.address
+}
+
+// ABI returns the ABI of the precompile.
+func (p *StatefulPrecompile) ABI() abi.ABI {
+	return p.abi
+}
+
+// Run executes a plain CALL of the precompile, see RunCall.
+func (p *StatefulPrecompile) Run(stateDB StateDB, blockCtx BlockContext, txCtx TxContext, caller common.Address, input []byte, suppliedGas uint64) ([]byte, uint64, error) {
+	call := &PrecompileCall{
+		StateDB:  stateDB,
+		BlockCtx: blockCtx,
+		TxCtx:    txCtx,
+		Caller:   caller,
+		Address:  p.address,
+		Value:    new(big.Int),
+	}
+	return p.RunCall(call, input, suppliedGas)
+}
+
+// RunCall executes the method selected by the first four bytes of input.
+func (p *StatefulPrecompile) RunCall(call *PrecompileCall, input []byte, suppliedGas uint64) ([]byte, uint64, error) {
+	if len(input) < 4 {
+		return nil, suppliedGas, ErrExecutionReverted
+	}
+	m, ok := p.methods[string(input[:4])]
+	if !ok {
+		return nil, suppliedGas, ErrExecutionReverted
+	}
+	if suppliedGas < m.impl.Gas {
+		return nil, 0, ErrOutOfGas
+	}
+	if call.ReadOnly && !m.method.IsConstant() {
+		return nil, 0, ErrWriteProtection
+	}
+	ctx := &PrecompileContext{PrecompileCall: call, precompile: p, gas: suppliedGas - m.impl.Gas}
+	if call.Value != nil && call.Value.Sign() != 0 && !m.method.IsPayable() {
+		return packRevert("non-payable method " + m.method.Name), ctx.gas, ErrExecutionReverted
+	}
+	args, err := m.method.Inputs.Unpack(input[4:])
+	if err != nil {
+		return packRevert("invalid arguments: " + err.Error()), ctx.gas, ErrExecutionReverted
+	}
+	snapshot := call.StateDB.Snapshot()
+	results, err := m.impl.Run(ctx, args)
+	var ret []byte
+	if err == nil {
+		if ret, err = m.method.Outputs.Pack(results...); err != nil {
+			err = fmt.Errorf("invalid results of %s: %v", m.method.Name, err)
+		}
+	}
+	if err == nil {
+		return ret, ctx.gas, nil
+	}
+	call.StateDB.RevertToSnapshot(snapshot)
+	switch {
+	case errors.Is(err, ErrExecutionReverted):
+		return nil, ctx.gas, ErrExecutionReverted
+	case errors.Is(err, ErrOutOfGas), errors.Is(err, ErrWriteProtection):
+		return nil, 0, err
+	}
+	return packRevert(err.Error()), ctx.gas, ErrExecutionReverted
+}
+
+// ActivateStatePrecompile prepares the account of a stateful precompile at
+// addr before its first use. Accounts without nonce, balance and code are
+// removed as empty at the end of a transaction (EIP-158), regardless of their
+// storage, so the nonce of the account is set to 1. Accounts which are not
+// empty are left unchanged.
+func ActivateStatePrecompile(statedb StateDB, addr common.Address) {
+	if statedb.GetNonce(addr) == 0 {
+		statedb.SetNonce(addr, 1)
+	}
+}
+
+// packRevert returns the ABI encoding of a revert reason.
+func packRevert(reason string) []byte {
+	typ, _ := abi.NewType("string", "", nil)
+	data, _ := abi.Arguments{{Type: typ}}.Pack(reason)
+	return append(common.CopyBytes(revertSelector), data...)
+}
+
+// PrecompileContext is the environment of a precompile method. State
+// modifications through the context are rejected in static calls.
+type PrecompileContext struct {
+	*PrecompileCall
+	precompile *StatefulPrecompile
+	gas        uint64 // remaining gas
+}
+
+// Gas returns the remaining gas of the call.
+func (c *PrecompileContext) Gas() uint64 {
+	return c.gas
+}
+
+// UseGas charges gas in addition to the declared gas of the method.
+func (c *PrecompileContext) UseGas(gas uint64) error {
+	if c.gas < gas {
+		return ErrOutOfGas
+	}
+	c.gas -= gas
+	return nil
+}
+
+// GetState returns a storage slot of the accessed account.
+func (c *PrecompileContext) GetState(key common.Hash) common.Hash {
+	return c.StateDB.GetState(c.Address, key)
+}
+
+// SetState modifies a storage slot of the accessed account.
+func (c *PrecompileContext) SetState(key, value common.Hash) error {
+	if c.ReadOnly {
+		return ErrWriteProtection
+	}
+	c.StateDB.SetState(c.Address, key, value)
+	return nil
+}
+
+// Emit logs an event of the precompile's ABI. The arguments are given in
+// the order of the event's inputs.
+func (c *PrecompileContext) Emit(name string, args ...interface{}) error {
+	if c.ReadOnly {
+		return ErrWriteProtection
+	}
+	event, ok := c.precompile.abi.Events[name]
+	if !ok {
+		return fmt.Errorf("unknown event %s", name)
+	}
+	if len(args) != len(event.Inputs) {
+		return fmt.Errorf("event %s: have %d arguments, want %d", name, len(args), len(event.Inputs))
+	}
+	var (
+		indexed [][]interface{}
+		data    []interface{}
+	)
+	for i, input := range event.Inputs {
+		if input.Indexed {
+			indexed = append(indexed, []interface{}{args[i]})
+		} else {
+			data = append(data, args[i])
+		}
+	}
+	var topics []common.Hash
+	if !event.Anonymous {
+		topics = append(topics, event.ID)
+	}
+	hashes, err := abi.MakeTopics(indexed...)
+	if err != nil {
+		return fmt.Errorf("event %s: %v", name, err)
+	}
+	for _, hash := range hashes {
+		topics = append(topics, hash[0])
+	}
+	packed, err := event.Inputs.NonIndexed().Pack(data...)
+	if err != nil {
+		return fmt.Errorf("event %s: %v", name, err)
+	}
+	c.StateDB.AddLog(&types.Log{
+		Address:     c.Address,
+		Topics:      topics,
+		Data:        packed,
+		BlockNumber: c.BlockCtx.BlockNumber.Uint64(),
+	})
+	return nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	substate "github.com/Fantom-foundation/Substate"
+	"github.com/ethereum/go-ethereum/core/state"
+)
+
+// SubstateRecorder receives the substate of every transaction of the blocks
+// processed with a Config that has the recorder set. The record holds the
+// input alloc, the block environment, the message, the output alloc and the
+// result of the transaction. Transactions applied outside of block processing,
+// e.g. by the miner, are not recorded. Errors of the recorder are logged and
+// do not fail the block.
+//
+// Recording is scoped to the StateDB and EVM the transaction runs on, so
+// multiple chain instances in one process can record independently.
+type SubstateRecorder interface {
+	RecordSubstate(block uint64, tx int, record *substate.Substate) error
+}
+
+// SubstateAccessRecorder is an optional extension of SubstateRecorder. If the
+// configured recorder implements it, it additionally receives the read and
+// write set of every transaction, e.g. for dependency analysis.
+type SubstateAccessRecorder interface {
+	RecordAccessSet(block uint64, tx int, set state.AccessSet) error
+}
+
+// SubstateDBRecorder is a SubstateRecorder writing all records into a
+// substate database.
+type SubstateDBRecorder struct {
+	DB *substate.SubstateDB
+}
+
+// NewSubstateDBRecorder returns a recorder storing substates in db.
+func NewSubstateDBRecorder(db *substate.SubstateDB) *SubstateDBRecorder {
+	return &SubstateDBRecorder{DB: db}
+}
+
+// RecordSubstate implements SubstateRecorder.
+func (r *SubstateDBRecorder) RecordSubstate(block uint64, tx int, record *substate.Substate) error {
+	r.DB.PutSubstate(block, tx, record)
+	return nil
+}
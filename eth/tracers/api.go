@@ -20,6 +20,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -153,12 +154,15 @@ func (api *API) blockByNumberAndHash(ctx context.Context, number rpc.BlockNumber
 	return api.blockByHash(ctx, hash)
 }
 
-// TraceConfig holds extra parameters to trace functions.
+// TraceConfig holds extra parameters to trace functions. Several tracers are
+// run in a single execution by the muxTracer, its TracerConfig maps the names
+// of the tracers to their configs.
 type TraceConfig struct {
 	*vm.LogConfig
-	Tracer  *string
-	Timeout *string
-	Reexec  *uint64
+	Tracer       *string
+	TracerConfig json.RawMessage
+	Timeout      *string
+	Reexec       *uint64
 }
 
 // TraceCallConfig is the config for traceCall API. It holds one more
@@ -166,6 +170,7 @@ type TraceConfig struct {
 type TraceCallConfig struct {
 	*vm.LogConfig
 	Tracer         *string
+	TracerConfig   json.RawMessage
 	Timeout        *string
 	Reexec         *uint64
 	StateOverrides *ethapi.StateOverride
@@ -495,6 +500,12 @@ func (api *API) traceBlock(ctx context.Context, block *types.Block, config *Trac
 	if err != nil {
 		return nil, err
 	}
+	return api.traceBlockOnState(ctx, block, statedb, config)
+}
+
+// traceBlockOnState traces the transactions of the block on top of the given
+// state of its parent. The state is advanced to the end of the transactions.
+func (api *API) traceBlockOnState(ctx context.Context, block *types.Block, statedb *state.StateDB, config *TraceConfig) ([]*txTraceResult, error) {
 	// Execute all the transaction contained within the block concurrently
 	var (
 		signer  = types.MakeSigner(api.backend.ChainConfig(), block.Number())
@@ -764,10 +775,11 @@ func (api *API) TraceCall(ctx context.Context, args ethapi.TransactionArgs, bloc
 	var traceConfig *TraceConfig
 	if config != nil {
 		traceConfig = &TraceConfig{
-			LogConfig: config.LogConfig,
-			Tracer:    config.Tracer,
-			Timeout:   config.Timeout,
-			Reexec:    config.Reexec,
+			LogConfig:    config.LogConfig,
+			Tracer:       config.Tracer,
+			TracerConfig: config.TracerConfig,
+			Timeout:      config.Timeout,
+			Reexec:       config.Reexec,
 		}
 	}
 	return api.traceTx(ctx, msg, new(Context), vmctx, statedb, traceConfig)
@@ -777,7 +789,7 @@ func (api *API) TraceCall(ctx context.Context, args ethapi.TransactionArgs, bloc
 // executes the given message in the provided environment. The return value will
 // be tracer dependent.
 func (api *API) traceTx(ctx context.Context, message core.Message, txctx *Context, vmctx vm.BlockContext, statedb *state.StateDB, config *TraceConfig) (interface{}, error) {
-	// Assemble the structured logger or the JavaScript tracer
+	// Assemble the structured logger or the native or JavaScript tracer
 	var (
 		tracer    vm.Tracer
 		err       error
@@ -792,16 +804,20 @@ func (api *API) traceTx(ctx context.Context, message core.Message, txctx *Contex
 				return nil, err
 			}
 		}
-		// Constuct the JavaScript tracer to execute with
-		if tracer, err = New(*config.Tracer, txctx); err != nil {
+		// Construct the native or JavaScript tracer to execute with
+		var txTracer TxTracer
+		if txTracer, err = NewTracer(*config.Tracer, txctx, config.TracerConfig); err != nil {
 			return nil, err
 		}
+		// Stop the profilers' collectors even if the execution fails
+		defer release(txTracer)
+		tracer = txTracer
 		// Handle timeouts and RPC cancellations
 		deadlineCtx, cancel := context.WithTimeout(ctx, timeout)
 		go func() {
 			<-deadlineCtx.Done()
 			if deadlineCtx.Err() == context.DeadlineExceeded {
-				tracer.(*Tracer).Stop(errors.New("execution timeout"))
+				txTracer.Stop(errors.New("execution timeout"))
 			}
 		}()
 		defer cancel()
@@ -812,8 +828,11 @@ func (api *API) traceTx(ctx context.Context, message core.Message, txctx *Contex
 	default:
 		tracer = vm.NewStructLogger(config.LogConfig)
 	}
-	// Run the transaction with tracing enabled.
-	vmenv := vm.NewEVM(vmctx, txContext, statedb, api.backend.ChainConfig(), vm.Config{Debug: true, Tracer: tracer, NoBaseFee: true})
+	// Run the transaction with tracing enabled, tracers may instrument the
+	// interpreter beyond the tracer interface.
+	vmConfig := vm.Config{Debug: true, Tracer: tracer, NoBaseFee: true}
+	configureVM(tracer, &vmConfig)
+	vmenv := vm.NewEVM(vmctx, txContext, statedb, api.backend.ChainConfig(), vmConfig)
 
 	// Call Prepare to clear out the statedb access list
 	statedb.Prepare(txctx.TxHash, txctx.TxIndex)
@@ -838,7 +857,7 @@ func (api *API) traceTx(ctx context.Context, message core.Message, txctx *Contex
 			StructLogs:  ethapi.FormatLogs(tracer.StructLogs()),
 		}, nil
 
-	case *Tracer:
+	case TxTracer:
 		return tracer.GetResult()
 
 	default:
@@ -856,5 +875,11 @@ func APIs(backend Backend) []rpc.API {
 			Service:   NewAPI(backend),
 			Public:    false,
 		},
+		{
+			Namespace: "trace",
+			Version:   "1.0",
+			Service:   NewTraceAPI(backend),
+			Public:    false,
+		},
 	}
 }
@@ -0,0 +1,364 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	// DefaultExportShardSize is the number of blocks written to a shard if
+	// the shard size of an export is not set.
+	DefaultExportShardSize = 1000
+
+	// ExportManifestName is the name of the manifest file of an export.
+	ExportManifestName = "manifest.json"
+)
+
+// ExportConfig configures an export of the traces of a block range.
+type ExportConfig struct {
+	Dir       string       // directory the shards and manifest are written to
+	First     uint64       // first block to trace
+	Last      uint64       // last block to trace
+	ShardSize uint64       // number of blocks per shard
+	Workers   int          // number of shards traced concurrently
+	Trace     *TraceConfig // tracer to run, must name a tracer
+}
+
+// ExportManifest describes an export and the shards already written. It is
+// rewritten after every shard, so an interrupted export can be resumed.
+type ExportManifest struct {
+	Tracer       string          `json:"tracer"`
+	TracerConfig json.RawMessage `json:"tracerConfig,omitempty"`
+	First        uint64          `json:"first"`
+	Last         uint64          `json:"last"`
+	ShardSize    uint64          `json:"shardSize"`
+	Shards       []*ExportShard  `json:"shards"` // completed shards, sorted by first block
+}
+
+// ExportShard is a gzip compressed file holding the traces of a block range,
+// one JSON object per transaction and line.
+type ExportShard struct {
+	File  string `json:"file"`
+	First uint64 `json:"first"`
+	Last  uint64 `json:"last"`
+	Txs   int    `json:"txs"`
+}
+
+// exportedTrace is a line of a shard.
+type exportedTrace struct {
+	Block     uint64      `json:"block"`
+	BlockHash common.Hash `json:"blockHash"`
+	TxIndex   int         `json:"txIndex"`
+	TxHash    common.Hash `json:"txHash"`
+	Result    interface{} `json:"result,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// LoadExportManifest reads the manifest of the export in the given directory.
+// Nil is returned if there is none.
+func LoadExportManifest(dir string) (*ExportManifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, ExportManifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	manifest := new(ExportManifest)
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest in %s: %v", dir, err)
+	}
+	return manifest, nil
+}
+
+// save writes the manifest to the given directory. The file is replaced
+// atomically, so a crash never leaves a partial manifest behind.
+func (m *ExportManifest) save(dir string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(dir, ExportManifestName)
+	if err := ioutil.WriteFile(file+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+// compatible returns an error if the other manifest describes a different
+// export.
+func (m *ExportManifest) compatible(other *ExportManifest) error {
+	switch {
+	case m.Tracer != other.Tracer:
+		return fmt.Errorf("tracer mismatch: have %s, want %s", other.Tracer, m.Tracer)
+	case !bytes.Equal(m.TracerConfig, other.TracerConfig):
+		return fmt.Errorf("tracer config mismatch: have %s, want %s", other.TracerConfig, m.TracerConfig)
+	case m.First != other.First || m.Last != other.Last:
+		return fmt.Errorf("block range mismatch: have %d-%d, want %d-%d", other.First, other.Last, m.First, m.Last)
+	case m.ShardSize != other.ShardSize:
+		return fmt.Errorf("shard size mismatch: have %d, want %d", other.ShardSize, m.ShardSize)
+	}
+	return nil
+}
+
+// shardFile returns the file name of the shard of the given block range.
+func shardFile(first, last uint64) string {
+	return fmt.Sprintf("traces-%09d-%09d.jsonl.gz", first, last)
+}
+
+// ExportTraces traces the blocks of the configured range and writes the
+// results to shards of the export directory. Shards are traced by multiple
+// workers concurrently. Shards listed in the manifest of a previous run of
+// the same export are skipped, so an interrupted export continues where it
+// stopped.
+func ExportTraces(ctx context.Context, backend Backend, config *ExportConfig) (*ExportManifest, error) {
+	if config.Trace == nil || config.Trace.Tracer == nil {
+		return nil, errors.New("no tracer specified")
+	}
+	if config.First > config.Last {
+		return nil, fmt.Errorf("first block %d is larger than last block %d", config.First, config.Last)
+	}
+	if config.First == 0 {
+		return nil, errors.New("genesis is not traceable")
+	}
+	shardSize, workers := config.ShardSize, config.Workers
+	if shardSize == 0 {
+		shardSize = DefaultExportShardSize
+	}
+	if workers <= 0 {
+		workers = 1
+	}
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, err
+	}
+	manifest := &ExportManifest{
+		Tracer:       *config.Trace.Tracer,
+		TracerConfig: config.Trace.TracerConfig,
+		First:        config.First,
+		Last:         config.Last,
+		ShardSize:    shardSize,
+		Shards:       []*ExportShard{},
+	}
+	prev, err := LoadExportManifest(config.Dir)
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[uint64]bool)
+	if prev != nil {
+		if err := manifest.compatible(prev); err != nil {
+			return nil, fmt.Errorf("incompatible export in %s: %v", config.Dir, err)
+		}
+		// Shards whose file went missing are traced again.
+		for _, shard := range prev.Shards {
+			if _, err := os.Stat(filepath.Join(config.Dir, shard.File)); err == nil {
+				manifest.Shards = append(manifest.Shards, shard)
+				done[shard.First] = true
+			}
+		}
+		log.Info("Resuming trace export", "dir", config.Dir, "done", len(manifest.Shards))
+	}
+	if err := manifest.save(config.Dir); err != nil {
+		return nil, err
+	}
+	// Assemble the shards left to trace.
+	var pending []*ExportShard
+	for first := config.First; first <= config.Last; first += shardSize {
+		last := first + shardSize - 1
+		if last > config.Last || last < first {
+			last = config.Last
+		}
+		if !done[first] {
+			pending = append(pending, &ExportShard{File: shardFile(first, last), First: first, Last: last})
+		}
+		if last == config.Last {
+			break
+		}
+	}
+	var (
+		api   = NewAPI(backend)
+		tasks = make(chan *ExportShard, len(pending))
+		start = time.Now()
+
+		lock     sync.Mutex
+		failure  error
+		wg       sync.WaitGroup
+		exported int
+	)
+	for _, shard := range pending {
+		tasks <- shard
+	}
+	close(tasks)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for shard := range tasks {
+				if ctx.Err() != nil {
+					return
+				}
+				err := api.exportShard(ctx, config.Dir, shard, config.Trace)
+
+				lock.Lock()
+				if err == nil {
+					manifest.Shards = append(manifest.Shards, shard)
+					sort.Slice(manifest.Shards, func(i, j int) bool { return manifest.Shards[i].First < manifest.Shards[j].First })
+					err = manifest.save(config.Dir)
+				}
+				if err != nil {
+					if failure == nil {
+						failure = err
+					}
+					cancel()
+				} else {
+					exported++
+					log.Info("Exported trace shard", "first", shard.First, "last", shard.Last, "txs", shard.Txs,
+						"shards", exported, "remaining", len(pending)-exported, "elapsed", common.PrettyDuration(time.Since(start)))
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if failure == nil {
+		failure = ctx.Err()
+	}
+	if failure != nil {
+		return nil, failure
+	}
+	return manifest, nil
+}
+
+// exportShard traces the blocks of the shard and writes their traces to the
+// shard file. The blocks are traced in order, only the state of the first one
+// is regenerated, the following ones carry the state forward like traceChain.
+// The file is written under a temporary name and only renamed once complete.
+func (api *API) exportShard(ctx context.Context, dir string, shard *ExportShard, config *TraceConfig) (err error) {
+	tmp := filepath.Join(dir, shard.File+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+		}
+	}()
+	var (
+		buf = bufio.NewWriter(f)
+		gz  = gzip.NewWriter(buf)
+		enc = json.NewEncoder(gz)
+
+		reexec  = defaultTraceReexec
+		statedb *state.StateDB
+		root    common.Hash
+	)
+	if config.Reexec != nil {
+		reexec = *config.Reexec
+	}
+	// Release the state held for tracing on any exit path.
+	defer func() {
+		if statedb != nil && statedb.Database().TrieDB() != nil {
+			statedb.Database().TrieDB().Dereference(root)
+		}
+	}()
+	parent, err := api.blockByNumber(ctx, rpc.BlockNumber(shard.First-1))
+	if err != nil {
+		return err
+	}
+	shard.Txs = 0
+	for number := shard.First; number <= shard.Last; number++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Advance the state to the parent of the block, processing the
+		// parent on top of the previous state without tracing it.
+		next, err := api.backend.StateAtBlock(ctx, parent, reexec, statedb, false)
+		if err != nil {
+			return err
+		}
+		if next.Database().TrieDB() != nil {
+			next.Database().TrieDB().Reference(parent.Root(), common.Hash{})
+			if statedb != nil {
+				statedb.Database().TrieDB().Dereference(root)
+			}
+		}
+		statedb, root = next, parent.Root()
+
+		block, err := api.blockByNumber(ctx, rpc.BlockNumber(number))
+		if err != nil {
+			return err
+		}
+		results, err := api.traceBlockOnState(ctx, block, statedb.Copy(), config)
+		if err != nil {
+			return fmt.Errorf("failed to trace block %d: %v", number, err)
+		}
+		for i, tx := range block.Transactions() {
+			trace := &exportedTrace{
+				Block:     number,
+				BlockHash: block.Hash(),
+				TxIndex:   i,
+				TxHash:    tx.Hash(),
+				Result:    results[i].Result,
+				Error:     results[i].Error,
+			}
+			if err := enc.Encode(trace); err != nil {
+				return err
+			}
+		}
+		shard.Txs += len(results)
+		parent = block
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	// Make sure the shard is on disk before it is renamed and listed in the
+	// manifest, a crash must not leave a truncated shard behind.
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, shard.File))
+}
@@ -0,0 +1,133 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// readShard returns the traces of the given shard file.
+func readShard(t *testing.T, file string) []*exportedTrace {
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("failed to open shard: %v", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("failed to decompress shard: %v", err)
+	}
+	var traces []*exportedTrace
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		trace := new(exportedTrace)
+		if err := json.Unmarshal(scanner.Bytes(), trace); err != nil {
+			t.Fatalf("failed to parse trace: %v", err)
+		}
+		traces = append(traces, trace)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("failed to read shard: %v", err)
+	}
+	return traces
+}
+
+func TestExportTraces(t *testing.T) {
+	t.Parallel()
+
+	// Initialize test accounts
+	accounts := newAccounts(2)
+	genesis := &core.Genesis{Alloc: core.GenesisAlloc{
+		accounts[0].addr: {Balance: big.NewInt(params.Ether)},
+		accounts[1].addr: {Balance: big.NewInt(params.Ether)},
+	}}
+	genBlocks := 10
+	signer := types.HomesteadSigner{}
+	backend := newTestBackend(t, genBlocks, genesis, func(i int, b *core.BlockGen) {
+		// Transfer from account[0] to account[1]
+		//    value: 1000 wei
+		//    fee:   0 wei
+		tx, _ := types.SignTx(types.NewTransaction(uint64(i), accounts[1].addr, big.NewInt(1000), params.TxGas, b.BaseFee(), nil), signer, accounts[0].key)
+		b.AddTx(tx)
+	})
+	dir, err := ioutil.TempDir("", "trace-export")
+	if err != nil {
+		t.Fatalf("failed to create export directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := &ExportConfig{
+		Dir:       dir,
+		First:     1,
+		Last:      uint64(genBlocks),
+		ShardSize: 3,
+		Workers:   2,
+		Trace:     tracerConfig(callTracerName),
+	}
+	manifest, err := ExportTraces(context.Background(), backend, config)
+	if err != nil {
+		t.Fatalf("failed to export traces: %v", err)
+	}
+	if len(manifest.Shards) != 4 {
+		t.Fatalf("shard count mismatch: have %d, want 4", len(manifest.Shards))
+	}
+	next := config.First
+	for _, shard := range manifest.Shards {
+		if shard.First != next {
+			t.Fatalf("shard %s starts at %d, want %d", shard.File, shard.First, next)
+		}
+		traces := readShard(t, filepath.Join(dir, shard.File))
+		if len(traces) != shard.Txs || len(traces) != int(shard.Last-shard.First+1) {
+			t.Fatalf("shard %s trace count mismatch: have %d, want %d", shard.File, len(traces), shard.Txs)
+		}
+		for i, trace := range traces {
+			if trace.Block != shard.First+uint64(i) || trace.Error != "" || trace.Result == nil {
+				t.Errorf("shard %s trace %d mismatch: %+v", shard.File, i, trace)
+			}
+		}
+		next = shard.Last + 1
+	}
+	// Resume the export with a shard missing, only that one is traced again.
+	missing := filepath.Join(dir, manifest.Shards[1].File)
+	os.Remove(missing)
+	if manifest, err = ExportTraces(context.Background(), backend, config); err != nil {
+		t.Fatalf("failed to resume export: %v", err)
+	}
+	if len(manifest.Shards) != 4 {
+		t.Fatalf("shard count mismatch after resume: have %d, want 4", len(manifest.Shards))
+	}
+	if _, err := os.Stat(missing); err != nil {
+		t.Errorf("missing shard not exported again: %v", err)
+	}
+	// Resuming with a different configuration is rejected.
+	config.ShardSize = 5
+	if _, err := ExportTraces(context.Background(), backend, config); err == nil {
+		t.Error("expected error resuming an incompatible export")
+	}
+}
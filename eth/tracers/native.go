@@ -0,0 +1,106 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/uint256"
+)
+
+// TxTracer is a tracer of a single transaction producing a JSON result. It is
+// implemented by the JavaScript Tracer as well as by all native tracers.
+type TxTracer interface {
+	vm.Tracer
+
+	// GetResult returns the result of the trace, or any error that occurred
+	// while tracing.
+	GetResult() (json.RawMessage, error)
+
+	// Stop terminates the trace at the first opportune moment, the error is
+	// returned by GetResult.
+	Stop(err error)
+}
+
+// NativeTracerFactory creates a native tracer for a transaction. The config is
+// the tracer specific configuration passed by the user, it is nil if none was
+// given.
+type NativeTracerFactory func(ctx *Context, config json.RawMessage) (TxTracer, error)
+
+var (
+	nativeLock sync.RWMutex
+	native     = make(map[string]NativeTracerFactory)
+)
+
+// RegisterNativeTracer makes a native tracer available under the given name.
+// Native tracers take precedence over the JavaScript tracers of the same name.
+// It panics if a native tracer is already registered under the name.
+func RegisterNativeTracer(name string, factory NativeTracerFactory) {
+	nativeLock.Lock()
+	defer nativeLock.Unlock()
+
+	if _, ok := native[name]; ok {
+		panic(fmt.Sprintf("native tracer %q already registered", name))
+	}
+	native[name] = factory
+}
+
+// NativeTracers returns the names of all registered native tracers in
+// alphabetical order.
+func NativeTracers() []string {
+	nativeLock.RLock()
+	defer nativeLock.RUnlock()
+
+	names := make([]string, 0, len(native))
+	for name := range native {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewTracer creates the tracer specified by code. If code is the name of a
+// native tracer, the native one is created with the given config. Otherwise
+// code is passed on to New, i.e. it is either the name of a built in
+// JavaScript tracer or a JavaScript snippet, and the config is ignored.
+func NewTracer(code string, ctx *Context, config json.RawMessage) (TxTracer, error) {
+	nativeLock.RLock()
+	factory, ok := native[code]
+	nativeLock.RUnlock()
+
+	if ok {
+		return factory(ctx, config)
+	}
+	return New(code, ctx)
+}
+
+// memorySlice returns a copy of size bytes of memory at offset, or nil if the
+// range exceeds the memory, like the slice method of the JavaScript tracers.
+func memorySlice(memory *vm.Memory, offset, size *uint256.Int) []byte {
+	if !offset.IsUint64() || !size.IsUint64() {
+		return nil
+	}
+	begin, length := offset.Uint64(), size.Uint64()
+	if begin+length < begin || begin+length > uint64(memory.Len()) {
+		return nil
+	}
+	return memory.GetCopy(int64(begin), int64(length))
+}
@@ -0,0 +1,168 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/uint256"
+)
+
+func init() {
+	RegisterNativeTracer("4byteTracer", newFourByteTracer)
+}
+
+// fourByteTracer is the native version of 4byte_tracer.js, it counts the
+// 4 byte function selectors and call data sizes of the calls of a
+// transaction. The result maps "<selector>-<size>" to the number of calls, the
+// size excludes the selector.
+type fourByteTracer struct {
+	ids               []string       // ids in the order they were first seen
+	counts            map[string]int // number of calls per id
+	input             []byte         // input of the transaction
+	activePrecompiles []common.Address
+
+	interrupt uint32 // atomic flag to signal the tracer to stop
+	reason    error  // reason of the interrupt
+	err       error  // error stopping the trace
+}
+
+// newFourByteTracer creates a native 4byte tracer, it takes no config.
+func newFourByteTracer(ctx *Context, config json.RawMessage) (TxTracer, error) {
+	return &fourByteTracer{counts: make(map[string]int)}, nil
+}
+
+// store counts a call with the given selector and data size.
+func (t *fourByteTracer) store(id []byte, size string) {
+	key := hexutil.Encode(id) + "-" + size
+	if _, ok := t.counts[key]; !ok {
+		t.ids = append(t.ids, key)
+	}
+	t.counts[key]++
+}
+
+// isPrecompiled reports whether the address is an active precompile.
+func (t *fourByteTracer) isPrecompiled(addr common.Address) bool {
+	for _, p := range t.activePrecompiles {
+		if p == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// CaptureStart implements the Tracer interface to initialize the tracing operation.
+func (t *fourByteTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	t.input = common.CopyBytes(input)
+
+	t.activePrecompiles = vm.ActiveChainPrecompiles(env.ChainConfig(), env.Context.BlockNumber)
+}
+
+// CaptureState implements the Tracer interface to trace a single step of VM execution.
+func (t *fourByteTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	if t.err != nil {
+		return
+	}
+	// If tracing was interrupted, set the error and stop
+	if atomic.LoadUint32(&t.interrupt) > 0 {
+		t.err = t.reason
+		return
+	}
+	// Stack position of the input offset, the size follows it.
+	var pos int
+	switch op {
+	case vm.CALL, vm.CALLCODE:
+		pos = 3
+	case vm.DELEGATECALL, vm.STATICCALL:
+		pos = 2
+	default:
+		return
+	}
+	stack := scope.Stack
+	if t.isPrecompiled(stack.Back(1).Bytes20()) {
+		return
+	}
+	// The JavaScript tracer operates on the sizes as doubles.
+	size, _ := new(big.Float).SetInt(stack.Back(pos + 1).ToBig()).Float64()
+	if size < 4 {
+		return
+	}
+	selector := memorySlice(scope.Memory, stack.Back(pos), uint256.NewInt(4))
+	t.store(selector, jsNumberString(size-4))
+}
+
+// CaptureFault implements the Tracer interface, the 4byte tracer ignores faults.
+func (t *fourByteTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+}
+
+// CaptureEnd implements the Tracer interface, the 4byte tracer ignores the end
+// of the transaction.
+func (t *fourByteTracer) CaptureEnd(output []byte, gasUsed uint64, _ time.Duration, err error) {
+}
+
+// CaptureEnter implements the Tracer interface, the 4byte tracer ignores call
+// frames.
+func (t *fourByteTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+}
+
+// CaptureExit implements the Tracer interface, the 4byte tracer ignores call
+// frames.
+func (t *fourByteTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+}
+
+// GetResult returns the number of calls per selector and data size.
+func (t *fourByteTracer) GetResult() (json.RawMessage, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+	if len(t.input) >= 4 {
+		t.store(t.input[:4], strconv.Itoa(len(t.input)-4))
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, id := range t.ids {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, "%q:%d", id, t.counts[id])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Stop terminates execution of the tracer at the first opportune moment.
+func (t *fourByteTracer) Stop(err error) {
+	t.reason = err
+	atomic.StoreUint32(&t.interrupt, 1)
+}
+
+// jsNumberString formats a non-negative integral double like JavaScript does.
+func jsNumberString(f float64) string {
+	if f < 1e21 {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,171 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+func init() {
+	RegisterNativeTracer("callTracer", newCallTracer)
+}
+
+// callFrame is a call of the callTracer result. The fields are ordered like
+// the ones of the JavaScript call tracer.
+type callFrame struct {
+	Type    string          `json:"type"`
+	From    common.Address  `json:"from"`
+	To      *common.Address `json:"to,omitempty"`
+	Value   *hexutil.Big    `json:"value,omitempty"`
+	Gas     hexutil.Uint64  `json:"gas"`
+	GasUsed hexutil.Uint64  `json:"gasUsed"`
+	Input   hexutil.Bytes   `json:"input"`
+	Output  *hexutil.Bytes  `json:"output,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Calls   []*callFrame    `json:"calls,omitempty"`
+}
+
+// callTracer is the native version of call_tracer.js, it collects the call
+// frames of a transaction into a tree.
+type callTracer struct {
+	callstack []*callFrame
+
+	interrupt uint32 // atomic flag to signal the tracer to stop
+	reason    error  // reason of the interrupt
+	err       error  // error stopping the trace
+}
+
+// newCallTracer creates a native call tracer, it takes no config.
+func newCallTracer(ctx *Context, config json.RawMessage) (TxTracer, error) {
+	return new(callTracer), nil
+}
+
+// CaptureStart implements the Tracer interface to initialize the tracing operation.
+func (t *callTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	typ := vm.CALL.String()
+	if create {
+		typ = vm.CREATE.String()
+	}
+	t.callstack = []*callFrame{{
+		Type:  typ,
+		From:  from,
+		To:    &to,
+		Value: (*hexutil.Big)(new(big.Int).Set(value)),
+		Gas:   hexutil.Uint64(gas),
+		Input: common.CopyBytes(input),
+	}}
+}
+
+// CaptureState implements the Tracer interface, the call tracer ignores steps.
+func (t *callTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+}
+
+// CaptureFault implements the Tracer interface, the call tracer ignores faults.
+func (t *callTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *callTracer) CaptureEnd(output []byte, gasUsed uint64, _ time.Duration, err error) {
+	call := t.callstack[0]
+	call.GasUsed = hexutil.Uint64(gasUsed)
+
+	// Like the JavaScript tracer, the output of a failed call is only kept if
+	// it holds a revert reason.
+	if err != nil {
+		call.Error = err.Error()
+		if call.Error != vm.ErrExecutionReverted.Error() || len(output) == 0 {
+			return
+		}
+	}
+	out := hexutil.Bytes(common.CopyBytes(output))
+	call.Output = &out
+}
+
+// CaptureEnter is called when EVM enters a new scope (via call, create or selfdestruct).
+func (t *callTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	if t.err != nil {
+		return
+	}
+	// If tracing was interrupted, set the error and stop
+	if atomic.LoadUint32(&t.interrupt) > 0 {
+		t.err = t.reason
+		return
+	}
+	call := &callFrame{
+		Type:  typ.String(),
+		From:  from,
+		To:    &to,
+		Gas:   hexutil.Uint64(gas),
+		Input: common.CopyBytes(input),
+	}
+	if value != nil {
+		call.Value = (*hexutil.Big)(new(big.Int).Set(value))
+	}
+	t.callstack = append(t.callstack, call)
+}
+
+// CaptureExit is called when EVM exits a scope, even if the scope didn't
+// execute any code.
+func (t *callTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	if t.err != nil {
+		return
+	}
+	size := len(t.callstack)
+	if size <= 1 {
+		return
+	}
+	call := t.callstack[size-1]
+	t.callstack = t.callstack[:size-1]
+
+	call.GasUsed = hexutil.Uint64(gasUsed)
+	if err == nil {
+		out := hexutil.Bytes(common.CopyBytes(output))
+		call.Output = &out
+	} else {
+		call.Error = err.Error()
+		if call.Type == vm.CREATE.String() || call.Type == vm.CREATE2.String() {
+			call.To = nil
+		}
+	}
+	parent := t.callstack[size-2]
+	parent.Calls = append(parent.Calls, call)
+}
+
+// GetResult returns the call tree of the transaction.
+func (t *callTracer) GetResult() (json.RawMessage, error) {
+	if len(t.callstack) == 0 {
+		return nil, t.err
+	}
+	res, err := json.Marshal(t.callstack[0])
+	if err != nil {
+		return nil, err
+	}
+	return res, t.err
+}
+
+// Stop terminates execution of the tracer at the first opportune moment.
+func (t *callTracer) Stop(err error) {
+	t.reason = err
+	atomic.StoreUint32(&t.interrupt, 1)
+}
@@ -0,0 +1,330 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// profilerBufferSize is the number of records a profiler of a single
+// transaction queues before the interpreter blocks.
+const profilerBufferSize = 1024
+
+func init() {
+	RegisterNativeTracer("muxTracer", newMuxTracer)
+	RegisterNativeTracer("microProfiler", newMicroProfilerTracer)
+	RegisterNativeTracer("basicBlockProfiler", newBasicBlockProfilerTracer)
+}
+
+// vmConfigurer is implemented by tracers which instrument the interpreter
+// through the vm config rather than the tracer interface, like the profilers.
+// The config is adjusted before the transaction is executed.
+type vmConfigurer interface {
+	configureVM(cfg *vm.Config)
+}
+
+// configureVM applies the vm instrumentation of the tracer, if any, to the
+// given config.
+func configureVM(tracer vm.Tracer, cfg *vm.Config) {
+	if c, ok := tracer.(vmConfigurer); ok {
+		c.configureVM(cfg)
+	}
+}
+
+// releaser is implemented by tracers running background goroutines, like the
+// profilers. release stops them and must be called once the tracer is no
+// longer used, whether its result was retrieved or not.
+type releaser interface {
+	release()
+}
+
+// release frees the resources of the tracer, if any.
+func release(tracer vm.Tracer) {
+	if r, ok := tracer.(releaser); ok {
+		r.release()
+	}
+}
+
+// profilerKind is implemented by the profilers and the mux, profilerKinds
+// returns the names of the profilers configuring the vm.
+type profilerKind interface {
+	profilerKinds() []string
+}
+
+// muxTracer runs several tracers in a single execution of a transaction. Its
+// config maps the names of the member tracers to their configs, e.g.
+// {"callTracer": {}, "prestateTracer": {"diffMode": true}}, and the result
+// maps the names to the results of the members.
+type muxTracer struct {
+	names   []string
+	tracers []TxTracer
+}
+
+// newMuxTracer creates the member tracers listed in the config. Each kind of
+// profiler may occur once in the mux and its nested muxes, since the
+// profilers of a kind share a field of the vm config.
+func newMuxTracer(ctx *Context, config json.RawMessage) (TxTracer, error) {
+	var members map[string]json.RawMessage
+	if len(config) > 0 {
+		if err := json.Unmarshal(config, &members); err != nil {
+			return nil, fmt.Errorf("invalid muxTracer config: %v", err)
+		}
+	}
+	if len(members) == 0 {
+		return nil, errors.New("no tracers specified for muxTracer")
+	}
+	t := new(muxTracer)
+	for name := range members {
+		t.names = append(t.names, name)
+	}
+	sort.Strings(t.names)
+	for _, name := range t.names {
+		tracer, err := NewTracer(name, ctx, members[name])
+		if err != nil {
+			t.release()
+			return nil, fmt.Errorf("failed to create %s: %v", name, err)
+		}
+		t.tracers = append(t.tracers, tracer)
+	}
+	seen := make(map[string]bool)
+	for _, kind := range t.profilerKinds() {
+		if seen[kind] {
+			t.release()
+			return nil, fmt.Errorf("%s specified more than once in muxTracer", kind)
+		}
+		seen[kind] = true
+	}
+	return t, nil
+}
+
+// profilerKinds returns the profilers of all members, including the ones of
+// nested muxes.
+func (t *muxTracer) profilerKinds() []string {
+	var kinds []string
+	for _, tracer := range t.tracers {
+		if p, ok := tracer.(profilerKind); ok {
+			kinds = append(kinds, p.profilerKinds()...)
+		}
+	}
+	return kinds
+}
+
+// release frees the resources of all members.
+func (t *muxTracer) release() {
+	for _, tracer := range t.tracers {
+		release(tracer)
+	}
+}
+
+// configureVM applies the vm instrumentation of all members.
+func (t *muxTracer) configureVM(cfg *vm.Config) {
+	for _, tracer := range t.tracers {
+		configureVM(tracer, cfg)
+	}
+}
+
+// CaptureTxStart implements the vm.TxStartTracer interface, it forwards the gas
+// limit to the members which need it.
+func (t *muxTracer) CaptureTxStart(gasLimit uint64) {
+	for _, tracer := range t.tracers {
+		if ts, ok := tracer.(vm.TxStartTracer); ok {
+			ts.CaptureTxStart(gasLimit)
+		}
+	}
+}
+
+// CaptureStart implements the Tracer interface to initialize the tracing operation.
+func (t *muxTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	for _, tracer := range t.tracers {
+		tracer.CaptureStart(env, from, to, create, input, gas, value)
+	}
+}
+
+// CaptureState implements the Tracer interface to trace a single step of VM execution.
+func (t *muxTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	for _, tracer := range t.tracers {
+		tracer.CaptureState(env, pc, op, gas, cost, scope, rData, depth, err)
+	}
+}
+
+// CaptureFault implements the Tracer interface to trace an execution fault.
+func (t *muxTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+	for _, tracer := range t.tracers {
+		tracer.CaptureFault(env, pc, op, gas, cost, scope, depth, err)
+	}
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *muxTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) {
+	for _, tracer := range t.tracers {
+		tracer.CaptureEnd(output, gasUsed, d, err)
+	}
+}
+
+// CaptureEnter is called when EVM enters a new scope (via call, create or selfdestruct).
+func (t *muxTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	for _, tracer := range t.tracers {
+		tracer.CaptureEnter(typ, from, to, input, gas, value)
+	}
+}
+
+// CaptureExit is called when EVM exits a scope, even if the scope didn't
+// execute any code.
+func (t *muxTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	for _, tracer := range t.tracers {
+		tracer.CaptureExit(output, gasUsed, err)
+	}
+}
+
+// GetResult returns the results of the members by name.
+func (t *muxTracer) GetResult() (json.RawMessage, error) {
+	results := make(map[string]json.RawMessage, len(t.tracers))
+	for i, tracer := range t.tracers {
+		res, err := tracer.GetResult()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", t.names[i], err)
+		}
+		results[t.names[i]] = res
+	}
+	return json.Marshal(results)
+}
+
+// Stop terminates execution of all members at the first opportune moment.
+func (t *muxTracer) Stop(err error) {
+	for _, tracer := range t.tracers {
+		tracer.Stop(err)
+	}
+}
+
+// profilerTracer implements the tracer interface for the profilers, which
+// observe the interpreter through the vm config only.
+type profilerTracer struct{}
+
+func (profilerTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+}
+
+func (profilerTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+}
+
+func (profilerTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+}
+
+func (profilerTracer) CaptureEnd(output []byte, gasUsed uint64, _ time.Duration, err error) {}
+
+func (profilerTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+}
+
+func (profilerTracer) CaptureExit(output []byte, gasUsed uint64, err error) {}
+
+// Stop is a no-op, the profilers don't stop the execution.
+func (profilerTracer) Stop(err error) {}
+
+// profileTables encodes profiling tables as an object mapping the table names
+// to their rows.
+func profileTables(tables ...*vm.ProfileTable) (json.RawMessage, error) {
+	res := make(map[string]*vm.ProfileTable, len(tables))
+	for _, t := range tables {
+		res[t.Name] = t
+	}
+	return json.Marshal(res)
+}
+
+// microProfilerTracer collects the micro-profiling statistic of a
+// transaction. The config may set the maximal length of the profiled opcode
+// sequences, e.g. {"nGramLength": 3}, opcode sequences are not profiled if it
+// is omitted.
+type microProfilerTracer struct {
+	profilerTracer
+	profiler *vm.MicroProfiler
+}
+
+func newMicroProfilerTracer(ctx *Context, config json.RawMessage) (TxTracer, error) {
+	var cfg struct {
+		NGramLength int `json:"nGramLength"`
+	}
+	if len(config) > 0 {
+		if err := json.Unmarshal(config, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid microProfiler config: %v", err)
+		}
+	}
+	profiler := vm.NewMicroProfiler(profilerBufferSize)
+	if cfg.NGramLength != 0 {
+		if err := profiler.SetMaxNGramLength(cfg.NGramLength); err != nil {
+			profiler.Close()
+			return nil, err
+		}
+	}
+	return &microProfilerTracer{profiler: profiler}, nil
+}
+
+func (t *microProfilerTracer) configureVM(cfg *vm.Config) {
+	cfg.MicroProfiler = t.profiler
+}
+
+func (t *microProfilerTracer) profilerKinds() []string {
+	return []string{"microProfiler"}
+}
+
+// release stops the collector of the profiler.
+func (t *microProfilerTracer) release() {
+	t.profiler.Close()
+}
+
+// GetResult returns the micro-profiling tables of the transaction.
+func (t *microProfilerTracer) GetResult() (json.RawMessage, error) {
+	return profileTables(t.profiler.Close().Tables(params.VersionWithMeta())...)
+}
+
+// basicBlockProfilerTracer collects the basic-block frequencies of a
+// transaction, it takes no config.
+type basicBlockProfilerTracer struct {
+	profilerTracer
+	profiler *vm.BasicBlockProfiler
+}
+
+func newBasicBlockProfilerTracer(ctx *Context, config json.RawMessage) (TxTracer, error) {
+	return &basicBlockProfilerTracer{profiler: vm.NewBasicBlockProfiler(profilerBufferSize)}, nil
+}
+
+func (t *basicBlockProfilerTracer) configureVM(cfg *vm.Config) {
+	cfg.BasicBlockProfiler = t.profiler
+}
+
+func (t *basicBlockProfilerTracer) profilerKinds() []string {
+	return []string{"basicBlockProfiler"}
+}
+
+// release stops the collector of the profiler.
+func (t *basicBlockProfilerTracer) release() {
+	t.profiler.Close()
+}
+
+// GetResult returns the basic-block frequencies by contract and by code and
+// the static basic blocks of the codes executed by the transaction.
+func (t *basicBlockProfilerTracer) GetResult() (json.RawMessage, error) {
+	stats := t.profiler.Close()
+	return profileTables(stats.Table(), stats.CodeTable(), stats.StaticTable())
+}
@@ -0,0 +1,415 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func init() {
+	RegisterNativeTracer("prestateTracer", newPrestateTracer)
+}
+
+// prestateAccount is an account of the prestateTracer result. All fields are
+// set in the prestate, only the modified ones in the post state of the diff
+// mode.
+type prestateAccount struct {
+	Balance *hexutil.Big   `json:"balance,omitempty"`
+	Nonce   *uint64        `json:"nonce,omitempty"`
+	Code    *hexutil.Bytes `json:"code,omitempty"`
+	Storage *storageMap    `json:"storage,omitempty"`
+}
+
+// accountMap maps addresses to accounts, it is marshalled in insertion order
+// like the objects of the JavaScript tracers.
+type accountMap struct {
+	addrs    []common.Address
+	accounts map[common.Address]*prestateAccount
+}
+
+func newAccountMap() *accountMap {
+	return &accountMap{accounts: make(map[common.Address]*prestateAccount)}
+}
+
+func (m *accountMap) get(addr common.Address) *prestateAccount {
+	return m.accounts[addr]
+}
+
+func (m *accountMap) set(addr common.Address, account *prestateAccount) {
+	if _, ok := m.accounts[addr]; !ok {
+		m.addrs = append(m.addrs, addr)
+	}
+	m.accounts[addr] = account
+}
+
+func (m *accountMap) delete(addr common.Address) {
+	if _, ok := m.accounts[addr]; !ok {
+		return
+	}
+	delete(m.accounts, addr)
+	for i, a := range m.addrs {
+		if a == addr {
+			m.addrs = append(m.addrs[:i], m.addrs[i+1:]...)
+			break
+		}
+	}
+}
+
+// MarshalJSON marshals the accounts in insertion order.
+func (m *accountMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, addr := range m.addrs {
+		account, err := json.Marshal(m.accounts[addr])
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, "%q:%s", hexutil.Encode(addr[:]), account)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// storageMap maps storage keys to values, it is marshalled in insertion order
+// like the objects of the JavaScript tracers.
+type storageMap struct {
+	keys  []common.Hash
+	slots map[common.Hash]common.Hash
+}
+
+func newStorageMap() *storageMap {
+	return &storageMap{slots: make(map[common.Hash]common.Hash)}
+}
+
+func (m *storageMap) set(key, value common.Hash) {
+	if _, ok := m.slots[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.slots[key] = value
+}
+
+// MarshalJSON marshals the slots in insertion order.
+func (m *storageMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, "%q:%q", key.Hex(), m.slots[key].Hex())
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// prestateTracerConfig is the config of the prestateTracer.
+type prestateTracerConfig struct {
+	// DiffMode makes the tracer return the modified accounts only, as they
+	// were before and after the transaction.
+	DiffMode bool `json:"diffMode"`
+}
+
+// prestateTracer is the native version of prestate_tracer.js, it collects the
+// accounts and storage slots accessed by a transaction as they were before it.
+type prestateTracer struct {
+	config prestateTracerConfig
+	env    *vm.EVM
+	pre    *accountMap
+
+	from, to     common.Address
+	create       bool
+	value        *big.Int
+	gasLimit     uint64
+	gasUsed      uint64
+	intrinsicGas uint64
+
+	interrupt uint32 // atomic flag to signal the tracer to stop
+	reason    error  // reason of the interrupt
+	err       error  // error stopping the trace
+}
+
+// newPrestateTracer creates a native prestate tracer, see prestateTracerConfig
+// for the optional config.
+func newPrestateTracer(ctx *Context, config json.RawMessage) (TxTracer, error) {
+	t := &prestateTracer{pre: newAccountMap()}
+	if len(config) > 0 {
+		if err := json.Unmarshal(config, &t.config); err != nil {
+			return nil, fmt.Errorf("invalid prestateTracer config: %v", err)
+		}
+	}
+	return t, nil
+}
+
+// CaptureTxStart implements the vm.TxStartTracer interface to receive the gas
+// limit of the transaction.
+func (t *prestateTracer) CaptureTxStart(gasLimit uint64) {
+	t.gasLimit = gasLimit
+}
+
+// CaptureStart implements the Tracer interface to initialize the tracing operation.
+func (t *prestateTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	t.env = env
+	t.from, t.to, t.create = from, to, create
+	t.value = new(big.Int).Set(value)
+
+	// The JavaScript tracer ignores the access list of the transaction.
+	isHomestead := env.ChainConfig().IsHomestead(env.Context.BlockNumber)
+	isIstanbul := env.ChainConfig().IsIstanbul(env.Context.BlockNumber)
+	t.intrinsicGas, _ = core.IntrinsicGas(input, nil, create, isHomestead, isIstanbul)
+
+	// The JavaScript tracer looks up the recipient on the first step, which
+	// sees the same state. Doing it here also covers calls without code.
+	t.lookupAccount(to)
+
+	if t.config.DiffMode {
+		// The sender already paid the value and the gas limit and its nonce
+		// was incremented, undo this exactly to get the state before the
+		// transaction. Without CaptureTxStart the gas limit is derived from
+		// the intrinsic gas, which misses the access list.
+		if t.gasLimit == 0 {
+			t.gasLimit = gas + t.intrinsicGas
+		}
+		t.lookupAccount(from)
+
+		sender, recipient := t.pre.get(from), t.pre.get(to)
+		recipient.Balance = (*hexutil.Big)(new(big.Int).Sub(recipient.Balance.ToInt(), value))
+
+		cost := new(big.Int).Mul(new(big.Int).SetUint64(t.gasLimit), env.TxContext.GasPrice)
+		balance := new(big.Int).Add(sender.Balance.ToInt(), value)
+		sender.Balance = (*hexutil.Big)(balance.Add(balance, cost))
+
+		nonce := *sender.Nonce - 1
+		sender.Nonce = &nonce
+	}
+}
+
+// CaptureState implements the Tracer interface to trace a single step of VM execution.
+func (t *prestateTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	if t.err != nil {
+		return
+	}
+	// If tracing was interrupted, set the error and stop
+	if atomic.LoadUint32(&t.interrupt) > 0 {
+		t.err = t.reason
+		return
+	}
+	stack := scope.Stack
+	switch op {
+	case vm.EXTCODECOPY, vm.EXTCODESIZE, vm.BALANCE:
+		t.lookupAccount(stack.Back(0).Bytes20())
+	case vm.CREATE:
+		from := scope.Contract.Address()
+		t.lookupAccount(crypto.CreateAddress(from, env.StateDB.GetNonce(from)))
+	case vm.CREATE2:
+		init := memorySlice(scope.Memory, stack.Back(1), stack.Back(2))
+		t.lookupAccount(crypto.CreateAddress2(scope.Contract.Address(), stack.Back(3).Bytes32(), crypto.Keccak256(init)))
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		t.lookupAccount(stack.Back(1).Bytes20())
+	case vm.SSTORE, vm.SLOAD:
+		t.lookupStorage(scope.Contract.Address(), stack.Back(0).Bytes32())
+	}
+}
+
+// CaptureFault implements the Tracer interface, the prestate tracer ignores faults.
+func (t *prestateTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *prestateTracer) CaptureEnd(output []byte, gasUsed uint64, _ time.Duration, err error) {
+	t.gasUsed = gasUsed
+}
+
+// CaptureEnter implements the Tracer interface, the prestate tracer ignores
+// call frames.
+func (t *prestateTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+}
+
+// CaptureExit implements the Tracer interface, the prestate tracer ignores
+// call frames.
+func (t *prestateTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+}
+
+// GetResult returns the prestate of the accessed accounts, or their pre and
+// post state in diff mode.
+func (t *prestateTracer) GetResult() (json.RawMessage, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+	if t.env == nil {
+		return nil, fmt.Errorf("prestateTracer: no transaction traced")
+	}
+	if !t.config.DiffMode {
+		// Like the JavaScript tracer, undo the value transfer and the gas
+		// payment of the transaction to reconstruct the balances before it.
+		// The diff mode did so exactly at the start of the transaction.
+		t.lookupAccount(t.from)
+
+		from, to := t.pre.get(t.from), t.pre.get(t.to)
+		to.Balance = (*hexutil.Big)(new(big.Int).Sub(to.Balance.ToInt(), t.value))
+
+		fee := jsMulFloat(float64(t.gasUsed)+float64(t.intrinsicGas), t.env.TxContext.GasPrice)
+		balance := new(big.Int).Add(from.Balance.ToInt(), t.value)
+		from.Balance = (*hexutil.Big)(balance.Add(balance, fee))
+
+		if nonce := *from.Nonce; nonce > 0 {
+			nonce--
+			from.Nonce = &nonce
+		}
+	}
+	if t.create {
+		t.pre.delete(t.to)
+	}
+	if !t.config.DiffMode {
+		return json.Marshal(t.pre)
+	}
+	pre, post := t.diff()
+	return json.Marshal(struct {
+		Pre  *accountMap `json:"pre"`
+		Post *accountMap `json:"post"`
+	}{pre, post})
+}
+
+// diff returns the pre and post state of the accounts modified by the
+// transaction. Only modified storage slots are kept, the post state holds
+// only the modified fields. Accounts created by the transaction are absent
+// from the pre state, destructed ones from the post state.
+func (t *prestateTracer) diff() (*accountMap, *accountMap) {
+	var (
+		db   = t.env.StateDB
+		pre  = newAccountMap()
+		post = newAccountMap()
+	)
+	addrs := t.pre.addrs
+	if t.create {
+		addrs = append([]common.Address{t.to}, addrs...)
+	}
+	for _, addr := range addrs {
+		prev := t.pre.get(addr)
+		if prev == nil {
+			// The created contract, it had no state before.
+			prev = &prestateAccount{Balance: new(hexutil.Big), Nonce: new(uint64), Code: new(hexutil.Bytes), Storage: newStorageMap()}
+		}
+		existed := prev.Balance.ToInt().Sign() != 0 || *prev.Nonce != 0 || len(*prev.Code) != 0
+		if db.HasSuicided(addr) || !db.Exist(addr) {
+			if existed {
+				pre.set(addr, prev)
+			}
+			continue
+		}
+		var (
+			modified bool
+			before   = &prestateAccount{Balance: prev.Balance, Nonce: prev.Nonce, Code: prev.Code}
+			after    = new(prestateAccount)
+		)
+		if balance := db.GetBalance(addr); balance.Cmp(prev.Balance.ToInt()) != 0 {
+			after.Balance, modified = (*hexutil.Big)(new(big.Int).Set(balance)), true
+		}
+		if nonce := db.GetNonce(addr); nonce != *prev.Nonce {
+			after.Nonce, modified = &nonce, true
+		}
+		if code := db.GetCode(addr); !bytes.Equal(code, *prev.Code) {
+			code := hexutil.Bytes(common.CopyBytes(code))
+			after.Code, modified = &code, true
+		}
+		for _, key := range prev.Storage.keys {
+			value := db.GetState(addr, key)
+			if value == prev.Storage.slots[key] {
+				continue
+			}
+			if before.Storage == nil {
+				before.Storage, after.Storage = newStorageMap(), newStorageMap()
+			}
+			before.Storage.set(key, prev.Storage.slots[key])
+			after.Storage.set(key, value)
+			modified = true
+		}
+		if !modified {
+			continue
+		}
+		if existed {
+			pre.set(addr, before)
+		}
+		post.set(addr, after)
+	}
+	return pre, post
+}
+
+// Stop terminates execution of the tracer at the first opportune moment.
+func (t *prestateTracer) Stop(err error) {
+	t.reason = err
+	atomic.StoreUint32(&t.interrupt, 1)
+}
+
+// lookupAccount records the current state of the account unless it is
+// already known.
+func (t *prestateTracer) lookupAccount(addr common.Address) {
+	if t.pre.get(addr) != nil {
+		return
+	}
+	db := t.env.StateDB
+
+	nonce := db.GetNonce(addr)
+	code := hexutil.Bytes(common.CopyBytes(db.GetCode(addr)))
+	t.pre.set(addr, &prestateAccount{
+		Balance: (*hexutil.Big)(new(big.Int).Set(db.GetBalance(addr))),
+		Nonce:   &nonce,
+		Code:    &code,
+		Storage: newStorageMap(),
+	})
+}
+
+// lookupStorage records the current value of the storage slot unless it is
+// already known.
+func (t *prestateTracer) lookupStorage(addr common.Address, key common.Hash) {
+	t.lookupAccount(addr)
+
+	storage := t.pre.get(addr).Storage
+	if _, ok := storage.slots[key]; !ok {
+		storage.set(key, t.env.StateDB.GetState(addr, key))
+	}
+}
+
+// jsMulFloat returns x*y computed like the JavaScript tracers do when a number
+// is multiplied with a bigInt: y is converted to the nearest double, the
+// product is rounded to a double and parsed back from its decimal string.
+func jsMulFloat(x float64, y *big.Int) *big.Int {
+	fy, _ := new(big.Float).SetInt(y).Float64()
+	product := x * fy
+	if product < 1<<53 {
+		return new(big.Int).SetUint64(uint64(product))
+	}
+	r, ok := new(big.Rat).SetString(strconv.FormatFloat(product, 'g', -1, 64))
+	if !ok {
+		return new(big.Int)
+	}
+	return new(big.Int).Quo(r.Num(), r.Denom())
+}
@@ -0,0 +1,248 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/tests"
+)
+
+// runTracerTest executes the transaction of the given call tracer test with the
+// tracer and returns the trace result.
+func runTracerTest(t *testing.T, test *callTracerTest, tracer TxTracer) json.RawMessage {
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(common.FromHex(test.Input), tx); err != nil {
+		t.Fatalf("failed to parse testcase input: %v", err)
+	}
+	signer := types.MakeSigner(test.Genesis.Config, new(big.Int).SetUint64(uint64(test.Context.Number)))
+	origin, _ := signer.Sender(tx)
+	txContext := vm.TxContext{
+		Origin:   origin,
+		GasPrice: tx.GasPrice(),
+	}
+	context := vm.BlockContext{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		Coinbase:    test.Context.Miner,
+		BlockNumber: new(big.Int).SetUint64(uint64(test.Context.Number)),
+		Time:        new(big.Int).SetUint64(uint64(test.Context.Time)),
+		Difficulty:  (*big.Int)(test.Context.Difficulty),
+		GasLimit:    uint64(test.Context.GasLimit),
+	}
+	_, statedb := tests.MakePreState(rawdb.NewMemoryDatabase(), test.Genesis.Alloc, false)
+	vmConfig := vm.Config{Debug: true, Tracer: tracer}
+	configureVM(tracer, &vmConfig)
+	evm := vm.NewEVM(context, txContext, statedb, test.Genesis.Config, vmConfig)
+
+	msg, err := tx.AsMessage(signer, nil)
+	if err != nil {
+		t.Fatalf("failed to prepare transaction for tracing: %v", err)
+	}
+	st := core.NewStateTransition(evm, msg, new(core.GasPool).AddGas(tx.Gas()))
+	if _, err = st.TransitionDb(); err != nil {
+		t.Fatalf("failed to execute transaction: %v", err)
+	}
+	res, err := tracer.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve trace result: %v", err)
+	}
+	return res
+}
+
+// Tests that the native tracers produce the same output as their JavaScript
+// versions on the call tracer test harness.
+func TestNativeTracersMatchJavaScript(t *testing.T) {
+	files, err := ioutil.ReadDir(filepath.Join("testdata", "call_tracer"))
+	if err != nil {
+		t.Fatalf("failed to retrieve tracer test suite: %v", err)
+	}
+	for _, name := range []string{"callTracer", "prestateTracer", "4byteTracer"} {
+		for _, file := range files {
+			if !strings.HasSuffix(file.Name(), ".json") {
+				continue
+			}
+			name, file := name, file // capture range variables
+			t.Run(name+"/"+camel(strings.TrimSuffix(file.Name(), ".json")), func(t *testing.T) {
+				t.Parallel()
+
+				blob, err := ioutil.ReadFile(filepath.Join("testdata", "call_tracer", file.Name()))
+				if err != nil {
+					t.Fatalf("failed to read testcase: %v", err)
+				}
+				test := new(callTracerTest)
+				if err := json.Unmarshal(blob, test); err != nil {
+					t.Fatalf("failed to parse testcase: %v", err)
+				}
+				js, err := New(name, new(Context))
+				if err != nil {
+					t.Fatalf("failed to create JavaScript tracer: %v", err)
+				}
+				goTracer, err := NewTracer(name, new(Context), nil)
+				if err != nil {
+					t.Fatalf("failed to create native tracer: %v", err)
+				}
+				if _, ok := goTracer.(*Tracer); ok {
+					t.Fatalf("tracer %s not resolved to a native tracer", name)
+				}
+				want, have := runTracerTest(t, test, js), runTracerTest(t, test, goTracer)
+				if string(have) != string(want) {
+					t.Fatalf("trace mismatch:\nhave %s\nwant %s", have, want)
+				}
+			})
+		}
+	}
+}
+
+func TestPrestateTracerDiffMode(t *testing.T) {
+	blob, err := ioutil.ReadFile(filepath.Join("testdata", "call_tracer", "simple.json"))
+	if err != nil {
+		t.Fatalf("failed to read testcase: %v", err)
+	}
+	test := new(callTracerTest)
+	if err := json.Unmarshal(blob, test); err != nil {
+		t.Fatalf("failed to parse testcase: %v", err)
+	}
+	tracer, err := NewTracer("prestateTracer", new(Context), json.RawMessage(`{"diffMode": true}`))
+	if err != nil {
+		t.Fatalf("failed to create tracer: %v", err)
+	}
+	var res struct {
+		Pre  map[common.Address]*prestateAccount `json:"pre"`
+		Post map[common.Address]*prestateAccount `json:"post"`
+	}
+	if err := json.Unmarshal(runTracerTest(t, test, tracer), &res); err != nil {
+		t.Fatalf("failed to unmarshal trace result: %v", err)
+	}
+	from := common.HexToAddress("0xb436ba50d378d4bbc8660d312a13df6af6e89dfb")
+	pre, post := res.Pre[from], res.Post[from]
+	if pre == nil || post == nil {
+		t.Fatalf("sender missing from diff: pre %v post %v", pre, post)
+	}
+	if genesis := test.Genesis.Alloc[from]; pre.Balance.ToInt().Cmp(genesis.Balance) != 0 || *pre.Nonce != genesis.Nonce {
+		t.Errorf("sender prestate mismatch: have balance %v nonce %d, want balance %v nonce %d", pre.Balance, *pre.Nonce, genesis.Balance, genesis.Nonce)
+	}
+	if *post.Nonce != *pre.Nonce+1 {
+		t.Errorf("sender nonce mismatch: pre %d post %d", *pre.Nonce, *post.Nonce)
+	}
+	if post.Balance.ToInt().Cmp(pre.Balance.ToInt()) >= 0 {
+		t.Errorf("sender balance did not decrease: pre %v post %v", pre.Balance, post.Balance)
+	}
+	for addr, account := range res.Post {
+		if account.Balance == nil && account.Nonce == nil && account.Code == nil && account.Storage == nil {
+			t.Errorf("unmodified account %x in post state", addr)
+		}
+	}
+}
+
+func TestJSMulFloat(t *testing.T) {
+	cases := []struct {
+		x    float64
+		y    string
+		want string
+	}{
+		{21000, "1000000000", "21000000000000"},
+		{0, "1000000000", "0"},
+		// The product is not representable as a double.
+		{123456789, "98765432109876543", "12193263112482853000000000"},
+	}
+	for i, tt := range cases {
+		y, _ := new(big.Int).SetString(tt.y, 10)
+		if have := jsMulFloat(tt.x, y); have.String() != tt.want {
+			t.Errorf("test %d: have %v, want %v", i, have, tt.want)
+		}
+	}
+}
+
+// Tests that the members of the mux tracer produce the same results as the
+// tracers run on their own, and that the profilers can be members.
+func TestMuxTracer(t *testing.T) {
+	blob, err := ioutil.ReadFile(filepath.Join("testdata", "call_tracer", "inner_create_oog_outer_throw.json"))
+	if err != nil {
+		t.Fatalf("failed to read testcase: %v", err)
+	}
+	test := new(callTracerTest)
+	if err := json.Unmarshal(blob, test); err != nil {
+		t.Fatalf("failed to parse testcase: %v", err)
+	}
+	config := `{"callTracer": {}, "prestateTracer": {"diffMode": true}, "4byteTracer": null, "microProfiler": {"nGramLength": 2}, "basicBlockProfiler": {}}`
+	mux, err := NewTracer("muxTracer", new(Context), json.RawMessage(config))
+	if err != nil {
+		t.Fatalf("failed to create mux tracer: %v", err)
+	}
+	// The prestate member needs the gas limit for its diff.
+	if _, ok := mux.(vm.TxStartTracer); !ok {
+		t.Fatalf("mux tracer does not forward the gas limit")
+	}
+	var results map[string]json.RawMessage
+	if err := json.Unmarshal(runTracerTest(t, test, mux), &results); err != nil {
+		t.Fatalf("failed to unmarshal trace result: %v", err)
+	}
+	if len(results) != 5 {
+		t.Fatalf("result count mismatch: have %d, want 5", len(results))
+	}
+	members := map[string]json.RawMessage{
+		"callTracer":     nil,
+		"prestateTracer": json.RawMessage(`{"diffMode": true}`),
+		"4byteTracer":    nil,
+	}
+	for name, config := range members {
+		tracer, err := NewTracer(name, new(Context), config)
+		if err != nil {
+			t.Fatalf("failed to create %s: %v", name, err)
+		}
+		if want := runTracerTest(t, test, tracer); string(results[name]) != string(want) {
+			t.Errorf("%s result mismatch:\nhave %s\nwant %s", name, results[name], want)
+		}
+	}
+	var micro map[string][]map[string]interface{}
+	if err := json.Unmarshal(results["microProfiler"], &micro); err != nil {
+		t.Fatalf("failed to unmarshal micro profile: %v", err)
+	}
+	if len(micro["OpCodeFrequency"]) == 0 || len(micro["OpCodeNGramFrequency"]) == 0 {
+		t.Errorf("micro profile lacks opcode statistics: %s", results["microProfiler"])
+	}
+	var blocks map[string][]map[string]interface{}
+	if err := json.Unmarshal(results["basicBlockProfiler"], &blocks); err != nil {
+		t.Fatalf("failed to unmarshal basic-block profile: %v", err)
+	}
+	if len(blocks["BasicBlockFrequency"]) == 0 || len(blocks["BasicBlock"]) == 0 {
+		t.Errorf("basic-block profile lacks blocks: %s", results["basicBlockProfiler"])
+	}
+	// Profilers of a kind share the vm config, even across nested muxes.
+	nested := `{"microProfiler": {}, "muxTracer": {"basicBlockProfiler": {}}}`
+	if mux, err = NewTracer("muxTracer", new(Context), json.RawMessage(nested)); err != nil {
+		t.Errorf("failed to create nested mux: %v", err)
+	} else {
+		release(mux)
+	}
+	duplicate := `{"microProfiler": {}, "muxTracer": {"microProfiler": {}}}`
+	if _, err := NewTracer("muxTracer", new(Context), json.RawMessage(duplicate)); err == nil {
+		t.Error("mux with two micro profilers accepted")
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/uint256"
+)
+
+func init() {
+	RegisterNativeTracer("vmTracer", newVMTracer)
+}
+
+// vmTrace is the OpenEthereum style trace of the instructions executed in a
+// call frame.
+type vmTrace struct {
+	Code hexutil.Bytes `json:"code"`
+	Ops  []*vmTraceOp  `json:"ops"`
+}
+
+// vmTraceOp is an executed instruction. Ex holds the effects of the
+// instruction, it is nil if the instruction failed, and Sub the trace of the
+// call frame entered by the instruction.
+type vmTraceOp struct {
+	Cost uint64     `json:"cost"`
+	Ex   *vmTraceEx `json:"ex"`
+	Pc   uint64     `json:"pc"`
+	Sub  *vmTrace   `json:"sub"`
+}
+
+// vmTraceEx holds the effects of an instruction: the stack items it pushed,
+// the memory and storage it wrote and the gas remaining after it.
+type vmTraceEx struct {
+	Mem   *vmTraceMem   `json:"mem"`
+	Push  []string      `json:"push"`
+	Store *vmTraceStore `json:"store"`
+	Used  uint64        `json:"used"`
+}
+
+type vmTraceMem struct {
+	Data hexutil.Bytes `json:"data"`
+	Off  uint64        `json:"off"`
+}
+
+type vmTraceStore struct {
+	Key string `json:"key"`
+	Val string `json:"val"`
+}
+
+// vmTraceFrame is a call frame being traced. The effects of an instruction
+// are only known once the next one starts or the frame is left, so the last
+// instruction is kept pending until then.
+type vmTraceFrame struct {
+	trace   *vmTrace
+	started bool // the first instruction of the frame was traced
+
+	pending    *vmTraceOp    // last traced instruction, nil if none
+	pendingOp  vm.OpCode     // opcode of the pending instruction
+	pendingGas uint64        // gas remaining before the pending instruction
+	failed     bool          // the pending instruction failed
+	mem        bool          // the pending instruction writes memory
+	memOff     uint64        // offset of the memory written
+	memSize    uint64        // size of the memory written
+	store      *vmTraceStore // storage written by the pending instruction
+}
+
+// vmTracer produces the vmTrace of the OpenEthereum trace API.
+type vmTracer struct {
+	frames []*vmTraceFrame
+	root   *vmTrace
+
+	interrupt uint32 // atomic flag to signal the tracer to stop
+	reason    error  // reason of the interrupt
+	err       error  // error stopping the trace
+}
+
+// newVMTracer creates a native vmTrace tracer, it takes no config.
+func newVMTracer(ctx *Context, config json.RawMessage) (TxTracer, error) {
+	return new(vmTracer), nil
+}
+
+// CaptureStart implements the Tracer interface to initialize the tracing operation.
+func (t *vmTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	t.root = &vmTrace{Ops: []*vmTraceOp{}}
+	t.frames = []*vmTraceFrame{{trace: t.root}}
+}
+
+// CaptureState implements the Tracer interface to trace a single step of VM execution.
+func (t *vmTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	if t.err != nil {
+		return
+	}
+	// If tracing was interrupted, set the error and stop
+	if atomic.LoadUint32(&t.interrupt) > 0 {
+		t.err = t.reason
+		return
+	}
+	frame := t.frames[len(t.frames)-1]
+	if !frame.started {
+		frame.trace.Code = common.CopyBytes(scope.Contract.Code)
+		frame.started = true
+	}
+	frame.finish(gas, scope)
+
+	traced := &vmTraceOp{Pc: pc, Cost: cost}
+	frame.trace.Ops = append(frame.trace.Ops, traced)
+	frame.pending, frame.pendingOp, frame.pendingGas = traced, op, gas
+	frame.failed = err != nil
+
+	// Record the memory and storage written by the instruction.
+	stack := scope.Stack
+	frame.mem, frame.store = false, nil
+	switch op {
+	case vm.MSTORE:
+		frame.setMemSpan(stack.Back(0), uint256.NewInt(32))
+	case vm.MSTORE8:
+		frame.setMemSpan(stack.Back(0), uint256.NewInt(1))
+	case vm.CALLDATACOPY, vm.CODECOPY, vm.RETURNDATACOPY:
+		frame.setMemSpan(stack.Back(0), stack.Back(2))
+	case vm.EXTCODECOPY:
+		frame.setMemSpan(stack.Back(1), stack.Back(3))
+	case vm.CALL, vm.CALLCODE:
+		frame.setMemSpan(stack.Back(5), stack.Back(6))
+	case vm.DELEGATECALL, vm.STATICCALL:
+		frame.setMemSpan(stack.Back(4), stack.Back(5))
+	case vm.SSTORE:
+		frame.store = &vmTraceStore{Key: stack.Back(0).Hex(), Val: stack.Back(1).Hex()}
+	}
+}
+
+// CaptureFault implements the Tracer interface to trace an execution fault.
+func (t *vmTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+	if t.err != nil || len(t.frames) == 0 {
+		return
+	}
+	t.frames[len(t.frames)-1].failed = true
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *vmTracer) CaptureEnd(output []byte, gasUsed uint64, _ time.Duration, err error) {
+	if t.err != nil || len(t.frames) == 0 {
+		return
+	}
+	t.frames[0].finish(0, nil)
+}
+
+// CaptureEnter is called when EVM enters a new scope (via call, create or selfdestruct).
+func (t *vmTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	if t.err != nil {
+		return
+	}
+	// If tracing was interrupted, set the error and stop
+	if atomic.LoadUint32(&t.interrupt) > 0 {
+		t.err = t.reason
+		return
+	}
+	sub := &vmTrace{Ops: []*vmTraceOp{}}
+
+	// Self destructs are reported as frames, but execute no code.
+	if parent := t.frames[len(t.frames)-1]; parent.pending != nil && typ != vm.SELFDESTRUCT {
+		parent.pending.Sub = sub
+	}
+	t.frames = append(t.frames, &vmTraceFrame{trace: sub})
+}
+
+// CaptureExit is called when EVM exits a scope, even if the scope didn't
+// execute any code.
+func (t *vmTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	if t.err != nil || len(t.frames) <= 1 {
+		return
+	}
+	t.frames[len(t.frames)-1].finish(0, nil)
+	t.frames = t.frames[:len(t.frames)-1]
+}
+
+// GetResult returns the vmTrace of the transaction.
+func (t *vmTracer) GetResult() (json.RawMessage, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+	return json.Marshal(t.root)
+}
+
+// Stop terminates execution of the tracer at the first opportune moment.
+func (t *vmTracer) Stop(err error) {
+	t.reason = err
+	atomic.StoreUint32(&t.interrupt, 1)
+}
+
+// setMemSpan records the memory range written by the pending instruction.
+func (f *vmTraceFrame) setMemSpan(offset, size *uint256.Int) {
+	if !offset.IsUint64() || !size.IsUint64() || size.IsZero() {
+		return
+	}
+	f.memOff, f.memSize, f.mem = offset.Uint64(), size.Uint64(), true
+}
+
+// finish fills in the effects of the pending instruction. If the frame
+// continues, gas is the gas remaining and scope the state after the
+// instruction. If the frame was left, scope is nil and the instruction halted
+// the frame, so it pushed nothing.
+func (f *vmTraceFrame) finish(gas uint64, scope *vm.ScopeContext) {
+	op := f.pending
+	if op == nil {
+		return
+	}
+	f.pending = nil
+	if f.failed {
+		return
+	}
+	ex := &vmTraceEx{Push: []string{}, Store: f.store}
+	if scope == nil {
+		ex.Used = f.pendingGas - op.Cost
+		op.Ex = ex
+		return
+	}
+	ex.Used = gas
+
+	stack := scope.Stack
+	pushes := vmTracePushes(f.pendingOp)
+	if pushes > stack.Len() {
+		pushes = stack.Len()
+	}
+	for i := pushes - 1; i >= 0; i-- {
+		ex.Push = append(ex.Push, stack.Back(i).Hex())
+	}
+	if f.mem {
+		data := memorySlice(scope.Memory, uint256.NewInt(f.memOff), uint256.NewInt(f.memSize))
+		if data != nil {
+			ex.Mem = &vmTraceMem{Data: data, Off: f.memOff}
+		}
+	}
+	op.Ex = ex
+}
+
+// vmTracePushes returns the number of stack items an instruction is reported
+// to push. Like OpenEthereum, DUP and SWAP report all items they touch.
+func vmTracePushes(op vm.OpCode) int {
+	switch {
+	case op >= vm.DUP1 && op <= vm.DUP16:
+		return int(op-vm.DUP1) + 2
+	case op >= vm.SWAP1 && op <= vm.SWAP16:
+		return int(op-vm.SWAP1) + 2
+	case op >= vm.LOG0 && op <= vm.LOG4:
+		return 0
+	}
+	switch op {
+	case vm.STOP, vm.POP, vm.MSTORE, vm.MSTORE8, vm.SSTORE, vm.JUMP, vm.JUMPI, vm.JUMPDEST,
+		vm.RETURN, vm.REVERT, vm.SELFDESTRUCT, vm.CALLDATACOPY, vm.CODECOPY,
+		vm.RETURNDATACOPY, vm.EXTCODECOPY, vm.INVALID:
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,660 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/internal/ethapi"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Trace types accepted by trace_replayTransaction and trace_call.
+const (
+	traceTypeTrace     = "trace"
+	traceTypeStateDiff = "stateDiff"
+	traceTypeVMTrace   = "vmTrace"
+)
+
+const (
+	callTracerName     = "callTracer"
+	prestateTracerName = "prestateTracer"
+	vmTracerName       = "vmTracer"
+	muxTracerName      = "muxTracer"
+)
+
+// maxFilterBlocks is the maximum number of blocks traced by a single
+// trace_filter request. Every block is traced on a regenerated state.
+const maxFilterBlocks = 100
+
+// tracerConfig returns a trace config running the given tracer.
+func tracerConfig(tracer string) *TraceConfig {
+	return &TraceConfig{Tracer: &tracer}
+}
+
+// TraceAPI is the collection of OpenEthereum style tracing APIs exposed in the
+// trace namespace. It is built on the call tracer of the debug API.
+type TraceAPI struct {
+	api *API
+}
+
+// NewTraceAPI creates a new API definition for the OpenEthereum style tracing
+// methods of the Ethereum service.
+func NewTraceAPI(backend Backend) *TraceAPI {
+	return &TraceAPI{api: NewAPI(backend)}
+}
+
+// flatTrace is a call of a transaction or a reward of a block in the flat
+// OpenEthereum format. The position of the call in the call tree is given by
+// its trace address, the indices of the calls leading to it.
+type flatTrace struct {
+	Action       interface{} `json:"action"`
+	Error        string      `json:"error,omitempty"`
+	Result       interface{} `json:"result"`
+	Subtraces    int         `json:"subtraces"`
+	TraceAddress []int       `json:"traceAddress"`
+	Type         string      `json:"type"`
+}
+
+// blockTrace is a flatTrace of a block. Rewards belong to no transaction.
+type blockTrace struct {
+	*flatTrace
+	BlockHash           common.Hash  `json:"blockHash"`
+	BlockNumber         uint64       `json:"blockNumber"`
+	TransactionHash     *common.Hash `json:"transactionHash"`
+	TransactionPosition *int         `json:"transactionPosition"`
+}
+
+type callAction struct {
+	CallType string         `json:"callType"`
+	From     common.Address `json:"from"`
+	Gas      hexutil.Uint64 `json:"gas"`
+	Input    hexutil.Bytes  `json:"input"`
+	To       common.Address `json:"to"`
+	Value    *hexutil.Big   `json:"value"`
+}
+
+type callResult struct {
+	GasUsed hexutil.Uint64 `json:"gasUsed"`
+	Output  hexutil.Bytes  `json:"output"`
+}
+
+type createAction struct {
+	From  common.Address `json:"from"`
+	Gas   hexutil.Uint64 `json:"gas"`
+	Init  hexutil.Bytes  `json:"init"`
+	Value *hexutil.Big   `json:"value"`
+}
+
+type createResult struct {
+	Address common.Address `json:"address"`
+	Code    hexutil.Bytes  `json:"code"`
+	GasUsed hexutil.Uint64 `json:"gasUsed"`
+}
+
+type suicideAction struct {
+	Address       common.Address `json:"address"`
+	Balance       *hexutil.Big   `json:"balance"`
+	RefundAddress common.Address `json:"refundAddress"`
+}
+
+type rewardAction struct {
+	Author     common.Address `json:"author"`
+	RewardType string         `json:"rewardType"`
+	Value      *hexutil.Big   `json:"value"`
+}
+
+// from returns the address a trace originates from, if any.
+func (t *flatTrace) from() (common.Address, bool) {
+	switch action := t.Action.(type) {
+	case *callAction:
+		return action.From, true
+	case *createAction:
+		return action.From, true
+	case *suicideAction:
+		return action.Address, true
+	}
+	return common.Address{}, false
+}
+
+// to returns the address a trace is directed to, if any.
+func (t *flatTrace) to() (common.Address, bool) {
+	switch action := t.Action.(type) {
+	case *callAction:
+		return action.To, true
+	case *createAction:
+		if result, ok := t.Result.(*createResult); ok {
+			return result.Address, true
+		}
+	case *suicideAction:
+		return action.RefundAddress, true
+	case *rewardAction:
+		return action.Author, true
+	}
+	return common.Address{}, false
+}
+
+// flattenCallFrame appends the calls of the given call tree to traces in
+// depth-first order.
+func flattenCallFrame(frame *callFrame, address []int, traces []*flatTrace) []*flatTrace {
+	trace := &flatTrace{
+		Subtraces:    len(frame.Calls),
+		TraceAddress: append([]int{}, address...),
+	}
+	value := frame.Value
+	if value == nil {
+		value = new(hexutil.Big)
+	}
+	var output hexutil.Bytes
+	if frame.Output != nil {
+		output = *frame.Output
+	}
+	switch frame.Type {
+	case vm.CREATE.String(), vm.CREATE2.String():
+		trace.Type = "create"
+		trace.Action = &createAction{From: frame.From, Gas: frame.Gas, Init: frame.Input, Value: value}
+		if frame.Error == "" {
+			trace.Result = &createResult{Address: *frame.To, Code: output, GasUsed: frame.GasUsed}
+		}
+	case vm.SELFDESTRUCT.String():
+		trace.Type = "suicide"
+		trace.Action = &suicideAction{Address: frame.From, Balance: value, RefundAddress: *frame.To}
+	default:
+		trace.Type = "call"
+		trace.Action = &callAction{
+			CallType: strings.ToLower(frame.Type),
+			From:     frame.From,
+			Gas:      frame.Gas,
+			Input:    frame.Input,
+			To:       *frame.To,
+			Value:    value,
+		}
+		if frame.Error == "" {
+			trace.Result = &callResult{GasUsed: frame.GasUsed, Output: output}
+		}
+	}
+	if frame.Error != "" {
+		trace.Error = parityError(frame.Error)
+	}
+	traces = append(traces, trace)
+	for i, call := range frame.Calls {
+		traces = flattenCallFrame(call, append(address, i), traces)
+	}
+	return traces
+}
+
+// parityError converts an EVM error into its OpenEthereum description.
+func parityError(err string) string {
+	switch err {
+	case vm.ErrExecutionReverted.Error():
+		return "Reverted"
+	case vm.ErrOutOfGas.Error(), vm.ErrCodeStoreOutOfGas.Error():
+		return "Out of gas"
+	case vm.ErrInvalidJump.Error():
+		return "Bad jump destination"
+	case vm.ErrWriteProtection.Error():
+		return "Mutable Call In Static Context"
+	case vm.ErrDepth.Error():
+		return "Out of depth"
+	}
+	switch {
+	case strings.HasPrefix(err, "invalid opcode"):
+		return "Bad instruction"
+	case strings.HasPrefix(err, "stack underflow"):
+		return "Stack underflow"
+	case strings.HasPrefix(err, "stack limit reached"):
+		return "Out of stack"
+	}
+	return err
+}
+
+// decodeCallFrame decodes the result of the call tracer.
+func decodeCallFrame(res interface{}) (*callFrame, error) {
+	raw, ok := res.(json.RawMessage)
+	if !ok {
+		return nil, fmt.Errorf("unexpected call tracer result %T", res)
+	}
+	frame := new(callFrame)
+	if err := json.Unmarshal(raw, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// Block returns the flat traces of all transactions and the rewards of the
+// given block.
+func (api *TraceAPI) Block(ctx context.Context, number rpc.BlockNumber) ([]*blockTrace, error) {
+	block, err := api.api.blockByNumber(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+	return api.traceBlock(ctx, block)
+}
+
+// traceBlock traces the transactions of the block with the call tracer and
+// flattens the results.
+func (api *TraceAPI) traceBlock(ctx context.Context, block *types.Block) ([]*blockTrace, error) {
+	results, err := api.api.traceBlock(ctx, block, tracerConfig(callTracerName))
+	if err != nil {
+		return nil, err
+	}
+	var (
+		traces []*blockTrace
+		txs    = block.Transactions()
+	)
+	for i, result := range results {
+		if result.Error != "" {
+			return nil, fmt.Errorf("failed to trace transaction %#x: %s", txs[i].Hash(), result.Error)
+		}
+		frame, err := decodeCallFrame(result.Result)
+		if err != nil {
+			return nil, err
+		}
+		hash, index := txs[i].Hash(), i
+		for _, trace := range flattenCallFrame(frame, nil, nil) {
+			traces = append(traces, &blockTrace{
+				flatTrace:           trace,
+				BlockHash:           block.Hash(),
+				BlockNumber:         block.NumberU64(),
+				TransactionHash:     &hash,
+				TransactionPosition: &index,
+			})
+		}
+	}
+	return append(traces, api.rewards(block)...), nil
+}
+
+// rewards returns the traces of the mining rewards of the block. Only ethash
+// blocks are rewarded.
+func (api *TraceAPI) rewards(block *types.Block) []*blockTrace {
+	if _, ok := api.api.backend.Engine().(*ethash.Ethash); !ok {
+		return nil
+	}
+	reward, uncleRewards := ethash.Rewards(api.api.backend.ChainConfig(), block.Header(), block.Uncles())
+	if reward == nil {
+		return nil
+	}
+	newReward := func(author common.Address, typ string, value *big.Int) *blockTrace {
+		return &blockTrace{
+			flatTrace: &flatTrace{
+				Action:       &rewardAction{Author: author, RewardType: typ, Value: (*hexutil.Big)(value)},
+				TraceAddress: []int{},
+				Type:         "reward",
+			},
+			BlockHash:   block.Hash(),
+			BlockNumber: block.NumberU64(),
+		}
+	}
+	traces := []*blockTrace{newReward(block.Coinbase(), "block", reward)}
+	for i, uncle := range block.Uncles() {
+		traces = append(traces, newReward(uncle.Coinbase, "uncle", uncleRewards[i]))
+	}
+	return traces
+}
+
+// Transaction returns the flat traces of the given transaction.
+func (api *TraceAPI) Transaction(ctx context.Context, hash common.Hash) ([]*blockTrace, error) {
+	_, blockHash, blockNumber, index, err := api.api.backend.GetTransaction(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	res, err := api.api.TraceTransaction(ctx, hash, tracerConfig(callTracerName))
+	if err != nil {
+		return nil, err
+	}
+	frame, err := decodeCallFrame(res)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		traces   []*blockTrace
+		position = int(index)
+	)
+	for _, trace := range flattenCallFrame(frame, nil, nil) {
+		traces = append(traces, &blockTrace{
+			flatTrace:           trace,
+			BlockHash:           blockHash,
+			BlockNumber:         blockNumber,
+			TransactionHash:     &hash,
+			TransactionPosition: &position,
+		})
+	}
+	return traces, nil
+}
+
+// TraceFilterArgs are the arguments of trace_filter. A trace matches if it
+// originates from one of the from addresses and is directed to one of the to
+// addresses, an empty list matches any address.
+type TraceFilterArgs struct {
+	FromBlock   *rpc.BlockNumber `json:"fromBlock"`
+	ToBlock     *rpc.BlockNumber `json:"toBlock"`
+	FromAddress []common.Address `json:"fromAddress"`
+	ToAddress   []common.Address `json:"toAddress"`
+	After       *uint64          `json:"after"` // number of matching traces to skip
+	Count       *uint64          `json:"count"` // maximum number of traces to return
+}
+
+// matches reports whether the trace matches the address filters.
+func (args *TraceFilterArgs) matches(trace *flatTrace) bool {
+	contains := func(addrs []common.Address, addr common.Address, ok bool) bool {
+		if len(addrs) == 0 {
+			return true
+		}
+		if !ok {
+			return false
+		}
+		for _, a := range addrs {
+			if a == addr {
+				return true
+			}
+		}
+		return false
+	}
+	from, ok := trace.from()
+	if !contains(args.FromAddress, from, ok) {
+		return false
+	}
+	to, ok := trace.to()
+	return contains(args.ToAddress, to, ok)
+}
+
+// Filter returns the flat traces of the given block range matching the
+// filter. Both ends of the range default to the latest block, the range may
+// span at most maxFilterBlocks blocks.
+func (api *TraceAPI) Filter(ctx context.Context, args TraceFilterArgs) ([]*blockTrace, error) {
+	first, last := rpc.LatestBlockNumber, rpc.LatestBlockNumber
+	if args.FromBlock != nil {
+		first = *args.FromBlock
+	}
+	if args.ToBlock != nil {
+		last = *args.ToBlock
+	}
+	start, err := api.api.blockByNumber(ctx, first)
+	if err != nil {
+		return nil, err
+	}
+	end, err := api.api.blockByNumber(ctx, last)
+	if err != nil {
+		return nil, err
+	}
+	if start.NumberU64() > end.NumberU64() {
+		return nil, fmt.Errorf("from block %d is larger than to block %d", start.NumberU64(), end.NumberU64())
+	}
+	if blocks := end.NumberU64() - start.NumberU64() + 1; blocks > maxFilterBlocks {
+		return nil, fmt.Errorf("block range of %d blocks exceeds the maximum of %d", blocks, maxFilterBlocks)
+	}
+	var (
+		traces []*blockTrace
+		after  uint64
+	)
+	if args.After != nil {
+		after = *args.After
+	}
+	for number := start.NumberU64(); number <= end.NumberU64(); number++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		// The genesis block has neither transactions nor rewards.
+		if number == 0 {
+			continue
+		}
+		block, err := api.api.blockByNumber(ctx, rpc.BlockNumber(number))
+		if err != nil {
+			return nil, err
+		}
+		blockTraces, err := api.traceBlock(ctx, block)
+		if err != nil {
+			return nil, err
+		}
+		for _, trace := range blockTraces {
+			if !args.matches(trace.flatTrace) {
+				continue
+			}
+			if after > 0 {
+				after--
+				continue
+			}
+			if args.Count != nil && uint64(len(traces)) >= *args.Count {
+				return traces, nil
+			}
+			traces = append(traces, trace)
+		}
+	}
+	return traces, nil
+}
+
+// traceResults is the result of trace_replayTransaction and trace_call. The
+// parts not requested are empty.
+type traceResults struct {
+	Output    hexutil.Bytes                        `json:"output"`
+	StateDiff map[common.Address]*stateDiffAccount `json:"stateDiff"`
+	Trace     []*flatTrace                         `json:"trace"`
+	VMTrace   json.RawMessage                      `json:"vmTrace"`
+}
+
+// stateDiffAccount describes the changes of an account. Every field is either
+// "=" if it is unchanged, {"+": value} if the account was created,
+// {"-": value} if it was deleted or {"*": {"from": old, "to": new}}.
+type stateDiffAccount struct {
+	Balance interface{}                 `json:"balance"`
+	Code    interface{}                 `json:"code"`
+	Nonce   interface{}                 `json:"nonce"`
+	Storage map[common.Hash]interface{} `json:"storage"`
+}
+
+// ReplayTransaction replays the given transaction and returns the requested
+// trace types: trace, stateDiff and vmTrace.
+func (api *TraceAPI) ReplayTransaction(ctx context.Context, hash common.Hash, traceTypes []string) (*traceResults, error) {
+	_, blockHash, blockNumber, index, err := api.api.backend.GetTransaction(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	// It shouldn't happen in practice.
+	if blockNumber == 0 {
+		return nil, errors.New("genesis is not traceable")
+	}
+	block, err := api.api.blockByNumberAndHash(ctx, rpc.BlockNumber(blockNumber), blockHash)
+	if err != nil {
+		return nil, err
+	}
+	msg, vmctx, statedb, err := api.api.backend.StateAtTransaction(ctx, block, int(index), defaultTraceReexec)
+	if err != nil {
+		return nil, err
+	}
+	txctx := &Context{
+		BlockHash: blockHash,
+		TxIndex:   int(index),
+		TxHash:    hash,
+	}
+	return api.replay(ctx, msg, txctx, vmctx, statedb, traceTypes)
+}
+
+// Call executes the given call on top of the given block, the latest one by
+// default, and returns the requested trace types: trace, stateDiff and
+// vmTrace.
+func (api *TraceAPI) Call(ctx context.Context, args ethapi.TransactionArgs, traceTypes []string, blockNrOrHash *rpc.BlockNumberOrHash) (*traceResults, error) {
+	var (
+		err   error
+		block *types.Block
+	)
+	if blockNrOrHash == nil {
+		block, err = api.api.blockByNumber(ctx, rpc.LatestBlockNumber)
+	} else if hash, ok := blockNrOrHash.Hash(); ok {
+		block, err = api.api.blockByHash(ctx, hash)
+	} else if number, ok := blockNrOrHash.Number(); ok {
+		block, err = api.api.blockByNumber(ctx, number)
+	} else {
+		return nil, errors.New("invalid arguments; neither block nor hash specified")
+	}
+	if err != nil {
+		return nil, err
+	}
+	statedb, err := api.api.backend.StateAtBlock(ctx, block, defaultTraceReexec, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := args.ToMessage(api.api.backend.RPCGasCap(), block.BaseFee())
+	if err != nil {
+		return nil, err
+	}
+	vmctx := core.NewEVMBlockContext(block.Header(), api.api.chainContext(ctx), nil)
+	return api.replay(ctx, msg, new(Context), vmctx, statedb, traceTypes)
+}
+
+// replay executes the message on the given state and returns the requested
+// trace types. All tracers run in a single execution through the mux tracer.
+// The call trace is always produced, as it provides the output.
+func (api *TraceAPI) replay(ctx context.Context, msg core.Message, txctx *Context, vmctx vm.BlockContext, statedb *state.StateDB, traceTypes []string) (*traceResults, error) {
+	var (
+		members = map[string]json.RawMessage{callTracerName: nil}
+		err     error
+	)
+	for _, typ := range traceTypes {
+		switch typ {
+		case traceTypeTrace:
+		case traceTypeStateDiff:
+			members[prestateTracerName] = nil
+		case traceTypeVMTrace:
+			members[vmTracerName] = nil
+		default:
+			return nil, fmt.Errorf("unknown trace type %q", typ)
+		}
+	}
+	config := tracerConfig(muxTracerName)
+	if config.TracerConfig, err = json.Marshal(members); err != nil {
+		return nil, err
+	}
+	pre := statedb.Copy()
+	res, err := api.api.traceTx(ctx, msg, txctx, vmctx, statedb, config)
+	if err != nil {
+		return nil, err
+	}
+	var outputs map[string]json.RawMessage
+	if err := json.Unmarshal(res.(json.RawMessage), &outputs); err != nil {
+		return nil, err
+	}
+	frame, err := decodeCallFrame(outputs[callTracerName])
+	if err != nil {
+		return nil, err
+	}
+	results := &traceResults{Trace: []*flatTrace{}}
+	if frame.Output != nil {
+		results.Output = *frame.Output
+	}
+	for _, typ := range traceTypes {
+		switch typ {
+		case traceTypeTrace:
+			results.Trace = flattenCallFrame(frame, nil, nil)
+		case traceTypeStateDiff:
+			if results.StateDiff, err = api.stateDiff(msg, vmctx, pre, statedb, outputs[prestateTracerName]); err != nil {
+				return nil, err
+			}
+		case traceTypeVMTrace:
+			results.VMTrace = outputs[vmTracerName]
+		}
+	}
+	return results, nil
+}
+
+// stateDiff returns the changes of the accounts and storage slots accessed by
+// the message between the states before and after its execution. The accessed
+// state is the result of the prestate tracer.
+func (api *TraceAPI) stateDiff(msg core.Message, vmctx vm.BlockContext, pre, statedb *state.StateDB, prestate json.RawMessage) (map[common.Address]*stateDiffAccount, error) {
+	var accessed map[common.Address]struct {
+		Storage map[common.Hash]common.Hash `json:"storage"`
+	}
+	if err := json.Unmarshal(prestate, &accessed); err != nil {
+		return nil, err
+	}
+	// Apply the deletions of the transaction like the end of a block would.
+	statedb.Finalise(api.api.backend.ChainConfig().IsEIP158(vmctx.BlockNumber))
+
+	// The prestate tracer omits the contract created by the transaction and
+	// does not see the fee payment.
+	addrs := map[common.Address]bool{msg.From(): true, vmctx.Coinbase: true}
+	if msg.To() != nil {
+		addrs[*msg.To()] = true
+	} else {
+		addrs[crypto.CreateAddress(msg.From(), msg.Nonce())] = true
+	}
+	for addr := range accessed {
+		addrs[addr] = true
+	}
+	diff := make(map[common.Address]*stateDiffAccount)
+	for addr := range addrs {
+		var (
+			existed = pre.Exist(addr)
+			exists  = statedb.Exist(addr)
+		)
+		if !existed && !exists {
+			continue
+		}
+		account := &stateDiffAccount{
+			Balance: diffValue(existed, exists, (*hexutil.Big)(pre.GetBalance(addr)), (*hexutil.Big)(statedb.GetBalance(addr))),
+			Code:    diffValue(existed, exists, hexutil.Bytes(pre.GetCode(addr)), hexutil.Bytes(statedb.GetCode(addr))),
+			Nonce:   diffValue(existed, exists, hexutil.Uint64(pre.GetNonce(addr)), hexutil.Uint64(statedb.GetNonce(addr))),
+			Storage: make(map[common.Hash]interface{}),
+		}
+		for key := range accessed[addr].Storage {
+			before, after := pre.GetState(addr, key), statedb.GetState(addr, key)
+			switch {
+			case existed && exists && before == after:
+				continue
+			case !existed && after == (common.Hash{}):
+				continue
+			case !exists && before == (common.Hash{}):
+				continue
+			}
+			account.Storage[key] = diffValue(existed, exists, before, after)
+		}
+		if account.Balance == "=" && account.Code == "=" && account.Nonce == "=" && len(account.Storage) == 0 {
+			continue
+		}
+		diff[addr] = account
+	}
+	return diff, nil
+}
+
+// diffValue returns the OpenEthereum state diff of a value.
+func diffValue(existed, exists bool, before, after interface{}) interface{} {
+	switch {
+	case !existed:
+		return map[string]interface{}{"+": after}
+	case !exists:
+		return map[string]interface{}{"-": before}
+	}
+	b, _ := json.Marshal(before)
+	a, _ := json.Marshal(after)
+	if bytes.Equal(a, b) {
+		return "="
+	}
+	return map[string]interface{}{"*": map[string]interface{}{"from": before, "to": after}}
+}
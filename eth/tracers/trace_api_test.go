@@ -0,0 +1,175 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func TestFlattenCallFrame(t *testing.T) {
+	var (
+		a, b, c = common.Address{0xa}, common.Address{0xb}, common.Address{0xc}
+		output  = hexutil.Bytes{0x1}
+	)
+	frame := &callFrame{
+		Type: "CALL", From: a, To: &b, Gas: 100, GasUsed: 50, Output: &output,
+		Calls: []*callFrame{
+			{Type: "STATICCALL", From: b, To: &c, Gas: 10, GasUsed: 10, Error: "out of gas"},
+			{Type: "CREATE", From: b, To: &c, Gas: 20, GasUsed: 5,
+				Calls: []*callFrame{{Type: "SELFDESTRUCT", From: c, To: &a}},
+			},
+		},
+	}
+	traces := flattenCallFrame(frame, nil, nil)
+	want := []struct {
+		typ       string
+		address   []int
+		subtraces int
+		err       string
+	}{
+		{"call", []int{}, 2, ""},
+		{"call", []int{0}, 0, "Out of gas"},
+		{"create", []int{1}, 1, ""},
+		{"suicide", []int{1, 0}, 0, ""},
+	}
+	if len(traces) != len(want) {
+		t.Fatalf("trace count mismatch: have %d, want %d", len(traces), len(want))
+	}
+	for i, trace := range traces {
+		if trace.Type != want[i].typ || !reflect.DeepEqual(trace.TraceAddress, want[i].address) ||
+			trace.Subtraces != want[i].subtraces || trace.Error != want[i].err {
+			t.Errorf("trace %d mismatch: have %s %v %d %q", i, trace.Type, trace.TraceAddress, trace.Subtraces, trace.Error)
+		}
+	}
+	if traces[1].Result != nil {
+		t.Errorf("failed call has a result: %v", traces[1].Result)
+	}
+	if to, _ := traces[2].to(); to != c {
+		t.Errorf("created address mismatch: have %x, want %x", to, c)
+	}
+}
+
+func TestTraceAPI(t *testing.T) {
+	t.Parallel()
+
+	// Initialize test accounts
+	accounts := newAccounts(3)
+	genesis := &core.Genesis{Alloc: core.GenesisAlloc{
+		accounts[0].addr: {Balance: big.NewInt(params.Ether)},
+		accounts[1].addr: {Balance: big.NewInt(params.Ether)},
+		accounts[2].addr: {Balance: big.NewInt(params.Ether)},
+	}}
+	var (
+		genBlocks = 4
+		signer    = types.HomesteadSigner{}
+		txs       []common.Hash
+	)
+	api := NewTraceAPI(newTestBackend(t, genBlocks, genesis, func(i int, b *core.BlockGen) {
+		// Transfer from account[0] to account[1] in even blocks and to
+		// account[2] in odd ones
+		//    value: 1000 wei
+		//    fee:   0 wei
+		to := accounts[1+i%2].addr
+		tx, _ := types.SignTx(types.NewTransaction(uint64(i), to, big.NewInt(1000), params.TxGas, b.BaseFee(), nil), signer, accounts[0].key)
+		b.AddTx(tx)
+		txs = append(txs, tx.Hash())
+	}))
+	ctx := context.Background()
+
+	// A block holds the transfer followed by the block reward.
+	traces, err := api.Block(ctx, rpc.BlockNumber(1))
+	if err != nil {
+		t.Fatalf("failed to trace block: %v", err)
+	}
+	if len(traces) != 2 {
+		t.Fatalf("trace count mismatch: have %d, want 2", len(traces))
+	}
+	if traces[0].Type != "call" || *traces[0].TransactionHash != txs[0] || *traces[0].TransactionPosition != 0 {
+		t.Errorf("unexpected transaction trace: %+v", traces[0])
+	}
+	if traces[1].Type != "reward" || traces[1].TransactionHash != nil {
+		t.Errorf("unexpected reward trace: %+v", traces[1])
+	}
+	// A transaction only holds its own calls.
+	traces, err = api.Transaction(ctx, txs[1])
+	if err != nil {
+		t.Fatalf("failed to trace transaction: %v", err)
+	}
+	if len(traces) != 1 || traces[0].BlockNumber != 2 {
+		t.Fatalf("unexpected transaction traces: %+v", traces)
+	}
+	// Filter the transfers to account[2] out of the whole chain.
+	first, last := rpc.BlockNumber(0), rpc.LatestBlockNumber
+	traces, err = api.Filter(ctx, TraceFilterArgs{
+		FromBlock:   &first,
+		ToBlock:     &last,
+		FromAddress: []common.Address{accounts[0].addr},
+		ToAddress:   []common.Address{accounts[2].addr},
+	})
+	if err != nil {
+		t.Fatalf("failed to filter traces: %v", err)
+	}
+	if len(traces) != genBlocks/2 {
+		t.Fatalf("filtered trace count mismatch: have %d, want %d", len(traces), genBlocks/2)
+	}
+	for i, trace := range traces {
+		if *trace.TransactionHash != txs[2*i+1] {
+			t.Errorf("filtered trace %d mismatch: have %x, want %x", i, *trace.TransactionHash, txs[2*i+1])
+		}
+	}
+	// A zero count returns no traces, the skipped ones are not counted.
+	for _, count := range []uint64{0, 1} {
+		after, count := uint64(1), count
+		traces, err = api.Filter(ctx, TraceFilterArgs{FromBlock: &first, ToBlock: &last, After: &after, Count: &count})
+		if err != nil {
+			t.Fatalf("failed to filter traces: %v", err)
+		}
+		if uint64(len(traces)) != count {
+			t.Errorf("count %d: filtered trace count mismatch: have %d", count, len(traces))
+		}
+	}
+	// The replay reports the balance change of the recipient.
+	res, err := api.ReplayTransaction(ctx, txs[0], []string{"trace", "stateDiff", "vmTrace"})
+	if err != nil {
+		t.Fatalf("failed to replay transaction: %v", err)
+	}
+	if len(res.Trace) != 1 || res.VMTrace == nil {
+		t.Errorf("unexpected replay result: %+v", res)
+	}
+	blob, err := json.Marshal(res.StateDiff[accounts[1].addr])
+	if err != nil {
+		t.Fatalf("failed to marshal state diff: %v", err)
+	}
+	want := `{"balance":{"*":{"from":"0xde0b6b3a7640000","to":"0xde0b6b3a76403e8"}},"code":"=","nonce":"=","storage":{}}`
+	if string(blob) != want {
+		t.Errorf("state diff mismatch:\nhave %s\nwant %s", blob, want)
+	}
+	if _, err := api.ReplayTransaction(ctx, txs[0], []string{"foo"}); err == nil {
+		t.Error("expected error for unknown trace type")
+	}
+}
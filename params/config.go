@@ -17,7 +17,9 @@
 package params
 
 import (
+	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"math/big"
 
@@ -225,16 +227,16 @@ var (
 	//
 	// This configuration is intentionally not using keyed fields to force anyone
 	// adding flags to the config to also have to set these fields.
-	AllEthashProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, new(EthashConfig), nil}
+	AllEthashProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, new(EthashConfig), nil}
 
 	// AllCliqueProtocolChanges contains every protocol change (EIPs) introduced
 	// and accepted by the Ethereum core developers into the Clique consensus.
 	//
 	// This configuration is intentionally not using keyed fields to force anyone
 	// adding flags to the config to also have to set these fields.
-	AllCliqueProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, &CliqueConfig{Period: 0, Epoch: 30000}}
+	AllCliqueProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, &CliqueConfig{Period: 0, Epoch: 30000}}
 
-	TestChainConfig = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, new(EthashConfig), nil}
+	TestChainConfig = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, new(EthashConfig), nil}
 	TestRules       = TestChainConfig.Rules(new(big.Int))
 )
 
@@ -316,11 +318,40 @@ type ChainConfig struct {
 
 	CatalystBlock *big.Int `json:"catalystBlock,omitempty"` // Catalyst switch block (nil = no fork, 0 = already on catalyst)
 
+	// Stateful precompiles activated at fork blocks
+	StatePrecompiles []StatePrecompileConfig `json:"statePrecompiles,omitempty"`
+
 	// Various consensus engines
 	Ethash *EthashConfig `json:"ethash,omitempty"`
 	Clique *CliqueConfig `json:"clique,omitempty"`
 }
 
+// StatePrecompileConfig activates a stateful precompile, registered with the
+// EVM by name, at an address.
+type StatePrecompileConfig struct {
+	Name    string          `json:"name"`             // name the precompile is registered with
+	Address common.Address  `json:"address"`          // address of the precompile
+	Block   *big.Int        `json:"block,omitempty"`  // activation block (nil = genesis)
+	Params  json.RawMessage `json:"params,omitempty"` // precompile specific parameters
+}
+
+// Activation returns the activation block of a precompile, nil if it is not
+// configured at all.
+func (c *StatePrecompileConfig) Activation() *big.Int {
+	switch {
+	case c == nil:
+		return nil
+	case c.Block == nil:
+		return common.Big0
+	}
+	return c.Block
+}
+
+// IsActive returns whether the precompile is active at block num.
+func (c *StatePrecompileConfig) IsActive(num *big.Int) bool {
+	return isForked(c.Activation(), num)
+}
+
 // EthashConfig is the consensus engine configs for proof-of-work based sealing.
 type EthashConfig struct{}
 
@@ -437,6 +468,17 @@ func (c *ChainConfig) IsCatalyst(num *big.Int) bool {
 	return isForked(c.CatalystBlock, num)
 }
 
+// ActiveStatePrecompiles returns the stateful precompiles active at block num.
+func (c *ChainConfig) ActiveStatePrecompiles(num *big.Int) []StatePrecompileConfig {
+	var active []StatePrecompileConfig
+	for _, p := range c.StatePrecompiles {
+		if p.IsActive(num) {
+			active = append(active, p)
+		}
+	}
+	return active
+}
+
 // CheckCompatible checks whether scheduled fork transitions have been imported
 // with a mismatching chain configuration.
 func (c *ChainConfig) CheckCompatible(newcfg *ChainConfig, height uint64) *ConfigCompatError {
@@ -496,6 +538,16 @@ func (c *ChainConfig) CheckConfigForkOrder() error {
 			lastFork = cur
 		}
 	}
+	addresses := make(map[common.Address]bool)
+	for _, p := range c.StatePrecompiles {
+		if p.Name == "" {
+			return fmt.Errorf("unnamed stateful precompile at %x", p.Address)
+		}
+		if addresses[p.Address] {
+			return fmt.Errorf("multiple stateful precompiles at %x", p.Address)
+		}
+		addresses[p.Address] = true
+	}
 	return nil
 }
 
@@ -546,6 +598,32 @@ func (c *ChainConfig) checkCompatible(newcfg *ChainConfig, head *big.Int) *Confi
 	if isForkIncompatible(c.LondonBlock, newcfg.LondonBlock, head) {
 		return newCompatError("London fork block", c.LondonBlock, newcfg.LondonBlock)
 	}
+	if err := checkStatePrecompilesCompatible(c.StatePrecompiles, newcfg.StatePrecompiles, head); err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkStatePrecompilesCompatible checks that no stateful precompile active
+// at head is added, removed or modified.
+func checkStatePrecompilesCompatible(stored, configured []StatePrecompileConfig, head *big.Int) *ConfigCompatError {
+	precompiles := func(configs []StatePrecompileConfig) map[common.Address]*StatePrecompileConfig {
+		m := make(map[common.Address]*StatePrecompileConfig)
+		for i := range configs {
+			m[configs[i].Address] = &configs[i]
+		}
+		return m
+	}
+	s1, s2 := precompiles(stored), precompiles(configured)
+	for _, configs := range []map[common.Address]*StatePrecompileConfig{s1, s2} {
+		for addr := range configs {
+			p1, p2 := s1[addr], s2[addr]
+			if isForkIncompatible(p1.Activation(), p2.Activation(), head) ||
+				(p1.IsActive(head) && p2.IsActive(head) && (p1.Name != p2.Name || !bytes.Equal(p1.Params, p2.Params))) {
+				return newCompatError(fmt.Sprintf("stateful precompile %x", addr), p1.Activation(), p2.Activation())
+			}
+		}
+	}
 	return nil
 }
 
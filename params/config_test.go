@@ -20,6 +20,8 @@ import (
 	"math/big"
 	"reflect"
 	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
 )
 
 func TestCheckCompatible(t *testing.T) {
@@ -87,6 +89,45 @@ func TestCheckCompatible(t *testing.T) {
 				RewindTo:     30,
 			},
 		},
+		{
+			stored:  &ChainConfig{StatePrecompiles: []StatePrecompileConfig{statePrecompile("a", 10, nil)}},
+			new:     &ChainConfig{StatePrecompiles: []StatePrecompileConfig{statePrecompile("a", 20, nil)}},
+			head:    5,
+			wantErr: nil,
+		},
+		{
+			stored: &ChainConfig{StatePrecompiles: []StatePrecompileConfig{statePrecompile("a", 10, nil)}},
+			new:    &ChainConfig{StatePrecompiles: []StatePrecompileConfig{statePrecompile("a", 20, nil)}},
+			head:   15,
+			wantErr: &ConfigCompatError{
+				What:         "stateful precompile 0000000000000000000000000000000000000100",
+				StoredConfig: big.NewInt(10),
+				NewConfig:    big.NewInt(20),
+				RewindTo:     9,
+			},
+		},
+		{
+			stored: &ChainConfig{StatePrecompiles: []StatePrecompileConfig{statePrecompile("a", 10, []byte(`1`))}},
+			new:    &ChainConfig{StatePrecompiles: []StatePrecompileConfig{statePrecompile("a", 10, []byte(`2`))}},
+			head:   15,
+			wantErr: &ConfigCompatError{
+				What:         "stateful precompile 0000000000000000000000000000000000000100",
+				StoredConfig: big.NewInt(10),
+				NewConfig:    big.NewInt(10),
+				RewindTo:     9,
+			},
+		},
+		{
+			stored: &ChainConfig{StatePrecompiles: []StatePrecompileConfig{statePrecompile("a", 10, nil)}},
+			new:    &ChainConfig{},
+			head:   15,
+			wantErr: &ConfigCompatError{
+				What:         "stateful precompile 0000000000000000000000000000000000000100",
+				StoredConfig: big.NewInt(10),
+				NewConfig:    nil,
+				RewindTo:     9,
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -96,3 +137,32 @@ func TestCheckCompatible(t *testing.T) {
 		}
 	}
 }
+
+func statePrecompile(name string, block int64, params []byte) StatePrecompileConfig {
+	return StatePrecompileConfig{
+		Name:    name,
+		Address: common.BytesToAddress([]byte{1, 0}),
+		Block:   big.NewInt(block),
+		Params:  params,
+	}
+}
+
+func TestActiveStatePrecompiles(t *testing.T) {
+	config := &ChainConfig{StatePrecompiles: []StatePrecompileConfig{
+		{Name: "genesis", Address: common.BytesToAddress([]byte{1})},
+		{Name: "later", Address: common.BytesToAddress([]byte{2}), Block: big.NewInt(10)},
+	}}
+	if err := config.CheckConfigForkOrder(); err != nil {
+		t.Fatal(err)
+	}
+	if active := config.ActiveStatePrecompiles(big.NewInt(9)); len(active) != 1 || active[0].Name != "genesis" {
+		t.Errorf("unexpected precompiles at block 9: %v", active)
+	}
+	if active := config.ActiveStatePrecompiles(big.NewInt(10)); len(active) != 2 || active[1].Name != "later" {
+		t.Errorf("unexpected precompiles at block 10: %v", active)
+	}
+	config.StatePrecompiles[1].Address = config.StatePrecompiles[0].Address
+	if err := config.CheckConfigForkOrder(); err == nil {
+		t.Error("duplicate precompile address accepted")
+	}
+}
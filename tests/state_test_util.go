@@ -44,6 +44,9 @@ import (
 // See https://github.com/ethereum/EIPs/issues/176 for the test format specification.
 type StateTest struct {
 	json stJSON
+
+	// StatePrecompiles are activated in addition to the fork's configuration
+	StatePrecompiles []params.StatePrecompileConfig
 }
 
 // StateSubtest selects a specific configuration of a General State Test.
@@ -57,6 +60,7 @@ func (t *StateTest) UnmarshalJSON(in []byte) error {
 }
 
 type stJSON struct {
+	Info *stInfo                  `json:"_info,omitempty"`
 	Env  stEnv                    `json:"env"`
 	Pre  core.GenesisAlloc        `json:"pre"`
 	Tx   stTransaction            `json:"transaction"`
@@ -64,6 +68,15 @@ type stJSON struct {
 	Post map[string][]stPostState `json:"post"`
 }
 
+// stInfo describes the origin of a test. Extensions lists the non-standard
+// fields the test relies on, which other clients do not understand:
+//   - "transaction.sender": the sender of a transaction without secretKey,
+//   - "env.blockHashes": block hashes replacing the derived ones.
+type stInfo struct {
+	Comment    string   `json:"comment,omitempty"`
+	Extensions []string `json:"extensions,omitempty"`
+}
+
 type stPostState struct {
 	Root            common.UnprefixedHash `json:"hash"`
 	Logs            common.UnprefixedHash `json:"logs"`
@@ -79,21 +92,32 @@ type stPostState struct {
 //go:generate gencodec -type stEnv -field-override stEnvMarshaling -out gen_stenv.go
 
 type stEnv struct {
-	Coinbase   common.Address `json:"currentCoinbase"   gencodec:"required"`
-	Difficulty *big.Int       `json:"currentDifficulty" gencodec:"required"`
-	GasLimit   uint64         `json:"currentGasLimit"   gencodec:"required"`
-	Number     uint64         `json:"currentNumber"     gencodec:"required"`
-	Timestamp  uint64         `json:"currentTimestamp"  gencodec:"required"`
-	BaseFee    *big.Int       `json:"currentBaseFee"  gencodec:"optional"`
+	Coinbase    common.Address         `json:"currentCoinbase"   gencodec:"required"`
+	Difficulty  *big.Int               `json:"currentDifficulty" gencodec:"required"`
+	GasLimit    uint64                 `json:"currentGasLimit"   gencodec:"required"`
+	Number      uint64                 `json:"currentNumber"     gencodec:"required"`
+	Timestamp   uint64                 `json:"currentTimestamp"  gencodec:"required"`
+	BaseFee     *big.Int               `json:"currentBaseFee"  gencodec:"optional"`
+	BlockHashes map[uint64]common.Hash `json:"blockHashes,omitempty"` // extension, see stInfo
 }
 
 type stEnvMarshaling struct {
-	Coinbase   common.UnprefixedAddress
-	Difficulty *math.HexOrDecimal256
-	GasLimit   math.HexOrDecimal64
-	Number     math.HexOrDecimal64
-	Timestamp  math.HexOrDecimal64
-	BaseFee    *math.HexOrDecimal256
+	Coinbase    common.UnprefixedAddress
+	Difficulty  *math.HexOrDecimal256
+	GasLimit    math.HexOrDecimal64
+	Number      math.HexOrDecimal64
+	Timestamp   math.HexOrDecimal64
+	BaseFee     *math.HexOrDecimal256
+	BlockHashes map[math.HexOrDecimal64]common.Hash
+}
+
+// getHash returns the hash of the given block, the hashes of the env take
+// precedence over the ones derived by vmTestBlockHash.
+func (env *stEnv) getHash(n uint64) common.Hash {
+	if hash, ok := env.BlockHashes[n]; ok {
+		return hash
+	}
+	return vmTestBlockHash(n)
 }
 
 //go:generate gencodec -type stTransaction -field-override stTransactionMarshaling -out gen_sttransaction.go
@@ -109,6 +133,7 @@ type stTransaction struct {
 	GasLimit             []uint64            `json:"gasLimit"`
 	Value                []string            `json:"value"`
 	PrivateKey           []byte              `json:"secretKey"`
+	Sender               *common.Address     `json:"sender,omitempty"` // extension, see stInfo
 }
 
 type stTransactionMarshaling struct {
@@ -159,7 +184,7 @@ func (t *StateTest) Subtests() []StateSubtest {
 
 // Run executes a specific subtest and verifies the post-state and logs
 func (t *StateTest) Run(subtest StateSubtest, vmconfig vm.Config, snapshotter bool) (*snapshot.Tree, *state.StateDB, error) {
-	snaps, statedb, root, err := t.RunNoVerify(subtest, vmconfig, snapshotter)
+	snaps, statedb, root, _, err := t.RunNoVerify(subtest, vmconfig, snapshotter)
 	if err != nil {
 		return snaps, statedb, err
 	}
@@ -175,15 +200,25 @@ func (t *StateTest) Run(subtest StateSubtest, vmconfig vm.Config, snapshotter bo
 	return snaps, statedb, nil
 }
 
-// RunNoVerify runs a specific subtest and returns the statedb and post-state root
-func (t *StateTest) RunNoVerify(subtest StateSubtest, vmconfig vm.Config, snapshotter bool) (*snapshot.Tree, *state.StateDB, common.Hash, error) {
+// RunNoVerify runs a specific subtest and returns the statedb, the post-state
+// root and the gas used by the transaction, which is zero if it was invalid.
+func (t *StateTest) RunNoVerify(subtest StateSubtest, vmconfig vm.Config, snapshotter bool) (*snapshot.Tree, *state.StateDB, common.Hash, uint64, error) {
 	config, eips, err := GetChainConfig(subtest.Fork)
 	if err != nil {
-		return nil, nil, common.Hash{}, UnsupportedForkError{subtest.Fork}
+		return nil, nil, common.Hash{}, 0, UnsupportedForkError{subtest.Fork}
 	}
 	vmconfig.ExtraEips = eips
+	if len(t.StatePrecompiles) > 0 {
+		cpy := *config
+		cpy.StatePrecompiles = t.StatePrecompiles
+		config = &cpy
+		if err := vm.CheckStatePrecompiles(config); err != nil {
+			return nil, nil, common.Hash{}, 0, err
+		}
+	}
 	block := t.genesis(config).ToBlock(nil)
 	snaps, statedb := MakePreState(rawdb.NewMemoryDatabase(), t.json.Pre, snapshotter)
+	vm.ActivateStatePrecompiles(config, block.Number(), statedb)
 
 	var baseFee *big.Int
 	if config.IsLondon(new(big.Int)) {
@@ -197,7 +232,7 @@ func (t *StateTest) RunNoVerify(subtest StateSubtest, vmconfig vm.Config, snapsh
 	post := t.json.Post[subtest.Fork][subtest.Index]
 	msg, err := t.json.Tx.toMessage(post, baseFee)
 	if err != nil {
-		return nil, nil, common.Hash{}, err
+		return nil, nil, common.Hash{}, 0, err
 	}
 
 	// Try to recover tx with current signer
@@ -205,18 +240,18 @@ func (t *StateTest) RunNoVerify(subtest StateSubtest, vmconfig vm.Config, snapsh
 		var ttx types.Transaction
 		err := ttx.UnmarshalBinary(post.TxBytes)
 		if err != nil {
-			return nil, nil, common.Hash{}, err
+			return nil, nil, common.Hash{}, 0, err
 		}
 
 		if _, err := types.Sender(types.LatestSigner(config), &ttx); err != nil {
-			return nil, nil, common.Hash{}, err
+			return nil, nil, common.Hash{}, 0, err
 		}
 	}
 
 	// Prepare the EVM.
 	txContext := core.NewEVMTxContext(msg)
 	context := core.NewEVMBlockContext(block.Header(), nil, &t.json.Env.Coinbase)
-	context.GetHash = vmTestBlockHash
+	context.GetHash = t.json.Env.getHash
 	context.BaseFee = baseFee
 	evm := vm.NewEVM(context, txContext, statedb, config, vmconfig)
 
@@ -224,8 +259,11 @@ func (t *StateTest) RunNoVerify(subtest StateSubtest, vmconfig vm.Config, snapsh
 	snapshot := statedb.Snapshot()
 	gaspool := new(core.GasPool)
 	gaspool.AddGas(block.GasLimit())
-	if _, err := core.ApplyMessage(evm, msg, gaspool); err != nil {
+	var gasUsed uint64
+	if result, err := core.ApplyMessage(evm, msg, gaspool); err != nil {
 		statedb.RevertToSnapshot(snapshot)
+	} else {
+		gasUsed = result.UsedGas
 	}
 
 	// Commit block
@@ -238,7 +276,7 @@ func (t *StateTest) RunNoVerify(subtest StateSubtest, vmconfig vm.Config, snapsh
 	statedb.AddBalance(block.Coinbase(), new(big.Int))
 	// And _now_ get the state root
 	root := statedb.IntermediateRoot(config.IsEIP158(block.Number()))
-	return snaps, statedb, root, nil
+	return snaps, statedb, root, gasUsed, nil
 }
 
 func (t *StateTest) gasLimit(subtest StateSubtest) uint64 {
@@ -288,6 +326,9 @@ func (tx *stTransaction) toMessage(ps stPostState, baseFee *big.Int) (core.Messa
 			return nil, fmt.Errorf("invalid private key: %v", err)
 		}
 		from = crypto.PubkeyToAddress(key.PublicKey)
+	} else if tx.Sender != nil {
+		// Tests converted from recorded transactions carry no key.
+		from = *tx.Sender
 	}
 	// Parse recipient if present.
 	var to *common.Address
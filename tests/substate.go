@@ -0,0 +1,184 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tests
+
+import (
+	"encoding/json"
+	"math/big"
+
+	substate "github.com/Fantom-foundation/Substate"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// ForkName returns the name of the newest fork in Forks whose rules are active
+// at the given block of config.
+func ForkName(config *params.ChainConfig, num *big.Int) string {
+	switch {
+	case config.IsLondon(num):
+		return "London"
+	case config.IsBerlin(num):
+		return "Berlin"
+	case config.IsIstanbul(num):
+		return "Istanbul"
+	case config.IsPetersburg(num):
+		return "ConstantinopleFix"
+	case config.IsConstantinople(num):
+		return "Constantinople"
+	case config.IsByzantium(num):
+		return "Byzantium"
+	case config.IsEIP158(num):
+		return "EIP158"
+	case config.IsEIP150(num):
+		return "EIP150"
+	case config.IsHomestead(num):
+		return "Homestead"
+	}
+	return "Frontier"
+}
+
+// SubstatePostState returns the state root and logs hash the execution of the
+// given substate is expected to produce under the given fork. The root is the
+// one of the recorded output alloc, which holds every account touched by the
+// transaction, and the logs are the recorded ones. If touchCoinbase is set,
+// the coinbase is touched after the transaction like state tests do.
+func SubstatePostState(s *substate.Substate, fork string, touchCoinbase bool) (common.Hash, common.Hash, error) {
+	config, _, err := GetChainConfig(fork)
+	if err != nil {
+		return common.Hash{}, common.Hash{}, err
+	}
+	post := GenesisAllocFromSubstate(s.OutputAlloc)
+	// Touching the coinbase creates it if it does not exist before EIP-158.
+	if _, ok := post[s.Env.Coinbase]; !ok && touchCoinbase && !config.IsEIP158(new(big.Int).SetUint64(s.Env.Number)) {
+		post[s.Env.Coinbase] = core.GenesisAccount{Balance: new(big.Int)}
+	}
+	_, statedb := MakePreState(rawdb.NewMemoryDatabase(), post, false)
+
+	var logs []*types.Log
+	if s.Result != nil {
+		logs = s.Result.Logs
+	}
+	return statedb.IntermediateRoot(false), rlpHash(logs), nil
+}
+
+// GenesisAllocFromSubstate converts a substate alloc into a genesis alloc.
+func GenesisAllocFromSubstate(alloc substate.SubstateAlloc) core.GenesisAlloc {
+	accounts := make(core.GenesisAlloc, len(alloc))
+	for addr, account := range alloc {
+		storage := make(map[common.Hash]common.Hash, len(account.Storage))
+		for key, value := range account.Storage {
+			storage[key] = value
+		}
+		accounts[addr] = core.GenesisAccount{
+			Code:    common.CopyBytes(account.Code),
+			Storage: storage,
+			Balance: new(big.Int).Set(account.Balance),
+			Nonce:   account.Nonce,
+		}
+	}
+	return accounts
+}
+
+// StateTestFromSubstate converts a recorded substate into a state test with a
+// single subtest for the given fork. The expected post state is the recorded
+// one, see SubstatePostState.
+//
+// The result is not a standard state test: recorded transactions carry no
+// private key, so the sender is stored in the non-standard transaction.sender
+// field, and the recorded block hashes in env.blockHashes. Re-keying the
+// sender is not an option, since its address is part of the state, e.g. of
+// storage keys and created addresses. The extensions are listed in the _info
+// section of the test, only this package executes such tests.
+func StateTestFromSubstate(s *substate.Substate, fork string) (*StateTest, error) {
+	root, logs, err := SubstatePostState(s, fork, true)
+	if err != nil {
+		return nil, err
+	}
+	env := stEnv{
+		Coinbase:   s.Env.Coinbase,
+		Difficulty: new(big.Int),
+		GasLimit:   s.Env.GasLimit,
+		Number:     s.Env.Number,
+		Timestamp:  s.Env.Timestamp,
+	}
+	if s.Env.Difficulty != nil {
+		env.Difficulty.Set(s.Env.Difficulty)
+	}
+	if s.Env.BaseFee != nil {
+		env.BaseFee = new(big.Int).Set(s.Env.BaseFee)
+	}
+	info := &stInfo{
+		Comment:    "converted from a recorded substate",
+		Extensions: []string{"transaction.sender"},
+	}
+	if len(s.Env.BlockHashes) > 0 {
+		info.Extensions = append(info.Extensions, "env.blockHashes")
+		env.BlockHashes = make(map[uint64]common.Hash, len(s.Env.BlockHashes))
+		for num, hash := range s.Env.BlockHashes {
+			env.BlockHashes[num] = hash
+		}
+	}
+	msg := s.Message
+	tx := stTransaction{
+		GasPrice:             msg.GasPrice,
+		MaxFeePerGas:         msg.GasFeeCap,
+		MaxPriorityFeePerGas: msg.GasTipCap,
+		Nonce:                msg.Nonce,
+		Data:                 []string{hexutil.Encode(msg.Data)},
+		GasLimit:             []uint64{msg.Gas},
+		Value:                []string{hexutil.EncodeBig(msg.Value)},
+		Sender:               &msg.From,
+	}
+	if msg.To != nil {
+		tx.To = msg.To.Hex()
+	}
+	if msg.AccessList != nil {
+		accessList := make(types.AccessList, len(msg.AccessList))
+		copy(accessList, msg.AccessList)
+		tx.AccessLists = []*types.AccessList{&accessList}
+	}
+	post := stPostState{Root: common.UnprefixedHash(root), Logs: common.UnprefixedHash(logs)}
+	return &StateTest{json: stJSON{
+		Info: info,
+		Env:  env,
+		Pre:  GenesisAllocFromSubstate(s.InputAlloc),
+		Tx:   tx,
+		Post: map[string][]stPostState{fork: {post}},
+	}}, nil
+}
+
+// FillPostState executes the subtest with the given configuration and records
+// the resulting state root and logs hash as its expected post state.
+func (t *StateTest) FillPostState(subtest StateSubtest, vmconfig vm.Config) error {
+	_, statedb, root, _, err := t.RunNoVerify(subtest, vmconfig, false)
+	if err != nil {
+		return err
+	}
+	post := &t.json.Post[subtest.Fork][subtest.Index]
+	post.Root = common.UnprefixedHash(root)
+	post.Logs = common.UnprefixedHash(rlpHash(statedb.Logs()))
+	return nil
+}
+
+func (t *StateTest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&t.json)
+}
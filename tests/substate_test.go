@@ -0,0 +1,114 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tests
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	substate "github.com/Fantom-foundation/Substate"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+type testRecorder []*substate.Substate
+
+func (r *testRecorder) RecordSubstate(block uint64, tx int, record *substate.Substate) error {
+	*r = append(*r, record)
+	return nil
+}
+
+var (
+	substateKey, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	substateSender  = crypto.PubkeyToAddress(substateKey.PublicKey)
+	substateCounter = common.HexToAddress("0xc0de")
+)
+
+// recordSubstates records the substates of a chain calling a contract that
+// stores the hash of the previous block and emits a log.
+func recordSubstates(t *testing.T) []*substate.Substate {
+	var (
+		config = params.AllEthashProtocolChanges
+		signer = types.LatestSigner(config)
+		gspec  = &core.Genesis{
+			Config: config,
+			Alloc: core.GenesisAlloc{
+				substateSender: {Balance: big.NewInt(1000000000000000000)},
+				// SSTORE(NUMBER, BLOCKHASH(NUMBER-1)); LOG0(0, 0)
+				substateCounter: {Code: common.FromHex("6001430340435560006000a0"), Balance: common.Big0},
+			},
+		}
+		db      = rawdb.NewMemoryDatabase()
+		genesis = gspec.MustCommit(db)
+	)
+	blocks, _ := core.GenerateChain(config, genesis, ethash.NewFaker(), db, 2, func(i int, b *core.BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(substateSender), substateCounter, common.Big0, 100000, b.BaseFee(), nil), signer, substateKey)
+		b.AddTx(tx)
+	})
+	recorder := new(testRecorder)
+	db = rawdb.NewMemoryDatabase()
+	gspec.MustCommit(db)
+	chain, _ := core.NewBlockChain(db, nil, config, ethash.NewFaker(), vm.Config{SubstateRecorder: recorder}, nil, nil)
+	defer chain.Stop()
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	return *recorder
+}
+
+func TestStateTestFromSubstate(t *testing.T) {
+	for i, s := range recordSubstates(t) {
+		test, err := StateTestFromSubstate(s, "London")
+		if err != nil {
+			t.Fatalf("substate %d: failed to convert: %v", i, err)
+		}
+		blob, err := json.Marshal(test)
+		if err != nil {
+			t.Fatalf("substate %d: failed to encode test: %v", i, err)
+		}
+		var decoded StateTest
+		if err := json.Unmarshal(blob, &decoded); err != nil {
+			t.Fatalf("substate %d: failed to decode test: %v", i, err)
+		}
+		if info := decoded.json.Info; info == nil || len(info.Extensions) != 2 {
+			t.Errorf("substate %d: extensions not flagged: %+v", i, info)
+		}
+		subtest := StateSubtest{Fork: "London"}
+		_, statedb, err := decoded.Run(subtest, vm.Config{}, false)
+		if err != nil {
+			t.Fatalf("substate %d: converted test failed: %v", i, err)
+		}
+		key := common.BigToHash(new(big.Int).SetUint64(s.Env.Number))
+		if have, want := statedb.GetState(substateCounter, key), s.Env.BlockHashes[s.Env.Number-1]; have != want {
+			t.Errorf("substate %d: wrong block hash stored: have %x, want %x", i, have, want)
+		}
+		// Re-filling the post state with geth must not change it.
+		if err := test.FillPostState(subtest, vm.Config{}); err != nil {
+			t.Fatalf("substate %d: failed to fill post state: %v", i, err)
+		}
+		if refilled, _ := json.Marshal(test); string(refilled) != string(blob) {
+			t.Errorf("substate %d: post state changed by filling:\nhave %s\nwant %s", i, refilled, blob)
+		}
+	}
+}